@@ -0,0 +1,248 @@
+package dirsync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BandwidthConfig limits how fast a pair transfers, with different limits
+// at different times of day, such as full speed overnight and a cap during
+// working hours
+type BandwidthConfig struct {
+	// Limit is the limit in KB/s outside the schedule (default 0, no limit)
+	Limit int `json:"limit"`
+
+	// Schedule lists the windows with their own limit. The first window
+	// that contains the current time applies.
+	Schedule []BandwidthWindow `json:"schedule,omitempty"`
+}
+
+// BandwidthWindow is a time of day with its own bandwidth limit
+type BandwidthWindow struct {
+	// Days the window starts on, like "mon" or "sat" (default every day)
+	Days []string `json:"days,omitempty"`
+
+	// Start and End are local times like "09:00". A window that ends
+	// before it starts runs past midnight; one that ends when it starts
+	// lasts all day.
+	Start string `json:"start"`
+	End   string `json:"end"`
+
+	// Limit is the limit in KB/s during the window, 0 for none
+	Limit int `json:"limit"`
+}
+
+// bandwidthCheckInterval is how often a running transfer checks whether it
+// moved into another window
+var bandwidthCheckInterval = time.Minute
+
+// bandwidthClock tells the time rsync's limit is picked by, replaced in tests
+var bandwidthClock = time.Now
+
+// weekdays maps the day names of bandwidth windows to days
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Validate checks the limits and the windows' days and times
+func (c *BandwidthConfig) Validate() error {
+	if c.Limit < 0 {
+		return fmt.Errorf("bandwidth limit %d is negative", c.Limit)
+	}
+	for i, w := range c.Schedule {
+		if w.Limit < 0 {
+			return fmt.Errorf("bandwidth schedule %d has a negative limit", i+1)
+		}
+		if _, err := parseTimeOfDay(w.Start); err != nil {
+			return fmt.Errorf("bandwidth schedule %d start: %v", i+1, err)
+		}
+		if _, err := parseTimeOfDay(w.End); err != nil {
+			return fmt.Errorf("bandwidth schedule %d end: %v", i+1, err)
+		}
+		for _, day := range w.Days {
+			if _, ok := weekdays[strings.ToLower(day)]; !ok {
+				return fmt.Errorf("bandwidth schedule %d has unknown day %q", i+1, day)
+			}
+		}
+	}
+	return nil
+}
+
+// parseTimeOfDay parses a time like "09:00" into minutes after midnight
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q isn't a time like 09:00", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// LimitAt returns the limit in KB/s at t, 0 for none
+func (c *BandwidthConfig) LimitAt(t time.Time) int {
+	if c == nil {
+		return 0
+	}
+	for _, w := range c.Schedule {
+		if w.contains(t) {
+			return w.Limit
+		}
+	}
+	return c.Limit
+}
+
+// contains reports whether t falls inside the window
+func (w BandwidthWindow) contains(t time.Time) bool {
+	start, err := parseTimeOfDay(w.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseTimeOfDay(w.End)
+	if err != nil {
+		return false
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	switch {
+	case start < end:
+		return minute >= start && minute < end && w.startsOn(t.Weekday())
+	case start == end:
+		return w.startsOn(t.Weekday())
+	}
+	// Past midnight, the window started the day before
+	if minute >= start {
+		return w.startsOn(t.Weekday())
+	}
+	return minute < end && w.startsOn((t.Weekday()+6)%7)
+}
+
+// startsOn reports whether the window starts on day
+func (w BandwidthWindow) startsOn(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// watchBandwidth sends the new limit once the limit changes from current,
+// until stop is closed. Without a schedule the limit never changes and the
+// returned channel never receives.
+func (c *BandwidthConfig) watchBandwidth(current int, stop <-chan struct{}) <-chan int {
+	changed := make(chan int, 1)
+	if c == nil || len(c.Schedule) == 0 {
+		return changed
+	}
+
+	interval, clock := bandwidthCheckInterval, bandwidthClock
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if limit := c.LimitAt(clock()); limit != current {
+					changed <- limit
+					return
+				}
+			}
+		}
+	}()
+	return changed
+}
+
+// describeLimit describes a limit in KB/s for the run's output
+func describeLimit(limit int) string {
+	if limit == 0 {
+		return "no bandwidth limit"
+	}
+	return fmt.Sprintf("bandwidth limit %d KB/s", limit)
+}
+
+// bandwidthLimiter paces the built-in copier's reads to the pair's current
+// limit, shared by all of its workers. The limit is looked up as data is
+// read, so a new window takes effect in the middle of a run.
+type bandwidthLimiter struct {
+	config   *BandwidthConfig
+	onChange func(limit int)
+
+	mu    sync.Mutex
+	limit int
+	next  time.Time
+}
+
+// newBandwidthLimiter returns a limiter for config, or nil if the pair has
+// no limits. onChange is called when the limit changes during a run.
+func newBandwidthLimiter(config *BandwidthConfig, onChange func(limit int)) *bandwidthLimiter {
+	if config == nil || (config.Limit == 0 && len(config.Schedule) == 0) {
+		return nil
+	}
+	return &bandwidthLimiter{config: config, onChange: onChange, limit: config.LimitAt(time.Now())}
+}
+
+// wait blocks until n more bytes may be read at the current limit
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	now := time.Now()
+	limit := l.config.LimitAt(now)
+
+	l.mu.Lock()
+	if limit != l.limit {
+		l.limit = limit
+		if l.onChange != nil {
+			l.onChange(limit)
+		}
+	}
+	if limit == 0 {
+		l.next = time.Time{}
+		l.mu.Unlock()
+		return nil
+	}
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(limit*1024))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	if !sleepContext(ctx, delay) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// maxThrottledRead keeps each read small, so a low limit paces the copy
+// smoothly instead of in long bursts
+const maxThrottledRead = 64 * 1024
+
+// throttledReader reads through a bandwidth limiter
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *bandwidthLimiter
+}
+
+func (t throttledReader) Read(p []byte) (int, error) {
+	if len(p) > maxThrottledRead {
+		p = p[:maxThrottledRead]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if waitErr := t.limiter.wait(t.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,148 @@
+package dirsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBandwidthLimitAt tests picking the limit of the window a time falls in
+func TestBandwidthLimitAt(t *testing.T) {
+	config := &BandwidthConfig{
+		Limit: 500,
+		Schedule: []BandwidthWindow{
+			{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "18:00", Limit: 100},
+			{Start: "23:00", End: "06:00", Limit: 0},
+		},
+	}
+
+	// 2024-01-01 was a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.Local)
+	}
+	tests := []struct {
+		t    time.Time
+		want int
+	}{
+		{at(1, 9, 0), 100},
+		{at(1, 17, 59), 100},
+		{at(1, 18, 0), 500},
+		{at(6, 12, 0), 500}, // Saturday
+		{at(1, 23, 30), 0},
+		{at(2, 5, 59), 0},
+		{at(2, 6, 0), 500},
+	}
+	for _, tt := range tests {
+		if got := config.LimitAt(tt.t); got != tt.want {
+			t.Errorf("LimitAt(%s) = %d, want %d", tt.t.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+
+	var none *BandwidthConfig
+	if got := none.LimitAt(time.Now()); got != 0 {
+		t.Errorf("Expected no limit without a config, got %d", got)
+	}
+
+	// A window past midnight belongs to the day it starts
+	friday := &BandwidthConfig{Schedule: []BandwidthWindow{{Days: []string{"fri"}, Start: "22:00", End: "02:00", Limit: 10}}}
+	if got := friday.LimitAt(at(6, 1, 0)); got != 10 {
+		t.Errorf("Expected Friday's window early on Saturday, got %d", got)
+	}
+	if got := friday.LimitAt(at(5, 1, 0)); got != 0 {
+		t.Errorf("Expected no window early on Friday, got %d", got)
+	}
+}
+
+// TestBandwidthValidate tests rejecting bad limits, times and days
+func TestBandwidthValidate(t *testing.T) {
+	valid := BandwidthConfig{Limit: 100, Schedule: []BandwidthWindow{{Days: []string{"Sat"}, Start: "00:00", End: "00:00"}}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected %+v to be valid, got %v", valid, err)
+	}
+
+	for _, c := range []BandwidthConfig{
+		{Limit: -1},
+		{Schedule: []BandwidthWindow{{Start: "9am", End: "17:00"}}},
+		{Schedule: []BandwidthWindow{{Start: "09:00", End: "25:00"}}},
+		{Schedule: []BandwidthWindow{{Start: "09:00", End: "17:00", Limit: -5}}},
+		{Schedule: []BandwidthWindow{{Days: []string{"someday"}, Start: "09:00", End: "17:00"}}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", c)
+		}
+	}
+}
+
+// TestBandwidthRsyncArgs tests passing the current limit to rsync
+func TestBandwidthRsyncArgs(t *testing.T) {
+	testSync := NewSync(testSourceDir, testDestDir, 60)
+	testSync.Pair.Bandwidth = &BandwidthConfig{Limit: 2048}
+	args := strings.Join(testSync.rsyncArgs(testSourceDir+"/"), " ")
+	if !strings.Contains(args, "--bwlimit=2048") {
+		t.Errorf("Expected --bwlimit=2048 in %q", args)
+	}
+
+	testSync.Pair.Bandwidth = &BandwidthConfig{Limit: 2048, Schedule: []BandwidthWindow{{Start: "00:00", End: "00:00"}}}
+	if args := strings.Join(testSync.rsyncArgs(testSourceDir+"/"), " "); strings.Contains(args, "--bwlimit") {
+		t.Errorf("Expected no limit during an unlimited window, got %q", args)
+	}
+}
+
+// TestBandwidthWatch tests noticing that the schedule moved into a window
+// with another limit
+func TestBandwidthWatch(t *testing.T) {
+	old := bandwidthCheckInterval
+	bandwidthCheckInterval = 10 * time.Millisecond
+	defer func() { bandwidthCheckInterval = old }()
+
+	config := &BandwidthConfig{Limit: 100, Schedule: []BandwidthWindow{{Start: "00:00", End: "00:00", Limit: 300}}}
+	stop := make(chan struct{})
+	defer close(stop)
+	select {
+	case limit := <-config.watchBandwidth(100, stop):
+		if limit != 300 {
+			t.Errorf("Expected the new limit 300, got %d", limit)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the change of limit to be noticed")
+	}
+
+	select {
+	case limit := <-(&BandwidthConfig{Limit: 100}).watchBandwidth(100, stop):
+		t.Errorf("Expected no change without a schedule, got %d", limit)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// TestBandwidthLimiter tests pacing the built-in copier to the limit
+func TestBandwidthLimiter(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	os.WriteFile(src, make([]byte, 256*1024), 0644)
+	info, _ := os.Stat(src)
+
+	limiter := newBandwidthLimiter(&BandwidthConfig{Limit: 512}, nil)
+	start := time.Now()
+	if err := copyFileContents(context.Background(), src, filepath.Join(dir, "dest"), info, 0, limiter, nil); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	// The first read goes straight through, the other three wait their turn
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("Expected 256 KB at 512 KB/s to take about half a second, took %v", elapsed)
+	}
+
+	if newBandwidthLimiter(&BandwidthConfig{}, nil) != nil || newBandwidthLimiter(nil, nil) != nil {
+		t.Error("Expected no limiter without limits")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	limiter = newBandwidthLimiter(&BandwidthConfig{Limit: 1}, nil)
+	limiter.wait(ctx, 1024)
+	if err := limiter.wait(ctx, 1024); err == nil {
+		t.Error("Expected waiting to stop when the run is cancelled")
+	}
+}
@@ -0,0 +1,131 @@
+package dirsync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sides of a pair that can be browsed
+const (
+	BrowseSource      = "src"
+	BrowseDestination = "dst"
+)
+
+// ErrOutsideRoot is returned by Browse for paths that lead out of the
+// pair's source or destination, including through symlinks
+var ErrOutsideRoot = errors.New("path is outside the pair's directories")
+
+// ErrUnknownSide is returned by Browse for a side other than src or dst
+var ErrUnknownSide = errors.New("side must be src or dst")
+
+// ErrNotDirectory is returned by Browse for paths that aren't directories
+var ErrNotDirectory = errors.New("path is not a directory")
+
+// Listing is the contents of a directory in a pair's source or destination
+type Listing struct {
+	SyncID string `json:"sync_id"`
+	Side   string `json:"side"`
+	Root   string `json:"root"`
+
+	// Path is the listed directory relative to Root, with forward slashes
+	// and "" for the root itself
+	Path    string         `json:"path"`
+	Entries []ListingEntry `json:"entries"`
+}
+
+// ListingEntry is a file or directory in a listing
+type ListingEntry struct {
+	Name    string    `json:"name"`
+	IsDir   bool      `json:"is_dir,omitempty"`
+	Symlink bool      `json:"symlink,omitempty"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Browse lists the directory at path, relative to the pair's source or
+// destination depending on side. Directories are listed first, and each
+// group is sorted by name.
+func (s *Sync) Browse(side, path string) (*Listing, error) {
+	var root string
+	switch side {
+	case BrowseSource, "source":
+		side, root = BrowseSource, s.SourcePath
+	case BrowseDestination, "dest", "destination":
+		side, root = BrowseDestination, s.DestinationPath
+	default:
+		return nil, ErrUnknownSide
+	}
+
+	dir, rel, err := resolveWithin(root, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info, err := os.Stat(dir); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, ErrNotDirectory
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	listing := &Listing{SyncID: s.ID, Side: side, Root: root, Path: rel, Entries: []ListingEntry{}}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			// Removed while listing
+			continue
+		}
+		item := ListingEntry{
+			Name:    entry.Name(),
+			IsDir:   info.IsDir(),
+			Symlink: info.Mode()&os.ModeSymlink != 0,
+			ModTime: info.ModTime(),
+		}
+		if item.Symlink {
+			// Show links to directories as directories, so they can be opened
+			if target, err := os.Stat(filepath.Join(dir, entry.Name())); err == nil {
+				item.IsDir = target.IsDir()
+			}
+		}
+		if !item.IsDir {
+			item.Size = info.Size()
+		}
+		listing.Entries = append(listing.Entries, item)
+	}
+
+	sort.Slice(listing.Entries, func(i, j int) bool {
+		a, b := listing.Entries[i], listing.Entries[j]
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		return a.Name < b.Name
+	})
+	return listing, nil
+}
+
+// resolveWithin returns the real path of path relative to root, and the
+// cleaned relative path, or ErrOutsideRoot if it leads out of root
+func resolveWithin(root, path string) (string, string, error) {
+	rel := strings.TrimPrefix(filepath.Clean("/"+filepath.FromSlash(path)), string(filepath.Separator))
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", "", err
+	}
+	real, err := filepath.EvalSymlinks(filepath.Join(realRoot, rel))
+	if err != nil {
+		return "", "", err
+	}
+	if within, err := filepath.Rel(realRoot, real); err != nil || within == ".." || strings.HasPrefix(within, ".."+string(filepath.Separator)) {
+		return "", "", ErrOutsideRoot
+	}
+	return real, filepath.ToSlash(rel), nil
+}
@@ -0,0 +1,55 @@
+package dirsync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestBrowse tests listing directories in a pair's source and destination
+func TestBrowse(t *testing.T) {
+	destDir := t.TempDir()
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(destDir, "backup.txt"), []byte("backup"), 0644)
+	os.Symlink(outside, filepath.Join(destDir, "escape"))
+	s := NewSync(testSourceDir, destDir, 60)
+
+	listing, err := s.Browse("src", "")
+	if err != nil {
+		t.Fatalf("Browse failed: %v", err)
+	}
+	var names []string
+	for _, entry := range listing.Entries {
+		names = append(names, entry.Name)
+	}
+	if len(names) != 3 || names[0] != "subdir" || names[1] != "file1.txt" || !listing.Entries[0].IsDir {
+		t.Errorf("Expected the subdirectory first and then the files, got %v", names)
+	}
+	if listing.Entries[1].Size != int64(len("Test file 1 content")) {
+		t.Errorf("Expected file sizes, got %+v", listing.Entries[1])
+	}
+
+	listing, err = s.Browse("src", "../subdir")
+	if err != nil || listing.Path != "subdir" || len(listing.Entries) != 1 || listing.Entries[0].Name != "file3.txt" {
+		t.Errorf("Expected .. to stop at the root, got %+v (%v)", listing, err)
+	}
+
+	listing, err = s.Browse("dst", "/")
+	if err != nil || listing.Side != BrowseDestination || len(listing.Entries) != 2 {
+		t.Errorf("Expected the destination's entries, got %+v (%v)", listing, err)
+	}
+
+	if _, err := s.Browse("dst", "escape"); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("Expected a symlink out of the destination to be rejected, got %v", err)
+	}
+	if _, err := s.Browse("dst", "backup.txt"); !errors.Is(err, ErrNotDirectory) {
+		t.Errorf("Expected a file to be rejected, got %v", err)
+	}
+	if _, err := s.Browse("dst", "missing"); !os.IsNotExist(err) {
+		t.Errorf("Expected a missing directory to be reported, got %v", err)
+	}
+	if _, err := s.Browse("both", ""); !errors.Is(err, ErrUnknownSide) {
+		t.Errorf("Expected an unknown side to be rejected, got %v", err)
+	}
+}
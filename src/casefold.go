@@ -0,0 +1,256 @@
+package dirsync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// How a pair handles files whose names differ only in case when the
+// destination ignores case, set with case_collisions
+const (
+	// caseCollisionsFail copies the first of the files and lists the others
+	// as failures, so the run fails until the source is fixed (default)
+	caseCollisionsFail = "fail"
+
+	// caseCollisionsRename copies the others under names with a number
+	caseCollisionsRename = "rename"
+
+	// caseCollisionsIgnore copies them all, so the last one copied wins
+	caseCollisionsIgnore = "ignore"
+)
+
+// CaseCollisionModes are the valid case_collisions settings
+var CaseCollisionModes = []string{caseCollisionsFail, caseCollisionsRename, caseCollisionsIgnore}
+
+// ValidCaseCollisions reports whether mode is a known case_collisions
+// setting; empty means the default
+func ValidCaseCollisions(mode string) bool {
+	if mode == "" {
+		return true
+	}
+	for _, m := range CaseCollisionModes {
+		if mode == m {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrorClassCaseCollision is the class of files left out because their
+// names differ only in case from another file's
+const ErrorClassCaseCollision = "case_collision"
+
+// foldName returns the name case-insensitive file systems see
+func foldName(name string) string {
+	return strings.ToLower(name)
+}
+
+// swapCase swaps upper and lower case letters
+func swapCase(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsUpper(r) {
+			return unicode.ToLower(r)
+		}
+		return unicode.ToUpper(r)
+	}, name)
+}
+
+// caseInsensitive reports whether dir is on a file system that ignores
+// case, like the defaults on macOS and Windows. It looks up an existing
+// entry with its case swapped, or if there is none and probe is set, a
+// temporary file.
+func caseInsensitive(dir string, probe bool) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		swapped := swapCase(entry.Name())
+		if swapped == entry.Name() {
+			continue
+		}
+		info, err := os.Lstat(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		other, err := os.Lstat(filepath.Join(dir, swapped))
+		return err == nil && os.SameFile(info, other)
+	}
+
+	if !probe {
+		return false
+	}
+	f, err := os.CreateTemp(dir, ".dirsync-case-probe-")
+	if err != nil {
+		return false
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	_, err = os.Lstat(filepath.Join(dir, swapCase(filepath.Base(f.Name()))))
+	return err == nil
+}
+
+// hasCaseCollisions reports whether any directory below dir holds names
+// that differ only in case
+func hasCaseCollisions(dir string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		key := foldName(entry.Name())
+		if seen[key] {
+			return true, nil
+		}
+		seen[key] = true
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		found, err := hasCaseCollisions(filepath.Join(dir, entry.Name()))
+		if found || err != nil {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// caseCollisionsMatter reports whether the source holds names that differ
+// only in case and the destination ignores case, so rsync would copy one
+// over the other
+func (s *Sync) caseCollisionsMatter() bool {
+	if s.Pair.CaseCollisions == caseCollisionsIgnore || !caseInsensitive(s.runDestination(), !s.Pair.DryRun) {
+		return false
+	}
+	found, err := hasCaseCollisions(s.SourcePath)
+	return found && err == nil
+}
+
+// resolveCaseCollisions finds the entries of the source directory at rel
+// whose names differ only in case from an earlier entry's, when the
+// destination ignores case. With case_collisions set to rename they are
+// given new names; otherwise they are recorded as failures and returned to
+// be skipped.
+func (c *fileCopier) resolveCaseCollisions(rel string, entries []os.DirEntry) (map[string]bool, error) {
+	if !c.caseFold || c.sync.Pair.CaseCollisions == caseCollisionsIgnore {
+		return nil, nil
+	}
+
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	colliding, renamed := caseCollisions(names)
+
+	var skip map[string]bool
+	for _, name := range names {
+		other, ok := colliding[name]
+		if !ok {
+			continue
+		}
+
+		entryRel := filepath.Join(rel, name)
+		if c.sync.Pair.CaseCollisions == caseCollisionsRename {
+			c.setRename(entryRel, renamed[name])
+			c.sync.appendOutput(fmt.Sprintf("%s differs only in case from %s, copying it as %s", filepath.ToSlash(entryRel), other, renamed[name]))
+			continue
+		}
+
+		if skip == nil {
+			skip = make(map[string]bool)
+		}
+		skip[name] = true
+		path := filepath.ToSlash(entryRel)
+		msg := "name differs only in case from " + other
+		c.sync.appendOutput("failed: " + path + ": " + msg)
+		if err := c.sync.recordFailure(FileFailure{Path: path, Error: msg, ErrorClass: ErrorClassCaseCollision}); err != nil {
+			return nil, err
+		}
+	}
+	return skip, nil
+}
+
+// caseCollisions finds the names, in the order given, that differ only in
+// case from an earlier one. It returns the earlier name each collides with,
+// and the name each gets with case_collisions set to rename.
+func caseCollisions(names []string) (colliding, renamed map[string]string) {
+	taken := make(map[string]string, len(names))
+	for _, name := range names {
+		if _, ok := taken[foldName(name)]; !ok {
+			taken[foldName(name)] = name
+		}
+	}
+
+	for _, name := range names {
+		other := taken[foldName(name)]
+		if other == name {
+			continue
+		}
+		if colliding == nil {
+			colliding, renamed = make(map[string]string), make(map[string]string)
+		}
+		colliding[name] = other
+		renamed[name] = collisionName(name, taken)
+	}
+	return colliding, renamed
+}
+
+// collisionName returns a name for a file that collides with another in
+// case, like "Report (2).txt", that is free in taken, and takes it
+func collisionName(name string, taken map[string]string) string {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", stem, n, ext)
+		if _, ok := taken[foldName(candidate)]; !ok {
+			taken[foldName(candidate)] = candidate
+			return candidate
+		}
+	}
+}
+
+// setRename records the destination name of the entry at rel
+func (c *fileCopier) setRename(rel, name string) {
+	c.renameMu.Lock()
+	defer c.renameMu.Unlock()
+	if c.renames == nil {
+		c.renames = make(map[string]string)
+	}
+	c.renames[rel] = name
+}
+
+// renamedRel returns rel with any renamed entries along it replaced by
+// their destination names
+func (c *fileCopier) renamedRel(rel string) string {
+	c.renameMu.RLock()
+	defer c.renameMu.RUnlock()
+	if len(c.renames) == 0 {
+		return rel
+	}
+
+	parts := strings.Split(rel, string(filepath.Separator))
+	renamed := make([]string, len(parts))
+	for i := range parts {
+		renamed[i] = parts[i]
+		if name, ok := c.renames[filepath.Join(parts[:i+1]...)]; ok {
+			renamed[i] = name
+		}
+	}
+	return filepath.Join(renamed...)
+}
+
+// nameKey returns the name by which destination entries are matched with
+// source entries, ignoring case when the destination does
+func (c *fileCopier) nameKey(name string) string {
+	if c.caseFold {
+		return foldName(name)
+	}
+	return name
+}
@@ -0,0 +1,99 @@
+package dirsync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFiles creates files under dir with their names as content
+func writeFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+}
+
+// TestCaseCollisions tests that files differing only in case fail or are
+// renamed when the destination ignores case
+func TestCaseCollisions(t *testing.T) {
+	sourceDir := t.TempDir()
+	writeFiles(t, sourceDir, "Report.txt", "report.txt", "Docs/a.txt", "docs/b.txt")
+
+	// By default the first name is copied and the others fail
+	s := NewSync(sourceDir, t.TempDir(), 60)
+	c := newFileCopier(s, sourceDir, s.DestinationPath)
+	c.caseFold = true
+	if err := c.copyDir(""); err != nil {
+		t.Fatalf("copyDir failed: %v", err)
+	}
+	if s.stats.FailureCount != 2 || s.stats.Failures[0].ErrorClass != ErrorClassCaseCollision {
+		t.Errorf("Expected the colliding names to fail, got %+v", s.stats.Failures)
+	}
+	if _, err := os.Stat(filepath.Join(s.DestinationPath, "report.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected report.txt not to be copied over Report.txt")
+	}
+
+	// Renamed files get a number, avoiding names already in use
+	writeFiles(t, sourceDir, "Report (2).txt")
+	s = NewSync(sourceDir, t.TempDir(), 60)
+	s.Pair.CaseCollisions = caseCollisionsRename
+	c = newFileCopier(s, sourceDir, s.DestinationPath)
+	c.caseFold = true
+	if err := c.copyDir(""); err != nil {
+		t.Fatalf("copyDir failed: %v", err)
+	}
+	for path, content := range map[string]string{"Report.txt": "Report.txt", "report (3).txt": "report.txt", "docs (2)/b.txt": "docs/b.txt"} {
+		data, err := os.ReadFile(filepath.Join(s.DestinationPath, path))
+		if err != nil || string(data) != content {
+			t.Errorf("Expected %s to hold %s, got %q (%v)", path, content, data, err)
+		}
+	}
+	if s.stats.FailureCount != 0 {
+		t.Errorf("Expected no failures when renaming, got %+v", s.stats.Failures)
+	}
+
+	// Deleting keeps renamed files, and files whose name only changed case
+	writeFiles(t, s.DestinationPath, "REPORT (2).TXT")
+	s.Pair.Delete = true
+	c = newFileCopier(s, sourceDir, s.DestinationPath)
+	c.caseFold = true
+	if deleted, _, err := c.countDeletions(""); err != nil || deleted != 0 {
+		t.Errorf("Expected nothing to be deleted, got %d (%v)", deleted, err)
+	}
+	if err := c.copyDir(""); err != nil {
+		t.Fatalf("copyDir failed: %v", err)
+	}
+	for _, path := range []string{"REPORT (2).TXT", "report (3).txt", "docs (2)/b.txt"} {
+		if _, err := os.Stat(filepath.Join(s.DestinationPath, path)); err != nil {
+			t.Errorf("Expected %s to be kept: %v", path, err)
+		}
+	}
+}
+
+// TestCaseInsensitive tests telling whether a directory ignores case
+func TestCaseInsensitive(t *testing.T) {
+	dir := t.TempDir()
+	want := caseInsensitive(dir, true)
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected the probe file to be removed, got %d entries", len(entries))
+	}
+
+	writeFiles(t, dir, "a.txt")
+	_, err := os.Stat(filepath.Join(dir, "A.TXT"))
+	if got := caseInsensitive(dir, false); got != (err == nil) || got != want {
+		t.Errorf("Expected %v, got %v", err == nil, got)
+	}
+
+	if found, err := hasCaseCollisions(dir); found || err != nil {
+		t.Errorf("Expected no collisions, got %v (%v)", found, err)
+	}
+	writeFiles(t, dir, "sub/x.txt", "sub/X.txt")
+	if found, _ := hasCaseCollisions(dir); found != !want {
+		t.Errorf("Expected collisions on a case-sensitive file system")
+	}
+}
@@ -0,0 +1,64 @@
+package dirsync
+
+// chainSchedule returns the schedule of a pair. Pairs that run after other
+// pairs and have no schedule of their own only run when those pairs are
+// done, or when triggered.
+func chainSchedule(pair PairConfig) *ScheduleConfig {
+	if pair.Schedule == nil && len(pair.After) > 0 {
+		return &ScheduleConfig{Type: scheduleManual}
+	}
+	return pair.Schedule
+}
+
+// upstreamFinished records a successful run of one of the pairs s runs
+// after, as it is listed in After, and starts s once all of them have succeeded since its last run
+// started. Paused and disabled pairs note the run without starting.
+func (s *Sync) upstreamFinished(syncID string) {
+	s.mu.Lock()
+	if s.upstreamDone == nil {
+		s.upstreamDone = make(map[string]bool)
+	}
+	s.upstreamDone[syncID] = true
+	ready := !s.Paused && !s.disabled && len(s.waitingFor()) == 0
+	s.mu.Unlock()
+
+	if ready {
+		s.TriggerSync()
+	}
+}
+
+// waitingFor returns the pairs s runs after that haven't succeeded since its
+// last run started. The caller must hold s.mu.
+func (s *Sync) waitingFor() []string {
+	var waiting []string
+	for _, id := range s.Pair.After {
+		if !s.upstreamDone[id] {
+			waiting = append(waiting, id)
+		}
+	}
+	return waiting
+}
+
+// chainRun starts the pairs that run after the pair of a finished run, if it
+// succeeded. Skipped runs changed nothing, so they start nothing.
+func (sm *SyncManager) chainRun(run RunRecord) {
+	if !run.Success || run.Skipped != "" {
+		return
+	}
+
+	upstream := sm.GetSyncByID(run.SyncID)
+
+	sm.mu.RLock()
+	syncs := append([]*Sync(nil), sm.Syncs...)
+	sm.mu.RUnlock()
+
+	// Pairs may be listed by the paths they had before they were named
+	for _, s := range syncs {
+		for _, id := range s.Pair.After {
+			if id == run.SyncID || (upstream != nil && upstream.hasID(id)) {
+				s.upstreamFinished(id)
+				break
+			}
+		}
+	}
+}
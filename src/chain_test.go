@@ -0,0 +1,73 @@
+package dirsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestChainedPairs tests that a pair running after another starts once its
+// run succeeds, copying what the first pair staged
+func TestChainedPairs(t *testing.T) {
+	staging, archive := t.TempDir(), t.TempDir()
+	manager := NewSyncManager(Options{})
+	first := manager.AddSync(testSourceDir, staging, 3600)
+	second := manager.AddPair(PairConfig{Source: staging, Destination: archive, After: []string{first.ID}}, 3600)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		manager.Wait()
+	}()
+	manager.Start(ctx, 3600)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(manager.History.ListRuns(second.ID)) == 0 || second.GetStatus()["is_syncing"].(bool) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the chained pair to run, got %+v", second.GetStatus())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	firstRun := manager.History.ListRuns(first.ID)[0]
+	secondRun := manager.History.ListRuns(second.ID)[0]
+	if !secondRun.Success || secondRun.StartTime.Before(firstRun.EndTime) {
+		t.Errorf("Expected the chained run to start after the first finished, got %+v and %+v", firstRun, secondRun)
+	}
+	if _, err := os.Stat(filepath.Join(archive, "file1.txt")); err != nil {
+		t.Errorf("Expected the staged files in the archive: %v", err)
+	}
+}
+
+// TestChainWaitsForAllUpstream tests that a pair after several others only
+// starts once all of them succeeded, and not after failures or skipped runs
+func TestChainWaitsForAllUpstream(t *testing.T) {
+	manager := NewSyncManager(Options{})
+	s := manager.AddPair(PairConfig{Source: testSourceDir, Destination: t.TempDir(), After: []string{"a", "b"}}, 60)
+	s.NextSyncTime = time.Time{}
+
+	manager.chainRun(RunRecord{SyncID: "a", Success: false})
+	manager.chainRun(RunRecord{SyncID: "b", Success: true, Skipped: "maintenance"})
+	if waiting := s.GetStatus()["waiting_for"].([]string); len(waiting) != 2 {
+		t.Errorf("Expected to wait for both pairs, got %v", waiting)
+	}
+
+	manager.chainRun(RunRecord{SyncID: "a", Success: true})
+	if waiting := s.GetStatus()["waiting_for"].([]string); len(waiting) != 1 || waiting[0] != "b" {
+		t.Errorf("Expected to wait for b, got %v", waiting)
+	}
+	if !s.NextSyncTime.IsZero() {
+		t.Errorf("Expected no run before b succeeds")
+	}
+
+	manager.chainRun(RunRecord{SyncID: "b", Success: true})
+	if s.NextSyncTime.IsZero() {
+		t.Errorf("Expected a run once both succeeded")
+	}
+
+	if schedule := chainSchedule(s.Pair); schedule == nil || schedule.Type != scheduleManual {
+		t.Errorf("Expected a chained pair to wait for triggers, got %+v", schedule)
+	}
+}
@@ -0,0 +1,116 @@
+package dirsync
+
+import "strings"
+
+// defaultChangeLimit is how many changed paths a run records when no limit
+// is configured
+const defaultChangeLimit = 100
+
+// What a run did to a path in the destination
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	ChangeDeleted = "deleted"
+)
+
+// Change is a path a run created, updated or deleted in the destination,
+// relative to the root of the pair
+type Change struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+
+	// Attributes lists what changed about an updated path, such as "size"
+	// and "time", when rsync reports it
+	Attributes []string `json:"attributes,omitempty"`
+}
+
+// String describes the change for the sync's output
+func (c Change) String() string {
+	switch {
+	case c.Action == ChangeDeleted:
+		return "deleting " + c.Path
+	case len(c.Attributes) > 0:
+		return c.Path + " (" + c.Action + ": " + strings.Join(c.Attributes, ", ") + ")"
+	}
+	return c.Path + " (" + c.Action + ")"
+}
+
+// changeAction returns the action for writing a path that existed or not
+func changeAction(existed bool) string {
+	if existed {
+		return ChangeUpdated
+	}
+	return ChangeCreated
+}
+
+// changeLimit returns how many changes a run records, 0 if none
+func (s *Sync) changeLimit() int {
+	switch {
+	case s.maxChanges < 0:
+		return 0
+	case s.maxChanges == 0:
+		return defaultChangeLimit
+	}
+	return s.maxChanges
+}
+
+// recordChange adds a changed path to the run's change list. Every change
+// is counted, but only the first changeLimit are kept.
+func (s *Sync) recordChange(path, action string) {
+	s.addChange(Change{Path: path, Action: action})
+}
+
+// addChange adds a change to the run's change list, like recordChange
+func (s *Sync) addChange(change Change) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.ChangeCount++
+	if change.Action != ChangeDeleted {
+		s.stats.FilesCopied++
+	}
+	if len(s.stats.Changes) < s.changeLimit() {
+		s.stats.Changes = append(s.stats.Changes, change)
+	}
+}
+
+// itemizedAttributes names the attribute columns of rsync's itemized output
+// after the update type and file type, e.g. the "st" in ">f.st......"
+var itemizedAttributes = []string{"checksum", "size", "time", "permissions", "owner", "group", "access time", "acl", "xattr"}
+
+// parseItemizedChange parses a line of rsync's itemized output in the
+// "%i %n%L" format, such as ">f+++++++++ docs/new.txt",
+// ">f.st...... docs/changed.txt" or "*deleting   docs/old.txt". Lines for
+// paths whose content didn't change and other output are ignored.
+func parseItemizedChange(line string) (Change, bool) {
+	if path, ok := strings.CutPrefix(line, "*deleting "); ok {
+		return Change{Path: strings.TrimSuffix(strings.TrimSpace(path), "/"), Action: ChangeDeleted}, true
+	}
+
+	flags, path, ok := strings.Cut(line, " ")
+	if !ok || len(flags) < 9 || len(flags) > 11 || path == "" {
+		return Change{}, false
+	}
+	if !strings.ContainsRune("<>ch", rune(flags[0])) || !strings.ContainsRune("fdLDS", rune(flags[1])) {
+		return Change{}, false
+	}
+
+	// Symlinks are listed with their target
+	if flags[1] == 'L' {
+		path, _, _ = strings.Cut(path, " -> ")
+	}
+	// Directories are only interesting when they are created
+	created := strings.Trim(flags[2:], "+") == ""
+	if flags[1] == 'd' && !created {
+		return Change{}, false
+	}
+
+	change := Change{Path: strings.TrimSuffix(path, "/"), Action: changeAction(!created)}
+	if !created {
+		for i, flag := range flags[2:] {
+			if flag != '.' && flag != ' ' && i < len(itemizedAttributes) {
+				change.Attributes = append(change.Attributes, itemizedAttributes[i])
+			}
+		}
+	}
+	return change, true
+}
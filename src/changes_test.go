@@ -0,0 +1,77 @@
+package dirsync
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestParseItemizedChange tests reading changes from rsync's itemized output
+func TestParseItemizedChange(t *testing.T) {
+	tests := []struct {
+		line string
+		want Change
+		ok   bool
+	}{
+		{">f+++++++++ docs/new.txt", Change{Path: "docs/new.txt", Action: ChangeCreated}, true},
+		{">f.st...... docs/changed.txt", Change{"docs/changed.txt", ChangeUpdated, []string{"size", "time"}}, true},
+		{">fc.T...... docs/rewritten.txt", Change{"docs/rewritten.txt", ChangeUpdated, []string{"checksum", "time"}}, true},
+		{"*deleting   docs/old.txt", Change{Path: "docs/old.txt", Action: ChangeDeleted}, true},
+		{"cd+++++++++ docs/", Change{Path: "docs", Action: ChangeCreated}, true},
+		{"cL+++++++++ link -> target", Change{Path: "link", Action: ChangeCreated}, true},
+		{".d..t...... docs/", Change{}, false},
+		{"sending incremental file list", Change{}, false},
+		{"        32,768   1%    1.20MB/s    0:00:10", Change{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseItemizedChange(tt.line)
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseItemizedChange(%q) = %+v, %v, want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	change, _ := parseItemizedChange(">f.st...... docs/changed.txt")
+	if got := change.String(); got != "docs/changed.txt (updated: size, time)" {
+		t.Errorf("Unexpected change description %q", got)
+	}
+}
+
+// TestRunRecordsChanges tests that the built-in copier's changes end up in
+// the run's record, up to the change limit
+func TestRunRecordsChanges(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	os.WriteFile(filepath.Join(sourceDir, "kept.txt"), []byte("v1"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "removed.txt"), []byte("gone soon"), 0644)
+
+	manager := NewSyncManager(Options{})
+	s := manager.AddPair(PairConfig{Source: sourceDir, Destination: destDir, Delete: true}, 60)
+	if err := s.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	os.WriteFile(filepath.Join(sourceDir, "kept.txt"), []byte("version 2"), 0644)
+	os.Remove(filepath.Join(sourceDir, "removed.txt"))
+	if err := s.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	runs := manager.History.ListRuns(s.ID)
+	if first := runs[0]; first.ChangeCount != 2 || first.Changes[0].Action != ChangeCreated {
+		t.Errorf("Expected two created files in the first run, got %+v", first.Changes)
+	}
+	want := []Change{{Path: "kept.txt", Action: ChangeUpdated}, {Path: "removed.txt", Action: ChangeDeleted}}
+	if second := runs[1]; !reflect.DeepEqual(second.Changes, want) {
+		t.Errorf("Expected %+v in the second run, got %+v", want, second.Changes)
+	}
+
+	// Only the first changes are kept, but all are counted
+	s.maxChanges = 1
+	os.WriteFile(filepath.Join(sourceDir, "a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "b.txt"), []byte("b"), 0644)
+	s.SyncDirectories()
+	if third := manager.History.ListRuns(s.ID)[2]; len(third.Changes) != 1 || third.ChangeCount != 2 {
+		t.Errorf("Expected 1 of 2 changes to be kept, got %+v (%d)", third.Changes, third.ChangeCount)
+	}
+}
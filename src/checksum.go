@@ -0,0 +1,212 @@
+package dirsync
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// checksumCacheFile records the hashes of files compared by content, in the
+// root of the destination
+const checksumCacheFile = ".dirsync-checksums.json"
+
+// checksumEntry is the hash of a file as it was when it was hashed
+type checksumEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Hash    string    `json:"hash"`
+}
+
+// checksumCache remembers the hashes of source and destination files
+// between runs, so pairs that compare files by content only read files
+// whose size or modification time changed since they were hashed
+type checksumCache struct {
+	Files map[string]checksumEntry `json:"files"`
+
+	// Algorithm is the hash algorithm of the hashes
+	Algorithm string `json:"algorithm"`
+
+	path  string
+	seen  map[string]bool
+	dirty bool
+	mu    sync.Mutex
+}
+
+// loadChecksumCache reads the checksum cache from the destination, starting
+// an empty one if there is none or it holds hashes of another algorithm
+func loadChecksumCache(dest, algorithm string) *checksumCache {
+	cc := &checksumCache{
+		Files: make(map[string]checksumEntry),
+		path:  filepath.Join(dest, checksumCacheFile),
+		seen:  make(map[string]bool),
+	}
+
+	if data, err := os.ReadFile(cc.path); err == nil {
+		json.Unmarshal(data, cc)
+		if cc.Files == nil || cc.Algorithm != algorithm {
+			cc.Files = make(map[string]checksumEntry)
+			cc.dirty = true
+		}
+	}
+	cc.Algorithm = algorithm
+
+	return cc
+}
+
+// hash returns the hash of the file at path, from the cache if the file
+// has the same size and modification time as when it was hashed
+func (cc *checksumCache) hash(path string, info os.FileInfo) (string, error) {
+	cc.mu.Lock()
+	cc.seen[path] = true
+	entry, ok := cc.Files[path]
+	cc.mu.Unlock()
+	if ok && entry.Size == info.Size() && entry.ModTime.Equal(info.ModTime()) {
+		return entry.Hash, nil
+	}
+
+	b, err := hashFile(path, cc.Algorithm)
+	if err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(b)
+	cc.record(path, info, sum)
+	return sum, nil
+}
+
+// sha256 returns the SHA-256 of the file at path, from the cache if it
+// holds SHA-256 hashes. Without a cache the file is always read.
+func (cc *checksumCache) sha256(path string, info os.FileInfo) (string, error) {
+	if cc == nil || cc.Algorithm != hashSHA256 {
+		return hashContent(path)
+	}
+	return cc.hash(path, info)
+}
+
+// record remembers the hash of the file at path as it is now
+func (cc *checksumCache) record(path string, info os.FileInfo, sum string) {
+	if cc == nil {
+		return
+	}
+
+	cc.mu.Lock()
+	cc.Files[path] = checksumEntry{Size: info.Size(), ModTime: info.ModTime(), Hash: sum}
+	cc.seen[path] = true
+	cc.dirty = true
+	cc.mu.Unlock()
+}
+
+// prune forgets files that weren't seen during a complete run, because they
+// no longer exist or are no longer compared
+func (cc *checksumCache) prune() {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	for path := range cc.Files {
+		if !cc.seen[path] {
+			delete(cc.Files, path)
+			cc.dirty = true
+		}
+	}
+}
+
+// save writes the cache back to the destination if it changed
+func (cc *checksumCache) save() error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if !cc.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(cc)
+	if err != nil {
+		return err
+	}
+
+	tmp := cc.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, cc.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	cc.dirty = false
+	return nil
+}
+
+// matchModTime gives a destination file with the source's content the
+// source's modification time, keeping its cached hash
+func (c *fileCopier) matchModTime(srcPath, destPath string, srcInfo os.FileInfo) {
+	sum, err := c.checksums.hash(srcPath, srcInfo)
+	if err != nil || os.Chtimes(destPath, srcInfo.ModTime(), srcInfo.ModTime()) != nil {
+		return
+	}
+	if info, err := os.Lstat(destPath); err == nil {
+		c.checksums.record(destPath, info, sum)
+	}
+}
+
+// recordChecksums remembers the hash of a file just copied, for both the
+// source and the destination
+func (c *fileCopier) recordChecksums(srcPath, destPath string, srcInfo os.FileInfo, sum string) {
+	c.checksums.record(srcPath, srcInfo, sum)
+	if info, err := os.Lstat(destPath); err == nil {
+		c.checksums.record(destPath, info, sum)
+	}
+}
+
+// hashWorkers returns how many files a checksum pair compares in parallel:
+// hash_workers, or one per CPU, and never fewer than its copy workers
+func hashWorkers(pair PairConfig, copyWorkers int) int {
+	workers := pair.HashWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers < copyWorkers {
+		workers = copyWorkers
+	}
+	return workers
+}
+
+// fileSlots returns the workers files are handed to: the hashing workers
+// for checksum pairs, otherwise the copy workers
+func (c *fileCopier) fileSlots() chan struct{} {
+	if c.hashers != nil {
+		return c.hashers
+	}
+	return c.workers
+}
+
+// copySlot takes a copy worker for a file that was compared on a hashing
+// worker and needs copying, returning the function that gives it back.
+// Files handed to the copy workers already hold one.
+func (c *fileCopier) copySlot() func() {
+	if c.hashers == nil {
+		return func() {}
+	}
+	c.workers <- struct{}{}
+	return func() { <-c.workers }
+}
+
+// sameContent reports whether the source and destination files hold the
+// same content, hashing each unless the cache knows its hash
+func (c *fileCopier) sameContent(srcPath, destPath string, srcInfo, destInfo os.FileInfo) (bool, error) {
+	if srcInfo.Size() != destInfo.Size() {
+		return false, nil
+	}
+	srcSum, err := c.checksums.hash(srcPath, srcInfo)
+	if err != nil {
+		return false, err
+	}
+	destSum, err := c.checksums.hash(destPath, destInfo)
+	if err != nil {
+		return false, err
+	}
+	return srcSum == destSum, nil
+}
@@ -0,0 +1,107 @@
+package dirsync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// TestSyncWithChecksum tests comparing files by content, with their hashes
+// cached between runs
+func TestSyncWithChecksum(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	srcPath := filepath.Join(sourceDir, "a.txt")
+	destPath := filepath.Join(destDir, "a.txt")
+	os.WriteFile(srcPath, []byte("source"), 0644)
+
+	// Same size and modification time, but different content
+	info, _ := os.Stat(srcPath)
+	os.WriteFile(destPath, []byte("stale!"), 0644)
+	os.Chtimes(destPath, info.ModTime(), info.ModTime())
+
+	testSync := NewSync(sourceDir, destDir, 60)
+	testSync.Pair.Checksum = true
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if data, _ := os.ReadFile(destPath); string(data) != "source" {
+		t.Errorf("Expected different content to be copied, got %q", data)
+	}
+
+	cache := loadChecksumCache(destDir, hashSHA256)
+	if len(cache.Files[srcPath].Hash) != 64 || cache.Files[destPath].Hash != cache.Files[srcPath].Hash {
+		t.Fatalf("Expected both copies of a.txt in the cache, got %+v", cache.Files)
+	}
+
+	// Same content with another modification time only gets the time
+	later := time.Now().Add(time.Hour).Truncate(time.Second)
+	os.Chtimes(srcPath, later, later)
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if info, _ := os.Stat(destPath); !info.ModTime().Equal(later) {
+		t.Errorf("Expected the modification time to be updated, got %v", info.ModTime())
+	}
+	if data, _ := os.ReadFile(destPath); string(data) != "source" {
+		t.Errorf("Expected the content to be left alone, got %q", data)
+	}
+
+	// Unchanged files aren't hashed again: a cached hash that no longer
+	// matches the content is believed
+	cache = loadChecksumCache(destDir, hashSHA256)
+	entry := cache.Files[srcPath]
+	entry.Hash = "0000"
+	cache.Files[srcPath] = entry
+	cache.dirty = true
+	cache.save()
+	os.WriteFile(destPath, []byte("SOURCE"), 0644)
+	os.Chtimes(destPath, later, later)
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if data, _ := os.ReadFile(destPath); string(data) != "source" {
+		t.Errorf("Expected the cached hash to be used, got %q", data)
+	}
+}
+
+// TestChecksumHashWorkers tests comparing files on more workers than copy
+// them
+func TestChecksumHashWorkers(t *testing.T) {
+	if got := hashWorkers(PairConfig{}, 1); got != runtime.NumCPU() {
+		t.Errorf("Expected one hash worker per CPU, got %d", got)
+	}
+	if got := hashWorkers(PairConfig{HashWorkers: 2}, 8); got != 8 {
+		t.Errorf("Expected at least as many hash workers as copy workers, got %d", got)
+	}
+
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("file%d.txt", i)
+		os.WriteFile(filepath.Join(sourceDir, name), []byte(name), 0644)
+		if i%2 == 0 {
+			os.WriteFile(filepath.Join(destDir, name), []byte(name), 0644)
+		}
+	}
+
+	testSync := NewSync(sourceDir, destDir, 60)
+	testSync.Pair.Checksum = true
+	testSync.Pair.CopyWorkers = 1
+	testSync.Pair.HashWorkers = 4
+	if c := newFileCopier(testSync, sourceDir, destDir); cap(c.hashers) != 4 || cap(c.workers) != 1 {
+		t.Errorf("Expected 4 hash workers and 1 copy worker, got %d and %d", cap(c.hashers), cap(c.workers))
+	}
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("file%d.txt", i)
+		if data, _ := os.ReadFile(filepath.Join(destDir, name)); string(data) != name {
+			t.Errorf("Expected %s to be synced, got %q", name, data)
+		}
+	}
+}
@@ -0,0 +1,549 @@
+// Package client is a Go client for the DirSync HTTP API, for controlling a
+// running daemon from other programs.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client talks to a running DirSync daemon
+type Client struct {
+	// BaseURL is the daemon's address, e.g. http://localhost:8080
+	BaseURL string
+
+	// Token is sent as a bearer token if set
+	Token string
+
+	// Username and Password are sent with basic auth if set
+	Username string
+	Password string
+
+	// HTTPClient is used for requests, http.DefaultClient if nil
+	HTTPClient *http.Client
+}
+
+// SyncStatus is the status of a sync pair
+type SyncStatus struct {
+	ID              string    `json:"id"`
+	Name            string    `json:"name"`
+	Tags            []string  `json:"tags"`
+	Profile         string    `json:"profile"`
+	SourcePath      string    `json:"source_path"`
+	DestinationPath string    `json:"destination_path"`
+	IsSyncing       bool      `json:"is_syncing"`
+	Paused          bool      `json:"paused"`
+	Enabled         bool      `json:"enabled"`
+	LastSync        time.Time `json:"last_sync"`
+	NextSyncTime    time.Time `json:"next_sync_time"`
+	Output          string    `json:"output"`
+	LastError       string    `json:"last_error"`
+	FollowUpQueued  bool      `json:"follow_up_queued"`
+
+	// LastErrorClass is the kind of failure of the last run, such as
+	// "disk_full" or "source_missing", if it failed
+	LastErrorClass string `json:"last_error_class,omitempty"`
+
+	// LockedBy is the lock file holding off the pair's runs, if any
+	LockedBy string `json:"locked_by,omitempty"`
+
+	// Totals add up the pair's finished runs
+	Totals PairTotals `json:"totals"`
+
+	// SchedulerRestarts counts how often the daemon's watchdog restarted
+	// the pair's stalled scheduler
+	SchedulerRestarts int `json:"scheduler_restarts"`
+
+	// RunID is the ID of the run in progress, if any
+	RunID string `json:"run_id,omitempty"`
+
+	// Queued is set while the pair's run waits for others to finish,
+	// because of the daemon's max_concurrent_runs
+	Queued bool `json:"queued"`
+
+	// WaitingFor lists the pairs this pair runs after that haven't
+	// succeeded since it last started
+	WaitingFor []string `json:"waiting_for,omitempty"`
+
+	// SyncPhase is "initial" or "incremental" for pairs with incremental
+	// set, and empty for others
+	SyncPhase string `json:"sync_phase,omitempty"`
+}
+
+// PairTotals add up a pair's finished runs, skipped ones aside
+type PairTotals struct {
+	Runs                   int64   `json:"runs"`
+	Failures               int64   `json:"failures"`
+	BytesTransferred       int64   `json:"bytes_transferred"`
+	FilesCopied            int64   `json:"files_copied"`
+	DurationSeconds        float64 `json:"duration_seconds"`
+	AverageDurationSeconds float64 `json:"average_duration_seconds"`
+	FailureRate            float64 `json:"failure_rate"`
+}
+
+// Note is a free-form annotation on a run or pair
+type Note struct {
+	Time time.Time `json:"time"`
+	Text string    `json:"text"`
+}
+
+// Run is a recorded sync run
+type Run struct {
+	ID        string    `json:"id"`
+	SyncID    string    `json:"sync_id"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Success   bool      `json:"success"`
+	Error     string    `json:"error,omitempty"`
+	Notes     []Note    `json:"notes,omitempty"`
+
+	// ErrorClass is the kind of failure, such as "permission" or
+	// "rsync_exit", if the run failed
+	ErrorClass string `json:"error_class,omitempty"`
+
+	BytesTransferred int64 `json:"bytes_transferred,omitempty"`
+	TotalBytes       int64 `json:"total_bytes,omitempty"`
+	FilesCopied      int   `json:"files_copied,omitempty"`
+
+	// Changes are only filled in by Run and Window; ChangeCount counts
+	// all changed paths, including any beyond the daemon's limit
+	Changes     []Change `json:"changes,omitempty"`
+	ChangeCount int      `json:"change_count,omitempty"`
+
+	// Skipped is why the run didn't copy anything, such as a maintenance
+	// window of the destination
+	Skipped string `json:"skipped,omitempty"`
+
+	// RemoteCommands are the commands run on the destination host after the run
+	RemoteCommands []RemoteCommand `json:"remote_commands,omitempty"`
+
+	// FailureCount counts the files the run couldn't copy; Failures lists
+	// them and is only filled in by Run
+	Failures     []FileFailure `json:"failures,omitempty"`
+	FailureCount int           `json:"failure_count,omitempty"`
+
+	// Output is only filled in by Run: what the run printed, or has so far
+	Output string `json:"output,omitempty"`
+}
+
+// Change is a path a run created, updated or deleted
+type Change struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+
+	// Attributes lists what changed about an updated path, such as "size"
+	Attributes []string `json:"attributes,omitempty"`
+}
+
+// RemoteCommand is a command run on the destination host over SSH and
+// what it printed
+type RemoteCommand struct {
+	Command  string `json:"command"`
+	Output   string `json:"output,omitempty"`
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Profiles are the configuration profiles and the one that is active, ""
+// for none
+type Profiles struct {
+	Profile  string   `json:"profile"`
+	Profiles []string `json:"profiles"`
+}
+
+// FileFailure is a file a run couldn't copy, relative to the source root,
+// with the class of the error, such as "permission" or "vanished"
+type FileFailure struct {
+	Path       string `json:"path"`
+	Error      string `json:"error"`
+	ErrorClass string `json:"error_class"`
+}
+
+// RunFailures lists the files a run couldn't copy. FailureCount counts them
+// all, including any beyond the daemon's limit.
+type RunFailures struct {
+	RunID        string        `json:"run_id"`
+	SyncID       string        `json:"sync_id"`
+	FailureCount int           `json:"failure_count"`
+	Failures     []FileFailure `json:"failures"`
+}
+
+// History is the run history returned by the daemon
+type History struct {
+	Runs  []Run  `json:"runs"`
+	Notes []Note `json:"notes,omitempty"`
+}
+
+// Window is what ran during a window of time, returned by the daemon
+type Window struct {
+	From  time.Time      `json:"from"`
+	To    time.Time      `json:"to"`
+	Pairs []PairActivity `json:"pairs"`
+}
+
+// Diff compares a pair's source with its destination, returned by the daemon
+type Diff struct {
+	SyncID      string      `json:"sync_id"`
+	Source      string      `json:"source"`
+	Destination string      `json:"destination"`
+	New         []DiffEntry `json:"new"`
+	Modified    []DiffEntry `json:"modified"`
+	Extra       []DiffEntry `json:"extra"`
+	Conflicting []DiffEntry `json:"conflicting"`
+	BytesToCopy int64       `json:"bytes_to_copy"`
+	Truncated   bool        `json:"truncated,omitempty"`
+}
+
+// DiffEntry is a path that differs between a source and destination
+type DiffEntry struct {
+	Path          string     `json:"path"`
+	IsDir         bool       `json:"is_dir,omitempty"`
+	SourceSize    int64      `json:"source_size,omitempty"`
+	SourceModTime *time.Time `json:"source_mod_time,omitempty"`
+	DestSize      int64      `json:"dest_size,omitempty"`
+	DestModTime   *time.Time `json:"dest_mod_time,omitempty"`
+}
+
+// Listing is the contents of a directory in a pair's source or destination
+type Listing struct {
+	SyncID  string         `json:"sync_id"`
+	Side    string         `json:"side"`
+	Root    string         `json:"root"`
+	Path    string         `json:"path"`
+	Entries []ListingEntry `json:"entries"`
+}
+
+// ListingEntry is a file or directory in a listing
+type ListingEntry struct {
+	Name    string    `json:"name"`
+	IsDir   bool      `json:"is_dir,omitempty"`
+	Symlink bool      `json:"symlink,omitempty"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// PairStats is what a pair's source and destination hold, from the
+// daemon's last scan of them
+type PairStats struct {
+	SyncID      string    `json:"sync_id"`
+	Source      DirStats  `json:"source"`
+	Destination DirStats  `json:"destination"`
+	ScannedAt   time.Time `json:"scanned_at"`
+	Scanning    bool      `json:"scanning,omitempty"`
+}
+
+// DirStats summarizes the files in a directory tree
+type DirStats struct {
+	Path       string      `json:"path"`
+	Files      int64       `json:"files"`
+	Dirs       int64       `json:"dirs"`
+	TotalBytes int64       `json:"total_bytes"`
+	Largest    []FileSize  `json:"largest"`
+	Modified   []AgeBucket `json:"modified"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// FileSize is a file and its size, relative to the scanned directory
+type FileSize struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// AgeBucket counts the files last modified within a day, week, month,
+// year or longer ago
+type AgeBucket struct {
+	Within string `json:"within"`
+	Files  int64  `json:"files"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// Summary adds up the state of all pairs, returned by the daemon
+type Summary struct {
+	Pairs    int        `json:"pairs"`
+	Healthy  int        `json:"healthy"`
+	Failing  int        `json:"failing"`
+	Paused   int        `json:"paused"`
+	Locked   int        `json:"locked"`
+	Disabled int        `json:"disabled"`
+	Health   string     `json:"health"`
+	Running  []string   `json:"running"`
+	Today    DaySummary `json:"today"`
+	LastSync time.Time  `json:"last_sync"`
+}
+
+// DaySummary counts a day's runs across all pairs
+type DaySummary struct {
+	Runs             int   `json:"runs"`
+	Failed           int   `json:"failed"`
+	BytesTransferred int64 `json:"bytes_transferred"`
+}
+
+// PairActivity is what one pair did during a window
+type PairActivity struct {
+	SyncID           string `json:"sync_id"`
+	Runs             []Run  `json:"runs"`
+	Failed           int    `json:"failed"`
+	BytesTransferred int64  `json:"bytes_transferred"`
+}
+
+// APIError is returned when the daemon responds with an error status
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+// Error implements the error interface
+func (e *APIError) Error() string {
+	return fmt.Sprintf("dirsync: %d %s", e.StatusCode, e.Message)
+}
+
+// New creates a client for the daemon at baseURL
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// Status returns the status of all sync pairs
+func (c *Client) Status() ([]SyncStatus, error) {
+	var statuses []SyncStatus
+	err := c.do(http.MethodGet, "/status", nil, nil, &statuses)
+	return statuses, err
+}
+
+// TaggedStatus returns the status of the pairs tagged with tag
+func (c *Client) TaggedStatus(tag string) ([]SyncStatus, error) {
+	var statuses []SyncStatus
+	err := c.do(http.MethodGet, "/status", url.Values{"tag": {tag}}, nil, &statuses)
+	return statuses, err
+}
+
+// Summary returns totals across all pairs: how many are healthy or
+// failing, which are running and what ran today
+func (c *Client) Summary() (*Summary, error) {
+	var summary Summary
+	if err := c.do(http.MethodGet, "/api/summary", nil, nil, &summary); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// Sync returns the status of a single sync pair
+func (c *Client) Sync(id string) (*SyncStatus, error) {
+	var status SyncStatus
+	if err := c.do(http.MethodGet, "/api/sync/details", url.Values{"id": {id}}, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Diff compares the given pair's source with its destination without
+// copying anything
+func (c *Client) Diff(id string) (*Diff, error) {
+	var diff Diff
+	if err := c.do(http.MethodGet, "/api/sync/diff", url.Values{"id": {id}}, nil, &diff); err != nil {
+		return nil, err
+	}
+	return &diff, nil
+}
+
+// Browse lists the directory at path in the given pair's source ("src") or
+// destination ("dst")
+func (c *Client) Browse(id, side, path string) (*Listing, error) {
+	var listing Listing
+	query := url.Values{"id": {id}, "side": {side}, "path": {path}}
+	if err := c.do(http.MethodGet, "/api/browse", query, nil, &listing); err != nil {
+		return nil, err
+	}
+	return &listing, nil
+}
+
+// Stats returns the statistics from the daemon's last scan of the given
+// pair's source and destination. pair is the ID, or the position or slug
+// used for badges. ScannedAt is zero while the first scan is running.
+func (c *Client) Stats(pair string) (*PairStats, error) {
+	var stats PairStats
+	if err := c.do(http.MethodGet, "/api/pairs/"+url.PathEscape(pair)+"/stats", nil, nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// TriggerSync starts a sync of the given pair right away
+func (c *Client) TriggerSync(id string) error {
+	return c.do(http.MethodPost, "/api/sync/now", url.Values{"id": {id}}, nil, nil)
+}
+
+// TriggerAllSyncs starts a sync of every pair right away
+func (c *Client) TriggerAllSyncs() error {
+	return c.do(http.MethodPost, "/api/sync/now", nil, nil, nil)
+}
+
+// TriggerTagged starts a sync of every pair tagged with tag right away
+func (c *Client) TriggerTagged(tag string) error {
+	return c.do(http.MethodPost, "/api/sync/now", url.Values{"tag": {tag}}, nil, nil)
+}
+
+// PauseTagged pauses every pair tagged with tag
+func (c *Client) PauseTagged(tag string) error {
+	return c.do(http.MethodPost, "/api/sync/pause", url.Values{"tag": {tag}}, nil, nil)
+}
+
+// ResumeTagged resumes every pair tagged with tag
+func (c *Client) ResumeTagged(tag string) error {
+	return c.do(http.MethodPost, "/api/sync/resume", url.Values{"tag": {tag}}, nil, nil)
+}
+
+// PauseSync pauses a sync pair
+func (c *Client) PauseSync(id string) error {
+	return c.do(http.MethodPost, "/api/sync/pause", url.Values{"id": {id}}, nil, nil)
+}
+
+// ResumeSync resumes a paused sync pair
+func (c *Client) ResumeSync(id string) error {
+	return c.do(http.MethodPost, "/api/sync/resume", url.Values{"id": {id}}, nil, nil)
+}
+
+// SetEnabled enables or disables a pair, by its position, name like a
+// badge, or ID, and returns its status. Disabled pairs don't run, even when
+// triggered, but keep their history and configuration.
+func (c *Client) SetEnabled(pair string, enabled bool) (*SyncStatus, error) {
+	var status SyncStatus
+	if err := c.do(http.MethodPatch, "/api/pairs/"+url.PathEscape(pair), nil, map[string]bool{"enabled": enabled}, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Profiles returns the configuration profiles and the active one
+func (c *Client) Profiles() (*Profiles, error) {
+	var profiles Profiles
+	if err := c.do(http.MethodGet, "/api/profile", nil, nil, &profiles); err != nil {
+		return nil, err
+	}
+	return &profiles, nil
+}
+
+// SetProfile switches to a configuration profile, or to none for "",
+// enabling its pairs and disabling those of the other profiles
+func (c *Client) SetProfile(profile string) (*Profiles, error) {
+	var profiles Profiles
+	if err := c.do(http.MethodPost, "/api/profile", nil, map[string]string{"profile": profile}, &profiles); err != nil {
+		return nil, err
+	}
+	return &profiles, nil
+}
+
+// History returns the recorded runs, for a single pair if id isn't empty
+func (c *Client) History(id string) (*History, error) {
+	var query url.Values
+	if id != "" {
+		query = url.Values{"id": {id}}
+	}
+
+	var history History
+	if err := c.do(http.MethodGet, "/api/history", query, nil, &history); err != nil {
+		return nil, err
+	}
+	return &history, nil
+}
+
+// Run returns a single run, including the paths it changed
+func (c *Client) Run(id string) (*Run, error) {
+	var run Run
+	if err := c.do(http.MethodGet, "/api/history/run", url.Values{"id": {id}}, nil, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// RunFailures returns the files a run couldn't copy, such as files it wasn't
+// allowed to read or that vanished during the run
+func (c *Client) RunFailures(id string) (*RunFailures, error) {
+	var failures RunFailures
+	if err := c.do(http.MethodGet, "/api/runs/"+url.PathEscape(id)+"/failures", nil, nil, &failures); err != nil {
+		return nil, err
+	}
+	return &failures, nil
+}
+
+// Window returns the runs that were going between from and to, grouped by
+// pair, for a single pair if id isn't empty
+func (c *Client) Window(from, to time.Time, id string) (*Window, error) {
+	query := url.Values{"from": {from.Format(time.RFC3339)}, "to": {to.Format(time.RFC3339)}}
+	if id != "" {
+		query.Set("id", id)
+	}
+
+	var window Window
+	if err := c.do(http.MethodGet, "/api/history/window", query, nil, &window); err != nil {
+		return nil, err
+	}
+	return &window, nil
+}
+
+// AnnotateRun attaches a note to a run
+func (c *Client) AnnotateRun(runID, text string) error {
+	return c.do(http.MethodPost, "/api/notes", nil, map[string]string{"run_id": runID, "text": text}, nil)
+}
+
+// AnnotatePair attaches a note to a sync pair
+func (c *Client) AnnotatePair(syncID, text string) error {
+	return c.do(http.MethodPost, "/api/notes", nil, map[string]string{"sync_id": syncID, "text": text}, nil)
+}
+
+// do sends a request, encoding body as JSON if given, and decodes the
+// response into out if given
+func (c *Client) do(method, path string, query url.Values, body, out interface{}) error {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, u, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	} else if c.Username != "" || c.Password != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,167 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestClient tests the typed API methods against a fake daemon
+func TestClient(t *testing.T) {
+	var lastPath, lastQuery, lastAuth, lastMethod string
+	var lastBody map[string]string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastPath, lastQuery, lastMethod = r.URL.Path, r.URL.RawQuery, r.Method
+		lastAuth = r.Header.Get("Authorization")
+		lastBody = nil
+		json.NewDecoder(r.Body).Decode(&lastBody)
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/status":
+			json.NewEncoder(w).Encode([]SyncStatus{{ID: "a:b", SourcePath: "a", DestinationPath: "b"}})
+		case "/api/sync/details":
+			if r.URL.Query().Get("id") != "a:b" {
+				http.Error(w, "Sync not found", http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(SyncStatus{ID: "a:b", Paused: true})
+		case "/api/history":
+			json.NewEncoder(w).Encode(History{Runs: []Run{{ID: "run-1", SyncID: "a:b", Success: true}}})
+		case "/api/history/run":
+			json.NewEncoder(w).Encode(Run{ID: "run-1", ChangeCount: 1, Changes: []Change{{Path: "a.txt", Action: "created"}}})
+		case "/api/runs/run-1/failures":
+			json.NewEncoder(w).Encode(RunFailures{RunID: "run-1", FailureCount: 1, Failures: []FileFailure{{Path: "secret.txt", ErrorClass: "permission"}}})
+		case "/api/pairs/1/stats":
+			json.NewEncoder(w).Encode(PairStats{SyncID: "a:b", Source: DirStats{Files: 2, Largest: []FileSize{{"big.iso", 100}}}})
+		case "/api/pairs/a:b":
+			json.NewEncoder(w).Encode(SyncStatus{ID: "a:b", Enabled: false})
+		case "/api/profile":
+			json.NewEncoder(w).Encode(Profiles{Profile: lastBody["profile"], Profiles: []string{"home", "office"}})
+		case "/api/summary":
+			json.NewEncoder(w).Encode(Summary{Pairs: 2, Failing: 1, Running: []string{"a:b"}})
+		case "/api/browse":
+			json.NewEncoder(w).Encode(Listing{SyncID: "a:b", Side: "dst", Path: "sub", Entries: []ListingEntry{{Name: "file.txt", Size: 3}}})
+		case "/api/sync/diff":
+			json.NewEncoder(w).Encode(Diff{SyncID: "a:b", New: []DiffEntry{{Path: "new.txt", SourceSize: 3}}, BytesToCopy: 3})
+		case "/api/history/window":
+			json.NewEncoder(w).Encode(Window{Pairs: []PairActivity{{SyncID: "a:b", Failed: 1}}})
+		default:
+			w.Write([]byte(`{"success": true}`))
+		}
+	}))
+	defer ts.Close()
+
+	c := New(ts.URL + "/")
+	c.Token = "secret"
+
+	statuses, err := c.Status()
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if len(statuses) != 1 || statuses[0].ID != "a:b" {
+		t.Errorf("Unexpected statuses: %v", statuses)
+	}
+	if lastAuth != "Bearer secret" {
+		t.Errorf("Expected bearer token, got %q", lastAuth)
+	}
+
+	status, err := c.Sync("a:b")
+	if err != nil || !status.Paused {
+		t.Errorf("Expected paused sync, got %v (%v)", status, err)
+	}
+
+	summary, err := c.Summary()
+	if err != nil || summary.Pairs != 2 || summary.Failing != 1 || len(summary.Running) != 1 {
+		t.Errorf("Unexpected summary: %v (%v)", summary, err)
+	}
+
+	stats, err := c.Stats("1")
+	if err != nil || stats.Source.Files != 2 || stats.Source.Largest[0].Path != "big.iso" {
+		t.Errorf("Unexpected stats: %v (%v)", stats, err)
+	}
+
+	failures, err := c.RunFailures("run-1")
+	if err != nil || failures.FailureCount != 1 || failures.Failures[0].ErrorClass != "permission" {
+		t.Errorf("Unexpected run failures: %v (%v)", failures, err)
+	}
+
+	enabled, err := c.SetEnabled("a:b", false)
+	if err != nil || enabled.ID != "a:b" || enabled.Enabled || lastMethod != http.MethodPatch {
+		t.Errorf("Unexpected SetEnabled: %v (%v) with %s", enabled, err, lastMethod)
+	}
+
+	profiles, err := c.SetProfile("office")
+	if err != nil || profiles.Profile != "office" || len(profiles.Profiles) != 2 || lastMethod != http.MethodPost {
+		t.Errorf("Unexpected SetProfile: %v (%v) with %s", profiles, err, lastMethod)
+	}
+
+	listing, err := c.Browse("a:b", "dst", "sub")
+	if err != nil || len(listing.Entries) != 1 || listing.Entries[0].Name != "file.txt" || lastQuery != "id=a%3Ab&path=sub&side=dst" {
+		t.Errorf("Unexpected listing: %v (%v, %s)", listing, err, lastQuery)
+	}
+
+	diff, err := c.Diff("a:b")
+	if err != nil || len(diff.New) != 1 || diff.New[0].Path != "new.txt" || lastQuery != "id=a%3Ab" {
+		t.Errorf("Unexpected diff: %v (%v)", diff, err)
+	}
+
+	if err := c.TriggerSync("a:b"); err != nil {
+		t.Errorf("TriggerSync failed: %v", err)
+	}
+	if lastPath != "/api/sync/now" || lastQuery != "id=a%3Ab" {
+		t.Errorf("Unexpected request for TriggerSync: %s?%s", lastPath, lastQuery)
+	}
+
+	history, err := c.History("a:b")
+	if err != nil || len(history.Runs) != 1 || history.Runs[0].ID != "run-1" {
+		t.Errorf("Unexpected history: %v (%v)", history, err)
+	}
+
+	run, err := c.Run("run-1")
+	if err != nil || len(run.Changes) != 1 || run.Changes[0].Path != "a.txt" || lastQuery != "id=run-1" {
+		t.Errorf("Unexpected run: %v (%v)", run, err)
+	}
+
+	window, err := c.Window(time.Now().Add(-time.Hour), time.Now(), "a:b")
+	if err != nil || len(window.Pairs) != 1 || window.Pairs[0].Failed != 1 {
+		t.Errorf("Unexpected window: %v (%v)", window, err)
+	}
+	if lastPath != "/api/history/window" || !strings.Contains(lastQuery, "from=") || !strings.Contains(lastQuery, "id=a%3Ab") {
+		t.Errorf("Unexpected request for Window: %s?%s", lastPath, lastQuery)
+	}
+
+	if err := c.AnnotateRun("run-1", "checked"); err != nil {
+		t.Errorf("AnnotateRun failed: %v", err)
+	}
+	if lastBody["run_id"] != "run-1" || lastBody["text"] != "checked" {
+		t.Errorf("Unexpected note body: %v", lastBody)
+	}
+
+	if _, err := c.TaggedStatus("offsite"); err != nil || lastPath != "/status" || lastQuery != "tag=offsite" {
+		t.Errorf("Unexpected request for TaggedStatus: %s?%s (%v)", lastPath, lastQuery, err)
+	}
+	if err := c.PauseTagged("photos"); err != nil || lastPath != "/api/sync/pause" || lastQuery != "tag=photos" || lastMethod != http.MethodPost {
+		t.Errorf("Unexpected request for PauseTagged: %s %s?%s (%v)", lastMethod, lastPath, lastQuery, err)
+	}
+
+	// Errors are returned as APIError
+	_, err = c.Sync("missing")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Message != "Sync not found" {
+		t.Errorf("Expected not found APIError, got %v", err)
+	}
+
+	// Basic auth is used without a token
+	c.Token = ""
+	c.Username, c.Password = "admin", "pass"
+	c.TriggerAllSyncs()
+	if lastAuth == "" || lastQuery != "" {
+		t.Errorf("Expected basic auth and no sync ID, got auth %q query %q", lastAuth, lastQuery)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// AuthConfig configures protection of the web UI and API. With a username and
+// password, HTTP basic auth is required. With a token, requests must send
+// "Authorization: Bearer <token>" (browsers can use basic auth with the token
+// as password instead).
+type AuthConfig struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Token    string `json:"token"`
+}
+
+// enabled reports whether any credentials are configured
+func (a *AuthConfig) enabled() bool {
+	return a != nil && (a.Token != "" || a.Username != "" || a.Password != "")
+}
+
+// authorized checks the request's credentials against the configuration
+func (a *AuthConfig) authorized(r *http.Request) bool {
+	if a.Token != "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && secureCompare(token, a.Token) {
+			return true
+		}
+		if _, password, ok := r.BasicAuth(); ok && secureCompare(password, a.Token) {
+			return true
+		}
+	}
+
+	if a.Username != "" || a.Password != "" {
+		if username, password, ok := r.BasicAuth(); ok &&
+			secureCompare(username, a.Username) && secureCompare(password, a.Password) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// secureCompare compares two strings in constant time
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// withAuth wraps a handler so every request must be authenticated
+func withAuth(auth *AuthConfig, next http.Handler) http.Handler {
+	if !auth.enabled() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auth.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="DirSync"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestWithAuth tests that requests are checked against the configured credentials
+func TestWithAuth(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name     string
+		auth     *AuthConfig
+		setup    func(r *http.Request)
+		expected int
+	}{
+		{"no auth configured", nil, func(r *http.Request) {}, http.StatusOK},
+		{"missing credentials", &AuthConfig{Token: "secret"}, func(r *http.Request) {}, http.StatusUnauthorized},
+		{"valid bearer token", &AuthConfig{Token: "secret"}, func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer secret")
+		}, http.StatusOK},
+		{"invalid bearer token", &AuthConfig{Token: "secret"}, func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer wrong")
+		}, http.StatusUnauthorized},
+		{"token as basic auth password", &AuthConfig{Token: "secret"}, func(r *http.Request) {
+			r.SetBasicAuth("anyone", "secret")
+		}, http.StatusOK},
+		{"valid basic auth", &AuthConfig{Username: "admin", Password: "pass"}, func(r *http.Request) {
+			r.SetBasicAuth("admin", "pass")
+		}, http.StatusOK},
+		{"invalid basic auth", &AuthConfig{Username: "admin", Password: "pass"}, func(r *http.Request) {
+			r.SetBasicAuth("admin", "wrong")
+		}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/status", nil)
+			tt.setup(req)
+
+			rr := httptest.NewRecorder()
+			withAuth(tt.auth, next).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, rr.Code)
+			}
+
+			if rr.Code == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("Expected WWW-Authenticate header on unauthorized response")
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// Badge colours, matching the usual shields.io palette
+const (
+	badgeGreen = "#4c1"
+	badgeRed   = "#e05d44"
+	badgeBlue  = "#007ec6"
+	badgeGrey  = "#9f9f9f"
+)
+
+// badgeTemplate is a flat two-part badge like those served by shields.io
+const badgeTemplate = `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">
+<title>%[4]s: %[5]s</title>
+<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[7]d" y="15" fill="#010101" fill-opacity=".3">%[4]s</text><text x="%[7]d" y="14">%[4]s</text>
+<text x="%[8]d" y="15" fill="#010101" fill-opacity=".3">%[5]s</text><text x="%[8]d" y="14">%[5]s</text>
+</g>
+</svg>
+`
+
+// handleBadge serves an SVG status badge for a pair at /badge/{pair}.svg,
+// where {pair} is the pair's position in /status starting at 1, or its
+// source and destination with every run of other characters than letters
+// and digits replaced by "-", e.g. data-photos-mnt-backup-photos
+func handleBadge(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/badge/"), ".svg")
+	if !ok || name == "" {
+		http.Error(w, "Badge not found", http.StatusNotFound)
+		return
+	}
+
+	sync := findBadgeSync(name)
+	if sync == nil {
+		http.Error(w, "Sync not found", http.StatusNotFound)
+		return
+	}
+
+	status := sync.GetStatus()
+	message, color := badgeMessage(status, time.Now())
+
+	// Always show the live state when embedded in a page
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-cache, max-age=0")
+	w.Write([]byte(renderBadge("backup", message, color)))
+}
+
+// findBadgeSync looks up the sync a badge is for by position or slug
+func findBadgeSync(name string) *dirsync.Sync {
+	statuses := syncManager.GetAllStatus()
+
+	if n, err := strconv.Atoi(name); err == nil {
+		if n < 1 || n > len(statuses) {
+			return nil
+		}
+		return syncManager.GetSyncByID(statuses[n-1]["id"].(string))
+	}
+
+	for _, status := range statuses {
+		if id := status["id"].(string); dirsync.Slug(id) == strings.ToLower(name) {
+			return syncManager.GetSyncByID(id)
+		}
+	}
+	return nil
+}
+
+// badgeMessage picks the badge's text and colour from a sync's status
+func badgeMessage(status map[string]interface{}, now time.Time) (string, string) {
+	lastSync, _ := status["last_sync"].(time.Time)
+	age := "never"
+	if !lastSync.IsZero() {
+		age = shortAge(now.Sub(lastSync)) + " ago"
+	}
+
+	switch {
+	case status["is_syncing"] == true:
+		return "syncing", badgeBlue
+	case status["last_error"] != "":
+		if lastSync.IsZero() {
+			return "failing", badgeRed
+		}
+		return "failing, ok " + age, badgeRed
+	case lastSync.IsZero():
+		return "never synced", badgeGrey
+	}
+	return "ok " + age, badgeGreen
+}
+
+// shortAge formats a duration in its largest whole unit, e.g. "5m" or "3d"
+func shortAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd", int(d.Hours()/24))
+}
+
+// renderBadge draws a badge with a label and a coloured message, sizing
+// each part from an estimate of the text's width
+func renderBadge(label, message, color string) string {
+	labelWidth := textWidth(label) + 10
+	messageWidth := textWidth(message) + 10
+	return fmt.Sprintf(badgeTemplate, labelWidth+messageWidth, labelWidth, messageWidth,
+		html.EscapeString(label), html.EscapeString(message), color,
+		labelWidth/2, labelWidth+messageWidth/2)
+}
+
+// textWidth estimates the width of text in 11px Verdana
+func textWidth(text string) int {
+	return len([]rune(text))*7 + 1
+}
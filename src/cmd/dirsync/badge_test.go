@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// TestHandleBadge tests serving status badges by position and slug
+func TestHandleBadge(t *testing.T) {
+	syncManager = dirsync.NewSyncManager(dirsync.Options{})
+	okSync := syncManager.AddSync("/data/photos", "/mnt/backup/photos", 60)
+	okSync.LastSync = time.Now().Add(-2 * time.Hour)
+	failedSync := syncManager.AddSync("/data/docs", "/mnt/backup/docs", 60)
+	failedSync.LastError = "rsync error"
+
+	tests := map[string]string{
+		"/badge/1.svg": "ok 2h ago",
+		"/badge/data-photos-mnt-backup-photos.svg": "ok 2h ago",
+		"/badge/2.svg": "failing",
+	}
+	for path, want := range tests {
+		rr := httptest.NewRecorder()
+		handleBadge(rr, httptest.NewRequest("GET", path, nil))
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d", path, rr.Code)
+			continue
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != "image/svg+xml" {
+			t.Errorf("%s: unexpected content type %s", path, ct)
+		}
+		if !strings.Contains(rr.Body.String(), ">"+want+"<") {
+			t.Errorf("%s: expected %q in badge, got %s", path, want, rr.Body.String())
+		}
+	}
+
+	for _, path := range []string{"/badge/3.svg", "/badge/unknown.svg", "/badge/1.png"} {
+		rr := httptest.NewRecorder()
+		handleBadge(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("%s: expected status 404, got %d", path, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// benchResult is the throughput measured for one copy configuration
+type benchResult struct {
+	label      string
+	bufferKB   int
+	workers    int
+	throughput float64
+}
+
+// runBench measures scan, copy and hash performance between a source and a
+// destination and suggests per-pair copy settings
+func runBench(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	sampleMB := flags.Int("sample-mb", 64, "how much data to copy for each measurement")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dirsync bench [-sample-mb N] <source> <destination>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+	source, dest := flags.Arg(0), flags.Arg(1)
+
+	// Scan rate
+	fmt.Printf("Scanning %s...\n", source)
+	start := time.Now()
+	files, totalBytes, err := scanFiles(source)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error scanning source: %v\n", err)
+		return 1
+	}
+	scanTime := time.Since(start)
+	fmt.Printf("  %d files, %s in %v (%.0f files/s)\n", len(files), formatBytes(totalBytes),
+		scanTime.Round(time.Millisecond), float64(len(files))/scanTime.Seconds())
+
+	sample, sampleBytes := benchSample(files, int64(*sampleMB)*1024*1024)
+	if len(sample) == 0 {
+		fmt.Fprintln(os.Stderr, "Source has no files to benchmark with")
+		return 1
+	}
+	fmt.Printf("Using a sample of %d files, %s\n", len(sample), formatBytes(sampleBytes))
+	if canDropCache {
+		fmt.Println("Dropping the sample from the page cache before each pass")
+	} else {
+		fmt.Println("The sample can't be dropped from the page cache here, so passes after the first may read it from memory")
+	}
+
+	benchDir, err := os.MkdirTemp(dest, ".dirsync-bench-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating benchmark directory in destination: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(benchDir)
+
+	// Copy throughput at several buffer sizes, then worker counts
+	fmt.Println("Copy throughput:")
+	var results []benchResult
+	best := benchResult{}
+	for _, bufferKB := range []int{0, 32, 128, 1024, 4096} {
+		label := fmt.Sprintf("buffer %d KB, 1 worker", bufferKB)
+		if bufferKB == 0 {
+			label = "OS default, 1 worker"
+		}
+		result, err := benchCopy(source, sample, sampleBytes, benchDir, bufferKB, 1)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error copying: %v\n", err)
+			return 1
+		}
+		result.label = label
+		results = append(results, result)
+		fmt.Printf("  %-24s %s/s\n", label, formatBytes(int64(result.throughput)))
+		if result.throughput > best.throughput {
+			best = result
+		}
+	}
+	for _, workers := range []int{2, 4, 8} {
+		label := fmt.Sprintf("%d workers", workers)
+		result, err := benchCopy(source, sample, sampleBytes, benchDir, best.bufferKB, workers)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error copying: %v\n", err)
+			return 1
+		}
+		result.label = label
+		results = append(results, result)
+		fmt.Printf("  %-24s %s/s\n", label, formatBytes(int64(result.throughput)))
+	}
+
+	// Only suggest more workers if they're clearly faster
+	for _, result := range results {
+		if result.throughput > best.throughput*1.1 {
+			best = result
+		}
+	}
+
+	// Hash speed
+	if err := dropCaches(source, sample); err != nil {
+		fmt.Fprintf(os.Stderr, "Error dropping the page cache: %v\n", err)
+		return 1
+	}
+	start = time.Now()
+	if err := hashFiles(source, sample); err != nil {
+		fmt.Fprintf(os.Stderr, "Error hashing: %v\n", err)
+		return 1
+	}
+	hashTime := time.Since(start)
+	fmt.Printf("SHA-256 hashing: %s/s\n", formatBytes(int64(float64(sampleBytes)/hashTime.Seconds())))
+
+	fmt.Println()
+	fmt.Println("Suggested pair settings for the built-in copier:")
+	fmt.Printf("  \"copy_buffer_kb\": %d,\n", best.bufferKB)
+	fmt.Printf("  \"copy_workers\": %d\n", best.workers)
+
+	return 0
+}
+
+// benchFile is a file found while scanning the source
+type benchFile struct {
+	rel  string
+	size int64
+}
+
+// scanFiles lists all regular files under root
+func scanFiles(root string) ([]benchFile, int64, error) {
+	var files []benchFile
+	var total int64
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, benchFile{rel: rel, size: info.Size()})
+		total += info.Size()
+		return nil
+	})
+
+	return files, total, err
+}
+
+// benchSample picks files from the scan, largest first, up to limit bytes
+func benchSample(files []benchFile, limit int64) ([]benchFile, int64) {
+	sorted := append([]benchFile(nil), files...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].size > sorted[j].size })
+
+	// Mix large and small files by taking from both ends
+	var sample []benchFile
+	var total int64
+	for i, j := 0, len(sorted)-1; i <= j && total < limit; i++ {
+		sample = append(sample, sorted[i])
+		total += sorted[i].size
+		if i != j && total < limit {
+			sample = append(sample, sorted[j])
+			total += sorted[j].size
+			j--
+		}
+	}
+
+	return sample, total
+}
+
+// benchCopy copies the sample into a fresh directory of its own and
+// measures throughput
+func benchCopy(source string, sample []benchFile, sampleBytes int64, benchDir string, bufferKB, workers int) (benchResult, error) {
+	target, err := os.MkdirTemp(benchDir, "run-")
+	if err != nil {
+		return benchResult{}, err
+	}
+	defer os.RemoveAll(target)
+
+	// Read the sample from the disk, not from the previous pass's cache
+	if err := dropCaches(source, sample); err != nil {
+		return benchResult{}, err
+	}
+
+	var wg sync.WaitGroup
+	var errMu sync.Mutex
+	var firstErr error
+	queue := make(chan int)
+
+	start := time.Now()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				// Sampled files are numbered, as their names may repeat
+				src := filepath.Join(source, sample[i].rel)
+				dst := filepath.Join(target, fmt.Sprintf("%d", i))
+				if err := dirsync.CopyFile(src, dst, bufferKB*1024); err != nil {
+					errMu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					errMu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range sample {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	if firstErr != nil {
+		return benchResult{}, firstErr
+	}
+	elapsed := time.Since(start).Seconds()
+
+	// Every sampled file must have landed in a file of its own
+	if entries, err := os.ReadDir(target); err != nil {
+		return benchResult{}, err
+	} else if len(entries) != len(sample) {
+		return benchResult{}, fmt.Errorf("copied %d of %d sampled files", len(entries), len(sample))
+	}
+
+	return benchResult{
+		bufferKB:   bufferKB,
+		workers:    workers,
+		throughput: float64(sampleBytes) / elapsed,
+	}, nil
+}
+
+// dropCaches drops the sampled files from the page cache, where possible
+func dropCaches(source string, sample []benchFile) error {
+	for _, f := range sample {
+		if err := dropCache(filepath.Join(source, f.rel)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hashFiles computes the SHA-256 of every sampled file
+func hashFiles(source string, sample []benchFile) error {
+	for _, f := range sample {
+		in, err := os.Open(filepath.Join(source, f.rel))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(sha256.New(), in)
+		in.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatBytes formats a byte count for humans
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
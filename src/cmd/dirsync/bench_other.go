@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+// canDropCache reports whether dropCache works on this system
+const canDropCache = false
+
+// dropCache does nothing on systems without posix_fadvise
+func dropCache(path string) error {
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestBenchSample tests picking a sample from both ends of the size range
+func TestBenchSample(t *testing.T) {
+	files := []benchFile{{"a", 10}, {"b", 1000}, {"c", 1}, {"d", 100}}
+
+	sample, total := benchSample(files, 1001)
+	if len(sample) != 2 || sample[0].rel != "b" || sample[1].rel != "c" {
+		t.Errorf("Expected largest and smallest files in sample, got %v", sample)
+	}
+	if total != 1001 {
+		t.Errorf("Expected sample total 1001, got %d", total)
+	}
+
+	sample, _ = benchSample(files, 1<<20)
+	if len(sample) != len(files) {
+		t.Errorf("Expected all %d files in sample, got %d", len(files), len(sample))
+	}
+}
+
+// TestRunBench tests running the benchmark against the test directories
+func TestRunBench(t *testing.T) {
+	destDir := t.TempDir()
+
+	if code := runBench([]string{"-sample-mb", "1", testSourceDir, destDir}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+
+	// The benchmark should clean up after itself
+	entries, err := os.ReadDir(destDir)
+	if err != nil {
+		t.Fatalf("Failed to read destination: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected destination to be empty after benchmark, found %s", filepath.Join(destDir, entries[0].Name()))
+	}
+
+	if code := runBench([]string{testSourceDir}); code != 2 {
+		t.Errorf("Expected exit code 2 for missing arguments, got %d", code)
+	}
+}
+
+// TestBenchCopyDuplicateNames tests copying sampled files that share a
+// name and size without one overwriting the other
+func TestBenchCopyDuplicateNames(t *testing.T) {
+	source := t.TempDir()
+	for _, dir := range []string{"a", "b"} {
+		os.MkdirAll(filepath.Join(source, dir), 0755)
+		os.WriteFile(filepath.Join(source, dir, "same.bin"), []byte(dir), 0644)
+	}
+	sample := []benchFile{{filepath.Join("a", "same.bin"), 1}, {filepath.Join("b", "same.bin"), 1}}
+
+	if err := dropCaches(source, sample); err != nil {
+		t.Errorf("dropCaches failed: %v", err)
+	}
+
+	benchDir := t.TempDir()
+	if _, err := benchCopy(source, sample, 2, benchDir, 0, 2); err != nil {
+		t.Fatalf("benchCopy failed: %v", err)
+	}
+	if entries, _ := os.ReadDir(benchDir); len(entries) != 0 {
+		t.Errorf("Expected each pass to remove its directory, found %d entries", len(entries))
+	}
+}
+
+// TestFormatBytes tests human-readable byte counts
+func TestFormatBytes(t *testing.T) {
+	tests := map[int64]string{
+		512:             "512 B",
+		2048:            "2.0 KiB",
+		5 * 1024 * 1024: "5.0 MiB",
+	}
+	for n, want := range tests {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+const (
+	// defaultCalendarDays is how far ahead and back the calendar feed reaches
+	defaultCalendarDays = 7
+
+	// maxCalendarEvents limits the scheduled and past runs listed per pair,
+	// so short intervals don't flood the calendar
+	maxCalendarEvents = 48
+
+	// defaultRunLength is the length of scheduled runs for pairs without a
+	// successful run to estimate from
+	defaultRunLength = 15 * time.Minute
+)
+
+// calendarEvent is a single VEVENT in the feed
+type calendarEvent struct {
+	uid         string
+	start       time.Time
+	end         time.Time
+	summary     string
+	description string
+}
+
+// handleCalendar serves an iCalendar feed of each pair's upcoming scheduled
+// runs and recent results, for subscribing to from a shared calendar. The
+// feed covers the last and next days (default 7), optionally for one pair.
+func handleCalendar(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	days := defaultCalendarDays
+	if value := r.URL.Query().Get("days"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid days", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	if id != "" && syncManager.GetSyncByID(id) == nil {
+		http.Error(w, "Sync not found", http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	window := time.Duration(days) * 24 * time.Hour
+
+	var events []calendarEvent
+	for _, status := range syncManager.GetAllStatus() {
+		syncID := status["id"].(string)
+		if id != "" && syncID != id {
+			continue
+		}
+		events = append(events, scheduledEvents(status, now, now.Add(window))...)
+		events = append(events, resultEvents(syncManager.History.ListRuns(syncID), now.Add(-window))...)
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="dirsync.ics"`)
+	w.Write([]byte(renderCalendar(events, now)))
+}
+
+// scheduledEvents lists a pair's upcoming runs until the given time, following
+// its schedule from its next sync. Pairs that only run when triggered have
+// no scheduled runs.
+func scheduledEvents(status map[string]interface{}, now, until time.Time) []calendarEvent {
+	if status["paused"] == true {
+		return nil
+	}
+
+	syncID := status["id"].(string)
+	next, _ := status["next_sync_time"].(time.Time)
+	if next.IsZero() {
+		return nil
+	}
+	if next.Before(now) {
+		next = now
+	}
+
+	var schedule *dirsync.ScheduleConfig
+	if s := syncManager.GetSyncByID(syncID); s != nil {
+		schedule = s.Pair.Schedule
+	}
+	scheduler, err := dirsync.NewScheduler(schedule, config.SyncInterval)
+	if err != nil {
+		return nil
+	}
+
+	length := defaultRunLength
+	if typical, ok := typicalRunDuration(syncManager.History, syncID); ok && typical > time.Minute {
+		length = typical
+	}
+
+	var events []calendarEvent
+	for start := next; start.Before(until) && len(events) < maxCalendarEvents; {
+		events = append(events, calendarEvent{
+			uid:         fmt.Sprintf("scheduled-%x-%d@dirsync", pairHash(syncID), start.Unix()),
+			start:       start,
+			end:         start.Add(length),
+			summary:     "Backup scheduled: " + syncID,
+			description: fmt.Sprintf("Scheduled sync from %s to %s", status["source_path"], status["destination_path"]),
+		})
+
+		following := scheduler.Next(start)
+		if !following.After(start) {
+			break
+		}
+		start = following
+	}
+	return events
+}
+
+// resultEvents lists a pair's finished runs since the given time, newest first
+func resultEvents(runs []dirsync.RunRecord, since time.Time) []calendarEvent {
+	var events []calendarEvent
+	for i := len(runs) - 1; i >= 0 && len(events) < maxCalendarEvents; i-- {
+		run := runs[i]
+		if run.EndTime.IsZero() || run.StartTime.Before(since) {
+			continue
+		}
+
+		event := calendarEvent{
+			uid:         "run-" + run.ID + "@dirsync",
+			start:       run.StartTime,
+			end:         run.EndTime,
+			summary:     "Backup succeeded: " + run.SyncID,
+			description: fmt.Sprintf("Run %s took %v", run.ID, run.EndTime.Sub(run.StartTime).Round(time.Second)),
+		}
+		if !run.Success {
+			event.summary = "Backup failed: " + run.SyncID
+			event.description += "\n" + run.Error
+		} else if run.Skipped != "" {
+			event.summary = "Backup skipped: " + run.SyncID
+			event.description += "\n" + run.Skipped
+		}
+		for _, note := range run.Notes {
+			event.description += "\nNote: " + note.Text
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// pairHash shortens a pair ID for use in event UIDs
+func pairHash(syncID string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(syncID))
+	return h.Sum64()
+}
+
+// renderCalendar formats events as an iCalendar (RFC 5545) document
+func renderCalendar(events []calendarEvent, now time.Time) string {
+	var b strings.Builder
+	line := func(text string) {
+		b.WriteString(foldICSLine(text))
+		b.WriteString("\r\n")
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//DirSync//Backup schedule//EN")
+	line("CALSCALE:GREGORIAN")
+	line("X-WR-CALNAME:DirSync backups")
+	for _, event := range events {
+		line("BEGIN:VEVENT")
+		line("UID:" + event.uid)
+		line("DTSTAMP:" + icsTime(now))
+		line("DTSTART:" + icsTime(event.start))
+		line("DTEND:" + icsTime(event.end))
+		line("SUMMARY:" + icsEscape(event.summary))
+		line("DESCRIPTION:" + icsEscape(event.description))
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+
+	return b.String()
+}
+
+// icsTime formats t as a UTC iCalendar date-time
+func icsTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// icsEscape escapes text for an iCalendar property value
+func icsEscape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(text)
+}
+
+// foldICSLine splits lines longer than 75 bytes into continuation lines,
+// without breaking up multi-byte characters
+func foldICSLine(text string) string {
+	var b strings.Builder
+	width := 0
+	for _, r := range text {
+		size := len(string(r))
+		if width+size > 75 {
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += size
+	}
+	return b.String()
+}
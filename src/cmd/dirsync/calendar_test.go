@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// TestHandleCalendar tests the iCalendar feed of scheduled runs and results
+func TestHandleCalendar(t *testing.T) {
+	config = Config{SyncInterval: 3600}
+	defer func() { config = Config{} }()
+
+	syncManager = dirsync.NewSyncManager(dirsync.Options{})
+	testSync := syncManager.AddSync(testSourceDir, testDestDir, 3600)
+
+	run := syncManager.History.StartRun(testSync.ID)
+	syncManager.History.FinishRun(run, errors.New("disk full, giving up"))
+
+	rr := httptest.NewRecorder()
+	handleCalendar(rr, httptest.NewRequest("GET", "/api/calendar.ics?days=1", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+
+	body := rr.Body.String()
+	if !strings.HasPrefix(body, "BEGIN:VCALENDAR\r\n") || !strings.HasSuffix(body, "END:VCALENDAR\r\n") {
+		t.Errorf("Expected a calendar, got %q", body)
+	}
+
+	// One scheduled run per hour of the day, plus the failed run
+	unfolded := strings.ReplaceAll(body, "\r\n ", "")
+	if n := strings.Count(unfolded, "SUMMARY:Backup scheduled: "); n != 24 {
+		t.Errorf("Expected 24 scheduled runs, got %d", n)
+	}
+	if !strings.Contains(unfolded, "SUMMARY:Backup failed: ") || !strings.Contains(unfolded, `disk full\, giving up`) {
+		t.Errorf("Expected the failed run with its escaped error, got %q", unfolded)
+	}
+	for _, line := range strings.Split(body, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("Line longer than 75 bytes: %q", line)
+		}
+	}
+
+	// Unknown pairs and invalid ranges are rejected
+	rr = httptest.NewRecorder()
+	handleCalendar(rr, httptest.NewRequest("GET", "/api/calendar.ics?id=unknown", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown pair, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	handleCalendar(rr, httptest.NewRequest("GET", "/api/calendar.ics?days=-1", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid days, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// pairsFile is a file in the conf.d directory. Each file defines its own
+// pairs, so they can be added and removed independently.
+type pairsFile struct {
+	SyncPairs []string             `json:"sync_pairs"`
+	Pairs     []dirsync.PairConfig `json:"pairs"`
+}
+
+// confDirPath returns the directory pair files are loaded from
+func (c *Config) confDirPath() string {
+	if c.ConfDir != "" {
+		return adjustPath(c.ConfDir)
+	}
+	return filepath.Join(baseDir, "conf.d")
+}
+
+// loadConfDir adds the pairs of every .json file in dir to the config, in
+// the order of their names. Hidden files are skipped, so tools can write a
+// file under a temporary name and rename it into place. A missing directory
+// adds nothing.
+func (c *Config) loadConfDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", dir, err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != ".json" {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", path, err)
+		}
+		var file pairsFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return fmt.Errorf("error parsing %s: %w", path, err)
+		}
+
+		c.SyncPairs = append(c.SyncPairs, file.SyncPairs...)
+		c.Pairs = append(c.Pairs, file.Pairs...)
+		log.Printf("Loaded %d pairs from %s", len(file.SyncPairs)+len(file.Pairs), path)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLoadConfDir tests merging the pairs defined in conf.d files
+func TestLoadConfDir(t *testing.T) {
+	dir := t.TempDir()
+	confDir := filepath.Join(dir, "conf.d")
+	os.MkdirAll(confDir, 0755)
+	files := map[string]string{
+		"config.json":              `{"sync_pairs": ["/a:/b"]}`,
+		"conf.d/20-photos.json":    `{"pairs": [{"name": "Photos", "source": "photos", "destination": "/nas/photos"}]}`,
+		"conf.d/10-documents.json": `{"sync_pairs": ["/docs:/nas/docs"]}`,
+		"conf.d/.30-partial.json":  `{"sync_pairs": [`,
+		"conf.d/README.txt":        "not a pair file",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	defer func() { config, baseDir = Config{}, "" }()
+	if err := loadConfig(filepath.Join(dir, "config.json"), ""); err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if len(config.SyncPairs) != 2 || config.SyncPairs[1] != "/docs:/nas/docs" {
+		t.Errorf("Expected the pair from conf.d after the config's, got %v", config.SyncPairs)
+	}
+	if len(config.Pairs) != 1 || config.Pairs[0].Source != filepath.Join(dir, "photos") {
+		t.Errorf("Expected the structured pair with its path resolved, got %+v", config.Pairs)
+	}
+
+	// A broken file stops the config from loading
+	os.WriteFile(filepath.Join(confDir, "40-broken.json"), []byte(`{"pairs": `), 0644)
+	config = Config{}
+	if err := loadConfig(filepath.Join(dir, "config.json"), ""); err == nil || !strings.Contains(err.Error(), "40-broken.json") {
+		t.Errorf("Expected an error naming the broken file, got %v", err)
+	}
+}
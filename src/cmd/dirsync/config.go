@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// Config holds our JSON configuration
+type Config struct {
+	SyncInterval      int                        `json:"sync_interval"`
+	SyncPairs         []string                   `json:"sync_pairs"`
+	Pairs             []dirsync.PairConfig       `json:"pairs"`
+	ConfDir           string                     `json:"conf_dir"`
+	Profile           string                     `json:"profile"`
+	Profiles          map[string]json.RawMessage `json:"profiles"`
+	SecretsFile       string                     `json:"secrets_file"`
+	Port              string                     `json:"port"`
+	StaticDir         string                     `json:"static_dir"`
+	Auth              *AuthConfig                `json:"auth"`
+	TLS               *TLSConfig                 `json:"tls"`
+	ReadOnly          bool                       `json:"read_only"`
+	HistoryFile       string                     `json:"history_file"`
+	HistoryLimit      int                        `json:"history_limit"`
+	JournalFile       string                     `json:"journal_file"`
+	ResumeInterrupted bool                       `json:"resume_interrupted"`
+	ChangeLimit       int                        `json:"change_limit"`
+	MaxConcurrentRuns int                        `json:"max_concurrent_runs"`
+	MaxMemoryMB       int                        `json:"max_memory_mb"`
+	Webhooks          []dirsync.WebhookConfig    `json:"webhooks"`
+	PluginsDir        string                     `json:"plugins_dir"`
+	ExecHooks         map[string]string          `json:"exec_hooks"`
+	StatusPage        string                     `json:"status_page"`
+	StatusLED         *StatusLEDConfig           `json:"status_led"`
+	Metrics           *MetricsConfig             `json:"metrics"`
+	DebugAPI          bool                       `json:"debug_api"`
+}
+
+// adjustPath resolves a relative path against the directory of the config
+// file, including paths that start with ".."
+func adjustPath(path string) string {
+	if path == "" || isAbsPath(path) {
+		return path
+	}
+	return filepath.Join(baseDir, path)
+}
+
+// loadConfig reads and parses the config file at path into the global
+// config, with profile active if one is given. Without a path, config.json
+// is looked for in the current directory and then in the user's config
+// directory, see userConfigPath. Relative paths in the config are relative
+// to the directory the config file is in.
+func loadConfig(path, profile string) error {
+	if path == "" {
+		path = "config.json"
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			if userPath, err := userConfigPath(); err == nil {
+				path = userPath
+			}
+		}
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("error resolving config path: %w", err)
+	}
+
+	log.Printf("Loading configuration from %s", path)
+	configFile, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config from %s: %w", path, err)
+	}
+	baseDir = filepath.Dir(path)
+
+	// Parse the config
+	if err := json.Unmarshal(configFile, &config); err != nil {
+		return fmt.Errorf("error parsing config: %w", err)
+	}
+
+	// Add the pairs defined in their own files
+	if err := config.loadConfDir(config.confDirPath()); err != nil {
+		return err
+	}
+	if err := config.applyProfiles(profile); err != nil {
+		return err
+	}
+
+	// Resolve relative paths against the config file's directory
+	for i, pair := range config.SyncPairs {
+		if source, destination, ok := splitPair(pair); ok {
+			config.SyncPairs[i] = adjustPath(source) + ":" + adjustPath(destination)
+		}
+	}
+	if config.StaticDir != "" {
+		config.StaticDir = adjustPath(config.StaticDir)
+	}
+	if config.TLS != nil {
+		if config.TLS.CertFile != "" {
+			config.TLS.CertFile = adjustPath(config.TLS.CertFile)
+		}
+		if config.TLS.KeyFile != "" {
+			config.TLS.KeyFile = adjustPath(config.TLS.KeyFile)
+		}
+	}
+	if config.HistoryFile != "" {
+		config.HistoryFile = adjustPath(config.HistoryFile)
+	}
+	if config.JournalFile != "" {
+		config.JournalFile = adjustPath(config.JournalFile)
+	}
+	if config.StatusPage != "" {
+		config.StatusPage = adjustPath(config.StatusPage)
+	}
+	if config.PluginsDir != "" {
+		config.PluginsDir = adjustPath(config.PluginsDir)
+	}
+	config.SecretsFile = adjustPath(config.SecretsFile)
+	for i := range config.Pairs {
+		config.Pairs[i].Source = adjustPath(config.Pairs[i].Source)
+		config.Pairs[i].Destination = adjustPath(config.Pairs[i].Destination)
+		if enc := config.Pairs[i].Encryption; enc != nil && enc.KeyFile != "" {
+			enc.KeyFile = adjustPath(enc.KeyFile)
+		}
+		if sched := config.Pairs[i].Schedule; sched != nil && sched.Path != "" {
+			sched.Path = adjustPath(sched.Path)
+		}
+		if m := config.Pairs[i].Maintenance; m != nil && m.URL != "" && !m.IsRemote() {
+			m.URL = adjustPath(m.URL)
+		}
+		if remote := config.Pairs[i].RemoteHooks; remote != nil {
+			remote.IdentityFile = adjustPath(remote.IdentityFile)
+			remote.AgentSocket = adjustPath(remote.AgentSocket)
+			remote.KnownHosts = adjustPath(remote.KnownHosts)
+		}
+	}
+
+	// Secrets are read once the path of the secrets file is resolved
+	return config.resolveSecrets()
+}
+
+// historyPath returns where the run history is stored
+func (c *Config) historyPath() string {
+	if c.HistoryFile != "" {
+		return c.HistoryFile
+	}
+	return statePath("history.json")
+}
+
+// journalPath returns where the runs in progress are recorded
+func (c *Config) journalPath() string {
+	if c.JournalFile != "" {
+		return c.JournalFile
+	}
+	return statePath("journal.json")
+}
+
+// pluginsPath returns the directory plugins are loaded from
+func (c *Config) pluginsPath() string {
+	if c.PluginsDir != "" {
+		return c.PluginsDir
+	}
+	return filepath.Join(baseDir, "plugins")
+}
+
+// registerExecHooks makes the configured exec hooks available to pairs
+func (c *Config) registerExecHooks() {
+	for name, command := range c.ExecHooks {
+		dirsync.RegisterHook(name, dirsync.ExecHook{Command: command})
+	}
+}
+
+// allPairs returns every configured pair, from both the sync_pairs and
+// pairs settings, with their paths cleaned, along with the pairs that
+// couldn't be parsed or whose paths were rejected
+func (c *Config) allPairs() (pairs []dirsync.PairConfig, invalid []invalidPair) {
+	var configured []dirsync.PairConfig
+	for _, pair := range c.SyncPairs {
+		source, destination, ok := splitPair(pair)
+		if !ok {
+			invalid = append(invalid, invalidPair{Pair: pair, Message: "invalid sync pair format",
+				Fix: `use "source:destination" or the structured "pairs" format`})
+			continue
+		}
+		configured = append(configured, dirsync.PairConfig{Source: source, Destination: destination})
+	}
+	configured = append(configured, c.Pairs...)
+
+	for _, pair := range configured {
+		cleaned, err := cleanPair(pair)
+		if err != nil {
+			var pathErr *pathError
+			errors.As(err, &pathErr)
+			invalid = append(invalid, invalidPair{Pair: pair.ID(), Message: pathErr.message, Fix: pathErr.fix})
+			continue
+		}
+		pairs = append(pairs, cleaned)
+	}
+	return pairs, invalid
+}
+
+// splitPair splits a "source:destination" pair. A colon after a drive
+// letter at the start of either path, as in "C:\\Users\\me:D:\\backup" or
+// "C:/data:/mnt/backup", belongs to the path. A pair with a single colon is
+// always split there, so "a:/backup" is still the directory "a" synced to
+// "/backup".
+func splitPair(pair string) (source, destination string, ok bool) {
+	sep, start := -1, 0
+	for i := 0; i < len(pair); i++ {
+		if pair[i] != ':' || hasDriveLetter(pair[start:]) && i == start+1 {
+			continue
+		}
+		if sep >= 0 {
+			return "", "", false
+		}
+		sep, start = i, i+1
+	}
+
+	if sep < 0 {
+		if strings.Count(pair, ":") != 1 {
+			return "", "", false
+		}
+		sep = strings.Index(pair, ":")
+	}
+	return pair[:sep], pair[sep+1:], true
+}
+
+// hasDriveLetter reports whether path starts with a Windows drive, like
+// "C:\\" or "C:/"
+func hasDriveLetter(path string) bool {
+	if len(path) < 3 || path[1] != ':' || (path[2] != '\\' && path[2] != '/') {
+		return false
+	}
+	c := path[0]
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// isAbsPath reports whether path is absolute here or a Windows path with a
+// drive letter, which stays absolute in configs shared across systems
+func isAbsPath(path string) bool {
+	return filepath.IsAbs(path) || hasDriveLetter(path)
+}
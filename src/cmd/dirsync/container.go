@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// shutdownTimeout is how long a stopping daemon waits for requests and runs
+// to finish, inside Docker's default 10 second grace period
+const shutdownTimeout = 8 * time.Second
+
+// shuttingDown is set once the daemon has been asked to stop, so /healthz
+// reports it as unavailable
+var shuttingDown atomic.Bool
+
+// Overrides are settings given on the command line or in DIRSYNC_* environment
+// variables, which take precedence over config.json so containers can be
+// configured without mounting a config file
+type Overrides struct {
+	ConfigFile   string
+	Profile      string
+	Port         string
+	SyncPairs    string
+	SyncInterval string
+	LogFormat    string
+}
+
+// envOverrides reads the overrides from the environment
+func envOverrides() Overrides {
+	return Overrides{
+		ConfigFile:   os.Getenv("DIRSYNC_CONFIG"),
+		Profile:      os.Getenv("DIRSYNC_PROFILE"),
+		Port:         os.Getenv("DIRSYNC_PORT"),
+		SyncPairs:    os.Getenv("DIRSYNC_SYNC_PAIRS"),
+		SyncInterval: os.Getenv("DIRSYNC_SYNC_INTERVAL"),
+		LogFormat:    os.Getenv("DIRSYNC_LOG_FORMAT"),
+	}
+}
+
+// registerFlags adds a flag for each override, defaulting to the environment
+func (o *Overrides) registerFlags(flags *flag.FlagSet) {
+	env := envOverrides()
+	flags.StringVar(&o.ConfigFile, "config", env.ConfigFile, "path to config.json (DIRSYNC_CONFIG)")
+	flags.StringVar(&o.Profile, "profile", env.Profile, "configuration profile to use (DIRSYNC_PROFILE)")
+	flags.StringVar(&o.Port, "port", env.Port, "port to listen on (DIRSYNC_PORT)")
+	flags.StringVar(&o.SyncPairs, "sync-pairs", env.SyncPairs, "comma-separated source:destination pairs (DIRSYNC_SYNC_PAIRS)")
+	flags.StringVar(&o.SyncInterval, "sync-interval", env.SyncInterval, "seconds between syncs (DIRSYNC_SYNC_INTERVAL)")
+	flags.StringVar(&o.LogFormat, "log-format", env.LogFormat, `"json" to log JSON lines to stdout (DIRSYNC_LOG_FORMAT)`)
+}
+
+// configured reports whether the overrides are enough to run without a config file
+func (o Overrides) configured() bool {
+	return o.SyncPairs != ""
+}
+
+// apply replaces the settings in cfg with any overrides that are set
+func (o Overrides) apply(cfg *Config) error {
+	if o.Port != "" {
+		cfg.Port = o.Port
+	}
+	if o.SyncPairs != "" {
+		cfg.SyncPairs = nil
+		for _, pair := range strings.Split(o.SyncPairs, ",") {
+			if pair = strings.TrimSpace(pair); pair != "" {
+				cfg.SyncPairs = append(cfg.SyncPairs, pair)
+			}
+		}
+	}
+	if o.SyncInterval != "" {
+		interval, err := strconv.Atoi(o.SyncInterval)
+		if err != nil {
+			return fmt.Errorf("invalid sync interval %q", o.SyncInterval)
+		}
+		cfg.SyncInterval = interval
+	}
+	return nil
+}
+
+// setupLogging switches the log to JSON lines on stdout if asked to
+func setupLogging(format string) error {
+	switch format {
+	case "", "text":
+		return nil
+	case "json":
+		log.SetOutput(os.Stdout)
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+		return nil
+	}
+	return fmt.Errorf("unknown log format %q, use text or json", format)
+}
+
+// handleHealthz reports whether the daemon is up, for container health checks
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	status := "ok"
+	if shuttingDown.Load() {
+		status = "shutting down"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+		"pairs":  len(syncManager.GetAllStatus()),
+	})
+}
+
+// withHealthz serves /healthz without authentication, so health checks
+// don't need credentials
+func withHealthz(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			handleHealthz(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listenPort returns the address the server listens on, like ":8080"
+func (c *Config) listenPort() string {
+	port := c.Port
+	if port == "" {
+		port = ":8080"
+	}
+	if !strings.HasPrefix(port, ":") {
+		port = ":" + port
+	}
+	return port
+}
+
+// healthURL returns where the daemon running with c serves /healthz
+func (c *Config) healthURL() string {
+	scheme := "http"
+	if c.TLS.enabled() {
+		scheme = "https"
+	}
+	return scheme + "://localhost" + c.listenPort() + "/healthz"
+}
+
+// runHealth asks the daemon running with the config for its health, for
+// container health checks, and returns the exit code: 0 if it is healthy
+func runHealth(args []string) int {
+	log.SetOutput(io.Discard)
+
+	flags := flag.NewFlagSet("health", flag.ContinueOnError)
+	var overrides Overrides
+	overrides.registerFlags(flags)
+	timeout := flags.Duration("timeout", 5*time.Second, "how long to wait for an answer")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	// Without a config file the daemon uses the defaults, and so does this
+	if err := loadConfig(overrides.ConfigFile, overrides.Profile); err != nil &&
+		(!errors.Is(err, fs.ErrNotExist) || overrides.ConfigFile != "") {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := overrides.apply(&config); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// The certificate is for the daemon's public name or self-signed, and
+	// this only ever connects to localhost
+	client := &http.Client{
+		Timeout:   *timeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	url := config.healthURL()
+	resp, err := client.Get(url)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error querying %s: %v\n", url, err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	var health struct {
+		Status string `json:"status"`
+	}
+	json.NewDecoder(resp.Body).Decode(&health)
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "%s answered %s: %s\n", url, resp.Status, health.Status)
+		return 1
+	}
+	fmt.Println(health.Status)
+	return 0
+}
+
+// shutdown stops the server and waits for running syncs to stop, giving up
+// after shutdownTimeout
+func shutdown(server *http.Server, syncManager *dirsync.SyncManager) {
+	log.Println("Shutting down")
+	shuttingDown.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("Error shutting down server: %v", err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		syncManager.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		log.Println("All syncs stopped")
+	case <-ctx.Done():
+		log.Println("Timed out waiting for syncs to stop")
+	}
+}
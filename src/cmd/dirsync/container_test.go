@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// TestOverrides tests overriding the config from the environment and flags
+func TestOverrides(t *testing.T) {
+	cfg := Config{Port: ":8080", SyncInterval: 60, SyncPairs: []string{"/a:/b"}}
+	overrides := Overrides{Port: ":9090", SyncPairs: "/c:/d, /e:/f", SyncInterval: "30"}
+
+	if err := overrides.apply(&cfg); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if cfg.Port != ":9090" || cfg.SyncInterval != 30 || len(cfg.SyncPairs) != 2 || cfg.SyncPairs[1] != "/e:/f" {
+		t.Errorf("Unexpected config after overrides: %+v", cfg)
+	}
+
+	if err := (Overrides{SyncInterval: "often"}).apply(&cfg); err == nil {
+		t.Errorf("Expected error for invalid sync interval")
+	}
+	if err := setupLogging("xml"); err == nil {
+		t.Errorf("Expected error for unknown log format")
+	}
+
+	// Relative paths in a config file given by path are relative to its directory
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(configPath, []byte(`{"sync_pairs": ["src:dst", "../shared:/mnt/backup"], "history_file": "runs.json", "static_dir": "web"}`), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	defer func() { config = Config{} }()
+	if err := loadConfig(configPath, ""); err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if want := filepath.Join(dir, "src") + ":" + filepath.Join(dir, "dst"); config.SyncPairs[0] != want {
+		t.Errorf("Expected sync pair %s, got %s", want, config.SyncPairs[0])
+	}
+	if want := filepath.Join(filepath.Dir(dir), "shared") + ":/mnt/backup"; config.SyncPairs[1] != want {
+		t.Errorf("Expected sync pair %s, got %s", want, config.SyncPairs[1])
+	}
+	if config.StaticDir != filepath.Join(dir, "web") {
+		t.Errorf("Unexpected static dir %s", config.StaticDir)
+	}
+	if config.historyPath() != filepath.Join(dir, "runs.json") {
+		t.Errorf("Unexpected history path %s", config.historyPath())
+	}
+}
+
+// TestHandleHealthz tests the health check endpoint, which skips authentication
+func TestHandleHealthz(t *testing.T) {
+	syncManager = dirsync.NewSyncManager(dirsync.Options{})
+	handler := withHealthz(withAuth(&AuthConfig{Token: "secret"}, http.DefaultServeMux))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rr.Code)
+	}
+
+	shuttingDown.Store(true)
+	defer shuttingDown.Store(false)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 while shutting down, got %d", rr.Code)
+	}
+}
+
+// TestRunHealth tests asking the daemon on the configured port for its
+// health, over HTTPS when TLS is configured
+func TestRunHealth(t *testing.T) {
+	syncManager = dirsync.NewSyncManager(dirsync.Options{})
+	server := httptest.NewServer(withHealthz(http.NotFoundHandler()))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(configPath, []byte(`{"port": "`+port+`"}`), 0644)
+	defer func() { config, baseDir = Config{}, "" }()
+	if code := runHealth([]string{"--config", configPath}); code != 0 {
+		t.Errorf("Expected a healthy daemon to exit with 0, got %d", code)
+	}
+
+	shuttingDown.Store(true)
+	defer shuttingDown.Store(false)
+	config = Config{}
+	if code := runHealth([]string{"--config", configPath}); code != 1 {
+		t.Errorf("Expected a daemon shutting down to exit with 1, got %d", code)
+	}
+
+	cfg := Config{Port: "8443", TLS: &TLSConfig{SelfSigned: true}}
+	if url := cfg.healthURL(); url != "https://localhost:8443/healthz" {
+		t.Errorf("Expected the health check over HTTPS, got %s", url)
+	}
+}
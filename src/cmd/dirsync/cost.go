@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// handleCosts serves the estimated costs of pairs with pricing configured,
+// optionally for one pair
+func handleCosts(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id != "" && syncManager.GetSyncByID(id) == nil {
+		http.Error(w, "Sync not found", http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	estimates := []dirsync.CostEstimate{}
+	for _, status := range syncManager.GetAllStatus() {
+		syncID := status["id"].(string)
+		if id != "" && syncID != id {
+			continue
+		}
+		s := syncManager.GetSyncByID(syncID)
+		if s == nil || s.Pair.Cost == nil {
+			continue
+		}
+		estimates = append(estimates, dirsync.EstimateCost(syncID, *s.Pair.Cost, syncManager.History.ListRuns(syncID), now))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(estimates); err != nil {
+		log.Printf("Error encoding costs: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// TestHandleCosts tests listing cost estimates for priced pairs
+func TestHandleCosts(t *testing.T) {
+	syncManager = dirsync.NewSyncManager(dirsync.Options{})
+	priced := syncManager.AddPair(dirsync.PairConfig{
+		Source:      testSourceDir,
+		Destination: testDestDir,
+		Cost:        &dirsync.CostConfig{TransferPerGB: 0.09, StorageClass: "GLACIER_IR"},
+	}, 60)
+	syncManager.AddSync(testSourceDir, testDestDir+"-unpriced", 60)
+
+	rr := httptest.NewRecorder()
+	handleCosts(rr, httptest.NewRequest("GET", "/api/costs", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+
+	var estimates []dirsync.CostEstimate
+	if err := json.NewDecoder(rr.Body).Decode(&estimates); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(estimates) != 1 || estimates[0].SyncID != priced.ID || estimates[0].StorageClass != "GLACIER_IR" {
+		t.Errorf("Expected an estimate for the priced pair only, got %+v", estimates)
+	}
+
+	rr = httptest.NewRecorder()
+	handleCosts(rr, httptest.NewRequest("GET", "/api/costs?id=unknown", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown pair, got %d", rr.Code)
+	}
+}
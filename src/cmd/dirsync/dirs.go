@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// userConfigPath returns where config.json is looked for when it isn't in
+// the current directory: $XDG_CONFIG_HOME/dirsync/config.json (by default
+// ~/.config), ~/Library/Application Support/dirsync on macOS or
+// %AppData%\dirsync on Windows
+func userConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "dirsync", "config.json"), nil
+}
+
+// userDataDir returns where the history and journal are kept by default:
+// $XDG_DATA_HOME/dirsync (by default ~/.local/share), ~/Library/Application
+// Support/dirsync on macOS or %LocalAppData%\dirsync on Windows
+func userDataDir() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "dirsync"), nil
+	}
+
+	switch runtime.GOOS {
+	case "windows":
+		dir := os.Getenv("LocalAppData")
+		if dir == "" {
+			return "", errors.New("%LocalAppData% is not defined")
+		}
+		return filepath.Join(dir, "dirsync"), nil
+	case "darwin", "ios":
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "dirsync"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "dirsync"), nil
+}
+
+// statePath returns where a state file such as history.json is kept by
+// default. Installs that already have it next to config.json keep it there;
+// otherwise it goes in the user's data directory, or next to config.json if
+// there is none.
+func statePath(name string) string {
+	if baseDir != "" {
+		if _, err := os.Stat(filepath.Join(baseDir, name)); err == nil {
+			return filepath.Join(baseDir, name)
+		}
+	}
+	dir, err := userDataDir()
+	if err != nil {
+		return filepath.Join(baseDir, name)
+	}
+	return filepath.Join(dir, name)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// TestUserDirs tests finding the config and keeping state in the XDG
+// directories when there is no config.json in the current directory
+func TestUserDirs(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		t.Skip("XDG_CONFIG_HOME is only used on Unix")
+	}
+
+	configHome, dataHome := t.TempDir(), t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	t.Setenv("XDG_DATA_HOME", dataHome)
+	configDir := filepath.Join(configHome, "dirsync")
+	os.MkdirAll(configDir, 0755)
+	if err := os.WriteFile(filepath.Join(configDir, "config.json"), []byte(`{"sync_pairs": ["src:dst"]}`), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	// Tests run in the package directory, which has no config.json
+	defer func() { config, baseDir = Config{}, "" }()
+	if err := loadConfig("", ""); err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if want := filepath.Join(configDir, "src") + ":" + filepath.Join(configDir, "dst"); config.SyncPairs[0] != want {
+		t.Errorf("Expected sync pair %s, got %s", want, config.SyncPairs[0])
+	}
+	if want := filepath.Join(dataHome, "dirsync", "history.json"); config.historyPath() != want {
+		t.Errorf("Expected history at %s, got %s", want, config.historyPath())
+	}
+
+	// A journal already next to the config stays there
+	os.WriteFile(filepath.Join(configDir, "journal.json"), []byte("{}"), 0644)
+	if want := filepath.Join(configDir, "journal.json"); config.journalPath() != want {
+		t.Errorf("Expected the existing journal at %s, got %s", want, config.journalPath())
+	}
+}
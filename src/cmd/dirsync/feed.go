@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// maxFeedEntries is how many of the most recent runs the feed lists
+const maxFeedEntries = 50
+
+// atomFeed is an Atom (RFC 4287) feed of finished runs
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Author  atomPerson  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID       string       `xml:"id"`
+	Title    string       `xml:"title"`
+	Updated  string       `xml:"updated"`
+	Category atomCategory `xml:"category"`
+	Content  atomContent  `xml:"content"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+// handleFeed serves an Atom feed of sync completions and failures, newest
+// first, optionally for one pair
+func handleFeed(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id != "" && syncManager.GetSyncByID(id) == nil {
+		http.Error(w, "Sync not found", http.StatusNotFound)
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	self := fmt.Sprintf("%s://%s%s", scheme, r.Host, r.URL.RequestURI())
+
+	feed := buildFeed(syncManager.History.ListRuns(id), self)
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(feed); err != nil {
+		log.Printf("Error encoding feed: %v", err)
+	}
+}
+
+// buildFeed turns the finished runs into feed entries, newest first
+func buildFeed(runs []dirsync.RunRecord, self string) atomFeed {
+	feed := atomFeed{
+		ID:     self,
+		Title:  "DirSync runs",
+		Link:   atomLink{Href: self, Rel: "self"},
+		Author: atomPerson{Name: "DirSync"},
+	}
+
+	var updated time.Time
+	for i := len(runs) - 1; i >= 0 && len(feed.Entries) < maxFeedEntries; i-- {
+		run := runs[i]
+		if run.EndTime.IsZero() {
+			continue
+		}
+		if run.EndTime.After(updated) {
+			updated = run.EndTime
+		}
+
+		entry := atomEntry{
+			ID:       "urn:dirsync:run:" + run.ID,
+			Title:    "Sync succeeded: " + run.SyncID,
+			Updated:  run.EndTime.UTC().Format(time.RFC3339),
+			Category: atomCategory{Term: "success"},
+		}
+
+		content := []string{
+			fmt.Sprintf("Started %s, took %v", run.StartTime.Format(time.RFC1123), run.EndTime.Sub(run.StartTime).Round(time.Second)),
+		}
+		if !run.Success {
+			entry.Title = "Sync failed: " + run.SyncID
+			entry.Category.Term = "failure"
+			content = append(content, "Error: "+run.Error)
+		} else if run.Skipped != "" {
+			entry.Title = "Sync skipped: " + run.SyncID
+			entry.Category.Term = "skipped"
+			content = append(content, "Skipped: "+run.Skipped)
+		}
+		if run.ChangeCount > 0 {
+			content = append(content, changeSummary(run))
+			for _, change := range run.Changes {
+				content = append(content, "  "+change.String())
+			}
+			if more := run.ChangeCount - len(run.Changes); more > 0 {
+				content = append(content, fmt.Sprintf("  ... and %d more", more))
+			}
+		}
+		for _, note := range run.Notes {
+			content = append(content, "Note: "+note.Text)
+		}
+		entry.Content = atomContent{Type: "text", Text: strings.Join(content, "\n")}
+
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	// Atom requires an update time even for an empty feed
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+
+	return feed
+}
+
+// changeSummary counts the listed changes of a run by action, e.g.
+// "Changed 12 paths: 3 created, 8 updated, 1 deleted"
+func changeSummary(run dirsync.RunRecord) string {
+	counts := make(map[string]int)
+	for _, change := range run.Changes {
+		counts[change.Action]++
+	}
+
+	summary := fmt.Sprintf("Changed %d paths", run.ChangeCount)
+	var parts []string
+	for _, action := range []string{dirsync.ChangeCreated, dirsync.ChangeUpdated, dirsync.ChangeDeleted} {
+		if counts[action] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[action], action))
+		}
+	}
+	if len(parts) > 0 {
+		summary += ": " + strings.Join(parts, ", ")
+		if len(run.Changes) < run.ChangeCount {
+			summary += " of the first " + fmt.Sprint(len(run.Changes))
+		}
+	}
+	return summary
+}
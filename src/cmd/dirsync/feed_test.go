@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// TestHandleFeed tests the Atom feed of finished runs
+func TestHandleFeed(t *testing.T) {
+	syncManager = dirsync.NewSyncManager(dirsync.Options{})
+	testSync := syncManager.AddSync(testSourceDir, testDestDir, 60)
+
+	syncManager.History.FinishRun(syncManager.History.StartRun(testSync.ID), nil)
+	syncManager.History.FinishRun(syncManager.History.StartRun(testSync.ID), errors.New("rsync error"))
+	syncManager.History.StartRun(testSync.ID) // still running
+
+	rr := httptest.NewRecorder()
+	handleFeed(rr, httptest.NewRequest("GET", "/api/feed.atom", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+
+	var feed atomFeed
+	if err := xml.Unmarshal(rr.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("Failed to parse feed: %v", err)
+	}
+	if len(feed.Entries) != 2 {
+		t.Fatalf("Expected 2 entries for finished runs, got %d", len(feed.Entries))
+	}
+	if feed.Entries[0].Category.Term != "failure" || feed.Entries[1].Category.Term != "success" {
+		t.Errorf("Expected newest run first, got %+v", feed.Entries)
+	}
+
+	rr = httptest.NewRecorder()
+	handleFeed(rr, httptest.NewRequest("GET", "/api/feed.atom?id=unknown", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown pair, got %d", rr.Code)
+	}
+}
+
+// TestFeedChanges tests that feed entries list the paths a run changed
+func TestFeedChanges(t *testing.T) {
+	now := time.Now()
+	run := dirsync.RunRecord{
+		ID:        "run-1",
+		SyncID:    "docs",
+		StartTime: now.Add(-time.Minute),
+		EndTime:   now,
+		Success:   true,
+	}
+	run.Changes = []dirsync.Change{
+		{Path: "new.txt", Action: dirsync.ChangeCreated},
+		{Path: "report.txt", Action: dirsync.ChangeUpdated, Attributes: []string{"size", "time"}},
+		{Path: "old.txt", Action: dirsync.ChangeDeleted},
+	}
+	run.ChangeCount = 5
+
+	feed := buildFeed([]dirsync.RunRecord{run}, "http://localhost/api/feed.atom")
+	if len(feed.Entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(feed.Entries))
+	}
+	content := feed.Entries[0].Content.Text
+	for _, want := range []string{
+		"Changed 5 paths: 1 created, 1 updated, 1 deleted of the first 3",
+		"new.txt (created)",
+		"report.txt (updated: size, time)",
+		"deleting old.txt",
+		"... and 2 more",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("Expected the entry to contain %q, got:\n%s", want, content)
+		}
+	}
+}
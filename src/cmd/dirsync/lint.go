@@ -0,0 +1,398 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// Lint severities, from most to least serious
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+	severityInfo    = "info"
+)
+
+// LintIssue is a problem found in the configuration, with a suggested fix
+type LintIssue struct {
+	Severity string `json:"severity"`
+	Pair     string `json:"pair,omitempty"`
+	Message  string `json:"message"`
+	Fix      string `json:"fix,omitempty"`
+}
+
+// String formats the issue for logs and the validate command
+func (i LintIssue) String() string {
+	s := fmt.Sprintf("[%s]", i.Severity)
+	if i.Pair != "" {
+		s += " " + i.Pair + ":"
+	}
+	s += " " + i.Message
+	if i.Fix != "" {
+		s += " (fix: " + i.Fix + ")"
+	}
+	return s
+}
+
+// lintConfig checks the configuration for problems that don't prevent
+// starting but are likely mistakes. history, if given, is used to compare
+// the sync interval against how long runs actually take.
+func lintConfig(cfg *Config, history *dirsync.History) []LintIssue {
+	var issues []LintIssue
+	add := func(severity, pair, message, fix string) {
+		issues = append(issues, LintIssue{Severity: severity, Pair: pair, Message: message, Fix: fix})
+	}
+
+	pairs, invalid := cfg.allPairs()
+	for _, pair := range invalid {
+		add(severityError, pair.Pair, pair.Message, pair.Fix)
+	}
+
+	if len(pairs) == 0 && len(invalid) == 0 {
+		add(severityWarning, "", "no sync pairs are configured", `add entries to "sync_pairs" or "pairs"`)
+	}
+
+	if cfg.SyncInterval <= 0 {
+		add(severityWarning, "", fmt.Sprintf("sync_interval is %d, pairs will sync back to back", cfg.SyncInterval),
+			`set "sync_interval" to the number of seconds between syncs`)
+	}
+
+	seen := make(map[string]bool)
+	for _, pair := range pairs {
+		id := pair.ID()
+
+		if pair.Source == "" || pair.Destination == "" {
+			add(severityError, id, "source and destination are required", "set both paths for the pair")
+			continue
+		}
+
+		switch {
+		case seen[id] && pair.Name != "":
+			add(severityError, id, fmt.Sprintf("name %q gives the same ID as another pair", pair.Name), "give each pair a different name")
+		case seen[id]:
+			add(severityWarning, id, "pair is configured more than once", "remove the duplicate entry")
+		}
+		seen[id] = true
+
+		if info, err := os.Stat(pair.Source); err != nil {
+			add(severityWarning, id, fmt.Sprintf("source is not accessible: %v", err), "create the source directory or fix the path")
+		} else if !info.IsDir() {
+			add(severityWarning, id, "source is not a directory", "point the pair at a directory")
+		}
+
+		if exists, err := checkDestinationReachable(pair.Destination); err != nil {
+			add(severityWarning, id, fmt.Sprintf("destination is not reachable: %v", err), "mount the destination or fix the path")
+		} else if !exists {
+			add(severityInfo, id, "destination does not exist yet and will be created on the first sync",
+				"if the destination is on a removable disk or network share, check that it is mounted")
+		}
+
+		if rel, ok := dirsync.DestinationWithin(pair.Source, pair.Destination); ok {
+			if rel == "." {
+				add(severityError, id, "source and destination are the same directory", "choose a different destination")
+			} else {
+				add(severityError, id, "destination is inside the source, the pair would copy the source into itself",
+					"move the destination outside the source")
+			}
+		} else if _, ok := dirsync.DestinationWithin(pair.Destination, pair.Source); ok {
+			add(severityError, id, "destination contains the source, the pair would copy the source into itself",
+				"move the destination outside the source")
+		}
+
+		if wol := pair.WakeOnLAN; wol != nil && wol.MAC != "" && wol.Host == "" {
+			add(severityInfo, id, "wake_on_lan has no host to wait for, syncs start right after the packet is sent",
+				`set "wake_on_lan.host" to a host:port that answers once the destination is up`)
+		}
+
+		if !dirsync.ValidTransferOrder(pair.TransferOrder) {
+			add(severityError, id, fmt.Sprintf("unknown transfer_order %q", pair.TransferOrder),
+				"use one of "+strings.Join(dirsync.TransferOrders, ", "))
+		}
+
+		if !dirsync.ValidPartialDir(pair.PartialDir) {
+			add(severityError, id, fmt.Sprintf("partial_dir %q would match directories of the same name anywhere in the destination", pair.PartialDir),
+				`use a dotted name like ".rsync-partial", or an absolute path`)
+		}
+
+		if !dirsync.ValidCaseCollisions(pair.CaseCollisions) {
+			add(severityError, id, fmt.Sprintf("unknown case_collisions %q", pair.CaseCollisions),
+				"use one of "+strings.Join(dirsync.CaseCollisionModes, ", "))
+		}
+
+		if !dirsync.ValidHashAlgorithm(pair.HashAlgorithm) {
+			add(severityError, id, fmt.Sprintf("unknown hash_algorithm %q", pair.HashAlgorithm),
+				"use one of "+strings.Join(dirsync.HashAlgorithms, ", "))
+		}
+
+		if pair.MaxErrors < 0 {
+			add(severityError, id, fmt.Sprintf("max_errors %d is negative", pair.MaxErrors), "set how many files may fail before a run stops, or 0 for no limit")
+		}
+
+		if pair.MaxDeletePercent < 0 || pair.MaxDeletePercent > 100 {
+			add(severityError, id, fmt.Sprintf("max_delete_percent %g is out of range 0-100", pair.MaxDeletePercent),
+				"set a percentage of the destination's files, or 0 for no limit")
+		} else if pair.MaxDeletePercent > 0 && !pair.Delete {
+			add(severityWarning, id, "max_delete_percent has no effect without delete", `set "delete" to true or remove "max_delete_percent"`)
+		}
+
+		for _, route := range pair.Routes {
+			if err := route.Validate(); err != nil {
+				add(severityError, id, err.Error(), `give each route "patterns" and a "destination"`)
+			}
+		}
+
+		for _, transform := range pair.Transforms {
+			if err := transform.Validate(); err != nil {
+				add(severityError, id, err.Error(), `give each transform "patterns" and a "command" or "plugin"`)
+			}
+		}
+
+		for _, name := range pair.Hooks {
+			if _, ok := dirsync.LookupHook(name); !ok && cfg.ExecHooks[name] == "" {
+				add(severityError, id, fmt.Sprintf("unknown hook %q", name), `define it in "exec_hooks" or remove it from "hooks"`)
+			}
+		}
+
+		if enc := pair.Encryption; enc != nil {
+			if err := enc.Validate(); err != nil {
+				add(severityError, id, err.Error(), `set "key_file" to a file holding a key from "openssl rand -hex 32"`)
+			} else if _, err := dirsync.LoadEncryptionKey(enc.KeyFile); err != nil {
+				add(severityError, id, err.Error(), `create it with "openssl rand -hex 32 > `+enc.KeyFile+`"`)
+			}
+		}
+
+		if pair.Schedule != nil {
+			if err := pair.Schedule.Validate(); err != nil {
+				add(severityError, id, err.Error(), `check "schedule"; its "type" is one of `+strings.Join(dirsync.SchedulerNames(), ", "))
+			}
+		}
+
+		if sched := pair.Schedule; sched != nil && len(sched.Ignore) > 0 {
+			if unmatched, err := dirsync.UnmatchedPatterns(pair.Source, sched.Ignore); err == nil && len(unmatched) > 0 {
+				add(severityWarning, id, fmt.Sprintf("schedule ignore patterns match nothing in the source: %s", strings.Join(unmatched, ", ")),
+					`check the patterns; ones with a "/" match the path inside the source, others the name`)
+			}
+		}
+
+		if pair.QuickCheck != nil {
+			if err := pair.QuickCheck.Validate(); err != nil {
+				add(severityError, id, err.Error(), `set "quick_check.full_scan_interval" to the most seconds runs may be skipped for, or leave it out for a day`)
+			}
+		}
+
+		if pair.Bandwidth != nil {
+			if err := pair.Bandwidth.Validate(); err != nil {
+				add(severityError, id, err.Error(), `give limits in KB/s, times like "09:00" and days like "mon"`)
+			}
+		}
+
+		if pair.Niceness != nil {
+			if err := pair.Niceness.Validate(); err != nil {
+				add(severityError, id, err.Error(), `set "niceness.cpu" between 0 and 19 and "niceness.io" to "idle" or "best-effort:N"`)
+			}
+		}
+
+		if pair.Deploy != nil {
+			if err := pair.Deploy.Validate(); err != nil {
+				add(severityError, id, err.Error(), `set "deploy.link" to a plain name such as "current" and "deploy.keep" to 1 or more`)
+			}
+		}
+
+		if m := pair.Maintenance; m != nil {
+			if err := m.Validate(); err != nil {
+				add(severityError, id, err.Error(), `set "maintenance.url" to the ICS calendar of the destination's maintenance windows`)
+			} else if _, err := os.Stat(m.URL); !m.IsRemote() && err != nil {
+				add(severityWarning, id, fmt.Sprintf("maintenance calendar %s can't be read: %v", m.URL, err),
+					"check the path; until it can be read, runs aren't held off for maintenance")
+			}
+		}
+
+		if report := pair.Report; report != nil && report.KeyFile != "" {
+			if _, err := dirsync.LoadReportKey(report.KeyFile); err != nil {
+				add(severityError, id, err.Error(), `create it with "openssl rand -hex 32 > `+report.KeyFile+`"`)
+			}
+		}
+
+		if pair.RemoteHooks != nil {
+			if err := pair.RemoteHooks.Validate(); err != nil {
+				add(severityError, id, err.Error(), `give "remote_hooks" a "host" to ssh to and the "commands" to run there`)
+			}
+			if socket := pair.RemoteHooks.AgentSocket; socket != "" {
+				if _, err := os.Stat(socket); err != nil {
+					add(severityWarning, id, fmt.Sprintf("remote_hooks agent_socket is not available: %v", err),
+						"start ssh-agent with its socket there and add the key with ssh-add")
+				}
+			}
+		}
+
+		if cfg.MaxMemoryMB > 0 && pair.TransferOrder != "" {
+			add(severityWarning, id, "transfer_order keeps every file of the source in memory to sort them, whatever max_memory_mb says",
+				`leave out "transfer_order" for sources with millions of files`)
+		}
+
+		if cost := pair.Cost; cost != nil && (cost.TransferPerGB < 0 || cost.StoragePerGBMonth < 0) {
+			add(severityError, id, "cost has a negative price", `set "transfer_per_gb" and "storage_per_gb_month" to 0 or more`)
+		}
+
+		if history != nil && cfg.SyncInterval > 0 {
+			if typical, ok := typicalRunDuration(history, id); ok && typical > time.Duration(cfg.SyncInterval)*time.Second {
+				add(severityWarning, id, fmt.Sprintf("runs typically take %v, longer than the %ds sync interval",
+					typical.Round(time.Second), cfg.SyncInterval),
+					fmt.Sprintf(`raise "sync_interval" to at least %d`, int(typical.Seconds())+1))
+			}
+		}
+	}
+
+	issues = append(issues, lintDependencies(pairs)...)
+
+	if cfg.MaxMemoryMB < 0 {
+		add(severityError, "", fmt.Sprintf("max_memory_mb %d is negative", cfg.MaxMemoryMB), `set "max_memory_mb" to 0 for no limit, or the megabytes the daemon should stay within`)
+	}
+
+	if cfg.Metrics != nil {
+		if err := cfg.Metrics.Validate(); err != nil {
+			add(severityError, "", err.Error(), `set "metrics.pair_label" to "id" or "slug", and the limits to 0 or more`)
+		}
+	}
+
+	for _, wh := range cfg.Webhooks {
+		if wh.URL == "" {
+			add(severityError, "", "webhook has no url", `set "url" or remove the webhook`)
+		}
+		if err := wh.Validate(); err != nil {
+			add(severityError, "", err.Error(), "fix the template syntax")
+		}
+	}
+
+	// Most serious issues first
+	rank := map[string]int{severityError: 0, severityWarning: 1, severityInfo: 2}
+	sort.SliceStable(issues, func(i, j int) bool {
+		return rank[issues[i].Severity] < rank[issues[j].Severity]
+	})
+
+	return issues
+}
+
+// lintDependencies checks that pairs only run after pairs that exist, and
+// never, however indirectly, after themselves. Named pairs may also be
+// listed by their paths.
+func lintDependencies(pairs []dirsync.PairConfig) []LintIssue {
+	var issues []LintIssue
+	ids := make(map[string]string, len(pairs))
+	after := make(map[string][]string, len(pairs))
+	for _, pair := range pairs {
+		ids[pair.ID()] = pair.ID()
+		ids[pair.Source+":"+pair.Destination] = pair.ID()
+	}
+	for _, pair := range pairs {
+		for _, upstream := range pair.After {
+			if id, ok := ids[upstream]; ok {
+				after[pair.ID()] = append(after[pair.ID()], id)
+			}
+		}
+	}
+
+	for _, pair := range pairs {
+		id := pair.ID()
+		for _, upstream := range pair.After {
+			if _, ok := ids[upstream]; !ok {
+				issues = append(issues, LintIssue{Severity: severityError, Pair: id,
+					Message: fmt.Sprintf("runs after unknown pair %q", upstream),
+					Fix:     `list pairs in "after" by their ID, "source:destination"`})
+			}
+		}
+	}
+
+	// Follow each pair's dependencies, reporting a cycle once from the pair
+	// it was found at
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int, len(after))
+	var visit func(id string, path []string)
+	visit = func(id string, path []string) {
+		switch state[id] {
+		case visiting:
+			for i, p := range path {
+				if p == id {
+					issues = append(issues, LintIssue{Severity: severityError, Pair: id,
+						Message: "pairs run after each other in a cycle: " + strings.Join(append(path[i:], id), " -> "),
+						Fix:     `remove one of the pairs from the other's "after"`})
+					break
+				}
+			}
+			return
+		case visited:
+			return
+		}
+
+		state[id] = visiting
+		for _, upstream := range after[id] {
+			visit(upstream, append(path, id))
+		}
+		state[id] = visited
+	}
+	for _, pair := range pairs {
+		visit(pair.ID(), nil)
+	}
+
+	return issues
+}
+
+// checkDestinationReachable checks that the destination, or the closest
+// existing parent it would be created in, is a directory. It also reports
+// whether the destination itself exists.
+func checkDestinationReachable(dest string) (bool, error) {
+	path := dest
+	for {
+		info, err := os.Stat(path)
+		if err == nil {
+			if !info.IsDir() {
+				return false, fmt.Errorf("%s is not a directory", path)
+			}
+			return path == dest, nil
+		}
+		if !os.IsNotExist(err) {
+			return false, err
+		}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			return false, err
+		}
+		path = parent
+	}
+}
+
+// typicalRunDuration returns the median duration of a pair's successful runs
+func typicalRunDuration(history *dirsync.History, syncID string) (time.Duration, bool) {
+	var durations []time.Duration
+	for _, run := range history.ListRuns(syncID) {
+		if run.Success && run.Skipped == "" && !run.EndTime.IsZero() {
+			durations = append(durations, run.EndTime.Sub(run.StartTime))
+		}
+	}
+
+	if len(durations) == 0 {
+		return 0, false
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return durations[len(durations)/2], true
+}
+
+// hasErrors reports whether any issue is an error
+func hasErrors(issues []LintIssue) bool {
+	for _, issue := range issues {
+		if issue.Severity == severityError {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// findIssue returns the first issue whose message contains text
+func findIssue(issues []LintIssue, text string) (LintIssue, bool) {
+	for _, issue := range issues {
+		if strings.Contains(issue.Message, text) {
+			return issue, true
+		}
+	}
+	return LintIssue{}, false
+}
+
+// TestLintConfig tests reporting likely configuration mistakes
+func TestLintConfig(t *testing.T) {
+	pair := testSourceDir + ":" + testDestDir
+	testConfig := &Config{
+		SyncInterval: 1,
+		SyncPairs:    []string{pair, pair, "invalid", testSourceDir + ":/"},
+		Pairs: []dirsync.PairConfig{
+			{Source: "/non/existent/source", Destination: testDestDir},
+			{Source: testSourceDir, Destination: filepath.Join(testSourceDir, "backup")},
+			{Source: filepath.Join(testDestDir, "inner"), Destination: testDestDir},
+			{Source: testSourceDir, Destination: filepath.Join(testDestDir, "ordered"), TransferOrder: "random", MaxDeletePercent: 150, MaxErrors: -1, CaseCollisions: "merge", HashAlgorithm: "crc32", PartialDir: "tmp",
+				Routes:      []dirsync.RouteConfig{{Patterns: []string{"[*.jpg"}, Destination: "photos"}},
+				Transforms:  []dirsync.TransformConfig{{Patterns: []string{"*.jpg"}}},
+				Hooks:       []string{"virus-scan"},
+				Cost:        &dirsync.CostConfig{TransferPerGB: -0.09},
+				Niceness:    &dirsync.NicenessConfig{CPU: 25},
+				Bandwidth:   &dirsync.BandwidthConfig{Schedule: []dirsync.BandwidthWindow{{Start: "9am", End: "17:00"}}},
+				Encryption:  &dirsync.EncryptionConfig{},
+				Schedule:    &dirsync.ScheduleConfig{Type: "cron", Cron: "0 25 * * *"},
+				RemoteHooks: &dirsync.RemoteHookConfig{Host: "nas", AgentSocket: "/non/existent/agent.sock"},
+				Deploy:      &dirsync.DeployConfig{Link: "www/current"},
+				Maintenance: &dirsync.MaintenanceConfig{},
+				QuickCheck:  &dirsync.QuickCheckConfig{FullScanInterval: -1},
+				Report:      &dirsync.ReportConfig{KeyFile: "/non/existent/key"},
+				After:       []string{"nowhere:else"}},
+			{Name: "Backup", Source: testSourceDir, Destination: filepath.Join(testDestDir, "a")},
+			{Name: "backup!", Source: testSourceDir, Destination: filepath.Join(testDestDir, "b")},
+		},
+		Webhooks:    []dirsync.WebhookConfig{{URL: "http://example.com", Template: "{{.Broken"}},
+		Metrics:     &MetricsConfig{PairLabel: "uuid"},
+		MaxMemoryMB: 64,
+	}
+
+	// Runs of the first pair take longer than the interval
+	history, _ := dirsync.NewHistory("", 0)
+	for i := 0; i < 3; i++ {
+		run := history.StartRun(pair)
+		run.StartTime = time.Now().Add(-10 * time.Second)
+		history.FinishRun(run, nil)
+	}
+
+	issues := lintConfig(testConfig, history)
+
+	expected := map[string]string{
+		"invalid sync pair format":               severityError,
+		"is the root of the filesystem":          severityError,
+		"configured more than once":              severityWarning,
+		"source is not accessible":               severityWarning,
+		"destination is inside the source":       severityError,
+		"destination contains the source":        severityError,
+		"longer than the 1s sync interval":       severityWarning,
+		"invalid webhook template":               severityError,
+		"does not exist yet and will be created": severityInfo,
+		"unknown transfer_order":                 severityError,
+		"max_delete_percent 150 is out of range": severityError,
+		"max_errors -1 is negative":              severityError,
+		"unknown case_collisions":                severityError,
+		"unknown hash_algorithm":                 severityError,
+		"partial_dir \"tmp\" would match":        severityError,
+		"invalid route pattern":                  severityError,
+		"transform has no command":               severityError,
+		"unknown hook":                           severityError,
+		"cost has a negative price":              severityError,
+		"encryption has no key_file":             severityError,
+		"is out of range 0-23":                   severityError,
+		"remote_hooks has no commands":           severityError,
+		"agent_socket is not available":          severityWarning,
+		"bandwidth schedule 1 start":             severityError,
+		"niceness cpu 25":                        severityError,
+		"transfer_order keeps every file":        severityWarning,
+		"must be a name, not a path":             severityError,
+		"maintenance has no url":                 severityError,
+		"full_scan_interval -1 is negative":      severityError,
+		"unknown metrics pair_label":             severityError,
+	}
+
+	for text, severity := range expected {
+		issue, ok := findIssue(issues, text)
+		if !ok {
+			t.Errorf("Expected issue %q, got %v", text, issues)
+			continue
+		}
+		if issue.Severity != severity {
+			t.Errorf("Expected issue %q to be %s, got %s", text, severity, issue.Severity)
+		}
+		if issue.Fix == "" {
+			t.Errorf("Expected issue %q to suggest a fix", text)
+		}
+	}
+
+	if !hasErrors(issues) {
+		t.Errorf("Expected hasErrors to be true")
+	}
+
+	// Errors should be reported first
+	if issues[0].Severity != severityError {
+		t.Errorf("Expected errors to be sorted first, got %v", issues[0])
+	}
+}
+
+// TestLintConfigClean tests that a sensible configuration has no warnings
+func TestLintConfigClean(t *testing.T) {
+	testConfig := &Config{
+		SyncInterval: 60,
+		SyncPairs:    []string{testSourceDir + ":" + testDestDir},
+	}
+
+	issues := lintConfig(testConfig, nil)
+	if len(issues) != 0 {
+		t.Errorf("Expected no issues, got %v", issues)
+	}
+}
+
+// TestLintUnmatchedIgnore tests warning about watch ignore patterns that
+// match nothing in the source
+func TestLintUnmatchedIgnore(t *testing.T) {
+	source := t.TempDir()
+	os.MkdirAll(filepath.Join(source, "build"), 0755)
+	os.WriteFile(filepath.Join(source, "build", "app.o"), []byte("obj"), 0644)
+	os.WriteFile(filepath.Join(source, "notes.swp"), []byte("swap"), 0644)
+
+	testConfig := &Config{
+		SyncInterval: 60,
+		Pairs: []dirsync.PairConfig{{Source: source, Destination: filepath.Join(t.TempDir(), "dest"),
+			Schedule: &dirsync.ScheduleConfig{Type: "watch", Ignore: []string{"*.SWP", "build/*", "*~", "dist/*"}}}},
+	}
+
+	issue, ok := findIssue(lintConfig(testConfig, nil), "match nothing")
+	if !ok || issue.Severity != severityWarning || !strings.HasSuffix(issue.Message, ": *~, dist/*") {
+		t.Errorf("Expected a warning about *~ and dist/* only, got %+v", issue)
+	}
+}
+
+// TestLintDependencies tests finding pairs that run after each other in a cycle
+func TestLintDependencies(t *testing.T) {
+	pairs := []dirsync.PairConfig{
+		{Source: "/a", Destination: "/b", After: []string{"/c:/d"}},
+		{Source: "/c", Destination: "/d", After: []string{"/e:/f"}},
+		{Source: "/e", Destination: "/f", After: []string{"/a:/b"}},
+		{Source: "/g", Destination: "/h", After: []string{"/a:/b"}},
+	}
+
+	issues := lintDependencies(pairs)
+	if len(issues) != 1 || !strings.Contains(issues[0].Message, "cycle: /a:/b -> /c:/d -> /e:/f -> /a:/b") {
+		t.Errorf("Expected one cycle, got %v", issues)
+	}
+
+	if issues := lintDependencies(pairs[1:3]); len(issues) != 1 || !strings.Contains(issues[0].Message, "unknown pair") {
+		t.Errorf("Expected only an unknown pair, got %v", issues)
+	}
+}
@@ -0,0 +1,949 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"embed"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// staticFiles holds the web UI bundled into the binary
+//
+//go:embed static
+var staticFiles embed.FS
+
+var (
+	config      Config
+	baseDir     string
+	syncManager *dirsync.SyncManager
+)
+
+func main() {
+	// Configure logging
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	// Run a subcommand if one was given
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "bench":
+			os.Exit(runBench(os.Args[2:]))
+		case "once":
+			os.Exit(runOnce(os.Args[2:]))
+		case "status":
+			os.Exit(runStatus(os.Args[2:]))
+		case "top":
+			os.Exit(runTop(os.Args[2:]))
+		case "restore":
+			os.Exit(runRestore(os.Args[2:]))
+		case "history":
+			os.Exit(runHistory(os.Args[2:]))
+		case "secret":
+			os.Exit(runSecret(os.Args[2:], os.Stdin))
+		case "health":
+			os.Exit(runHealth(os.Args[2:]))
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown command: %s\n", os.Args[1])
+			fmt.Fprintln(os.Stderr, "Usage: dirsync [--config <file>] [--oneshot | validate [--config <file>] | bench <source> <destination> | once --pair <source>:<destination> | status | top | restore --key-file <key> <destination> <target> | history [--from <time>] [--to <time>] | secret set <name> | health]")
+			os.Exit(2)
+		}
+	}
+
+	var overrides Overrides
+	overrides.registerFlags(flag.CommandLine)
+	oneshot := flag.Bool("oneshot", false, "sync every configured pair once, print a summary and exit instead of serving")
+	flag.Parse()
+
+	if err := setupLogging(overrides.LogFormat); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("Starting DirSync application")
+
+	// Load config, which can be left out if the pairs are given in the
+	// environment or on the command line
+	if err := loadConfig(overrides.ConfigFile, overrides.Profile); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) || overrides.ConfigFile != "" || !overrides.configured() {
+			log.Fatal(err)
+		}
+		log.Println("No config file found, using settings from the environment and command line")
+	}
+	if err := overrides.apply(&config); err != nil {
+		log.Fatal(err)
+	}
+
+	// Log the loaded configuration
+	log.Printf("Loaded configuration: Sync interval: %d seconds, Sync pairs: %v, Structured pairs: %d, Port: %s",
+		config.SyncInterval, config.SyncPairs, len(config.Pairs), config.Port)
+
+	// Load the run history
+	historyPath := config.historyPath()
+	for _, path := range []string{historyPath, config.journalPath()} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			log.Fatalf("Error creating state directory: %v", err)
+		}
+	}
+	log.Printf("Using run history file: %s", historyPath)
+
+	history, err := dirsync.NewHistory(historyPath, config.HistoryLimit)
+	if err != nil {
+		log.Fatalf("Error loading history: %v", err)
+	}
+
+	// Runs in progress are journaled so they can be recovered if the process dies
+	journal, err := dirsync.OpenJournal(config.journalPath())
+	if err != nil {
+		log.Fatalf("Error loading run journal: %v", err)
+	}
+
+	// Connections remote hooks keep open get their sockets in a private
+	// directory rather than in the shared temporary directory
+	if err := dirsync.SetSSHControlDir(statePath("ssh")); err != nil {
+		log.Fatalf("Error creating SSH control directory: %v", err)
+	}
+
+	// Report likely configuration mistakes
+	for _, issue := range lintConfig(&config, history) {
+		log.Printf("Config %s", issue)
+	}
+
+	// Check webhook templates up front rather than on the first notification
+	for _, wh := range config.Webhooks {
+		if err := wh.Validate(); err != nil {
+			log.Fatalf("Error in webhook configuration: %v", err)
+		}
+	}
+
+	// Load plugins, if there are any
+	plugins, err := dirsync.LoadPlugins(context.Background(), config.pluginsPath())
+	if err != nil {
+		log.Fatalf("Error loading plugins: %v", err)
+	}
+	if names := plugins.Names(); len(names) > 0 {
+		log.Printf("Loaded plugins from %s: %s", config.pluginsPath(), strings.Join(names, ", "))
+	}
+
+	// Make exec hooks available alongside any compiled-in ones
+	config.registerExecHooks()
+	if names := dirsync.HookNames(); len(names) > 0 {
+		log.Printf("Registered hooks: %s", strings.Join(names, ", "))
+	}
+
+	// Count runs for the metrics endpoint as they finish, and keep the
+	// static status report up to date
+	onRunFinished := statusPageWriter(config.StatusPage)
+	if config.Metrics != nil {
+		metrics = newMetricsCollector(*config.Metrics)
+		writeStatusPage := onRunFinished
+		onRunFinished = func(run dirsync.RunRecord) {
+			metrics.observe(run)
+			if writeStatusPage != nil {
+				writeStatusPage(run)
+			}
+		}
+	}
+
+	// Make the garbage collector work harder as the daemon nears its
+	// memory budget, instead of letting the heap double
+	if config.MaxMemoryMB > 0 {
+		debug.SetMemoryLimit(int64(config.MaxMemoryMB) << 20)
+	}
+
+	// Initialize sync manager
+	syncManager = dirsync.NewSyncManager(dirsync.Options{
+		History:           history,
+		Webhooks:          config.Webhooks,
+		Plugins:           plugins,
+		Journal:           journal,
+		ChangeLimit:       config.ChangeLimit,
+		MaxConcurrentRuns: config.MaxConcurrentRuns,
+		MaxMemoryMB:       config.MaxMemoryMB,
+		OnRunFinished:     onRunFinished,
+	})
+
+	// Sync everything once and exit, e.g. as a Kubernetes Job
+	if *oneshot {
+		os.Exit(runOneshot(syncManager, &config))
+	}
+
+	// Stop cleanly when the container is stopped
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Start sync process in a goroutine
+	go StartSyncProcess(ctx, syncManager, &config)
+
+	// Show the health of all pairs on a status LED
+	if config.StatusLED != nil {
+		led, err := openLED(config.StatusLED)
+		if err != nil {
+			log.Fatalf("Error setting up status LED: %v", err)
+		}
+		go runStatusLED(ctx, led, syncManager)
+	}
+
+	// Set up routes
+	staticHandler, err := newStaticHandler(config.StaticDir)
+	if err != nil {
+		log.Fatalf("Error setting up static files: %v", err)
+	}
+
+	http.Handle("/", staticHandler)
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/status", handleStatus)
+	http.HandleFunc("/api/summary", handleSummary)
+	http.HandleFunc("/badge/", handleBadge)
+	http.HandleFunc("/api/sync/now", handleSyncNow)
+	http.HandleFunc("/api/sync/details", handleSyncDetails)
+	http.HandleFunc("/api/sync/diff", handleSyncDiff)
+	http.HandleFunc("/api/browse", handleBrowse)
+	http.HandleFunc("/api/pairs/", handlePairs)
+	http.HandleFunc("/api/sync/pause", handleSyncPause)
+	http.HandleFunc("/api/sync/resume", handleSyncResume)
+	http.HandleFunc("/api/history", handleHistory)
+	http.HandleFunc("/api/history/export", handleHistoryExport)
+	http.HandleFunc("/api/history/window", handleHistoryWindow)
+	http.HandleFunc("/api/history/run", handleHistoryRun)
+	http.HandleFunc("/api/runs/", handleRun)
+	http.HandleFunc("/api/notes", handleNotes)
+	http.HandleFunc("/api/calendar.ics", handleCalendar)
+	http.HandleFunc("/api/feed.atom", handleFeed)
+	http.HandleFunc("/api/costs", handleCosts)
+	http.HandleFunc("/api/profile", handleProfile)
+	if metrics != nil {
+		http.HandleFunc("/metrics", handleMetrics)
+	}
+	if config.DebugAPI {
+		log.Println("Debug API is enabled")
+		http.HandleFunc("/api/debug/inject", handleInjectFailure)
+	}
+
+	// Start server
+	port := config.listenPort()
+
+	if config.Auth.enabled() {
+		log.Println("Authentication is enabled for all routes")
+	}
+	if config.ReadOnly {
+		log.Println("Read-only mode is enabled, sync controls are disabled")
+	}
+
+	server := &http.Server{
+		Addr:    port,
+		Handler: withHealthz(withAuth(config.Auth, withReadOnly(config.ReadOnly, http.DefaultServeMux))),
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		shutdown(server, syncManager)
+		close(stopped)
+	}()
+
+	if config.TLS.enabled() {
+		cert, err := loadCertificate(config.TLS)
+		if err != nil {
+			log.Fatalf("Error loading TLS certificate: %v", err)
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+		// Redirect plain HTTP to HTTPS if requested
+		if redirectPort := config.TLS.RedirectPort; redirectPort != "" {
+			if !strings.HasPrefix(redirectPort, ":") {
+				redirectPort = ":" + redirectPort
+			}
+			go func() {
+				log.Printf("Redirecting http://localhost%s to HTTPS", redirectPort)
+				if err := http.ListenAndServe(redirectPort, redirectToHTTPS(port)); err != nil {
+					log.Printf("HTTP redirect server error: %v", err)
+				}
+			}()
+		}
+
+		log.Printf("Starting server on https://localhost%s", port)
+		if err := server.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
+			log.Fatalf("Server error: %v", err)
+		}
+		<-stopped
+		return
+	}
+
+	log.Printf("Starting server on http://localhost%s", port)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatalf("Server error: %v", err)
+	}
+	<-stopped
+}
+
+// StartSyncProcess adds a sync for every configured pair and starts them,
+// running until ctx is done
+func StartSyncProcess(ctx context.Context, syncManager *dirsync.SyncManager, config *Config) {
+	log.Println("Starting sync process")
+
+	addPairs(syncManager, config)
+	syncManager.RecoverInterrupted(config.ResumeInterrupted)
+	syncManager.Start(ctx, config.SyncInterval)
+}
+
+// addPairs adds a sync for every valid configured pair
+func addPairs(syncManager *dirsync.SyncManager, config *Config) {
+	pairs, invalid := config.allPairs()
+	for _, pair := range invalid {
+		log.Printf("Invalid sync pair %s: %s, %s", pair.Pair, pair.Message, pair.Fix)
+	}
+
+	// Create a sync for each pair
+	for _, pair := range pairs {
+		if pair.Source == "" || pair.Destination == "" {
+			log.Printf("Invalid sync pair, source and destination are required: %+v", pair)
+			continue
+		}
+
+		s := syncManager.AddPair(pair, config.SyncInterval)
+		if !inProfile(pair, config.Profile) {
+			s.SetEnabled(false)
+		}
+	}
+}
+
+// runValidate checks the configuration, prints any issues and returns the exit code
+func runValidate(args []string) int {
+	log.SetOutput(io.Discard)
+
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	var overrides Overrides
+	overrides.registerFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if err := loadConfig(overrides.ConfigFile, overrides.Profile); err != nil &&
+		(!errors.Is(err, fs.ErrNotExist) || overrides.ConfigFile != "" || !overrides.configured()) {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := overrides.apply(&config); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	history, err := dirsync.NewHistory(config.historyPath(), config.HistoryLimit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not load history: %v\n", err)
+		history = nil
+	}
+
+	issues := lintConfig(&config, history)
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+
+	if len(issues) == 0 {
+		fmt.Println("Configuration OK")
+	}
+
+	if hasErrors(issues) {
+		return 1
+	}
+	return 0
+}
+
+// newStaticHandler serves the web UI from dir if set, or from the embedded files otherwise
+func newStaticHandler(dir string) (http.Handler, error) {
+	if dir != "" {
+		log.Printf("Serving static files from: %s", dir)
+
+		// Check if static directory exists
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			return nil, fmt.Errorf("static directory not found: %s", dir)
+		}
+
+		return http.FileServer(http.Dir(dir)), nil
+	}
+
+	log.Println("Serving embedded static files")
+	sub, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		return nil, err
+	}
+
+	return http.FileServer(http.FS(sub)), nil
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	statuses := syncManager.GetAllStatus()
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		statuses = []map[string]interface{}{}
+		for _, sync := range syncManager.SyncsWithTag(tag) {
+			statuses = append(statuses, sync.GetStatus())
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(statuses); err != nil {
+		log.Printf("Error encoding status: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleSyncNow triggers an immediate sync of one pair, the pairs with a tag,
+// or all pairs if neither is given
+func handleSyncNow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response := map[string]interface{}{"success": true, "message": "Sync triggered"}
+
+	if id := r.URL.Query().Get("id"); id != "" {
+		sync := syncManager.GetSyncByID(id)
+		if sync == nil {
+			http.Error(w, "Sync not found", http.StatusNotFound)
+			return
+		}
+
+		log.Printf("Manual sync triggered: %s", id)
+		result := sync.TriggerSync()
+		response["state"] = result
+		switch result {
+		case dirsync.TriggerFollowUp:
+			response["message"] = "Sync running, another run will follow it"
+		case dirsync.TriggerCoalesced:
+			response["message"] = "Sync already queued, trigger coalesced"
+		}
+	} else if tag := r.URL.Query().Get("tag"); tag != "" {
+		states := syncManager.TriggerTagged(tag)
+		if len(states) == 0 {
+			http.Error(w, "No syncs tagged "+tag, http.StatusNotFound)
+			return
+		}
+
+		log.Printf("Manual sync triggered for tag %s", tag)
+		response["states"] = states
+	} else {
+		log.Println("Manual sync triggered")
+
+		// Trigger all syncs
+		response["states"] = syncManager.TriggerAllSyncs()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleSyncDetails returns details for a specific sync
+func handleSyncDetails(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "Missing sync ID", http.StatusBadRequest)
+		return
+	}
+
+	sync := syncManager.GetSyncByID(id)
+	if sync == nil {
+		http.Error(w, "Sync not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(sync.GetStatus()); err != nil {
+		log.Printf("Error encoding sync details: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleSyncDiff compares a sync's source with its destination without
+// copying anything, for previewing a manual sync
+func handleSyncDiff(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "Missing sync ID", http.StatusBadRequest)
+		return
+	}
+
+	sync := syncManager.GetSyncByID(id)
+	if sync == nil {
+		http.Error(w, "Sync not found", http.StatusNotFound)
+		return
+	}
+
+	report, err := sync.Diff()
+	if errors.Is(err, dirsync.ErrDiffUnsupported) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error comparing %s: %v", id, err)
+		http.Error(w, "Error comparing source and destination: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("Error encoding diff: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// handleBrowse lists a directory in a pair's source or destination
+func handleBrowse(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	id := query.Get("id")
+	if id == "" {
+		http.Error(w, "Missing sync ID", http.StatusBadRequest)
+		return
+	}
+
+	sync := syncManager.GetSyncByID(id)
+	if sync == nil {
+		http.Error(w, "Sync not found", http.StatusNotFound)
+		return
+	}
+
+	side := query.Get("side")
+	if side == "" {
+		side = dirsync.BrowseSource
+	}
+
+	listing, err := sync.Browse(side, query.Get("path"))
+	switch {
+	case errors.Is(err, dirsync.ErrUnknownSide), errors.Is(err, dirsync.ErrOutsideRoot), errors.Is(err, dirsync.ErrNotDirectory):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case os.IsNotExist(err):
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("Error browsing %s: %v", id, err)
+		http.Error(w, "Error listing directory: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(listing); err != nil {
+		log.Printf("Error encoding listing: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// handlePairs serves a pair's statistics at /api/pairs/{pair}/stats, and
+// updates the pair with PATCH /api/pairs/{pair}
+func handlePairs(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.EscapedPath(), "/stats") {
+		handlePairStats(w, r)
+		return
+	}
+	handlePairUpdate(w, r)
+}
+
+// findPathSync returns the pair named in a URL path, like badges or by its
+// escaped ID
+func findPathSync(name string) *dirsync.Sync {
+	sync := findBadgeSync(name)
+	if id, err := url.PathUnescape(name); sync == nil && err == nil {
+		sync = syncManager.GetSyncByID(id)
+	}
+	return sync
+}
+
+// handlePairUpdate enables or disables a pair with PATCH /api/pairs/{pair}
+// and a body like {"enabled": false}, and returns the pair's status
+func handlePairUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.EscapedPath(), "/api/pairs/")
+	sync := findPathSync(name)
+	if name == "" || strings.Contains(name, "/") || sync == nil {
+		http.Error(w, "Sync not found", http.StatusNotFound)
+		return
+	}
+
+	var request struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.Enabled == nil {
+		http.Error(w, "Missing enabled", http.StatusBadRequest)
+		return
+	}
+
+	if *request.Enabled != sync.Enabled() {
+		sync.SetEnabled(*request.Enabled)
+		note := "Pair disabled"
+		if *request.Enabled {
+			note = "Pair enabled"
+		}
+		if err := syncManager.History.AnnotatePair(sync.ID, note); err != nil {
+			log.Printf("Error saving history: %v", err)
+		}
+		log.Printf("%s: %s", note, sync.ID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(sync.GetStatus()); err != nil {
+		log.Printf("Error encoding status: %v", err)
+	}
+}
+
+// handlePairStats returns the statistics from the last scan of a pair's
+// source and destination, at /api/pairs/{pair}/stats. A scan is started if
+// they're out of date, and 202 is returned until the first one is done.
+func handlePairStats(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.EscapedPath(), "/api/pairs/"), "/stats")
+	if !ok || name == "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	sync := findPathSync(name)
+	if sync == nil {
+		http.Error(w, "Sync not found", http.StatusNotFound)
+		return
+	}
+
+	stats := sync.DirStats()
+	status := http.StatusOK
+	if stats == nil {
+		stats = &dirsync.PairStats{SyncID: sync.ID, Scanning: true}
+		status = http.StatusAccepted
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		log.Printf("Error encoding stats: %v", err)
+	}
+}
+
+// handleSyncPause pauses a specific sync, or all syncs with a tag
+func handleSyncPause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		handleTagged(w, tag, "Paused", (*dirsync.Sync).PauseSync)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "Missing sync ID", http.StatusBadRequest)
+		return
+	}
+
+	success := syncManager.PauseSyncByID(id)
+	if !success {
+		http.Error(w, "Sync not found", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Paused sync: %s", id)
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"success": true, "message": "Sync paused"}`)
+}
+
+// handleSyncResume resumes a specific sync, or all syncs with a tag
+func handleSyncResume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		handleTagged(w, tag, "Resumed", (*dirsync.Sync).ResumeSync)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "Missing sync ID", http.StatusBadRequest)
+		return
+	}
+
+	success := syncManager.ResumeSyncByID(id)
+	if !success {
+		http.Error(w, "Sync not found", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Resumed sync: %s", id)
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"success": true, "message": "Sync resumed"}`)
+}
+
+// handleTagged applies action to every sync with a tag, and responds with
+// the IDs of the syncs it was applied to
+func handleTagged(w http.ResponseWriter, tag, done string, action func(*dirsync.Sync)) {
+	syncs := syncManager.SyncsWithTag(tag)
+	if len(syncs) == 0 {
+		http.Error(w, "No syncs tagged "+tag, http.StatusNotFound)
+		return
+	}
+
+	ids := make([]string, len(syncs))
+	for i, sync := range syncs {
+		action(sync)
+		ids[i] = sync.ID
+	}
+	log.Printf("%s %d syncs tagged %s", done, len(syncs), tag)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("%s %d syncs", done, len(syncs)),
+		"ids":     ids,
+	})
+}
+
+// handleHistory returns the recorded runs, optionally for a single sync
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+
+	// Changed paths, failed files and output are left to the run details
+	// to keep the list small
+	runs := syncManager.History.ListRuns(id)
+	for i := range runs {
+		runs[i].Changes = nil
+		runs[i].Failures = nil
+		runs[i].Output = ""
+	}
+
+	response := map[string]interface{}{
+		"runs": runs,
+	}
+	if id != "" {
+		response["notes"] = syncManager.History.GetPairNotes(id)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding history: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleHistoryRun serves a single run, including the paths it changed
+func handleHistoryRun(w http.ResponseWriter, r *http.Request) {
+	writeRun(w, r.URL.Query().Get("id"))
+}
+
+// handleRun serves a single run by the ID in its path, with its output
+func handleRun(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/runs/")
+	if runID, ok := strings.CutSuffix(id, "/failures"); ok && runID != "" && !strings.Contains(runID, "/") {
+		handleRunFailures(w, runID)
+		return
+	}
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	writeRun(w, id)
+}
+
+// handleRunFailures returns the files a run couldn't copy, at
+// /api/runs/{run_id}/failures
+func handleRunFailures(w http.ResponseWriter, id string) {
+	run, ok := syncManager.GetRun(id)
+	if !ok {
+		http.Error(w, "Run not found", http.StatusNotFound)
+		return
+	}
+
+	failures := run.Failures
+	if failures == nil {
+		failures = []dirsync.FileFailure{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"run_id":        run.ID,
+		"sync_id":       run.SyncID,
+		"failure_count": run.FailureCount,
+		"failures":      failures,
+	})
+	if err != nil {
+		log.Printf("Error encoding run failures: %v", err)
+	}
+}
+
+// writeRun writes a run, or a 404 if there is no run with the ID
+func writeRun(w http.ResponseWriter, id string) {
+	run, ok := syncManager.GetRun(id)
+	if !ok {
+		http.Error(w, "Run not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(run); err != nil {
+		log.Printf("Error encoding run: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// handleHistoryExport exports the run history, including notes, as JSON or CSV
+func handleHistoryExport(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	runs := syncManager.History.ListRuns(id)
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="dirsync-history.json"`)
+
+		pairNotes := syncManager.History.AllPairNotes()
+		if id != "" {
+			pairNotes = map[string][]dirsync.Note{id: pairNotes[id]}
+		}
+		err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"runs":       runs,
+			"pair_notes": pairNotes,
+		})
+
+		if err != nil {
+			log.Printf("Error encoding history export: %v", err)
+		}
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="dirsync-history.csv"`)
+
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"run_id", "sync_id", "start_time", "end_time", "success", "error", "notes"})
+		for _, run := range runs {
+			notes := make([]string, len(run.Notes))
+			for i, note := range run.Notes {
+				notes[i] = note.Time.Format(time.RFC3339) + " " + note.Text
+			}
+			cw.Write([]string{
+				run.ID,
+				run.SyncID,
+				run.StartTime.Format(time.RFC3339),
+				run.EndTime.Format(time.RFC3339),
+				strconv.FormatBool(run.Success),
+				run.Error,
+				strings.Join(notes, "; "),
+			})
+		}
+		cw.Flush()
+	default:
+		http.Error(w, "Unsupported export format", http.StatusBadRequest)
+	}
+}
+
+// handleNotes attaches a note to a run or a pair
+func handleNotes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		RunID  string `json:"run_id"`
+		SyncID string `json:"sync_id"`
+		Text   string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(request.Text) == "" {
+		http.Error(w, "Missing note text", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case request.RunID != "":
+		found, err := syncManager.History.AnnotateRun(request.RunID, request.Text)
+		if !found {
+			http.Error(w, "Run not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error saving history: %v", err)
+		}
+		log.Printf("Added note to run %s", request.RunID)
+	case request.SyncID != "":
+		if syncManager.GetSyncByID(request.SyncID) == nil {
+			http.Error(w, "Sync not found", http.StatusNotFound)
+			return
+		}
+		if err := syncManager.History.AnnotatePair(request.SyncID, request.Text); err != nil {
+			log.Printf("Error saving history: %v", err)
+		}
+		log.Printf("Added note to sync %s", request.SyncID)
+	default:
+		http.Error(w, "Missing run or sync ID", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"success": true, "message": "Note added"}`)
+}
+
+// handleInjectFailure makes a pair's next run fail in a simulated way
+func handleInjectFailure(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "Missing sync ID", http.StatusBadRequest)
+		return
+	}
+
+	sync := syncManager.GetSyncByID(id)
+	if sync == nil {
+		http.Error(w, "Sync not found", http.StatusNotFound)
+		return
+	}
+
+	failure, err := dirsync.NewInjectedFailure(r.URL.Query().Get("failure"), r.URL.Query().Get("delay"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sync.InjectFailure(failure)
+	log.Printf("Injected %s into next run of sync: %s", failure.Kind, id)
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"success": true, "message": "Failure injected into next run"}`)
+}
@@ -0,0 +1,802 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src"
+	"github.com/gkarolyi/dirsync/src/client"
+)
+
+// TestConfigLoading tests the loading and parsing of the config file
+func TestConfigLoading(t *testing.T) {
+	// Use the test_config.json created in setup
+	configFile, err := os.ReadFile("test_config.json")
+	if err != nil {
+		t.Fatalf("Error reading config: %v", err)
+	}
+
+	var testConfig Config
+	if err := json.Unmarshal(configFile, &testConfig); err != nil {
+		t.Fatalf("Error parsing config: %v", err)
+	}
+
+	// Verify the config was loaded correctly
+	if testConfig.SyncInterval != 5 {
+		t.Errorf("Expected SyncInterval %d, got %d", 5, testConfig.SyncInterval)
+	}
+
+	if len(testConfig.SyncPairs) != 1 {
+		t.Errorf("Expected %d SyncPairs, got %d", 1, len(testConfig.SyncPairs))
+	} else {
+		expectedPair := testSourceDir + ":" + testDestDir
+		if testConfig.SyncPairs[0] != expectedPair {
+			t.Errorf("Expected SyncPair %s, got %s", expectedPair, testConfig.SyncPairs[0])
+		}
+	}
+
+	if testConfig.Port != ":8090" {
+		t.Errorf("Expected Port %s, got %s", ":8090", testConfig.Port)
+	}
+}
+
+// TestSyncInitialization tests the initialization of the Sync struct
+func TestSyncInitialization(t *testing.T) {
+	// Load the test config
+	configFile, err := os.ReadFile("test_config.json")
+	if err != nil {
+		t.Fatalf("Error reading config: %v", err)
+	}
+
+	if err := json.Unmarshal(configFile, &config); err != nil {
+		t.Fatalf("Error parsing config: %v", err)
+	}
+
+	// Initialize a test sync
+	sourcePath := testSourceDir
+	destPath := testDestDir
+	interval := config.SyncInterval
+
+	testSync := dirsync.NewSync(sourcePath, destPath, interval)
+
+	// Verify sync initialization
+	if testSync.IsSyncing != false {
+		t.Errorf("Expected IsSyncing to be false, got %v", testSync.IsSyncing)
+	}
+
+	if testSync.SourcePath != sourcePath {
+		t.Errorf("Expected SourcePath %s, got %s", sourcePath, testSync.SourcePath)
+	}
+
+	if testSync.DestinationPath != destPath {
+		t.Errorf("Expected DestinationPath %s, got %s", destPath, testSync.DestinationPath)
+	}
+
+	expectedID := sourcePath + ":" + destPath
+	if testSync.ID != expectedID {
+		t.Errorf("Expected ID %s, got %s", expectedID, testSync.ID)
+	}
+
+	// Allow a small time difference due to execution time
+	timeDiff := testSync.NextSyncTime.Sub(time.Now())
+	// The NextSyncTime is now set to time.Now() for immediate first sync
+	if timeDiff < -5*time.Second || timeDiff > 5*time.Second {
+		t.Errorf("Expected NextSyncTime to be close to current time, but difference is %v", timeDiff)
+	}
+}
+
+// TestHandleStatus tests the status HTTP handler
+func TestHandleStatus(t *testing.T) {
+	// Set up test sync manager
+	testSyncManager := dirsync.NewSyncManager(dirsync.Options{})
+	syncManager = testSyncManager
+
+	// Add a test sync
+	testTime := time.Now()
+	testSync := &dirsync.Sync{
+		ID:              testSourceDir + ":" + testDestDir,
+		SourcePath:      testSourceDir,
+		DestinationPath: testDestDir,
+		IsSyncing:       true,
+		LastSync:        testTime,
+		NextSyncTime:    testTime.Add(60 * time.Second),
+		Output:          "test output",
+		LastError:       "test error",
+	}
+
+	testSyncManager.Syncs = append(testSyncManager.Syncs, testSync)
+
+	// Create a request to pass to our handler
+	req, err := http.NewRequest("GET", "/status", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a ResponseRecorder to record the response
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(handleStatus)
+
+	// Call the handler
+	handler.ServeHTTP(rr, req)
+
+	// Check the status code
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// Check the content type
+	expectedContentType := "application/json"
+	if contentType := rr.Header().Get("Content-Type"); contentType != expectedContentType {
+		t.Errorf("handler returned wrong content type: got %v want %v", contentType, expectedContentType)
+	}
+
+	// Check the response body
+	var responseStatuses []map[string]interface{}
+	if err := json.NewDecoder(rr.Body).Decode(&responseStatuses); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+
+	if len(responseStatuses) != 1 {
+		t.Fatalf("Expected 1 sync status, got %d", len(responseStatuses))
+	}
+
+	responseStatus := responseStatuses[0]
+
+	if isSyncing, ok := responseStatus["is_syncing"].(bool); !ok || isSyncing != testSync.IsSyncing {
+		t.Errorf("Expected IsSyncing %v, got %v", testSync.IsSyncing, responseStatus["is_syncing"])
+	}
+
+	if id, ok := responseStatus["id"].(string); !ok || id != testSync.ID {
+		t.Errorf("Expected ID %s, got %s", testSync.ID, responseStatus["id"])
+	}
+
+	if lastError, ok := responseStatus["last_error"].(string); !ok || lastError != testSync.LastError {
+		t.Errorf("Expected LastError %s, got %s", testSync.LastError, responseStatus["last_error"])
+	}
+}
+
+// TestHandleSyncNow tests the manual sync trigger endpoint
+func TestHandleSyncNow(t *testing.T) {
+	// Set up test sync manager
+	testSyncManager := dirsync.NewSyncManager(dirsync.Options{})
+	syncManager = testSyncManager
+
+	// Add a test sync
+	initialTime := time.Now().Add(60 * time.Second) // Next sync in 60 seconds
+	testSync := &dirsync.Sync{
+		ID:              testSourceDir + ":" + testDestDir,
+		SourcePath:      testSourceDir,
+		DestinationPath: testDestDir,
+		IsSyncing:       false,
+		LastSync:        time.Now().Add(-60 * time.Second), // Last sync was 60 seconds ago
+		NextSyncTime:    initialTime,
+		Output:          "",
+		LastError:       "",
+	}
+
+	testSyncManager.Syncs = append(testSyncManager.Syncs, testSync)
+
+	// Create a POST request to the sync now endpoint
+	req, err := http.NewRequest("POST", "/api/sync/now", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a ResponseRecorder to record the response
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(handleSyncNow)
+
+	// Call the handler
+	handler.ServeHTTP(rr, req)
+
+	// Check the status code
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// Check the content type
+	expectedContentType := "application/json"
+	if contentType := rr.Header().Get("Content-Type"); contentType != expectedContentType {
+		t.Errorf("handler returned wrong content type: got %v want %v", contentType, expectedContentType)
+	}
+
+	// Check the response body
+	var response map[string]interface{}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+
+	// Verify the response contains success: true
+	if success, ok := response["success"].(bool); !ok || !success {
+		t.Errorf("Expected success: true in response, got %v", response)
+	}
+
+	// Verify the next sync time was updated to now (or very close to now)
+	if time.Since(testSync.NextSyncTime) > 2*time.Second {
+		t.Errorf("Expected NextSyncTime to be updated to now, but it's %v in the past", time.Since(testSync.NextSyncTime))
+	}
+
+	states, _ := response["states"].(map[string]interface{})
+	if states[testSync.ID] != string(dirsync.TriggerQueued) {
+		t.Errorf("Expected the sync to be queued, got %v", response)
+	}
+
+	// Triggering a running sync queues a single follow-up run
+	testSync.IsSyncing = true
+	for _, want := range []dirsync.TriggerResult{dirsync.TriggerFollowUp, dirsync.TriggerCoalesced} {
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("POST", "/api/sync/now?id="+testSync.ID, nil))
+		response = nil
+		json.NewDecoder(rr.Body).Decode(&response)
+		if response["state"] != string(want) {
+			t.Errorf("Expected state %s, got %v", want, response)
+		}
+	}
+
+	// Test with wrong HTTP method
+	req, err = http.NewRequest("GET", "/api/sync/now", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	// Should return method not allowed
+	if status := rr.Code; status != http.StatusMethodNotAllowed {
+		t.Errorf("handler should return method not allowed for GET, got %v", status)
+	}
+
+	// Trigger a single sync by ID
+	testSync.NextSyncTime = initialTime
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/api/sync/now?id="+testSync.ID, nil))
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code for sync ID: got %v want %v", status, http.StatusOK)
+	}
+	if time.Since(testSync.NextSyncTime) > 2*time.Second {
+		t.Errorf("Expected NextSyncTime to be updated to now for sync ID")
+	}
+
+	// Unknown sync ID
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/api/sync/now?id=missing", nil))
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler should return not found for unknown sync ID, got %v", status)
+	}
+}
+
+// TestHandleSyncDetails tests the sync details endpoint
+func TestHandleSyncDetails(t *testing.T) {
+	// Set up test sync manager
+	testSyncManager := dirsync.NewSyncManager(dirsync.Options{})
+	syncManager = testSyncManager
+
+	// Add a test sync
+	testSync := &dirsync.Sync{
+		ID:              testSourceDir + ":" + testDestDir,
+		SourcePath:      testSourceDir,
+		DestinationPath: testDestDir,
+		IsSyncing:       false,
+		LastSync:        time.Now(),
+		NextSyncTime:    time.Now().Add(60 * time.Second),
+		Output:          "test output content",
+		LastError:       "",
+	}
+
+	testSyncManager.Syncs = append(testSyncManager.Syncs, testSync)
+
+	// Create a request to the sync details endpoint
+	req, err := http.NewRequest("GET", "/api/sync/details?id="+testSync.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a ResponseRecorder to record the response
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(handleSyncDetails)
+
+	// Call the handler
+	handler.ServeHTTP(rr, req)
+
+	// Check the status code
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// Check the content type
+	expectedContentType := "application/json"
+	if contentType := rr.Header().Get("Content-Type"); contentType != expectedContentType {
+		t.Errorf("handler returned wrong content type: got %v want %v", contentType, expectedContentType)
+	}
+
+	// Check the response body
+	var responseStatus map[string]interface{}
+	if err := json.NewDecoder(rr.Body).Decode(&responseStatus); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+
+	if output, ok := responseStatus["output"].(string); !ok || output != testSync.Output {
+		t.Errorf("Expected Output %s, got %s", testSync.Output, responseStatus["output"])
+	}
+
+	// Test with missing ID
+	req, err = http.NewRequest("GET", "/api/sync/details", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	// Should return bad request
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler should return bad request for missing ID, got %v", status)
+	}
+
+	// Test with non-existent ID
+	req, err = http.NewRequest("GET", "/api/sync/details?id=nonexistent", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	// Should return not found
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler should return not found for non-existent ID, got %v", status)
+	}
+}
+
+// TestHandleSyncDiff tests previewing what a sync would change
+func TestHandleSyncDiff(t *testing.T) {
+	syncManager = dirsync.NewSyncManager(dirsync.Options{})
+	testSync := syncManager.AddSync(testSourceDir, t.TempDir(), 60)
+
+	rr := httptest.NewRecorder()
+	handleSyncDiff(rr, httptest.NewRequest("GET", "/api/sync/diff?id="+testSync.ID, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var diff client.Diff
+	if err := json.NewDecoder(rr.Body).Decode(&diff); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if len(diff.New) != 3 || len(diff.Modified)+len(diff.Extra)+len(diff.Conflicting) != 0 {
+		t.Errorf("Expected every file to be new for an empty destination, got %+v", diff)
+	}
+	if entries, _ := os.ReadDir(testSync.DestinationPath); len(entries) != 0 {
+		t.Errorf("Expected nothing to be copied")
+	}
+
+	testSync.Pair.Encryption = &dirsync.EncryptionConfig{}
+	rr = httptest.NewRecorder()
+	handleSyncDiff(rr, httptest.NewRequest("GET", "/api/sync/diff?id="+testSync.ID, nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an encrypted pair, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handleSyncDiff(rr, httptest.NewRequest("GET", "/api/sync/diff?id=nonexistent", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown pair, got %d", rr.Code)
+	}
+}
+
+// TestHandleBrowse tests listing a pair's directories through the API
+func TestHandleBrowse(t *testing.T) {
+	syncManager = dirsync.NewSyncManager(dirsync.Options{})
+	testSync := syncManager.AddSync(testSourceDir, t.TempDir(), 60)
+
+	rr := httptest.NewRecorder()
+	handleBrowse(rr, httptest.NewRequest("GET", "/api/browse?id="+testSync.ID+"&path=subdir", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var listing client.Listing
+	if err := json.NewDecoder(rr.Body).Decode(&listing); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if listing.Side != "src" || len(listing.Entries) != 1 || listing.Entries[0].Name != "file3.txt" {
+		t.Errorf("Expected the source's subdirectory, got %+v", listing)
+	}
+
+	for query, want := range map[string]int{
+		"id=" + testSync.ID + "&side=dst&path=missing": http.StatusNotFound,
+		"id=" + testSync.ID + "&side=both":             http.StatusBadRequest,
+		"id=" + testSync.ID + "&path=file1.txt":        http.StatusBadRequest,
+		"id=nonexistent":                               http.StatusNotFound,
+		"side=src":                                     http.StatusBadRequest,
+	} {
+		rr = httptest.NewRecorder()
+		handleBrowse(rr, httptest.NewRequest("GET", "/api/browse?"+query, nil))
+		if rr.Code != want {
+			t.Errorf("Expected status %d for %s, got %d", want, query, rr.Code)
+		}
+	}
+}
+
+// TestHandlePairStats tests getting a pair's directory statistics
+func TestHandlePairStats(t *testing.T) {
+	syncManager = dirsync.NewSyncManager(dirsync.Options{})
+	testSync := syncManager.AddSync(testSourceDir, t.TempDir(), 60)
+
+	rr := httptest.NewRecorder()
+	handlePairStats(rr, httptest.NewRequest("GET", "/api/pairs/1/stats", nil))
+	if rr.Code != http.StatusAccepted && rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 202 or 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var stats client.PairStats
+	deadline := time.Now().Add(5 * time.Second)
+	for stats.ScannedAt.IsZero() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		rr = httptest.NewRecorder()
+		handlePairStats(rr, httptest.NewRequest("GET", "/api/pairs/"+url.PathEscape(testSync.ID)+"/stats", nil))
+		if err := json.NewDecoder(rr.Body).Decode(&stats); err != nil {
+			t.Fatalf("Failed to decode response body: %v", err)
+		}
+	}
+	if rr.Code != http.StatusOK || stats.SyncID != testSync.ID || stats.Source.Files != 3 {
+		t.Errorf("Expected the scanned source, got %d %+v", rr.Code, stats)
+	}
+
+	for _, path := range []string{"/api/pairs/2/stats", "/api/pairs/1", "/api/pairs/"} {
+		rr = httptest.NewRecorder()
+		handlePairStats(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for %s, got %d", path, rr.Code)
+		}
+	}
+}
+
+// TestSplitPair tests splitting pairs with Windows drive letters
+func TestSplitPair(t *testing.T) {
+	tests := []struct {
+		pair, source, destination string
+		ok                        bool
+	}{
+		{`src:dst`, "src", "dst", true},
+		{`a:/backup`, "a", "/backup", true},
+		{`C:\Users\me:D:\backup`, `C:\Users\me`, `D:\backup`, true},
+		{`C:/src:D:/dst`, "C:/src", "D:/dst", true},
+		{`/data:C:\dst`, "/data", `C:\dst`, true},
+		{`C:\src:/mnt/x`, `C:\src`, "/mnt/x", true},
+		{`\\server\share:E:\b`, `\\server\share`, `E:\b`, true},
+		{`a:b:c`, "", "", false},
+		{`nocolon`, "", "", false},
+	}
+	for _, tt := range tests {
+		source, destination, ok := splitPair(tt.pair)
+		if source != tt.source || destination != tt.destination || ok != tt.ok {
+			t.Errorf("splitPair(%q) = %q, %q, %v, want %q, %q, %v", tt.pair, source, destination, ok, tt.source, tt.destination, tt.ok)
+		}
+	}
+}
+
+// TestTaggedOperations tests querying, triggering and pausing the pairs
+// with a tag
+func TestTaggedOperations(t *testing.T) {
+	syncManager = dirsync.NewSyncManager(dirsync.Options{})
+	photos := syncManager.AddPair(dirsync.PairConfig{Name: "photos", Source: testSourceDir, Destination: t.TempDir(), Tags: []string{"photos", "offsite"}}, 60)
+	raw := syncManager.AddPair(dirsync.PairConfig{Name: "raw", Source: testSourceDir, Destination: t.TempDir(), Tags: []string{"photos"}}, 60)
+	docs := syncManager.AddPair(dirsync.PairConfig{Name: "docs", Source: testSourceDir, Destination: t.TempDir(), Tags: []string{"offsite"}}, 60)
+
+	rr := httptest.NewRecorder()
+	handleStatus(rr, httptest.NewRequest("GET", "/status?tag=offsite", nil))
+	var statuses []client.SyncStatus
+	json.NewDecoder(rr.Body).Decode(&statuses)
+	if len(statuses) != 2 || statuses[0].ID != photos.ID || statuses[1].ID != docs.ID || statuses[1].Tags[0] != "offsite" {
+		t.Errorf("Expected the offsite pairs, got %+v", statuses)
+	}
+
+	rr = httptest.NewRecorder()
+	handleSyncPause(rr, httptest.NewRequest("POST", "/api/sync/pause?tag=photos", nil))
+	var response struct {
+		IDs []string `json:"ids"`
+	}
+	json.NewDecoder(rr.Body).Decode(&response)
+	if rr.Code != http.StatusOK || len(response.IDs) != 2 || !photos.Paused || !raw.Paused || docs.Paused {
+		t.Errorf("Expected only the photos pairs to be paused, got %d %+v", rr.Code, response)
+	}
+
+	rr = httptest.NewRecorder()
+	handleSyncResume(rr, httptest.NewRequest("POST", "/api/sync/resume?tag=photos", nil))
+	if rr.Code != http.StatusOK || photos.Paused || raw.Paused {
+		t.Errorf("Expected the photos pairs to be resumed, got %d", rr.Code)
+	}
+
+	docs.NextSyncTime = time.Now().Add(time.Hour)
+	rr = httptest.NewRecorder()
+	handleSyncNow(rr, httptest.NewRequest("POST", "/api/sync/now?tag=offsite", nil))
+	var triggered struct {
+		States map[string]dirsync.TriggerResult `json:"states"`
+	}
+	json.NewDecoder(rr.Body).Decode(&triggered)
+	if len(triggered.States) != 2 || triggered.States[docs.ID] != dirsync.TriggerQueued || time.Until(docs.NextSyncTime) > time.Second {
+		t.Errorf("Expected the offsite pairs to be triggered, got %+v", triggered.States)
+	}
+
+	for _, handler := range []http.HandlerFunc{handleSyncNow, handleSyncPause, handleSyncResume} {
+		rr = httptest.NewRecorder()
+		handler(rr, httptest.NewRequest("POST", "/?tag=music", nil))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for an unused tag, got %d", rr.Code)
+		}
+	}
+}
+
+// TestHandlePairUpdate tests disabling and enabling a pair through the API
+func TestHandlePairUpdate(t *testing.T) {
+	syncManager = dirsync.NewSyncManager(dirsync.Options{})
+	testSync := syncManager.AddSync(testSourceDir, t.TempDir(), 60)
+
+	rr := httptest.NewRecorder()
+	handlePairs(rr, httptest.NewRequest("PATCH", "/api/pairs/"+url.PathEscape(testSync.ID), strings.NewReader(`{"enabled": false}`)))
+	var status client.SyncStatus
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if rr.Code != http.StatusOK || status.Enabled || testSync.Enabled() {
+		t.Errorf("Expected the pair to be disabled, got %d %+v", rr.Code, status)
+	}
+	if result := testSync.TriggerSync(); result != dirsync.TriggerDisabled {
+		t.Errorf("Expected a disabled pair not to be triggered, got %s", result)
+	}
+	if notes := syncManager.History.GetPairNotes(testSync.ID); len(notes) != 1 || notes[0].Text != "Pair disabled" {
+		t.Errorf("Expected a note on the pair, got %v", notes)
+	}
+
+	rr = httptest.NewRecorder()
+	handlePairs(rr, httptest.NewRequest("PATCH", "/api/pairs/1", strings.NewReader(`{"enabled": true}`)))
+	if rr.Code != http.StatusOK || !testSync.Enabled() {
+		t.Errorf("Expected the pair to be enabled again, got %d", rr.Code)
+	}
+
+	tests := []struct {
+		method, path, body string
+		want               int
+	}{
+		{"PATCH", "/api/pairs/2", `{"enabled": true}`, http.StatusNotFound},
+		{"PATCH", "/api/pairs/1", `{}`, http.StatusBadRequest},
+		{"PATCH", "/api/pairs/1", `not json`, http.StatusBadRequest},
+		{"POST", "/api/pairs/1", `{"enabled": true}`, http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		rr = httptest.NewRecorder()
+		handlePairs(rr, httptest.NewRequest(test.method, test.path, strings.NewReader(test.body)))
+		if rr.Code != test.want {
+			t.Errorf("Expected status %d for %s %s %s, got %d", test.want, test.method, test.path, test.body, rr.Code)
+		}
+	}
+}
+
+// TestIntegration performs an integration test of the entire application flow
+func TestIntegration(t *testing.T) {
+	// Skip in short mode
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Set up a test server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/status" {
+			handleStatus(w, r)
+		} else if r.URL.Path == "/api/sync/details" {
+			handleSyncDetails(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	// Load the config
+	configFile, err := os.ReadFile("test_config.json")
+	if err != nil {
+		t.Fatalf("Error reading config: %v", err)
+	}
+
+	if err := json.Unmarshal(configFile, &config); err != nil {
+		t.Fatalf("Error parsing config: %v", err)
+	}
+
+	// Initialize sync manager
+	testSyncManager := dirsync.NewSyncManager(dirsync.Options{})
+	syncManager = testSyncManager
+
+	// Add a test sync
+	testSync := dirsync.NewSync(testSourceDir, testDestDir, config.SyncInterval)
+	testSyncManager.Syncs = append(testSyncManager.Syncs, testSync)
+
+	// Make a request to the status endpoint
+	resp, err := http.Get(ts.URL + "/status")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Check the response
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response body: %v", err)
+	}
+
+	var responseStatuses []map[string]interface{}
+	if err := json.Unmarshal(body, &responseStatuses); err != nil {
+		t.Fatalf("Failed to unmarshal response body: %v", err)
+	}
+
+	if len(responseStatuses) != 1 {
+		t.Fatalf("Expected 1 sync status, got %d", len(responseStatuses))
+	}
+
+	responseStatus := responseStatuses[0]
+
+	if id, ok := responseStatus["id"].(string); !ok || id != testSync.ID {
+		t.Errorf("Expected ID %s, got %s", testSync.ID, responseStatus["id"])
+	}
+}
+
+// TestNewStaticHandler tests serving the web UI from embedded files and from disk
+func TestNewStaticHandler(t *testing.T) {
+	// Embedded files
+	handler, err := newStaticHandler("")
+	if err != nil {
+		t.Fatalf("newStaticHandler failed: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	if !strings.Contains(rr.Body.String(), "DirSync Status") {
+		t.Errorf("Expected embedded index.html to be served")
+	}
+
+	// On-disk override
+	staticDir, err := os.MkdirTemp("", "dirsync_test_static")
+	if err != nil {
+		t.Fatalf("Failed to create static directory: %v", err)
+	}
+	defer os.RemoveAll(staticDir)
+
+	if err := os.WriteFile(filepath.Join(staticDir, "index.html"), []byte("custom ui"), 0644); err != nil {
+		t.Fatalf("Failed to create index.html: %v", err)
+	}
+
+	handler, err = newStaticHandler(staticDir)
+	if err != nil {
+		t.Fatalf("newStaticHandler failed: %v", err)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if body := rr.Body.String(); body != "custom ui" {
+		t.Errorf("Expected on-disk index.html to be served, got %s", body)
+	}
+
+	// Missing directory
+	if _, err := newStaticHandler("/non/existent/path"); err == nil {
+		t.Errorf("Expected error for missing static directory, got nil")
+	}
+}
+
+// TestHandleNotes tests attaching notes to runs and pairs
+func TestHandleNotes(t *testing.T) {
+	testSyncManager := dirsync.NewSyncManager(dirsync.Options{})
+	syncManager = testSyncManager
+
+	testSync := testSyncManager.AddSync(testSourceDir, testDestDir, 60)
+	run := testSyncManager.History.StartRun(testSync.ID)
+	testSyncManager.History.FinishRun(run, nil)
+
+	handler := http.HandlerFunc(handleNotes)
+
+	tests := []struct {
+		body     string
+		expected int
+	}{
+		{`{"run_id": "` + run.ID + `", "text": "verified restore"}`, http.StatusOK},
+		{`{"sync_id": "` + testSync.ID + `", "text": "disk swapped"}`, http.StatusOK},
+		{`{"run_id": "nonexistent", "text": "note"}`, http.StatusNotFound},
+		{`{"sync_id": "nonexistent", "text": "note"}`, http.StatusNotFound},
+		{`{"run_id": "` + run.ID + `", "text": ""}`, http.StatusBadRequest},
+		{`{"text": "note"}`, http.StatusBadRequest},
+		{`not json`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/api/notes", strings.NewReader(tt.body))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != tt.expected {
+			t.Errorf("Body %s: expected status %d, got %d", tt.body, tt.expected, rr.Code)
+		}
+	}
+
+	// Notes should show up in the CSV export
+	req := httptest.NewRequest("GET", "/api/history/export?format=csv", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(handleHistoryExport).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Export returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	if !strings.Contains(rr.Body.String(), "verified restore") {
+		t.Errorf("Expected run note in CSV export, got %s", rr.Body.String())
+	}
+
+	// Pair notes should show up in the JSON export
+	req = httptest.NewRequest("GET", "/api/history/export?id="+testSync.ID, nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(handleHistoryExport).ServeHTTP(rr, req)
+
+	if !strings.Contains(rr.Body.String(), "disk swapped") {
+		t.Errorf("Expected pair note in JSON export, got %s", rr.Body.String())
+	}
+
+	// Wrong method
+	req = httptest.NewRequest("GET", "/api/notes", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("handler should return method not allowed for GET, got %v", rr.Code)
+	}
+}
+
+// TestHandleInjectFailure tests the failure injection debug endpoint
+func TestHandleInjectFailure(t *testing.T) {
+	testSyncManager := dirsync.NewSyncManager(dirsync.Options{})
+	syncManager = testSyncManager
+	testSync := testSyncManager.AddSync(testSourceDir, testDestDir, 60)
+
+	handler := http.HandlerFunc(handleInjectFailure)
+
+	tests := []struct {
+		method   string
+		query    string
+		expected int
+	}{
+		{"POST", "?id=" + testSync.ID + "&failure=transfer_error", http.StatusOK},
+		{"POST", "?id=" + testSync.ID + "&failure=bogus", http.StatusBadRequest},
+		{"POST", "?failure=transfer_error", http.StatusBadRequest},
+		{"POST", "?id=nonexistent&failure=transfer_error", http.StatusNotFound},
+		{"GET", "?id=" + testSync.ID + "&failure=transfer_error", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/api/debug/inject"+tt.query, nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != tt.expected {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.query, tt.expected, rr.Code)
+		}
+	}
+
+	// The next run should fail with the injected error
+	if err := testSync.SyncDirectories(); err == nil || !strings.Contains(testSync.LastError, "Injected failure") {
+		t.Errorf("Expected the injected transfer_error to fail the run, got %v (%s)", err, testSync.LastError)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// Values of MetricsConfig.PairLabel
+const (
+	pairLabelID   = "id"
+	pairLabelSlug = "slug"
+)
+
+// defaultMaxDirectories is how many directories per pair get their own
+// series when no limit is configured
+const defaultMaxDirectories = 20
+
+// otherDirectory collects the changes of directories beyond the limit
+const otherDirectory = "_other"
+
+// MetricsConfig exposes Prometheus metrics at /metrics. Every series is
+// labelled with its pair; per-directory series are off unless asked for
+// and capped per pair, so large deployments don't overwhelm the metrics
+// backend. Files never get series of their own.
+type MetricsConfig struct {
+	// PairLabel is what the pair label holds: the pair's id (default), or
+	// its slug as used for badges, which is shorter and has no slashes
+	PairLabel string `json:"pair_label"`
+
+	// DirectoryDepth counts changed paths by their first DirectoryDepth
+	// directories, such as 1 for "photos" or 2 for "photos/2024". Zero
+	// leaves per-directory series out.
+	DirectoryDepth int `json:"directory_depth"`
+
+	// MaxDirectories is how many directories per pair get their own
+	// series (default 20). Changes in any others are counted as "_other".
+	MaxDirectories int `json:"max_directories"`
+}
+
+// Validate checks the pair label and limits
+func (c *MetricsConfig) Validate() error {
+	switch {
+	case c.PairLabel != "" && c.PairLabel != pairLabelID && c.PairLabel != pairLabelSlug:
+		return fmt.Errorf("unknown metrics pair_label %q", c.PairLabel)
+	case c.DirectoryDepth < 0:
+		return fmt.Errorf("metrics directory_depth %d is negative", c.DirectoryDepth)
+	case c.MaxDirectories < 0:
+		return fmt.Errorf("metrics max_directories %d is negative", c.MaxDirectories)
+	}
+	return nil
+}
+
+// pairCounters are the counters of one pair since the daemon started
+type pairCounters struct {
+	runs        map[string]int64
+	bytes       int64
+	changes     int64
+	directories map[string]int64
+}
+
+// metricsCollector counts finished runs for the metrics endpoint
+type metricsCollector struct {
+	config MetricsConfig
+
+	mu    sync.Mutex
+	pairs map[string]*pairCounters
+}
+
+// metrics is the daemon's collector, nil unless metrics are configured
+var metrics *metricsCollector
+
+func newMetricsCollector(config MetricsConfig) *metricsCollector {
+	if config.MaxDirectories == 0 {
+		config.MaxDirectories = defaultMaxDirectories
+	}
+	return &metricsCollector{config: config, pairs: map[string]*pairCounters{}}
+}
+
+// observe counts a finished run
+func (m *metricsCollector) observe(run dirsync.RunRecord) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counters := m.pairs[run.SyncID]
+	if counters == nil {
+		counters = &pairCounters{runs: map[string]int64{}, directories: map[string]int64{}}
+		m.pairs[run.SyncID] = counters
+	}
+
+	switch {
+	case run.Skipped != "":
+		counters.runs["skipped"]++
+	case run.Success:
+		counters.runs["success"]++
+	default:
+		counters.runs["failure"]++
+	}
+	counters.bytes += run.BytesTransferred
+	counters.changes += int64(run.ChangeCount)
+
+	if m.config.DirectoryDepth == 0 {
+		return
+	}
+	for _, change := range run.Changes {
+		dir := changeDirectory(change.Path, m.config.DirectoryDepth)
+		if _, ok := counters.directories[dir]; !ok && len(counters.directories) >= m.config.MaxDirectories {
+			dir = otherDirectory
+		}
+		counters.directories[dir]++
+	}
+}
+
+// changeDirectory returns the first depth directories of a changed path,
+// or "." for files at the top
+func changeDirectory(p string, depth int) string {
+	parts := strings.Split(path.Dir(strings.TrimSuffix(p, "/")), "/")
+	if parts[0] == "." {
+		return "."
+	}
+	if len(parts) > depth {
+		parts = parts[:depth]
+	}
+	return strings.Join(parts, "/")
+}
+
+// pairLabel returns the label value for a pair
+func (m *metricsCollector) pairLabel(id string) string {
+	if m.config.PairLabel == pairLabelSlug {
+		return dirsync.Slug(id)
+	}
+	return id
+}
+
+// labelValue escapes a label value for the text exposition format
+func labelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// metricsWriter writes metric families in the text exposition format
+type metricsWriter struct {
+	b strings.Builder
+}
+
+// family starts a metric family
+func (w *metricsWriter) family(name, kind, help string) {
+	fmt.Fprintf(&w.b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes a sample with alternating label names and values
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.b.WriteString(name)
+	if len(labels) > 0 {
+		w.b.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				w.b.WriteByte(',')
+			}
+			fmt.Fprintf(&w.b, `%s="%s"`, labels[i], labelValue(labels[i+1]))
+		}
+		w.b.WriteByte('}')
+	}
+	fmt.Fprintf(&w.b, " %v\n", value)
+}
+
+// boolValue turns a flag into a gauge value
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// render writes the metrics of all pairs
+func (m *metricsCollector) render(statuses []map[string]interface{}) string {
+	var w metricsWriter
+
+	w.family("dirsync_pair_info", "gauge", "Source and destination of each pair.")
+	for _, status := range statuses {
+		id := status["id"].(string)
+		w.sample("dirsync_pair_info", 1, "pair", m.pairLabel(id),
+			"source", status["source_path"].(string), "destination", status["destination_path"].(string))
+	}
+
+	gauges := []struct {
+		name, help string
+		value      func(map[string]interface{}) float64
+	}{
+		{"dirsync_syncing", "Whether the pair is syncing.", func(s map[string]interface{}) float64 {
+			return boolValue(s["is_syncing"].(bool))
+		}},
+		{"dirsync_paused", "Whether the pair is paused.", func(s map[string]interface{}) float64 {
+			return boolValue(s["paused"].(bool))
+		}},
+		{"dirsync_last_run_success", "Whether the pair's last run succeeded.", func(s map[string]interface{}) float64 {
+			return boolValue(s["last_error"].(string) == "")
+		}},
+		{"dirsync_last_sync_timestamp_seconds", "When the pair last synced successfully, 0 if never.", func(s map[string]interface{}) float64 {
+			if last := s["last_sync"].(time.Time); !last.IsZero() {
+				return float64(last.Unix())
+			}
+			return 0
+		}},
+	}
+	for _, gauge := range gauges {
+		w.family(gauge.name, "gauge", gauge.help)
+		for _, status := range statuses {
+			w.sample(gauge.name, gauge.value(status), "pair", m.pairLabel(status["id"].(string)))
+		}
+	}
+
+	w.family("dirsync_scheduler_restarts_total", "counter", "Times the watchdog restarted the pair's stalled scheduler.")
+	for _, status := range statuses {
+		w.sample("dirsync_scheduler_restarts_total", float64(status["scheduler_restarts"].(int)), "pair", m.pairLabel(status["id"].(string)))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := make([]string, 0, len(m.pairs))
+	for id := range m.pairs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	w.family("dirsync_runs_total", "counter", "Finished runs by result.")
+	for _, id := range ids {
+		for _, result := range []string{"success", "failure", "skipped"} {
+			w.sample("dirsync_runs_total", float64(m.pairs[id].runs[result]), "pair", m.pairLabel(id), "result", result)
+		}
+	}
+	w.family("dirsync_transferred_bytes_total", "counter", "Bytes copied by runs.")
+	for _, id := range ids {
+		w.sample("dirsync_transferred_bytes_total", float64(m.pairs[id].bytes), "pair", m.pairLabel(id))
+	}
+	w.family("dirsync_changed_paths_total", "counter", "Paths created, updated or deleted by runs.")
+	for _, id := range ids {
+		w.sample("dirsync_changed_paths_total", float64(m.pairs[id].changes), "pair", m.pairLabel(id))
+	}
+
+	if m.config.DirectoryDepth > 0 {
+		w.family("dirsync_directory_changed_paths_total", "counter", "Recorded changed paths by directory.")
+		for _, id := range ids {
+			dirs := make([]string, 0, len(m.pairs[id].directories))
+			for dir := range m.pairs[id].directories {
+				dirs = append(dirs, dir)
+			}
+			sort.Strings(dirs)
+			for _, dir := range dirs {
+				w.sample("dirsync_directory_changed_paths_total", float64(m.pairs[id].directories[dir]),
+					"pair", m.pairLabel(id), "directory", dir)
+			}
+		}
+	}
+	return w.b.String()
+}
+
+// handleMetrics serves Prometheus metrics
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(metrics.render(syncManager.GetAllStatus())))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// TestMetrics tests the Prometheus metrics and their cardinality limits
+func TestMetrics(t *testing.T) {
+	syncManager = dirsync.NewSyncManager(dirsync.Options{})
+	testSync := syncManager.AddSync(testSourceDir, t.TempDir(), 60)
+	metrics = newMetricsCollector(MetricsConfig{PairLabel: "slug", DirectoryDepth: 1, MaxDirectories: 2})
+	defer func() { metrics = nil }()
+
+	run := dirsync.RunRecord{SyncID: testSync.ID, Success: true}
+	run.BytesTransferred = 100
+	run.ChangeCount = 5
+	for _, p := range []string{"top.txt", "photos/2024/a.jpg", "docs/b.txt", "music/c.mp3", "video/d.mp4"} {
+		run.Changes = append(run.Changes, dirsync.Change{Path: p, Action: "created"})
+	}
+	metrics.observe(run)
+	metrics.observe(dirsync.RunRecord{SyncID: testSync.ID, Error: "failed"})
+
+	rr := httptest.NewRecorder()
+	handleMetrics(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+
+	body := rr.Body.String()
+	slug := dirsync.Slug(testSync.ID)
+	for _, want := range []string{
+		"# TYPE dirsync_runs_total counter\n",
+		`dirsync_pair_info{pair="` + slug + `",source="` + testSourceDir + `"`,
+		`dirsync_runs_total{pair="` + slug + `",result="success"} 1`,
+		`dirsync_runs_total{pair="` + slug + `",result="failure"} 1`,
+		`dirsync_transferred_bytes_total{pair="` + slug + `"} 100`,
+		`dirsync_changed_paths_total{pair="` + slug + `"} 5`,
+		`dirsync_directory_changed_paths_total{pair="` + slug + `",directory="."} 1`,
+		`dirsync_directory_changed_paths_total{pair="` + slug + `",directory="photos"} 1`,
+		`dirsync_directory_changed_paths_total{pair="` + slug + `",directory="_other"} 3`,
+		`dirsync_paused{pair="` + slug + `"} 0`,
+		`dirsync_scheduler_restarts_total{pair="` + slug + `"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, `directory="docs"`) {
+		t.Errorf("Expected directories beyond the limit to be counted as _other")
+	}
+}
+
+// TestChangeDirectory tests grouping changed paths by directory
+func TestChangeDirectory(t *testing.T) {
+	tests := []struct {
+		path  string
+		depth int
+		want  string
+	}{
+		{"file.txt", 1, "."},
+		{"photos/a.jpg", 1, "photos"},
+		{"photos/2024/a.jpg", 1, "photos"},
+		{"photos/2024/a.jpg", 2, "photos/2024"},
+		{"photos/2024/", 2, "photos"},
+	}
+	for _, test := range tests {
+		if got := changeDirectory(test.path, test.depth); got != test.want {
+			t.Errorf("changeDirectory(%q, %d) = %q, expected %q", test.path, test.depth, got, test.want)
+		}
+	}
+}
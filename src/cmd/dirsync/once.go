@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// pairList collects repeated -pair flags
+type pairList []string
+
+// String implements flag.Value
+func (p *pairList) String() string {
+	return strings.Join(*p, ",")
+}
+
+// Set implements flag.Value
+func (p *pairList) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+// runOnce syncs the given pairs a single time without starting the server
+// and returns the exit code: 0 if every pair synced, 1 if any failed and 2
+// for usage errors
+func runOnce(args []string) int {
+	flags := flag.NewFlagSet("once", flag.ContinueOnError)
+	var pairs pairList
+	flags.Var(&pairs, "pair", "source:destination pair to sync (can be repeated)")
+	deleteFiles := flags.Bool("delete", false, "delete destination files that no longer exist in the source")
+	dryRun := flags.Bool("dry-run", false, "show what would change without changing anything")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dirsync once --pair /src:/dst [--delete] [--dry-run]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if len(pairs) == 0 || flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	log.SetOutput(io.Discard)
+
+	manager := dirsync.NewSyncManager(dirsync.Options{
+		OnProgress: func(p dirsync.Progress) {
+			fmt.Println(p.Line)
+		},
+	})
+
+	for _, pair := range pairs {
+		source, destination, ok := splitPair(pair)
+		if !ok || source == "" || destination == "" {
+			fmt.Fprintf(os.Stderr, "Invalid pair %q, expected source:destination\n", pair)
+			return 2
+		}
+
+		cleaned, err := cleanPair(dirsync.PairConfig{
+			Source:      source,
+			Destination: destination,
+			Delete:      *deleteFiles,
+			DryRun:      *dryRun,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid pair %q: %v\n", pair, err)
+			return 2
+		}
+		manager.AddPair(cleaned, 0)
+	}
+	manager.UpdateProtectiveExcludes()
+
+	// Stop the transfer cleanly on Ctrl-C or when cron/CI kills the job
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	failed := 0
+	for _, sync := range manager.Syncs {
+		if err := sync.Run(ctx); err != nil {
+			failed++
+			msg := sync.GetStatus()["last_error"]
+			if msg == "" {
+				msg = err.Error()
+			}
+			fmt.Fprintf(os.Stderr, "%s: %v\n", sync.ID, msg)
+		}
+	}
+
+	fmt.Printf("%d of %d pairs synced\n", len(pairs)-failed, len(pairs))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
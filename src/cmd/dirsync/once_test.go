@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRunOnce tests syncing a pair once from the command line
+func TestRunOnce(t *testing.T) {
+	destDir := t.TempDir()
+
+	// A dry run doesn't create anything
+	if code := runOnce([]string{"--pair", testSourceDir + ":" + destDir, "--dry-run"}); code != 0 {
+		t.Fatalf("Expected exit code 0 for dry run, got %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "file1.txt")); !os.IsNotExist(err) {
+		t.Errorf("Dry run should not copy files")
+	}
+
+	if code := runOnce([]string{"--pair", testSourceDir + ":" + destDir}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "subdir", "file3.txt")); err != nil {
+		t.Errorf("Expected files to be copied: %v", err)
+	}
+
+	// Failed syncs and usage errors have their own exit codes
+	if code := runOnce([]string{"--pair", "/non/existent/source:" + destDir}); code != 1 {
+		t.Errorf("Expected exit code 1 for a failed sync, got %d", code)
+	}
+	if code := runOnce(nil); code != 2 {
+		t.Errorf("Expected exit code 2 without pairs, got %d", code)
+	}
+	if code := runOnce([]string{"--pair", "invalid"}); code != 2 {
+		t.Errorf("Expected exit code 2 for an invalid pair, got %d", code)
+	}
+}
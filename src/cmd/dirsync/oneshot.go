@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// runOneshot runs every configured pair once instead of serving, waits for
+// them to finish and prints a summary. It returns the exit code: 0 if every
+// pair synced and 1 otherwise, so the daemon can run as a Kubernetes Job.
+func runOneshot(syncManager *dirsync.SyncManager, config *Config) int {
+	addPairs(syncManager, config)
+	syncManager.UpdateProtectiveExcludes()
+
+	// Every pair runs right away, picking up where interrupted runs left off
+	syncManager.RecoverInterrupted(true)
+
+	// Pairs of profiles that aren't active are left out
+	var syncs []*dirsync.Sync
+	for _, s := range syncManager.Syncs {
+		if inProfile(s.Pair, config.Profile) {
+			syncs = append(syncs, s)
+		}
+	}
+
+	if len(syncs) == 0 {
+		fmt.Fprintln(os.Stderr, "No sync pairs are configured")
+		return 1
+	}
+
+	// Stop the transfers cleanly when the Job is terminated
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make([]error, len(syncs))
+	durations := make([]time.Duration, len(syncs))
+
+	var wg sync.WaitGroup
+	for i, s := range syncs {
+		wg.Add(1)
+		go func(i int, s *dirsync.Sync) {
+			defer wg.Done()
+			start := time.Now()
+			errs[i] = s.Run(ctx)
+			durations[i] = time.Since(start)
+		}(i, s)
+	}
+	wg.Wait()
+
+	failed := 0
+	for i, s := range syncs {
+		if errs[i] == nil {
+			fmt.Printf("OK      %s (%v)\n", s.ID, durations[i].Round(time.Millisecond))
+			continue
+		}
+
+		failed++
+		msg := s.GetStatus()["last_error"]
+		if msg == "" {
+			msg = errs[i].Error()
+		}
+		fmt.Printf("FAILED  %s (%v): %v\n", s.ID, durations[i].Round(time.Millisecond), msg)
+	}
+
+	fmt.Printf("%d of %d pairs synced\n", len(syncs)-failed, len(syncs))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
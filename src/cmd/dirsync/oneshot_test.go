@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// TestRunOneshot tests syncing every configured pair once and exiting
+func TestRunOneshot(t *testing.T) {
+	destDir := t.TempDir()
+	cfg := &Config{Pairs: []dirsync.PairConfig{{Source: testSourceDir, Destination: destDir}}}
+
+	if code := runOneshot(dirsync.NewSyncManager(dirsync.Options{}), cfg); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "subdir", "file3.txt")); err != nil {
+		t.Errorf("Expected files to be copied: %v", err)
+	}
+
+	// Any failed pair fails the whole run
+	cfg.SyncPairs = []string{"/non/existent/source:" + t.TempDir()}
+	if code := runOneshot(dirsync.NewSyncManager(dirsync.Options{}), cfg); code != 1 {
+		t.Errorf("Expected exit code 1 for a failed pair, got %d", code)
+	}
+	if code := runOneshot(dirsync.NewSyncManager(dirsync.Options{}), &Config{}); code != 1 {
+		t.Errorf("Expected exit code 1 without pairs, got %d", code)
+	}
+}
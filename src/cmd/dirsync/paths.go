@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// invalidPair is a configured pair that can't be synced, with what is
+// wrong with it and how to fix it
+type invalidPair struct {
+	Pair    string
+	Message string
+	Fix     string
+}
+
+// pathError is a configured path that is rejected when the config loads
+type pathError struct {
+	message string
+	fix     string
+}
+
+func (e *pathError) Error() string {
+	return e.message
+}
+
+// cleanPath makes a configured path absolute and removes "." elements,
+// repeated separators and trailing slashes, so the same directory always
+// gives the same pair ID. Windows paths are left as they are. Paths that
+// climb back out of a directory they name with "..", such as
+// "/backup/../etc", are rejected rather than resolved.
+func cleanPath(role, path string) (string, error) {
+	if path == "" || hasDriveLetter(path) || strings.HasPrefix(path, `\\`) {
+		return path, nil
+	}
+	if strings.ContainsRune(path, 0) {
+		return "", &pathError{fmt.Sprintf("%s path %q contains a NUL byte", role, path), "remove the control character from the path"}
+	}
+
+	depth := 0
+	for _, elem := range strings.Split(filepath.ToSlash(path), "/") {
+		switch elem {
+		case "", ".":
+		case "..":
+			if depth > 0 {
+				return "", &pathError{fmt.Sprintf("%s path %q goes back up with \"..\"", role, path),
+					fmt.Sprintf("use %q", filepath.Clean(path))}
+			}
+		default:
+			depth++
+		}
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", &pathError{fmt.Sprintf("%s path %q can't be made absolute: %v", role, path, err), "use an absolute path"}
+	}
+	return abs, nil
+}
+
+// cleanPair cleans the paths of a pair and of the pairs it runs after,
+// rejecting a destination that is the filesystem root. A pair whose paths
+// change keeps its ID as configured in PreviousID.
+func cleanPair(pair dirsync.PairConfig) (dirsync.PairConfig, error) {
+	source, err := cleanPath("source", pair.Source)
+	if err != nil {
+		return pair, err
+	}
+	destination, err := cleanPath("destination", pair.Destination)
+	if err != nil {
+		return pair, err
+	}
+	if destination != "" && filepath.Dir(destination) == destination {
+		return pair, &pathError{fmt.Sprintf("destination %q is the root of the filesystem", pair.Destination),
+			"choose a directory for the destination"}
+	}
+
+	cleaned := pair
+	cleaned.Source, cleaned.Destination = source, destination
+	if id := pair.Source + ":" + pair.Destination; id != source+":"+destination {
+		cleaned.PreviousID = id
+	}
+
+	// Pairs are listed in after by "source:destination" or by name, and the
+	// IDs from names have no colons
+	if len(pair.After) > 0 {
+		cleaned.After = make([]string, len(pair.After))
+		for i, upstream := range pair.After {
+			cleaned.After[i] = upstream
+			if s, d, ok := splitPair(upstream); ok {
+				s, serr := cleanPath("source", s)
+				d, derr := cleanPath("destination", d)
+				if serr == nil && derr == nil {
+					cleaned.After[i] = s + ":" + d
+				}
+			}
+		}
+	}
+	return cleaned, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// TestCleanPair tests cleaning and rejecting configured paths
+func TestCleanPair(t *testing.T) {
+	dir := t.TempDir()
+	pair, err := cleanPair(dirsync.PairConfig{Source: dir + "//src/./", Destination: "dst/", After: []string{dir + "/a/:b", "documents"}})
+	if err != nil {
+		t.Fatalf("Failed to clean pair: %v", err)
+	}
+	abs, _ := filepath.Abs("dst")
+	if pair.Source != filepath.Join(dir, "src") || pair.Destination != abs {
+		t.Errorf("Expected clean absolute paths, got %q and %q", pair.Source, pair.Destination)
+	}
+	if pair.PreviousID != dir+"//src/./:dst/" {
+		t.Errorf("Expected the configured ID to be kept, got %q", pair.PreviousID)
+	}
+	if want := filepath.Join(dir, "a") + ":" + filepath.Join(filepath.Dir(abs), "b"); pair.After[0] != want || pair.After[1] != "documents" {
+		t.Errorf("Expected after to list %q and the name, got %v", want, pair.After)
+	}
+
+	// Clean paths and Windows paths are left alone
+	for _, p := range []dirsync.PairConfig{{Source: dir, Destination: filepath.Join(dir, "b")}, {Source: `C:\Users\me`, Destination: `D:\backup`}} {
+		if cleaned, err := cleanPair(p); err != nil || cleaned.Source != p.Source || cleaned.Destination != p.Destination || cleaned.PreviousID != "" {
+			t.Errorf("Expected %+v to be unchanged, got %+v (%v)", p, cleaned, err)
+		}
+	}
+
+	for _, tt := range []struct {
+		pair dirsync.PairConfig
+		text string
+	}{
+		{dirsync.PairConfig{Source: "/backup/../etc", Destination: dir}, `goes back up with ".."`},
+		{dirsync.PairConfig{Source: dir, Destination: "/"}, "root of the filesystem"},
+		{dirsync.PairConfig{Source: dir, Destination: "/mnt/\x00"}, "NUL byte"},
+	} {
+		if _, err := cleanPair(tt.pair); err == nil || !strings.Contains(err.Error(), tt.text) {
+			t.Errorf("Expected %+v to be rejected with %q, got %v", tt.pair, tt.text, err)
+		}
+	}
+
+	// Paths starting with ".." are relative to the parent directory
+	if _, err := cleanPair(dirsync.PairConfig{Source: "../src", Destination: dir}); err != nil {
+		t.Errorf("Expected a path in the parent directory to be allowed, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// profileMu guards switching the active profile while the daemon runs
+var profileMu sync.Mutex
+
+// profileNames returns the names of the configured profiles, sorted
+func (c *Config) profileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyProfiles adds the pairs of every profile to the config, marked with
+// the profile they belong to, and makes selected (or the config's profile
+// setting without one) the active profile. Settings in the active profile
+// replace the ones at the top of the config.
+func (c *Config) applyProfiles(selected string) error {
+	if selected == "" {
+		selected = c.Profile
+	}
+	if _, ok := c.Profiles[selected]; selected != "" && !ok {
+		return fmt.Errorf("unknown profile %q, the config has %s", selected, strings.Join(c.profileNames(), ", "))
+	}
+
+	for _, name := range c.profileNames() {
+		var file pairsFile
+		if err := json.Unmarshal(c.Profiles[name], &file); err != nil {
+			return fmt.Errorf("error parsing profile %q: %w", name, err)
+		}
+		for _, pair := range file.SyncPairs {
+			source, destination, ok := splitPair(pair)
+			if !ok {
+				// Reported as invalid along with the other sync_pairs
+				c.SyncPairs = append(c.SyncPairs, pair)
+				continue
+			}
+			file.Pairs = append(file.Pairs, dirsync.PairConfig{Source: source, Destination: destination})
+		}
+		for _, pair := range file.Pairs {
+			pair.Profile = name
+			c.Pairs = append(c.Pairs, pair)
+		}
+	}
+
+	if selected != "" {
+		// The profile's pairs were added above, so it mustn't replace the others
+		base := *c
+		c.SyncPairs, c.Pairs, c.Profiles = nil, nil, nil
+		if err := json.Unmarshal(base.Profiles[selected], c); err != nil {
+			return fmt.Errorf("error parsing profile %q: %w", selected, err)
+		}
+		c.SyncPairs, c.Pairs, c.Profiles, c.ConfDir = base.SyncPairs, base.Pairs, base.Profiles, base.ConfDir
+		log.Printf("Using profile %s", selected)
+	}
+	c.Profile = selected
+	return nil
+}
+
+// inProfile reports whether a pair runs with the given profile active.
+// Pairs outside any profile always run.
+func inProfile(pair dirsync.PairConfig, profile string) bool {
+	return pair.Profile == "" || pair.Profile == profile
+}
+
+// handleProfile returns the active profile and the configured ones, and
+// switches profiles with a POST body like {"profile": "office"}. Switching
+// enables the pairs of the new profile and disables the others; other
+// settings in a profile only take effect when it's selected at start.
+func handleProfile(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var request struct {
+			Profile string `json:"profile"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if _, ok := config.Profiles[request.Profile]; request.Profile != "" && !ok {
+			http.Error(w, "Unknown profile", http.StatusNotFound)
+			return
+		}
+		switchProfile(request.Profile)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	profileMu.Lock()
+	response := map[string]interface{}{
+		"profile":  config.Profile,
+		"profiles": config.profileNames(),
+	}
+	profileMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding profile: %v", err)
+	}
+}
+
+// switchProfile makes profile active, or no profile for "", enabling its
+// pairs unless they're disabled in the config and disabling the pairs of
+// the other profiles. Each pair that changes is noted.
+func switchProfile(profile string) {
+	profileMu.Lock()
+	defer profileMu.Unlock()
+	if profile == config.Profile {
+		return
+	}
+	config.Profile = profile
+	log.Printf("Switched to profile %q", profile)
+
+	for _, s := range syncManager.AllSyncs() {
+		if s.Pair.Profile == "" {
+			continue
+		}
+		enabled := s.Pair.Profile == profile && (s.Pair.Enabled == nil || *s.Pair.Enabled)
+		if enabled == s.Enabled() {
+			continue
+		}
+		s.SetEnabled(enabled)
+		note := fmt.Sprintf("Pair disabled by switching to profile %q", profile)
+		if enabled {
+			note = fmt.Sprintf("Pair enabled by switching to profile %q", profile)
+		}
+		if err := syncManager.History.AnnotatePair(s.ID, note); err != nil {
+			log.Printf("Error saving history: %v", err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gkarolyi/dirsync/src"
+	"github.com/gkarolyi/dirsync/src/client"
+)
+
+// TestProfiles tests selecting a profile when the config loads and
+// switching profiles through the API
+func TestProfiles(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	os.WriteFile(configPath, []byte(`{
+		"sync_interval": 60,
+		"sync_pairs": ["/always:/backup"],
+		"profile": "home",
+		"profiles": {
+			"home": {"sync_pairs": ["/photos:/nas/photos"]},
+			"office": {"sync_interval": 300, "pairs": [{"source": "work", "destination": "/mnt/work"}]}
+		}
+	}`), 0644)
+	defer func() { config, baseDir = Config{}, "" }()
+
+	if err := loadConfig(configPath, "office"); err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if config.Profile != "office" || config.SyncInterval != 300 {
+		t.Errorf("Expected the office profile and its interval, got %q and %d", config.Profile, config.SyncInterval)
+	}
+	if len(config.Pairs) != 2 || config.Pairs[0].Profile != "home" || config.Pairs[1].Source != filepath.Join(dir, "work") {
+		t.Errorf("Expected the pairs of both profiles, got %+v", config.Pairs)
+	}
+
+	// Without a profile on the command line, the config's profile is used
+	config = Config{}
+	if err := loadConfig(configPath, ""); err != nil || config.Profile != "home" || config.SyncInterval != 60 {
+		t.Errorf("Expected the home profile, got %q (%v)", config.Profile, err)
+	}
+	if err := loadConfig(configPath, "holiday"); err == nil || !strings.Contains(err.Error(), "home, office") {
+		t.Errorf("Expected an unknown profile to be rejected, got %v", err)
+	}
+
+	config = Config{}
+	loadConfig(configPath, "")
+	syncManager = dirsync.NewSyncManager(dirsync.Options{})
+	addPairs(syncManager, &config)
+	enabled := func() map[string]bool {
+		result := make(map[string]bool)
+		for _, s := range syncManager.Syncs {
+			result[s.SourcePath] = s.Enabled()
+		}
+		return result
+	}
+	if got := enabled(); !got["/always"] || !got["/photos"] || got[filepath.Join(dir, "work")] {
+		t.Errorf("Expected the home pairs to be enabled, got %v", got)
+	}
+
+	rr := httptest.NewRecorder()
+	handleProfile(rr, httptest.NewRequest("POST", "/api/profile", strings.NewReader(`{"profile": "office"}`)))
+	var profiles client.Profiles
+	json.NewDecoder(rr.Body).Decode(&profiles)
+	if rr.Code != http.StatusOK || profiles.Profile != "office" || len(profiles.Profiles) != 2 {
+		t.Errorf("Expected to switch to office, got %d %+v", rr.Code, profiles)
+	}
+	if got := enabled(); !got["/always"] || got["/photos"] || !got[filepath.Join(dir, "work")] {
+		t.Errorf("Expected the office pairs to be enabled, got %v", got)
+	}
+
+	rr = httptest.NewRecorder()
+	handleProfile(rr, httptest.NewRequest("POST", "/api/profile", strings.NewReader(`{"profile": "holiday"}`)))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown profile, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import "net/http"
+
+// withReadOnly rejects every request that could change state, so the status
+// dashboard can be exposed without giving away control over the syncs
+func withReadOnly(readOnly bool, next http.Handler) http.Handler {
+	if !readOnly {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Let the web UI know it shouldn't offer any controls
+		w.Header().Set("X-DirSync-Read-Only", "true")
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Server is in read-only mode", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
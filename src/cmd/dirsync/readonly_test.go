@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestWithReadOnly tests that mutating requests are rejected in read-only mode
+func TestWithReadOnly(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		readOnly bool
+		method   string
+		path     string
+		expected int
+	}{
+		{false, "POST", "/api/sync/now", http.StatusOK},
+		{true, "POST", "/api/sync/now", http.StatusForbidden},
+		{true, "POST", "/api/sync/pause?id=x", http.StatusForbidden},
+		{true, "GET", "/status", http.StatusOK},
+		{true, "HEAD", "/", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		rr := httptest.NewRecorder()
+
+		withReadOnly(tt.readOnly, next).ServeHTTP(rr, req)
+
+		if rr.Code != tt.expected {
+			t.Errorf("%s %s (read-only %v): expected status %d, got %d", tt.method, tt.path, tt.readOnly, tt.expected, rr.Code)
+		}
+
+		if tt.readOnly && rr.Header().Get("X-DirSync-Read-Only") != "true" {
+			t.Errorf("%s %s: expected read-only header", tt.method, tt.path)
+		}
+	}
+}
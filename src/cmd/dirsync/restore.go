@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// runRestore decrypts an encrypted destination into a directory and returns
+// the exit code: 0 on success, 1 if restoring failed and 2 for usage errors
+func runRestore(args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	keyFile := flags.String("key-file", "", "file holding the pair's encryption key")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dirsync restore --key-file KEY <encrypted destination> <target>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *keyFile == "" || flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	key, err := dirsync.LoadEncryptionKey(*keyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	restored, err := dirsync.RestoreDir(flags.Arg(0), flags.Arg(1), key)
+	fmt.Printf("Restored %d files to %s\n", restored, flags.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error restoring: %v\n", err)
+		return 1
+	}
+	return 0
+}
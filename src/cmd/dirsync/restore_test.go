@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// TestRunRestore tests decrypting an encrypted destination from the command line
+func TestRunRestore(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(keyFile, []byte(strings.Repeat("ab", 32)), 0600)
+
+	destDir := t.TempDir()
+	s := dirsync.NewSync(testSourceDir, destDir, 60)
+	s.Pair.Encryption = &dirsync.EncryptionConfig{KeyFile: keyFile}
+	if err := s.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	target := t.TempDir()
+	if code := runRestore([]string{"--key-file", keyFile, destDir, target}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	if data, _ := os.ReadFile(filepath.Join(target, "file1.txt")); string(data) != "Test file 1 content" {
+		t.Errorf("Expected file1.txt to be decrypted, got %q", data)
+	}
+
+	if code := runRestore([]string{destDir, target}); code != 2 {
+		t.Errorf("Expected exit code 2 without a key, got %d", code)
+	}
+}
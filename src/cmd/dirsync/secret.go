@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// secretPrefix marks a config value that names a secret in the OS keyring,
+// like "keyring:api-token", instead of holding it in plain text
+const secretPrefix = "keyring:"
+
+// keyringService is the service secrets are stored under in the keyring
+const keyringService = "dirsync"
+
+// secretStore keeps named secrets
+type secretStore interface {
+	Get(name string) (string, error)
+	Set(name, value string) error
+}
+
+// keyring is where secrets are stored, replaced in tests
+var keyring secretStore = osKeyring{}
+
+// osKeyring stores secrets in the macOS keychain with security, or in the
+// Secret Service keyring (GNOME Keyring, KWallet) with secret-tool elsewhere
+type osKeyring struct{}
+
+// Get returns the secret stored under name
+func (osKeyring) Get(name string) (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", name, "-w")
+	case "windows":
+		return "", errors.New("the OS keyring isn't supported on Windows")
+	default:
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "name", name)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", keyringError(cmd, err, stderr.String())
+	}
+	secret := strings.TrimSuffix(string(out), "\n")
+	if secret == "" {
+		return "", fmt.Errorf("no secret %q in the keyring", name)
+	}
+	return secret, nil
+}
+
+// Set stores value under name, replacing any secret stored before
+func (osKeyring) Set(name, value string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		// security -i reads the command from stdin, keeping the secret out of ps
+		line, err := keychainAddCommand(name, value)
+		if err != nil {
+			return err
+		}
+		cmd = exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader(line)
+	case "windows":
+		return errors.New("the OS keyring isn't supported on Windows")
+	default:
+		// secret-tool reads the secret from stdin, keeping it out of ps
+		cmd = exec.Command("secret-tool", "store", "--label", "dirsync "+name, "service", keyringService, "name", name)
+		cmd.Stdin = strings.NewReader(value)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return keyringError(cmd, err, stderr.String())
+	}
+	// security -i exits cleanly when the command it read fails
+	if runtime.GOOS == "darwin" && strings.TrimSpace(stderr.String()) != "" {
+		return keyringError(cmd, errors.New("storing the secret failed"), stderr.String())
+	}
+	return nil
+}
+
+// keychainAddCommand returns the line security -i runs to store value under
+// name. The secret is hex encoded with -X, so it needs no quoting.
+func keychainAddCommand(name, value string) (string, error) {
+	if strings.ContainsAny(name, "\"\\\n") {
+		return "", fmt.Errorf("secret name %q can't contain quotes, backslashes or newlines", name)
+	}
+	return fmt.Sprintf("add-generic-password -U -s %s -a \"%s\" -X %s\n", keyringService, name, hex.EncodeToString([]byte(value))), nil
+}
+
+// keyringError describes a failed keyring command, with a hint if the
+// command isn't installed
+func keyringError(cmd *exec.Cmd, err error, stderr string) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("%s not found, install it to use the keyring (secret-tool is in libsecret-tools)", cmd.Args[0])
+	}
+	if stderr = strings.TrimSpace(stderr); stderr != "" {
+		return fmt.Errorf("%s: %v: %s", cmd.Args[0], err, stderr)
+	}
+	return fmt.Errorf("%s: %v", cmd.Args[0], err)
+}
+
+// resolveSecrets replaces config values that name secrets in the keyring
+// with "keyring:" or in the secrets file with "secret:" with the secrets:
+// the auth password and token, webhook URLs and header values, and the
+// hosts and users of remote hooks. Keys for encryption and reports are read
+// from their own files, so they are never in the config. The secrets file
+// is only decrypted if a value names a secret in it.
+func (c *Config) resolveSecrets() error {
+	var fileSecrets map[string]string
+	resolve := func(value *string) error {
+		if name, ok := strings.CutPrefix(*value, secretPrefix); ok {
+			secret, err := keyring.Get(name)
+			if err != nil {
+				return fmt.Errorf("error reading secret %q: %w", name, err)
+			}
+			*value = secret
+			return nil
+		}
+
+		name, ok := strings.CutPrefix(*value, secretsFilePrefix)
+		if !ok {
+			return nil
+		}
+		if c.SecretsFile == "" {
+			return fmt.Errorf("secret %q is used but no secrets_file is configured", name)
+		}
+		if fileSecrets == nil {
+			passphrase, err := secretsPassphrase()
+			if err != nil {
+				return err
+			}
+			if fileSecrets, err = readSecretsFile(c.SecretsFile, passphrase); err != nil {
+				return err
+			}
+		}
+		secret, ok := fileSecrets[name]
+		if !ok {
+			return fmt.Errorf("no secret %q in %s", name, c.SecretsFile)
+		}
+		*value = secret
+		return nil
+	}
+
+	var values []*string
+	if c.Auth != nil {
+		values = append(values, &c.Auth.Password, &c.Auth.Token)
+	}
+	for i := range c.Webhooks {
+		hook := &c.Webhooks[i]
+		values = append(values, &hook.URL)
+		for name, value := range hook.Headers {
+			if err := resolve(&value); err != nil {
+				return err
+			}
+			hook.Headers[name] = value
+		}
+	}
+	for i := range c.Pairs {
+		if remote := c.Pairs[i].RemoteHooks; remote != nil {
+			values = append(values, &remote.Host, &remote.User)
+		}
+	}
+	for _, value := range values {
+		if err := resolve(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runSecret stores a secret read from stdin in the OS keyring, so the
+// config can name it with "keyring:<name>", or with --file in an encrypted
+// secrets file, named with "secret:<name>". It returns the exit code.
+func runSecret(args []string, stdin io.Reader) int {
+	flags := flag.NewFlagSet("secret", flag.ContinueOnError)
+	file := flags.String("file", "", "encrypted secrets file to store the secret in instead of the keyring, with the passphrase in "+passphraseEnv)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dirsync secret [--file <secrets file>] set <name> (reads the secret from stdin)")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 2 || flags.Arg(0) != "set" || flags.Arg(1) == "" {
+		flags.Usage()
+		return 2
+	}
+	name := flags.Arg(1)
+
+	if f, ok := stdin.(*os.File); ok {
+		if info, err := f.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			fmt.Fprintf(os.Stderr, "Secret for %s: ", name)
+		}
+	}
+	value, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if value = strings.TrimRight(value, "\r\n"); value == "" {
+		fmt.Fprintln(os.Stderr, "No secret given")
+		return 1
+	}
+
+	prefix := secretPrefix
+	if *file != "" {
+		prefix = secretsFilePrefix
+		err = setFileSecret(*file, name, value)
+	} else {
+		err = keyring.Set(name, value)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error storing secret: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Stored secret %s, use \"%s%s\" in the config\n", name, prefix, name)
+	return 0
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// memoryKeyring keeps secrets in a map instead of the OS keyring
+type memoryKeyring map[string]string
+
+func (k memoryKeyring) Get(name string) (string, error) {
+	secret, ok := k[name]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return secret, nil
+}
+
+func (k memoryKeyring) Set(name, value string) error {
+	k[name] = value
+	return nil
+}
+
+// TestSecrets tests storing secrets and reading them when the config loads
+func TestSecrets(t *testing.T) {
+	store := memoryKeyring{}
+	keyring = store
+	defer func() { keyring = osKeyring{} }()
+
+	if code := runSecret([]string{"set", "api-token"}, strings.NewReader("s3cret\n")); code != 0 || store["api-token"] != "s3cret" {
+		t.Fatalf("Expected the secret to be stored, got %d %v", code, store)
+	}
+	if code := runSecret([]string{"get", "api-token"}, strings.NewReader("")); code != 2 {
+		t.Errorf("Expected exit code 2 for an unknown action, got %d", code)
+	}
+	if code := runSecret([]string{"set", "empty"}, strings.NewReader("\n")); code != 1 {
+		t.Errorf("Expected exit code 1 for an empty secret, got %d", code)
+	}
+
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	store["hook-url"] = "https://hooks.example.com/T0/B0"
+	os.WriteFile(configPath, []byte(`{
+		"auth": {"username": "admin", "password": "plain", "token": "keyring:api-token"},
+		"webhooks": [{"url": "keyring:hook-url", "headers": {"Authorization": "keyring:api-token", "X-Plain": "plain"}}]
+	}`), 0644)
+	defer func() { config, baseDir = Config{}, "" }()
+	if err := loadConfig(configPath, ""); err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if config.Auth.Token != "s3cret" || config.Auth.Password != "plain" {
+		t.Errorf("Expected the token from the keyring, got %+v", config.Auth)
+	}
+	hook := config.Webhooks[0]
+	if hook.URL != store["hook-url"] || hook.Headers["Authorization"] != "s3cret" || hook.Headers["X-Plain"] != "plain" {
+		t.Errorf("Expected the webhook URL and header from the keyring, got %+v", hook)
+	}
+
+	os.WriteFile(configPath, []byte(`{"auth": {"token": "keyring:missing"}}`), 0644)
+	config = Config{}
+	if err := loadConfig(configPath, ""); err == nil || !strings.Contains(err.Error(), `secret "missing"`) {
+		t.Errorf("Expected a missing secret to stop the config from loading, got %v", err)
+	}
+}
+
+// TestSecretsFile tests storing secrets in an encrypted file and reading
+// them when the config loads
+func TestSecretsFile(t *testing.T) {
+	dir := t.TempDir()
+	secretsPath := filepath.Join(dir, "secrets.enc")
+	t.Setenv(passphraseEnv, "correct horse")
+
+	for name, value := range map[string]string{"api-token": "s3cret", "admin": "hunter2"} {
+		if code := runSecret([]string{"--file", secretsPath, "set", name}, strings.NewReader(value+"\n")); code != 0 {
+			t.Fatalf("Expected %s to be stored, got exit code %d", name, code)
+		}
+	}
+	if data, _ := os.ReadFile(secretsPath); strings.Contains(string(data), "s3cret") {
+		t.Errorf("Expected the secrets file to be encrypted")
+	}
+
+	configPath := filepath.Join(dir, "config.json")
+	os.WriteFile(configPath, []byte(`{
+		"secrets_file": "secrets.enc",
+		"auth": {"username": "admin", "password": "secret:admin", "token": "secret:api-token"},
+		"pairs": [{"source": "src", "destination": "dst", "remote_hooks": {"host": "backup.example.com", "user": "secret:admin"}}]
+	}`), 0644)
+	defer func() { config, baseDir = Config{}, "" }()
+	if err := loadConfig(configPath, ""); err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if config.Auth.Token != "s3cret" || config.Auth.Password != "hunter2" {
+		t.Errorf("Expected the secrets from the file, got %+v", config.Auth)
+	}
+	if remote := config.Pairs[0].RemoteHooks; remote.User != "hunter2" || remote.Host != "backup.example.com" {
+		t.Errorf("Expected the remote hook user from the file, got %+v", remote)
+	}
+
+	t.Setenv(passphraseEnv, "wrong")
+	config = Config{}
+	if err := loadConfig(configPath, ""); err == nil || !strings.Contains(err.Error(), "passphrase is wrong") {
+		t.Errorf("Expected a wrong passphrase to be reported, got %v", err)
+	}
+}
+
+// TestKeychainAddCommand tests keeping the secret out of the keychain
+// command's arguments and its quoting
+func TestKeychainAddCommand(t *testing.T) {
+	line, err := keychainAddCommand("api token", "p@ss \"word\"")
+	if err != nil {
+		t.Fatalf("keychainAddCommand failed: %v", err)
+	}
+	expected := "add-generic-password -U -s dirsync -a \"api token\" -X 704073732022776f726422\n"
+	if line != expected {
+		t.Errorf("Expected %q, got %q", expected, line)
+	}
+	if strings.Contains(line, "p@ss") {
+		t.Errorf("Expected the secret to be hex encoded, got %q", line)
+	}
+
+	if _, err := keychainAddCommand("bad\"name", "x"); err == nil {
+		t.Errorf("Expected a name with a quote to be rejected")
+	}
+}
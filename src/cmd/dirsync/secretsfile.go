@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// secretsFileMagic starts every encrypted secrets file
+	secretsFileMagic = "DSSECRETS1"
+
+	// secretsFilePrefix marks a config value that names a secret in the
+	// encrypted secrets file, like "secret:api-token"
+	secretsFilePrefix = "secret:"
+
+	// secretsLogN is log2 of scrypt's N for new files: 2^15 with r=8 takes
+	// 32 MiB of memory and a fraction of a second per guess
+	secretsLogN = 15
+
+	// secretsSaltSize is the length of the random scrypt salt
+	secretsSaltSize = 16
+)
+
+// passphraseEnv names the environment variable holding the passphrase of
+// the secrets file
+const passphraseEnv = "DIRSYNC_SECRETS_PASSPHRASE"
+
+// secretsPassphrase returns the passphrase of the secrets file from the
+// environment
+func secretsPassphrase() ([]byte, error) {
+	passphrase := os.Getenv(passphraseEnv)
+	if passphrase == "" {
+		return nil, fmt.Errorf("%s must be set to decrypt the secrets file", passphraseEnv)
+	}
+	return []byte(passphrase), nil
+}
+
+// secretsCipher returns the AES-256-GCM cipher for a secrets file with the
+// given scrypt cost and salt
+func secretsCipher(passphrase []byte, logN byte, salt []byte) (cipher.AEAD, error) {
+	if logN < 10 || logN > 22 {
+		return nil, fmt.Errorf("unsupported scrypt cost 2^%d", logN)
+	}
+	key, err := scrypt.Key(passphrase, salt, 1<<logN, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// readSecretsFile decrypts the secrets in the file at path. The file holds
+// the magic, the scrypt cost, the salt, the nonce and the sealed JSON object
+// of secrets by name, with everything before the nonce authenticated.
+func readSecretsFile(path string, passphrase []byte) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading secrets file: %w", err)
+	}
+
+	header := len(secretsFileMagic) + 1 + secretsSaltSize
+	if len(data) < header || !bytes.HasPrefix(data, []byte(secretsFileMagic)) {
+		return nil, fmt.Errorf("%s is not a dirsync secrets file", path)
+	}
+	logN := data[len(secretsFileMagic)]
+	salt := data[len(secretsFileMagic)+1 : header]
+
+	aead, err := secretsCipher(passphrase, logN, salt)
+	if err != nil {
+		return nil, fmt.Errorf("error reading secrets file: %w", err)
+	}
+	if len(data) < header+aead.NonceSize() {
+		return nil, fmt.Errorf("%s is truncated", path)
+	}
+	nonce := data[header : header+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, data[header+aead.NonceSize():], data[:header])
+	if err != nil {
+		return nil, fmt.Errorf("can't decrypt %s, the passphrase is wrong or the file was changed", path)
+	}
+
+	var secrets map[string]string
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("error parsing secrets file: %w", err)
+	}
+	return secrets, nil
+}
+
+// writeSecretsFile encrypts secrets into the file at path with a new salt,
+// replacing it in one step
+func writeSecretsFile(path string, passphrase []byte, secrets map[string]string) error {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, secretsSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	aead, err := secretsCipher(passphrase, secretsLogN, salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	header := append([]byte(secretsFileMagic), secretsLogN)
+	header = append(header, salt...)
+	data := append(append(header, nonce...), aead.Seal(nil, nonce, plaintext, header)...)
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".secrets-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// setFileSecret stores a secret in the encrypted secrets file at path,
+// creating the file if it doesn't exist
+func setFileSecret(path, name, value string) error {
+	passphrase, err := secretsPassphrase()
+	if err != nil {
+		return err
+	}
+
+	secrets := make(map[string]string)
+	if _, err := os.Stat(path); err == nil {
+		if secrets, err = readSecretsFile(path, passphrase); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if secrets == nil {
+		secrets = make(map[string]string)
+	}
+	secrets[name] = value
+	return writeSecretsFile(path, passphrase, secrets)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src/client"
+)
+
+// runStatus prints the status of every pair on a running daemon and
+// returns the exit code
+func runStatus(args []string) int {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	server := flags.String("server", "http://localhost:8080", "address of the running daemon")
+	token := flags.String("token", os.Getenv("DIRSYNC_TOKEN"), "API token, if auth is enabled (default $DIRSYNC_TOKEN)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dirsync status [--server http://host:port] [--token TOKEN]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	c := client.New(*server)
+	c.Token = *token
+
+	statuses, err := c.Status()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error querying %s: %v\n", *server, err)
+		return 1
+	}
+
+	printStatusTable(os.Stdout, statuses, time.Now())
+	return 0
+}
+
+// printStatusTable writes a table of pairs with their state and sync times
+func printStatusTable(w io.Writer, statuses []client.SyncStatus, now time.Time) {
+	if len(statuses) == 0 {
+		fmt.Fprintln(w, "No sync pairs configured")
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tDESTINATION\tSTATE\tLAST SYNC\tNEXT SYNC\tERROR")
+	for _, s := range statuses {
+		state := "idle"
+		switch {
+		case s.IsSyncing:
+			state = "syncing"
+		case s.Paused:
+			state = "paused"
+		case s.LockedBy != "":
+			state = "locked"
+		case s.LastError != "":
+			state = "failed"
+		}
+
+		next := relativeTime(s.NextSyncTime, now)
+		if s.Paused {
+			next = "-"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", s.SourcePath, s.DestinationPath, state,
+			relativeTime(s.LastSync, now), next, firstLine(s.LastError))
+	}
+	tw.Flush()
+}
+
+// relativeTime formats t relative to now, e.g. "5m ago" or "in 30s"
+func relativeTime(t, now time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+
+	d := now.Sub(t)
+	if d >= 0 {
+		return d.Round(time.Second).String() + " ago"
+	}
+	if d > -time.Second {
+		return "now"
+	}
+	return "in " + (-d).Round(time.Second).String()
+}
+
+// firstLine returns the first line of s
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
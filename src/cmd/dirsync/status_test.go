@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src/client"
+)
+
+// TestPrintStatusTable tests the human-readable status table
+func TestPrintStatusTable(t *testing.T) {
+	now := time.Now()
+	statuses := []client.SyncStatus{
+		{SourcePath: "/src", DestinationPath: "/dst", LastSync: now.Add(-5 * time.Minute), NextSyncTime: now.Add(30 * time.Second)},
+		{SourcePath: "/photos", DestinationPath: "/nas", Paused: true},
+		{SourcePath: "/logs", DestinationPath: "/backup", LastError: "rsync error: exit status 23\nmore"},
+		{SourcePath: "/site", DestinationPath: "/www", LockedBy: "/www/.dirsync-lock"},
+	}
+
+	var out strings.Builder
+	printStatusTable(&out, statuses, now)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+
+	if len(lines) != 5 {
+		t.Fatalf("Expected a header and 4 rows, got:\n%s", out.String())
+	}
+
+	for i, want := range []string{"5m0s ago", "paused", "failed", "locked"} {
+		if !strings.Contains(lines[i+1], want) {
+			t.Errorf("Expected row %d to contain %q, got %q", i+1, want, lines[i+1])
+		}
+	}
+	if !strings.Contains(lines[1], "in 30s") || !strings.Contains(lines[2], "never") {
+		t.Errorf("Unexpected sync times:\n%s", out.String())
+	}
+	if strings.Contains(out.String(), "more") {
+		t.Errorf("Expected only the first line of errors, got:\n%s", out.String())
+	}
+}
+
+// TestRunStatus tests querying a daemon for its status
+func TestRunStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode([]client.SyncStatus{{SourcePath: "/src", DestinationPath: "/dst"}})
+	}))
+	defer ts.Close()
+
+	if code := runStatus([]string{"--server", ts.URL, "--token", "secret"}); code != 0 {
+		t.Errorf("Expected exit code 0, got %d", code)
+	}
+	if code := runStatus([]string{"--server", ts.URL, "--token", "wrong"}); code != 1 {
+		t.Errorf("Expected exit code 1 when unauthorized, got %d", code)
+	}
+}
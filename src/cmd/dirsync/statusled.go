@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// Aggregate health of all pairs, shown on the status LED
+const (
+	healthOK      = "ok"
+	healthSyncing = "syncing"
+	healthFailed  = "failed"
+)
+
+// ledTick is how often the status LED is updated
+const ledTick = 100 * time.Millisecond
+
+// sysfsRoot is where the kernel's GPIO and LED interfaces live
+var sysfsRoot = "/sys/class"
+
+// StatusLEDConfig drives an LED from the health of all pairs, for headless
+// backup appliances such as a Raspberry Pi. The LED is steady while every
+// pair is fine, blinks slowly while syncing and quickly when a pair failed.
+type StatusLEDConfig struct {
+	// GPIO is the number of a GPIO pin driving an external LED
+	GPIO *int `json:"gpio"`
+
+	// LED is the name of a kernel LED, such as led0 for the Pi's activity LED
+	LED string `json:"led"`
+
+	// ActiveLow turns the LED on by driving the pin low
+	ActiveLow bool `json:"active_low"`
+}
+
+// ledDevice turns an LED on and off
+type ledDevice interface {
+	set(on bool) error
+	close()
+}
+
+// openLED opens the configured GPIO pin or kernel LED
+func openLED(cfg *StatusLEDConfig) (ledDevice, error) {
+	switch {
+	case cfg.GPIO != nil && cfg.LED != "":
+		return nil, errors.New("status_led can use either gpio or led, not both")
+	case cfg.GPIO != nil:
+		return openGPIO(*cfg.GPIO, cfg.ActiveLow)
+	case cfg.LED != "":
+		return openKernelLED(cfg.LED, cfg.ActiveLow)
+	}
+	return nil, errors.New("status_led needs a gpio pin or led name")
+}
+
+// gpioPin is a pin driven through the sysfs GPIO interface
+type gpioPin struct {
+	dir       string
+	pin       int
+	activeLow bool
+}
+
+// openGPIO exports a pin and configures it as an output
+func openGPIO(pin int, activeLow bool) (*gpioPin, error) {
+	dir := filepath.Join(sysfsRoot, "gpio", "gpio"+strconv.Itoa(pin))
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.WriteFile(filepath.Join(sysfsRoot, "gpio", "export"), []byte(strconv.Itoa(pin)), 0); err != nil {
+			return nil, fmt.Errorf("error exporting GPIO %d: %w", pin, err)
+		}
+	}
+
+	// The pin's files can take a moment to appear after exporting it
+	var err error
+	for i := 0; i < 10; i++ {
+		if err = os.WriteFile(filepath.Join(dir, "direction"), []byte("out"), 0); err == nil {
+			return &gpioPin{dir: dir, pin: pin, activeLow: activeLow}, nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return nil, fmt.Errorf("error configuring GPIO %d: %w", pin, err)
+}
+
+func (p *gpioPin) set(on bool) error {
+	value := "0"
+	if on != p.activeLow {
+		value = "1"
+	}
+	return os.WriteFile(filepath.Join(p.dir, "value"), []byte(value), 0)
+}
+
+func (p *gpioPin) close() {
+	p.set(false)
+	os.WriteFile(filepath.Join(sysfsRoot, "gpio", "unexport"), []byte(strconv.Itoa(p.pin)), 0)
+}
+
+// kernelLED is an LED driven through the sysfs LED class
+type kernelLED struct {
+	dir       string
+	trigger   string
+	max       string
+	activeLow bool
+}
+
+// openKernelLED takes the LED over from its kernel trigger, which is restored on close
+func openKernelLED(name string, activeLow bool) (*kernelLED, error) {
+	dir := filepath.Join(sysfsRoot, "leds", name)
+
+	led := &kernelLED{dir: dir, trigger: "none", max: "1", activeLow: activeLow}
+	if data, err := os.ReadFile(filepath.Join(dir, "max_brightness")); err == nil {
+		led.max = strings.TrimSpace(string(data))
+	}
+
+	// The current trigger is the one in brackets, e.g. "none [mmc0] timer"
+	if data, err := os.ReadFile(filepath.Join(dir, "trigger")); err == nil {
+		if start := strings.Index(string(data), "["); start >= 0 {
+			if end := strings.Index(string(data[start:]), "]"); end > 0 {
+				led.trigger = string(data[start+1 : start+end])
+			}
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "trigger"), []byte("none"), 0); err != nil {
+		return nil, fmt.Errorf("error taking over LED %s: %w", name, err)
+	}
+	return led, nil
+}
+
+func (l *kernelLED) set(on bool) error {
+	value := "0"
+	if on != l.activeLow {
+		value = l.max
+	}
+	return os.WriteFile(filepath.Join(l.dir, "brightness"), []byte(value), 0)
+}
+
+func (l *kernelLED) close() {
+	os.WriteFile(filepath.Join(l.dir, "trigger"), []byte(l.trigger), 0)
+}
+
+// aggregateHealth sums up the state of all enabled pairs: failed if any
+// pair's last run failed, syncing if any pair is running, and ok otherwise
+func aggregateHealth(statuses []map[string]interface{}) string {
+	health := healthOK
+	for _, status := range statuses {
+		if status["enabled"] == false {
+			continue
+		}
+		if status["last_error"] != "" {
+			return healthFailed
+		}
+		if status["is_syncing"] == true {
+			health = healthSyncing
+		}
+	}
+	return health
+}
+
+// ledOn returns whether the LED is lit for the given health at a tick:
+// steady for ok, a 1 Hz blink while syncing and a 5 Hz blink on failure
+func ledOn(health string, tick int) bool {
+	switch health {
+	case healthSyncing:
+		return tick%10 < 5
+	case healthFailed:
+		return tick%2 == 0
+	}
+	return true
+}
+
+// runStatusLED shows the health of all pairs on the LED until ctx is done
+func runStatusLED(ctx context.Context, led ledDevice, syncManager *dirsync.SyncManager) {
+	defer led.close()
+
+	ticker := time.NewTicker(ledTick)
+	defer ticker.Stop()
+
+	lastErr := ""
+	for tick := 0; ; tick++ {
+		on := ledOn(aggregateHealth(syncManager.GetAllStatus()), tick)
+		if err := led.set(on); err != nil && err.Error() != lastErr {
+			// Only log each distinct error once, not ten times a second
+			log.Printf("Error setting status LED: %v", err)
+			lastErr = err.Error()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
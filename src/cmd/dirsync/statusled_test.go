@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestStatusLED tests driving a GPIO pin and a kernel LED through sysfs
+func TestStatusLED(t *testing.T) {
+	root := t.TempDir()
+	oldRoot := sysfsRoot
+	sysfsRoot = root
+	defer func() { sysfsRoot = oldRoot }()
+
+	// An already exported pin
+	os.MkdirAll(filepath.Join(root, "gpio", "gpio17"), 0755)
+	pin := 17
+	led, err := openLED(&StatusLEDConfig{GPIO: &pin, ActiveLow: true})
+	if err != nil {
+		t.Fatalf("openLED failed: %v", err)
+	}
+	led.set(true)
+	if data, _ := os.ReadFile(filepath.Join(root, "gpio", "gpio17", "value")); string(data) != "0" {
+		t.Errorf("Expected active low pin to be driven low, got %q", data)
+	}
+
+	// The kernel LED's trigger is restored on close
+	ledDir := filepath.Join(root, "leds", "led0")
+	os.MkdirAll(ledDir, 0755)
+	os.WriteFile(filepath.Join(ledDir, "trigger"), []byte("none [mmc0] timer"), 0644)
+	os.WriteFile(filepath.Join(ledDir, "max_brightness"), []byte("255\n"), 0644)
+
+	led, err = openLED(&StatusLEDConfig{LED: "led0"})
+	if err != nil {
+		t.Fatalf("openLED failed: %v", err)
+	}
+	led.set(true)
+	if data, _ := os.ReadFile(filepath.Join(ledDir, "brightness")); string(data) != "255" {
+		t.Errorf("Expected full brightness, got %q", data)
+	}
+	led.close()
+	if data, _ := os.ReadFile(filepath.Join(ledDir, "trigger")); string(data) != "mmc0" {
+		t.Errorf("Expected trigger to be restored, got %q", data)
+	}
+
+	if _, err := openLED(&StatusLEDConfig{}); err == nil {
+		t.Errorf("Expected error without a pin or LED")
+	}
+}
+
+// TestAggregateHealth tests summing up pair states for the status LED
+func TestAggregateHealth(t *testing.T) {
+	ok := map[string]interface{}{"last_error": "", "is_syncing": false}
+	syncing := map[string]interface{}{"last_error": "", "is_syncing": true}
+	failed := map[string]interface{}{"last_error": "rsync error", "is_syncing": false}
+	disabled := map[string]interface{}{"last_error": "rsync error", "is_syncing": false, "enabled": false}
+
+	tests := []struct {
+		statuses []map[string]interface{}
+		want     string
+	}{
+		{[]map[string]interface{}{ok, ok}, healthOK},
+		{[]map[string]interface{}{ok, syncing}, healthSyncing},
+		{[]map[string]interface{}{syncing, failed}, healthFailed},
+		{[]map[string]interface{}{ok, disabled}, healthOK},
+	}
+	for _, test := range tests {
+		if got := aggregateHealth(test.statuses); got != test.want {
+			t.Errorf("Expected %s, got %s", test.want, got)
+		}
+	}
+
+	// Steady, slow and fast blinking over one second
+	patterns := map[string]string{healthOK: "1111111111", healthSyncing: "1111100000", healthFailed: "1010101010"}
+	for health, want := range patterns {
+		var b strings.Builder
+		for tick := 0; tick < 10; tick++ {
+			if ledOn(health, tick) {
+				b.WriteByte('1')
+			} else {
+				b.WriteByte('0')
+			}
+		}
+		if b.String() != want {
+			t.Errorf("%s: expected pattern %s, got %s", health, want, b.String())
+		}
+	}
+}
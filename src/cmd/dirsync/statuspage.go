@@ -0,0 +1,169 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// statusPageRuns is how many of the most recent runs the status page lists
+const statusPageRuns = 20
+
+// statusPageTemplate is a self-contained HTML report, with no scripts or
+// external styles, so it can be served by any web server or opened from disk
+var statusPageTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return t.Format("2006-01-02 15:04:05 MST")
+	},
+	"duration": func(run dirsync.RunRecord) string {
+		if run.EndTime.IsZero() {
+			return "running"
+		}
+		return run.EndTime.Sub(run.StartTime).Round(time.Second).String()
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>DirSync status</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+th, td { text-align: left; padding: 0.4em 0.8em; border-bottom: 1px solid #ddd; vertical-align: top; }
+th { background: #f4f4f4; }
+.ok { color: #2a7d2a; font-weight: bold; }
+.failed { color: #c0392b; font-weight: bold; }
+.other { color: #777; font-weight: bold; }
+.error { color: #c0392b; white-space: pre-wrap; }
+footer { color: #777; font-size: 0.9em; }
+</style>
+</head>
+<body>
+<h1>DirSync status</h1>
+<h2>Pairs</h2>
+<table>
+<tr><th>Source</th><th>Destination</th><th>State</th><th>Last sync</th><th>Next sync</th><th>Last error</th></tr>
+{{- range .Pairs}}
+<tr>
+<td>{{.Source}}</td><td>{{.Destination}}</td>
+<td class="{{.Class}}">{{.State}}</td>
+<td>{{time .LastSync}}</td><td>{{if .Paused}}-{{else}}{{time .NextSync}}{{end}}</td>
+<td class="error">{{.LastError}}</td>
+</tr>
+{{- end}}
+</table>
+<h2>Recent runs</h2>
+<table>
+<tr><th>Pair</th><th>Started</th><th>Duration</th><th>Result</th></tr>
+{{- range .Runs}}
+<tr>
+<td>{{.SyncID}}</td><td>{{time .StartTime}}</td><td>{{duration .}}</td>
+<td>{{if .EndTime.IsZero}}<span class="other">running</span>{{else if .Skipped}}<span class="other">skipped</span> <span class="error">{{.Skipped}}</span>{{else if .Success}}<span class="ok">ok</span>{{else}}<span class="failed">failed</span> <span class="error">{{.Error}}</span>{{end}}</td>
+</tr>
+{{- else}}
+<tr><td colspan="4">No runs yet</td></tr>
+{{- end}}
+</table>
+<footer>Generated {{time .Generated}}</footer>
+</body>
+</html>
+`))
+
+// statusPagePair is a pair's row on the status page
+type statusPagePair struct {
+	Source      string
+	Destination string
+	State       string
+	Class       string
+	Paused      bool
+	LastSync    time.Time
+	NextSync    time.Time
+	LastError   string
+}
+
+// statusPageMu keeps runs finishing at the same time from writing the page at once
+var statusPageMu sync.Mutex
+
+// writeStatusPage renders the current status of every pair and the most
+// recent runs to path, replacing the previous report in one step
+func writeStatusPage(path string, syncManager *dirsync.SyncManager) error {
+	statusPageMu.Lock()
+	defer statusPageMu.Unlock()
+
+	var pairs []statusPagePair
+	for _, status := range syncManager.GetAllStatus() {
+		pair := statusPagePair{
+			Source:      status["source_path"].(string),
+			Destination: status["destination_path"].(string),
+			State:       "ok",
+			Class:       "ok",
+			Paused:      status["paused"].(bool),
+			LastSync:    status["last_sync"].(time.Time),
+			NextSync:    status["next_sync_time"].(time.Time),
+			LastError:   status["last_error"].(string),
+		}
+		switch {
+		case status["is_syncing"].(bool):
+			pair.State, pair.Class = "syncing", "other"
+		case pair.LastError != "":
+			pair.State, pair.Class = "failed", "failed"
+		case pair.Paused:
+			pair.State, pair.Class = "paused", "other"
+		case status["locked_by"] != "":
+			pair.State, pair.Class = "locked", "other"
+		case pair.LastSync.IsZero():
+			pair.State, pair.Class = "pending", "other"
+		}
+		pairs = append(pairs, pair)
+	}
+
+	// Newest runs first
+	all := syncManager.History.ListRuns("")
+	var runs []dirsync.RunRecord
+	for i := len(all) - 1; i >= 0 && len(runs) < statusPageRuns; i-- {
+		runs = append(runs, all[i])
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".dirsync-status-*.html")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = statusPageTemplate.Execute(tmp, map[string]interface{}{
+		"Pairs":     pairs,
+		"Runs":      runs,
+		"Generated": time.Now(),
+	})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	os.Chmod(tmp.Name(), 0644)
+	return os.Rename(tmp.Name(), path)
+}
+
+// statusPageWriter returns a callback that rewrites the status page after
+// each run, or nil if no status page is configured
+func statusPageWriter(path string) func(dirsync.RunRecord) {
+	if path == "" {
+		return nil
+	}
+	return func(dirsync.RunRecord) {
+		if err := writeStatusPage(path, syncManager); err != nil {
+			log.Printf("Error writing status page %s: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// TestStatusPage tests writing the static status page after a run
+func TestStatusPage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.html")
+
+	syncManager = dirsync.NewSyncManager(dirsync.Options{OnRunFinished: statusPageWriter(path)})
+	testSync := syncManager.AddSync(testSourceDir, t.TempDir(), 60)
+	failingSync := syncManager.AddSync("/non/existent/<source>", t.TempDir(), 60)
+
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	failingSync.SyncDirectories()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Expected status page to be written: %v", err)
+	}
+
+	page := string(data)
+	for _, want := range []string{testSourceDir, `<td class="ok">ok</td>`, `<td class="failed">failed</td>`, "&lt;source&gt;"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Expected %q in status page, got %s", want, page)
+		}
+	}
+	if strings.Count(page, "<span class=\"ok\">ok</span>") != 1 {
+		t.Errorf("Expected one successful run listed")
+	}
+
+	if statusPageWriter("") != nil {
+		t.Errorf("Expected no writer without a status page path")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src"
+)
+
+// Summary adds up the state of all pairs, for dashboards that would
+// otherwise fetch every pair
+type Summary struct {
+	Pairs   int `json:"pairs"`
+	Healthy int `json:"healthy"`
+	Failing int `json:"failing"`
+	Paused  int `json:"paused"`
+	Locked  int `json:"locked"`
+
+	// Disabled pairs count as neither healthy nor failing
+	Disabled int `json:"disabled"`
+
+	// Health is ok, syncing or failed, as shown on the status LED
+	Health string `json:"health"`
+
+	// Running are the IDs of the pairs syncing right now
+	Running []string `json:"running"`
+
+	// Today counts the runs going since local midnight
+	Today DaySummary `json:"today"`
+
+	// LastSync is the most recent successful sync of any pair
+	LastSync time.Time `json:"last_sync"`
+}
+
+// DaySummary counts a day's runs across all pairs
+type DaySummary struct {
+	Runs             int   `json:"runs"`
+	Failed           int   `json:"failed"`
+	BytesTransferred int64 `json:"bytes_transferred"`
+}
+
+// buildSummary adds up the statuses of all pairs and the day's runs
+func buildSummary(statuses []map[string]interface{}, runs []dirsync.RunRecord) Summary {
+	summary := Summary{Pairs: len(statuses), Health: aggregateHealth(statuses), Running: []string{}}
+	for _, status := range statuses {
+		switch {
+		case status["enabled"] == false:
+			summary.Disabled++
+		case status["last_error"] != "":
+			summary.Failing++
+		default:
+			summary.Healthy++
+		}
+		if status["paused"] == true {
+			summary.Paused++
+		}
+		if status["locked_by"] != "" {
+			summary.Locked++
+		}
+		if status["is_syncing"] == true {
+			summary.Running = append(summary.Running, status["id"].(string))
+		}
+		if last := status["last_sync"].(time.Time); last.After(summary.LastSync) {
+			summary.LastSync = last
+		}
+	}
+	sort.Strings(summary.Running)
+
+	for _, run := range runs {
+		if run.Skipped != "" {
+			continue
+		}
+		summary.Today.Runs++
+		if !run.Success && !run.EndTime.IsZero() {
+			summary.Today.Failed++
+		}
+		summary.Today.BytesTransferred += run.BytesTransferred
+	}
+	return summary
+}
+
+// startOfDay returns local midnight on t's day
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// handleSummary serves totals across all pairs
+func handleSummary(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	summary := buildSummary(syncManager.GetAllStatus(), syncManager.History.RunsBetween(startOfDay(now), now, ""))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		log.Printf("Error encoding summary: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
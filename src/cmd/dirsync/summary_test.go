@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src"
+	"github.com/gkarolyi/dirsync/src/client"
+)
+
+// TestBuildSummary tests adding up pairs and the day's runs
+func TestBuildSummary(t *testing.T) {
+	now := time.Now()
+	statuses := []map[string]interface{}{
+		{"id": "a:b", "last_error": "", "paused": false, "locked_by": "", "is_syncing": true, "last_sync": now.Add(-time.Hour)},
+		{"id": "c:d", "last_error": "disk full", "paused": false, "locked_by": "", "is_syncing": false, "last_sync": now.Add(-2 * time.Hour)},
+		{"id": "e:f", "last_error": "", "paused": true, "locked_by": "/e/.dirsync-lock", "is_syncing": false, "last_sync": time.Time{}},
+		{"id": "g:h", "last_error": "nas offline", "paused": false, "locked_by": "", "is_syncing": false, "last_sync": time.Time{}, "enabled": false},
+	}
+
+	ok := dirsync.RunRecord{SyncID: "a:b", Success: true, EndTime: now}
+	ok.BytesTransferred = 100
+	failed := dirsync.RunRecord{SyncID: "c:d", Error: "disk full", EndTime: now}
+	failed.BytesTransferred = 20
+	running := dirsync.RunRecord{SyncID: "a:b"}
+	skipped := dirsync.RunRecord{SyncID: "e:f", Success: true, EndTime: now, Skipped: "maintenance"}
+
+	summary := buildSummary(statuses, []dirsync.RunRecord{ok, failed, running, skipped})
+	if summary.Pairs != 4 || summary.Healthy != 2 || summary.Failing != 1 || summary.Paused != 1 || summary.Locked != 1 || summary.Disabled != 1 {
+		t.Errorf("Unexpected pair counts: %+v", summary)
+	}
+	if summary.Health != healthFailed || len(summary.Running) != 1 || summary.Running[0] != "a:b" {
+		t.Errorf("Unexpected health or running pairs: %+v", summary)
+	}
+	if summary.Today != (DaySummary{Runs: 3, Failed: 1, BytesTransferred: 120}) {
+		t.Errorf("Unexpected day summary: %+v", summary.Today)
+	}
+	if !summary.LastSync.Equal(now.Add(-time.Hour)) {
+		t.Errorf("Expected the most recent sync, got %v", summary.LastSync)
+	}
+}
+
+// TestHandleSummary tests the summary endpoint
+func TestHandleSummary(t *testing.T) {
+	syncManager = dirsync.NewSyncManager(dirsync.Options{})
+	syncManager.AddSync(testSourceDir, t.TempDir(), 60)
+	run := syncManager.History.StartRun("other")
+	syncManager.History.FinishRun(run, nil)
+
+	rr := httptest.NewRecorder()
+	handleSummary(rr, httptest.NewRequest("GET", "/api/summary", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+
+	var summary client.Summary
+	if err := json.NewDecoder(rr.Body).Decode(&summary); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if summary.Pairs != 1 || summary.Healthy != 1 || summary.Today.Runs != 1 || summary.Health != healthOK {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// TLSConfig configures serving the web UI and API over HTTPS
+type TLSConfig struct {
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	SelfSigned   bool   `json:"self_signed"`
+	RedirectPort string `json:"redirect_port"`
+}
+
+// enabled reports whether HTTPS should be used
+func (c *TLSConfig) enabled() bool {
+	return c != nil && (c.SelfSigned || (c.CertFile != "" && c.KeyFile != ""))
+}
+
+// loadCertificate loads the configured certificate, generating a self-signed
+// one if requested and neither cert_file nor key_file exists yet. Generated
+// certificates are saved to cert_file and key_file when those are set, so
+// they survive restarts. Existing files that can't be loaded are an error,
+// and are never replaced.
+func loadCertificate(c *TLSConfig) (tls.Certificate, error) {
+	if c.CertFile != "" && c.KeyFile != "" {
+		if !c.SelfSigned || fileExists(c.CertFile) || fileExists(c.KeyFile) {
+			return tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		}
+	}
+
+	log.Println("Generating self-signed TLS certificate")
+	certPEM, keyPEM, err := generateSelfSigned()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	if c.CertFile != "" && c.KeyFile != "" {
+		if err := os.WriteFile(c.CertFile, certPEM, 0644); err != nil {
+			return tls.Certificate{}, err
+		}
+		if err := os.WriteFile(c.KeyFile, keyPEM, 0600); err != nil {
+			return tls.Certificate{}, err
+		}
+		log.Printf("Saved self-signed certificate to %s", c.CertFile)
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// fileExists reports whether anything is at path, or might be: a path that
+// can't be looked at isn't taken as missing
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return !os.IsNotExist(err)
+}
+
+// generateSelfSigned creates a self-signed certificate for localhost and this host's name
+func generateSelfSigned() (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hosts := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil {
+		hosts = append(hosts, hostname)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"DirSync"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              hosts,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// redirectToHTTPS redirects plain HTTP requests to the HTTPS server on httpsPort
+func redirectToHTTPS(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+
+		target := "https://" + host
+		if httpsPort != ":443" {
+			target += httpsPort
+		}
+		target += r.URL.RequestURI()
+
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
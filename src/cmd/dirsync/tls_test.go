@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLoadCertificateSelfSigned tests generating and reusing a self-signed certificate
+func TestLoadCertificateSelfSigned(t *testing.T) {
+	certDir, err := os.MkdirTemp("", "dirsync_test_tls")
+	if err != nil {
+		t.Fatalf("Failed to create certificate directory: %v", err)
+	}
+	defer os.RemoveAll(certDir)
+
+	tlsConfig := &TLSConfig{
+		CertFile:   filepath.Join(certDir, "cert.pem"),
+		KeyFile:    filepath.Join(certDir, "key.pem"),
+		SelfSigned: true,
+	}
+
+	cert, err := loadCertificate(tlsConfig)
+	if err != nil {
+		t.Fatalf("loadCertificate failed: %v", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("Failed to parse generated certificate: %v", err)
+	}
+
+	if err := leaf.VerifyHostname("localhost"); err != nil {
+		t.Errorf("Generated certificate should be valid for localhost: %v", err)
+	}
+
+	// The generated certificate should be saved and reused
+	if _, err := os.Stat(tlsConfig.CertFile); err != nil {
+		t.Fatalf("Generated certificate was not saved: %v", err)
+	}
+
+	again, err := loadCertificate(tlsConfig)
+	if err != nil {
+		t.Fatalf("loadCertificate failed on second call: %v", err)
+	}
+
+	if string(again.Certificate[0]) != string(cert.Certificate[0]) {
+		t.Errorf("Expected saved certificate to be reused")
+	}
+
+	// Missing files without self-signed generation is an error
+	if _, err := loadCertificate(&TLSConfig{CertFile: "/non/existent/cert.pem", KeyFile: "/non/existent/key.pem"}); err == nil {
+		t.Errorf("Expected error for missing certificate files, got nil")
+	}
+}
+
+// TestLoadCertificateKeepsFiles tests that self_signed never replaces
+// certificate files it can't load
+func TestLoadCertificateKeepsFiles(t *testing.T) {
+	certDir := t.TempDir()
+	tlsConfig := &TLSConfig{
+		CertFile:   filepath.Join(certDir, "cert.pem"),
+		KeyFile:    filepath.Join(certDir, "key.pem"),
+		SelfSigned: true,
+	}
+
+	// A certificate whose key went missing
+	os.WriteFile(tlsConfig.CertFile, []byte("not a certificate"), 0644)
+	if _, err := loadCertificate(tlsConfig); err == nil {
+		t.Errorf("Expected an error for a certificate without its key")
+	}
+
+	// A certificate and key that don't load
+	os.WriteFile(tlsConfig.KeyFile, []byte("not a key"), 0600)
+	if _, err := loadCertificate(tlsConfig); err == nil {
+		t.Errorf("Expected an error for files that don't load")
+	}
+	if data, _ := os.ReadFile(tlsConfig.CertFile); string(data) != "not a certificate" {
+		t.Errorf("Expected the certificate file to be left alone, got %q", data)
+	}
+	if data, _ := os.ReadFile(tlsConfig.KeyFile); string(data) != "not a key" {
+		t.Errorf("Expected the key file to be left alone, got %q", data)
+	}
+}
+
+// TestRedirectToHTTPS tests redirecting plain HTTP requests to HTTPS
+func TestRedirectToHTTPS(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com:8080/status?x=1", nil)
+	rr := httptest.NewRecorder()
+
+	redirectToHTTPS(":8443").ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusMovedPermanently {
+		t.Errorf("Expected status %d, got %d", http.StatusMovedPermanently, rr.Code)
+	}
+
+	expected := "https://example.com:8443/status?x=1"
+	if location := rr.Header().Get("Location"); location != expected {
+		t.Errorf("Expected redirect to %s, got %s", expected, location)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src/client"
+)
+
+// topRecentErrors is how many failed runs the dashboard lists
+const topRecentErrors = 5
+
+var (
+	// rsyncProgressLine matches rsync's progress output, e.g.
+	// "1,234,567  45%   12.34MB/s    0:00:10 (xfr#3, to-chk=12/100)"
+	rsyncProgressLine = regexp.MustCompile(`(\d+)%\s+([\d.]+[kMGT]?B/s)`)
+
+	// rsyncCheckCount matches rsync's count of files left to check
+	rsyncCheckCount = regexp.MustCompile(`(?:to|ir)-chk=(\d+)/(\d+)`)
+)
+
+// runTop shows a live dashboard of a running daemon's pairs in the terminal
+// until interrupted, and returns the exit code
+func runTop(args []string) int {
+	flags := flag.NewFlagSet("top", flag.ContinueOnError)
+	server := flags.String("server", "http://localhost:8080", "address of the running daemon")
+	token := flags.String("token", os.Getenv("DIRSYNC_TOKEN"), "API token, if auth is enabled (default $DIRSYNC_TOKEN)")
+	interval := flags.Duration("interval", time.Second, "how often to refresh")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dirsync top [--server http://host:port] [--token TOKEN] [--interval 1s]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	c := client.New(*server)
+	c.Token = *token
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Draw on the alternate screen with the cursor hidden, and put the
+	// terminal back the way it was on exit
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	width := terminalWidth()
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		statuses, err := c.Status()
+		var runs []client.Run
+		if err == nil {
+			if history, historyErr := c.History(""); historyErr == nil {
+				runs = history.Runs
+			}
+		}
+
+		screen := renderTop(*server, statuses, runs, err, time.Now(), width)
+		fmt.Print("\x1b[H\x1b[2J" + strings.ReplaceAll(screen, "\n", "\r\n"))
+
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-ticker.C:
+		}
+	}
+}
+
+// terminalWidth returns the terminal's width from $COLUMNS, or 100
+func terminalWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 40 {
+		return n
+	}
+	return 100
+}
+
+// pairProgress is what the dashboard can tell about a running pair from its output
+type pairProgress struct {
+	// Fraction of the files checked so far, or -1 if unknown
+	Fraction float64
+	Speed    string
+	File     string
+}
+
+// parseProgress reads the progress of a running sync from the end of its
+// output. rsync reports its speed and how many files are left to check; the
+// built-in copier only lists the files it copies.
+func parseProgress(output string) pairProgress {
+	progress := pairProgress{Fraction: -1}
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+
+	for i := len(lines) - 1; i >= 0 && i >= len(lines)-50; i-- {
+		// rsync redraws its progress with carriage returns; the last one is current
+		parts := strings.Split(lines[i], "\r")
+		line := strings.TrimSpace(parts[len(parts)-1])
+		if line == "" {
+			continue
+		}
+
+		if m := rsyncProgressLine.FindStringSubmatch(line); m != nil {
+			if progress.Speed == "" {
+				progress.Speed = m[2]
+			}
+			if m := rsyncCheckCount.FindStringSubmatch(line); m != nil && progress.Fraction < 0 {
+				left, _ := strconv.Atoi(m[1])
+				total, _ := strconv.Atoi(m[2])
+				if total > 0 {
+					progress.Fraction = float64(total-left) / float64(total)
+				}
+			}
+			continue
+		}
+
+		if progress.File == "" && !strings.HasPrefix(line, "ERROR:") {
+			progress.File = line
+		}
+		if progress.File != "" && progress.Speed != "" && progress.Fraction >= 0 {
+			break
+		}
+	}
+
+	return progress
+}
+
+// progressBar draws a bar of the given width for fraction, or a placeholder if unknown
+func progressBar(fraction float64, width int) string {
+	if fraction < 0 {
+		return "[" + strings.Repeat("?", width) + "]"
+	}
+	filled := int(fraction * float64(width))
+	if filled > width {
+		filled = width
+	}
+	return "[" + strings.Repeat("#", filled) + strings.Repeat("-", width-filled) + "]" +
+		fmt.Sprintf(" %3.0f%%", fraction*100)
+}
+
+// renderTop draws the dashboard as plain text lines
+func renderTop(server string, statuses []client.SyncStatus, runs []client.Run, err error, now time.Time, width int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "dirsync top - %s - %s\n\n", server, now.Format("15:04:05"))
+
+	if err != nil {
+		fmt.Fprintf(&b, "Error querying %s: %v\n", server, err)
+		return b.String()
+	}
+	if len(statuses) == 0 {
+		b.WriteString("No sync pairs configured\n")
+		return b.String()
+	}
+
+	syncing, failed := 0, 0
+	for _, s := range statuses {
+		if s.IsSyncing {
+			syncing++
+		}
+		if s.LastError != "" {
+			failed++
+		}
+	}
+	fmt.Fprintf(&b, "Pairs: %d  Syncing: %d  Failed: %d\n\n", len(statuses), syncing, failed)
+
+	for _, s := range statuses {
+		state := "idle"
+		switch {
+		case s.IsSyncing:
+			state = "SYNCING"
+		case s.Paused:
+			state = "paused"
+		case s.LockedBy != "":
+			state = "locked"
+		case s.LastError != "":
+			state = "FAILED"
+		}
+
+		fmt.Fprintf(&b, "%-8s %s\n", state, truncate(s.SourcePath+" -> "+s.DestinationPath, width-9))
+		fmt.Fprintf(&b, "         last %s, next %s\n", relativeTime(s.LastSync, now), relativeTime(s.NextSyncTime, now))
+
+		if s.IsSyncing {
+			progress := parseProgress(s.Output)
+			speed := progress.Speed
+			if speed == "" {
+				speed = "-"
+			}
+			fmt.Fprintf(&b, "         %s  %s\n", progressBar(progress.Fraction, 30), speed)
+			if progress.File != "" {
+				fmt.Fprintf(&b, "         %s\n", truncate(progress.File, width-9))
+			}
+		}
+		if s.LastError != "" {
+			fmt.Fprintf(&b, "         error: %s\n", truncate(firstLine(s.LastError), width-16))
+		}
+		b.WriteString("\n")
+	}
+
+	// Most recent failures first
+	var failures []client.Run
+	for i := len(runs) - 1; i >= 0 && len(failures) < topRecentErrors; i-- {
+		if !runs[i].Success && !runs[i].EndTime.IsZero() {
+			failures = append(failures, runs[i])
+		}
+	}
+	if len(failures) > 0 {
+		b.WriteString("Recent errors:\n")
+		for _, run := range failures {
+			line := fmt.Sprintf("%s  %s: %s", run.EndTime.Format("Jan 02 15:04"), run.SyncID, firstLine(run.Error))
+			fmt.Fprintf(&b, "  %s\n", truncate(line, width-2))
+		}
+	}
+
+	return b.String()
+}
+
+// truncate shortens s to at most n characters, marking the cut with "..."
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n || n < 4 {
+		return s
+	}
+	return string(runes[:n-3]) + "..."
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src/client"
+)
+
+// TestParseProgress tests reading progress from sync output
+func TestParseProgress(t *testing.T) {
+	output := "sending incremental file list\nphotos/a.jpg\n" +
+		"        32,768   1%    1.20MB/s    0:00:10\r     2,000,000 100%   12.34MB/s    0:00:00 (xfr#1, to-chk=75/100)\n" +
+		"photos/b.jpg\n"
+
+	progress := parseProgress(output)
+	if progress.File != "photos/b.jpg" || progress.Speed != "12.34MB/s" || progress.Fraction != 0.25 {
+		t.Errorf("Unexpected rsync progress: %+v", progress)
+	}
+
+	// The built-in copier only lists files
+	progress = parseProgress("rsync command not found, using built-in file copy\ndocs/report.pdf\n")
+	if progress.File != "docs/report.pdf" || progress.Speed != "" || progress.Fraction != -1 {
+		t.Errorf("Unexpected built-in copier progress: %+v", progress)
+	}
+
+	if bar := progressBar(0.5, 10); bar != "[#####-----]  50%" {
+		t.Errorf("Unexpected progress bar %q", bar)
+	}
+}
+
+// TestRenderTop tests drawing the dashboard
+func TestRenderTop(t *testing.T) {
+	now := time.Now()
+	statuses := []client.SyncStatus{
+		{SourcePath: "/photos", DestinationPath: "/nas", IsSyncing: true, Output: "a.jpg\n"},
+		{SourcePath: "/logs", DestinationPath: "/backup", LastError: "rsync error: exit status 23"},
+	}
+	runs := []client.Run{
+		{SyncID: "/logs:/backup", EndTime: now, Error: "disk full"},
+		{SyncID: "/photos:/nas", EndTime: now, Success: true},
+	}
+
+	screen := renderTop("http://localhost:8080", statuses, runs, nil, now, 80)
+	for _, want := range []string{"Pairs: 2  Syncing: 1  Failed: 1", "SYNCING  /photos -> /nas", "FAILED", "a.jpg", "Recent errors:", "disk full"} {
+		if !strings.Contains(screen, want) {
+			t.Errorf("Expected %q in dashboard, got:\n%s", want, screen)
+		}
+	}
+
+	screen = renderTop("http://localhost:8080", nil, nil, errors.New("connection refused"), now, 80)
+	if !strings.Contains(screen, "connection refused") {
+		t.Errorf("Expected the query error, got:\n%s", screen)
+	}
+}
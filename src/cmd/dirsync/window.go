@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src/client"
+)
+
+// defaultWindow is how far back a window goes when no start is given
+const defaultWindow = 24 * time.Hour
+
+// parseWindowTime parses the start or end of a window: an RFC 3339 time, a
+// local date and time like "2024-05-01 02:00", or a duration before now
+// like "12h". Empty values give def.
+func parseWindowTime(value string, now, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use RFC 3339, \"2006-01-02 15:04\" or a duration like 12h", value)
+}
+
+// parseWindow parses the from and to of a window, which defaults to the
+// last day
+func parseWindow(fromValue, toValue string, now time.Time) (from, to time.Time, err error) {
+	if to, err = parseWindowTime(toValue, now, now); err != nil {
+		return
+	}
+	if from, err = parseWindowTime(fromValue, now, to.Add(-defaultWindow)); err != nil {
+		return
+	}
+	if from.After(to) {
+		err = fmt.Errorf("window starts after it ends")
+	}
+	return
+}
+
+// handleHistoryWindow serves the runs that were going during a window of
+// time, grouped by pair, optionally for one pair
+func handleHistoryWindow(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, to, err := parseWindow(query.Get("from"), query.Get("to"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report := syncManager.History.Window(from, to, query.Get("id"))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("Error encoding history window: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// runHistory prints what ran during a window of time on a running daemon
+// and returns the exit code
+func runHistory(args []string) int {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	server := flags.String("server", "http://localhost:8080", "address of the running daemon")
+	token := flags.String("token", os.Getenv("DIRSYNC_TOKEN"), "API token, if auth is enabled (default $DIRSYNC_TOKEN)")
+	fromFlag := flags.String("from", "", `start of the window: a time, or a duration before now like "12h" (default 24h before --to)`)
+	toFlag := flags.String("to", "", "end of the window (default now)")
+	id := flags.String("id", "", "only show this pair")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dirsync history [--from TIME] [--to TIME] [--id PAIR] [--server http://host:port] [--token TOKEN]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	from, to, err := parseWindow(*fromFlag, *toFlag, time.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	c := client.New(*server)
+	c.Token = *token
+
+	window, err := c.Window(from, to, *id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error querying %s: %v\n", *server, err)
+		return 1
+	}
+
+	printWindow(os.Stdout, window)
+	return 0
+}
+
+// printWindow writes the runs of a window as a table, grouped by pair,
+// followed by the paths each run changed
+func printWindow(w io.Writer, window *client.Window) {
+	fmt.Fprintf(w, "Runs between %s and %s\n", window.From.Local().Format("2006-01-02 15:04"), window.To.Local().Format("2006-01-02 15:04"))
+	if len(window.Pairs) == 0 {
+		fmt.Fprintln(w, "Nothing ran")
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, pair := range window.Pairs {
+		fmt.Fprintf(tw, "\n%s: %d runs, %d failed, %s transferred\n", pair.SyncID, len(pair.Runs), pair.Failed, formatBytes(pair.BytesTransferred))
+		fmt.Fprintln(tw, "  RUN\tSTARTED\tDURATION\tRESULT\tTRANSFERRED\tCHANGES")
+		for _, run := range pair.Runs {
+			duration, result := "-", "running"
+			if !run.EndTime.IsZero() {
+				duration = run.EndTime.Sub(run.StartTime).Round(time.Second).String()
+				result = "ok"
+				if !run.Success {
+					result = "failed: " + firstLine(run.Error)
+				} else if run.Skipped != "" {
+					result = "skipped: " + firstLine(run.Skipped)
+				}
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\t%d\n", run.ID, run.StartTime.Local().Format("2006-01-02 15:04:05"),
+				duration, strings.TrimSpace(result), formatBytes(run.BytesTransferred), run.ChangeCount)
+		}
+		tw.Flush()
+
+		for _, run := range pair.Runs {
+			for _, change := range run.Changes {
+				if len(change.Attributes) > 0 {
+					fmt.Fprintf(w, "  %s %s %s (%s)\n", run.ID, change.Action, change.Path, strings.Join(change.Attributes, ", "))
+					continue
+				}
+				fmt.Fprintf(w, "  %s %s %s\n", run.ID, change.Action, change.Path)
+			}
+			if more := run.ChangeCount - len(run.Changes); more > 0 {
+				fmt.Fprintf(w, "  %s ... and %d more changes\n", run.ID, more)
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gkarolyi/dirsync/src"
+	"github.com/gkarolyi/dirsync/src/client"
+)
+
+// TestParseWindow tests parsing the start and end of a window
+func TestParseWindow(t *testing.T) {
+	now := time.Date(2024, 5, 2, 9, 0, 0, 0, time.Local)
+
+	from, to, err := parseWindow("", "", now)
+	if err != nil || !to.Equal(now) || !from.Equal(now.Add(-24*time.Hour)) {
+		t.Errorf("Expected the last day by default, got %v to %v, %v", from, to, err)
+	}
+
+	from, to, err = parseWindow("2024-05-01 22:00", "2h", now)
+	if err != nil || !from.Equal(time.Date(2024, 5, 1, 22, 0, 0, 0, time.Local)) || !to.Equal(now.Add(-2*time.Hour)) {
+		t.Errorf("Expected 22:00 to 07:00, got %v to %v, %v", from, to, err)
+	}
+
+	if _, _, err := parseWindow("yesterday", "", now); err == nil {
+		t.Errorf("Expected an invalid time to be rejected")
+	}
+	if _, _, err := parseWindow("1h", "2h", now); err == nil {
+		t.Errorf("Expected a window ending before it starts to be rejected")
+	}
+}
+
+// TestHandleHistoryWindow tests querying what ran during a window
+func TestHandleHistoryWindow(t *testing.T) {
+	syncManager = dirsync.NewSyncManager(dirsync.Options{})
+	run := syncManager.History.StartRun("a:b")
+	syncManager.History.FinishRun(run, errors.New("disk full"))
+
+	rr := httptest.NewRecorder()
+	handleHistoryWindow(rr, httptest.NewRequest("GET", "/api/history/window?from=1h", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+
+	var window client.Window
+	if err := json.NewDecoder(rr.Body).Decode(&window); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(window.Pairs) != 1 || window.Pairs[0].Failed != 1 || window.Pairs[0].Runs[0].ID != run.ID {
+		t.Errorf("Expected the failed run, got %+v", window.Pairs)
+	}
+
+	window.Pairs[0].Runs[0].Changes = []client.Change{{Path: "db/corrupt.sqlite", Action: "updated"}}
+	window.Pairs[0].Runs[0].ChangeCount = 3
+
+	var out strings.Builder
+	printWindow(&out, &window)
+	if !strings.Contains(out.String(), "a:b: 1 runs, 1 failed") || !strings.Contains(out.String(), "failed: disk full") ||
+		!strings.Contains(out.String(), "updated db/corrupt.sqlite") || !strings.Contains(out.String(), "and 2 more changes") {
+		t.Errorf("Unexpected window output:\n%s", out.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handleHistoryWindow(rr, httptest.NewRequest("GET", "/api/history/window?from=soon", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid time, got %d", rr.Code)
+	}
+}
+
+// TestHandleHistoryRun tests that run details include the changed paths
+// that the history list leaves out
+func TestHandleHistoryRun(t *testing.T) {
+	syncManager = dirsync.NewSyncManager(dirsync.Options{})
+	s := syncManager.AddSync(testSourceDir, t.TempDir(), 60)
+	if err := s.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	runID := syncManager.History.ListRuns(s.ID)[0].ID
+
+	rr := httptest.NewRecorder()
+	handleHistoryRun(rr, httptest.NewRequest("GET", "/api/history/run?id="+runID, nil))
+	var run client.Run
+	if err := json.NewDecoder(rr.Body).Decode(&run); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(run.Changes) == 0 || run.ChangeCount != len(run.Changes) {
+		t.Errorf("Expected the run's changes, got %+v", run)
+	}
+
+	rr = httptest.NewRecorder()
+	handleHistory(rr, httptest.NewRequest("GET", "/api/history", nil))
+	var history client.History
+	json.NewDecoder(rr.Body).Decode(&history)
+	if len(history.Runs) != 1 || history.Runs[0].Changes != nil || history.Runs[0].ChangeCount == 0 {
+		t.Errorf("Expected the list to count changes without listing them, got %+v", history.Runs)
+	}
+
+	rr = httptest.NewRecorder()
+	handleHistoryRun(rr, httptest.NewRequest("GET", "/api/history/run?id=missing", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown run, got %d", rr.Code)
+	}
+}
+
+// TestHandleRun tests serving a run by the ID in its path, with its output
+func TestHandleRun(t *testing.T) {
+	syncManager = dirsync.NewSyncManager(dirsync.Options{})
+	s := syncManager.AddSync(testSourceDir, t.TempDir(), 60)
+	if err := s.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	runID := syncManager.History.ListRuns(s.ID)[0].ID
+
+	rr := httptest.NewRecorder()
+	handleRun(rr, httptest.NewRequest("GET", "/api/runs/"+runID, nil))
+	var run client.Run
+	if err := json.NewDecoder(rr.Body).Decode(&run); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if run.ID != runID || !run.Success || run.EndTime.IsZero() || !strings.Contains(run.Output, "Starting sync") {
+		t.Errorf("Expected the run with its output, got %+v", run)
+	}
+
+	rr = httptest.NewRecorder()
+	handleHistory(rr, httptest.NewRequest("GET", "/api/history", nil))
+	var history client.History
+	json.NewDecoder(rr.Body).Decode(&history)
+	if len(history.Runs) != 1 || history.Runs[0].Output != "" {
+		t.Errorf("Expected the list to leave out output, got %+v", history.Runs)
+	}
+
+	rr = httptest.NewRecorder()
+	handleRun(rr, httptest.NewRequest("GET", "/api/runs/"+runID+"/failures", nil))
+	var failures client.RunFailures
+	json.NewDecoder(rr.Body).Decode(&failures)
+	if rr.Code != http.StatusOK || failures.RunID != runID || failures.FailureCount != 0 || failures.Failures == nil {
+		t.Errorf("Expected an empty list of failures, got %d %+v", rr.Code, failures)
+	}
+
+	for _, path := range []string{"/api/runs/missing", "/api/runs/", "/api/runs/" + runID + "/extra", "/api/runs/missing/failures", "/api/runs//failures"} {
+		rr = httptest.NewRecorder()
+		handleRun(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for %s, got %d", path, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package dirsync
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// sourceFingerprint summarises the source tree (paths, sizes and modification
+// times) into a short string. It only reads metadata from the source, so it
+// can be used to decide whether a run needs to touch the destination at all.
+// Directories in skip (relative to root, slash-separated) are left out.
+func sourceFingerprint(root string, skip ...string) (string, error) {
+	return treeFingerprint(root, nil, skip)
+}
+
+// treeFingerprint is sourceFingerprint, also leaving out files and
+// directories matching one of ignore (see ignored). With ignore patterns,
+// directory modification times are left out too, as adding or removing an
+// ignored file changes them; the names of the files cover the rest.
+func treeFingerprint(root string, ignore, skip []string) (string, error) {
+	h := fnv.New64a()
+	count := 0
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			for _, dir := range skip {
+				if filepath.ToSlash(relPath) == dir {
+					return filepath.SkipDir
+				}
+			}
+		}
+
+		if relPath != "." && ignored(ignore, relPath) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		modTime := info.ModTime().UnixNano()
+		if d.IsDir() && len(ignore) > 0 {
+			modTime = 0
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00%d\n", relPath, info.Mode(), info.Size(), modTime)
+		count++
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%d-%x", count, h.Sum64()), nil
+}
+
+// ignored reports whether the path rel matches one of patterns, ignoring
+// case. Patterns with a slash, like "build/*", match the path relative to
+// the source; others, like "*.swp", match the name alone.
+func ignored(patterns []string, rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, pattern := range patterns {
+		name := path.Base(rel)
+		if strings.Contains(pattern, "/") {
+			name = rel
+		}
+		if matchesAny([]string{pattern}, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmatchedPatterns returns the patterns, in the form ignored takes, that
+// match no file or directory under root. The walk stops once every pattern
+// has matched something.
+func UnmatchedPatterns(root string, patterns []string) ([]string, error) {
+	unmatched := append([]string(nil), patterns...)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable directories just can't match
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == "." {
+			return nil
+		}
+		for i := 0; i < len(unmatched); i++ {
+			if ignored(unmatched[i:i+1], rel) {
+				unmatched = append(unmatched[:i], unmatched[i+1:]...)
+				i--
+			}
+		}
+		if len(unmatched) == 0 {
+			return fs.SkipAll
+		}
+		return nil
+	})
+	return unmatched, err
+}
+
+// sourceUnchanged reports whether the source looks identical to the last
+// successful run, returning the current fingerprint for bookkeeping
+func (s *Sync) sourceUnchanged() (bool, string) {
+	s.mu.RLock()
+	last := s.lastFingerprint
+	skip := s.excludedDirs
+	s.mu.RUnlock()
+
+	fingerprint, err := sourceFingerprint(s.SourcePath, skip...)
+	if err != nil {
+		// Let the regular sync path report the problem
+		return false, ""
+	}
+
+	return last != "" && last == fingerprint, fingerprint
+}
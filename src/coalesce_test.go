@@ -0,0 +1,75 @@
+package dirsync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestSourceFingerprint tests that the fingerprint only changes when the source does
+func TestSourceFingerprint(t *testing.T) {
+	first, err := sourceFingerprint(testSourceDir)
+	if err != nil {
+		t.Fatalf("sourceFingerprint failed: %v", err)
+	}
+
+	second, err := sourceFingerprint(testSourceDir)
+	if err != nil {
+		t.Fatalf("sourceFingerprint failed: %v", err)
+	}
+
+	if first != second {
+		t.Errorf("Fingerprint changed without source changes: %s != %s", first, second)
+	}
+
+	// Modify a file and check the fingerprint changes
+	testFile := filepath.Join(testSourceDir, "file1.txt")
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(testFile, future, future); err != nil {
+		t.Fatalf("Failed to touch test file: %v", err)
+	}
+
+	third, err := sourceFingerprint(testSourceDir)
+	if err != nil {
+		t.Fatalf("sourceFingerprint failed: %v", err)
+	}
+
+	if third == second {
+		t.Errorf("Fingerprint should change after modifying a file")
+	}
+
+	// Test non-existent source
+	if _, err := sourceFingerprint("/non/existent/path"); err == nil {
+		t.Errorf("Expected error for non-existent source, got nil")
+	}
+}
+
+// TestCoalesceRunsSkipsUnchangedSource tests that an unchanged source doesn't touch the destination
+func TestCoalesceRunsSkipsUnchangedSource(t *testing.T) {
+	destDir := filepath.Join(os.TempDir(), "dirsync_test_coalesce_dest")
+	os.RemoveAll(destDir)
+	defer os.RemoveAll(destDir)
+
+	testSync := NewSync(testSourceDir, destDir, 60)
+	testSync.Pair.CoalesceRuns = true
+
+	fingerprint, err := sourceFingerprint(testSourceDir)
+	if err != nil {
+		t.Fatalf("sourceFingerprint failed: %v", err)
+	}
+	testSync.lastFingerprint = fingerprint
+
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	// The destination should not have been created
+	if _, err := os.Stat(destDir); !os.IsNotExist(err) {
+		t.Errorf("Destination should not be touched when the source is unchanged")
+	}
+
+	if testSync.LastSync.IsZero() {
+		t.Errorf("LastSync should be set after a skipped run, got zero time")
+	}
+}
@@ -0,0 +1,951 @@
+package dirsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errSyncPaused is returned by the fallback copier when the sync is paused mid-run
+var errSyncPaused = errors.New("sync paused")
+
+// fileCopier copies a source tree into a destination without rsync
+type fileCopier struct {
+	ctx        context.Context
+	sync       *Sync
+	source     string
+	dest       string
+	excludes   map[string]bool
+	visited    map[fileID]string
+	bufferSize int
+	bandwidth  *bandwidthLimiter
+	workers    chan struct{}
+	order      string
+	dryRun     bool
+	delete     bool
+
+	// continueOnError skips files that fail for any reason, not only those
+	// that can't be accessed or vanished
+	continueOnError bool
+
+	// caseFold is set when the destination ignores case, and renames holds
+	// the destination names of source entries that differ from another
+	// only in case
+	caseFold    bool
+	renames     map[string]string
+	renameMu    sync.RWMutex
+	protected   map[string]bool
+	transforms  *transformCache
+	manifest    *manifest
+	delta       bool
+	skipHidden  bool
+	oneFS       bool
+	device      uint64
+	dedup       *deduplicator
+	cipher      *fileCipher
+	completed   *completedDirs
+	runID       string
+	resumed     bool
+	filters     []*plugin
+	hooks       []Hook
+	deleted     int64
+	queue       []queuedFile
+	queued      map[string]bool
+	dirs        []queuedDir
+	copied      int64
+	bytesCopied int64
+	bytesTotal  int64
+	verify      bool
+	transferMu  sync.Mutex
+	transferred *transferSample
+	errMu       sync.Mutex
+	err         error
+
+	// targets are the only paths a targeted run syncs, nil for all
+	targets []string
+
+	// initial is set during an incremental pair's initial sync, when the
+	// manifest isn't trusted yet
+	initial bool
+
+	// checksums caches file hashes for pairs that compare files by content,
+	// and hashers are the workers that compare them, taking a copy worker
+	// only for files that need copying
+	checksums *checksumCache
+	hashers   chan struct{}
+
+	// dirTimes holds the modification time of each source directory a
+	// newest-first copy has read, so rescans only read the ones that changed
+	dirTimes map[string]time.Time
+}
+
+// syncWithFileCopy copies new and changed files from source to destination.
+// It is used when rsync isn't available. Like rsync -a it preserves
+// permissions, modification times and symlinks, and with delete it removes
+// what is missing from the source, within the pair's max_delete_percent.
+func (s *Sync) syncWithFileCopy(ctx context.Context, source, dest string) error {
+	c := newFileCopier(s, source, dest)
+	c.ctx = ctx
+
+	for _, name := range s.Pair.Filters {
+		filter, err := s.plugins.get(pluginFilter, name)
+		if err != nil {
+			return err
+		}
+		c.filters = append(c.filters, filter)
+	}
+
+	hooks, err := s.pairHooks()
+	if err != nil {
+		return err
+	}
+	c.hooks = hooks
+
+	if s.Pair.Encryption != nil {
+		key, err := LoadEncryptionKey(s.Pair.Encryption.KeyFile)
+		if err != nil {
+			return err
+		}
+		if c.cipher, err = newFileCipher(key); err != nil {
+			return err
+		}
+
+		// Forget files the transform cache knows by their plain names
+		if c.encryptNames() && c.transforms != nil {
+			c.transforms.forget(func(key string) bool {
+				_, err := c.cipher.decryptName(strings.SplitN(key, "/", 2)[0])
+				return err != nil
+			})
+		}
+	}
+
+	c.caseFold = caseInsensitive(dest, !c.dryRun)
+
+	// Targeted runs only delete what was deleted from the source
+	if c.targets == nil {
+		if err := c.checkDeleteLimit(); err != nil {
+			return err
+		}
+	}
+
+	if c.targets != nil {
+		err = c.copyTargets()
+	} else {
+		err = c.copyDir("")
+	}
+	if err == nil && c.order != "" {
+		err = c.copyQueued()
+	}
+
+	// Files that couldn't be copied fail the run once the rest are copied
+	if err == nil {
+		err = s.partialFailure()
+	}
+
+	// Keep track of transformed files even if the run didn't finish
+	if c.transforms != nil && !c.dryRun {
+		if saveErr := c.transforms.save(); saveErr != nil {
+			log.Printf("[%s] Error saving transform cache: %v", s.ID, saveErr)
+		}
+	}
+
+	if c.dedup != nil && !c.dryRun {
+		if saveErr := c.dedup.save(); saveErr != nil {
+			log.Printf("[%s] Error saving dedup index: %v", s.ID, saveErr)
+		}
+	}
+
+	// Likewise for copied files, only forgetting files missing from the
+	// source after a complete run that didn't skip directories a resumed
+	// run had already copied
+	if c.manifest != nil && !c.dryRun {
+		complete := err == nil && !c.resumed && c.targets == nil
+		if complete {
+			c.manifest.prune()
+			c.manifest.finishInitialSync()
+		}
+		if saveErr := c.manifest.save(); saveErr != nil {
+			log.Printf("[%s] Error saving manifest: %v", s.ID, saveErr)
+		} else if complete && c.initial {
+			s.setSyncPhase(phaseIncremental)
+			s.appendOutput("Initial sync complete, later runs only copy what changed")
+		}
+	}
+
+	// Likewise for the hashes of files compared by content
+	if c.checksums != nil && !c.dryRun {
+		if err == nil && !c.resumed && c.targets == nil {
+			c.checksums.prune()
+		}
+		if saveErr := c.checksums.save(); saveErr != nil {
+			log.Printf("[%s] Error saving checksum cache: %v", s.ID, saveErr)
+		}
+	}
+
+	// Count what was copied even if the run didn't finish
+	s.mu.Lock()
+	s.stats.BytesTransferred = atomic.LoadInt64(&c.bytesCopied)
+	s.stats.TotalBytes = atomic.LoadInt64(&c.bytesTotal)
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.transferred = c.transferred
+	s.mu.Unlock()
+
+	verb := "Copied"
+	if c.dryRun {
+		verb = "Would copy"
+	}
+	s.appendOutput(fmt.Sprintf("%s %d files", verb, atomic.LoadInt64(&c.copied)))
+	if c.delete {
+		verb = "Deleted"
+		if c.dryRun {
+			verb = "Would delete"
+		}
+		s.appendOutput(fmt.Sprintf("%s %d files", verb, c.deleted))
+	}
+	return nil
+}
+
+// newFileCopier creates a copier using the sync's excludes and copy settings
+func newFileCopier(s *Sync, source, dest string) *fileCopier {
+	s.mu.RLock()
+	excludes := make(map[string]bool, len(s.excludedDirs))
+	for _, dir := range s.excludedDirs {
+		excludes[dir] = true
+	}
+	runID := s.runID
+	resumed := s.resumeCompleted
+	targets := s.runTargets
+	s.mu.RUnlock()
+
+	workers := s.Pair.CopyWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	var transforms *transformCache
+	if len(s.Pair.Transforms) > 0 || s.Pair.Encryption != nil {
+		transforms = loadTransformCache(dest)
+	}
+
+	var m *manifest
+	initial := false
+	if s.Pair.Manifest || s.Pair.Incremental {
+		m = loadManifest(dest)
+	}
+	if s.Pair.Incremental {
+		// The destination may have been replaced since the last run
+		phase := m.phase()
+		s.setSyncPhase(phase)
+		initial = phase == phaseInitial
+	}
+
+	var checksums *checksumCache
+	var hashers chan struct{}
+	if s.Pair.Checksum {
+		checksums = loadChecksumCache(dest, s.Pair.hashAlgorithm())
+		hashers = make(chan struct{}, hashWorkers(s.Pair, workers))
+	}
+
+	var dedup *deduplicator
+	if s.Pair.Dedup {
+		dedup = newDeduplicator(s.Pair, dest)
+	}
+
+	bandwidth := newBandwidthLimiter(s.Pair.Bandwidth, func(limit int) {
+		s.appendOutput("Switched to " + describeLimit(limit))
+	})
+
+	return &fileCopier{
+		ctx:             context.Background(),
+		sync:            s,
+		source:          source,
+		dest:            dest,
+		excludes:        excludes,
+		visited:         make(map[fileID]string),
+		bufferSize:      s.Pair.CopyBufferKB * 1024,
+		bandwidth:       bandwidth,
+		workers:         make(chan struct{}, workers),
+		order:           s.Pair.TransferOrder,
+		queued:          make(map[string]bool),
+		dryRun:          s.Pair.DryRun,
+		delete:          s.Pair.Delete,
+		protected:       protectedPaths(s.Pair),
+		transforms:      transforms,
+		manifest:        m,
+		initial:         initial,
+		checksums:       checksums,
+		hashers:         hashers,
+		dirTimes:        make(map[string]time.Time),
+		delta:           s.Pair.DeltaCopy,
+		skipHidden:      s.Pair.SkipHidden,
+		continueOnError: s.Pair.ContinueOnError,
+		oneFS:           s.Pair.OneFilesystem,
+		dedup:           dedup,
+		completed:       newCompletedDirs(resumed),
+		runID:           runID,
+		targets:         targets,
+		resumed:         len(resumed) > 0,
+		verify:          s.Pair.VerifySample > 0 && !s.Pair.DryRun,
+		transferred:     newTransferSample(s.Pair.VerifySample),
+	}
+}
+
+// protectedPaths returns the destination paths that deleting never touches:
+// the partial directory, routes inside the destination and the transform cache
+func protectedPaths(pair PairConfig) map[string]bool {
+	protected := map[string]bool{LockFile: true}
+	if len(pair.Transforms) > 0 || pair.Encryption != nil {
+		protected[transformCacheFile] = true
+	}
+	if pair.Manifest || pair.Incremental {
+		protected[manifestFile] = true
+	}
+	if pair.Report != nil {
+		protected[reportFile] = true
+	}
+	if pair.Checksum {
+		protected[checksumCacheFile] = true
+	}
+	if pair.Dedup {
+		if pair.DedupStore == "" {
+			protected[dedupIndexFile] = true
+		} else if !filepath.IsAbs(pair.DedupStore) {
+			protected[filepath.ToSlash(filepath.Clean(pair.DedupStore))] = true
+		}
+	}
+	if pair.PartialDir != "" && !filepath.IsAbs(pair.PartialDir) {
+		protected[filepath.ToSlash(filepath.Clean(pair.PartialDir))] = true
+	}
+	for _, route := range pair.Routes {
+		if route.Destination != "" && !filepath.IsAbs(route.Destination) {
+			protected[filepath.ToSlash(filepath.Clean(route.Destination))] = true
+		}
+	}
+	return protected
+}
+
+// otherFilesystem reports whether a directory found in the source is on a
+// different filesystem than the source itself
+func (c *fileCopier) otherFilesystem(d os.DirEntry) bool {
+	info, err := d.Info()
+	if err != nil {
+		return false
+	}
+	id, ok := getFileID(info)
+	return ok && id.dev != c.device
+}
+
+// isHidden reports whether a file or directory name is a dotfile
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// encryptNames reports whether file names are encrypted in the destination
+func (c *fileCopier) encryptNames() bool {
+	return c.cipher != nil && c.sync.Pair.Encryption.EncryptNames
+}
+
+// destRel returns the destination's name for the path rel, relative to the
+// source root, which differs from rel when names are encrypted
+func (c *fileCopier) destRel(rel string) (string, error) {
+	rel = c.renamedRel(rel)
+	if !c.encryptNames() {
+		return rel, nil
+	}
+	return c.cipher.encryptPath(rel)
+}
+
+// destKey returns the key of the file at rel in the caches kept in the
+// destination. With encrypted names that is its encrypted name, so the
+// caches don't reveal what the files are called.
+func (c *fileCopier) destKey(rel, destPath string) string {
+	if !c.encryptNames() {
+		return filepath.ToSlash(rel)
+	}
+	destRel, err := filepath.Rel(c.dest, destPath)
+	if err != nil {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(destRel)
+}
+
+// destPath returns where the file at rel (relative to the source root) goes
+// in the destination
+func (c *fileCopier) destPath(rel string) (string, error) {
+	destRel, err := c.destRel(rel)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(c.dest, destRel), nil
+}
+
+// setErr records the first error hit by a copy worker
+func (c *fileCopier) setErr(err error) {
+	c.errMu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.errMu.Unlock()
+}
+
+// failed returns the first error hit by a copy worker, if any
+func (c *fileCopier) failed() error {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	return c.err
+}
+
+// copyDir copies the directory at rel (relative to the source root)
+func (c *fileCopier) copyDir(rel string) error {
+	srcDir := filepath.Join(c.source, rel)
+
+	info, err := os.Stat(srcDir)
+	if err != nil {
+		return err
+	}
+
+	// Detect symlink and bind-mount loops by tracking the directories we've entered
+	mountPoint := false
+	if id, ok := getFileID(info); ok {
+		if first, seen := c.visited[id]; seen {
+			msg := fmt.Sprintf("Loop detected: %s is the same directory as %s, skipping it", displayPath(rel), displayPath(first))
+			log.Printf("[%s] %s", c.sync.ID, msg)
+			c.sync.appendOutput(msg)
+			return nil
+		}
+		c.visited[id] = rel
+
+		if rel == "" {
+			c.device = id.dev
+		}
+		mountPoint = c.oneFS && rel != "" && id.dev != c.device
+	}
+
+	destDir, err := c.destPath(rel)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(destDir); os.IsNotExist(err) && rel != "" {
+		c.sync.recordChange(filepath.ToSlash(rel), ChangeCreated)
+	}
+	if !c.dryRun {
+		if err := os.MkdirAll(destDir, info.Mode().Perm()|0700); err != nil {
+			return err
+		}
+	}
+
+	// Other filesystems mounted in the source are created empty, as rsync
+	// does with --one-file-system
+	if mountPoint {
+		c.sync.appendOutput("Not descending into mount point: " + filepath.ToSlash(rel))
+		if !c.dryRun {
+			os.Chmod(destDir, info.Mode().Perm())
+			os.Chtimes(destDir, info.ModTime(), info.ModTime())
+		}
+		return nil
+	}
+
+	entries, err := os.ReadDir(srcDir)
+	if err != nil {
+		return err
+	}
+	collisions, err := c.resolveCaseCollisions(rel, entries)
+	if err != nil {
+		return err
+	}
+
+	// Files are copied by a pool of workers, directories are walked in order
+	var wg sync.WaitGroup
+	var loopErr error
+	slots := c.fileSlots()
+
+	for _, entry := range entries {
+		if c.sync.isPaused() {
+			loopErr = errSyncPaused
+			break
+		}
+		if loopErr = c.ctx.Err(); loopErr != nil {
+			break
+		}
+		if c.failed() != nil {
+			break
+		}
+
+		entryRel := filepath.Join(rel, entry.Name())
+		if (c.skipHidden && isHidden(entry.Name())) || collisions[entry.Name()] {
+			continue
+		}
+
+		switch {
+		case entry.IsDir():
+			if c.excludes[filepath.ToSlash(entryRel)] {
+				continue
+			}
+			if c.resumed && c.completed.done(entryRel) {
+				// Copied by the interrupted run this one resumes
+				continue
+			}
+			loopErr = c.skipFailed(entryRel, c.copyDir(entryRel))
+		case entry.Type()&os.ModeSymlink != 0:
+			loopErr = c.skipFailed(entryRel, c.copySymlink(entryRel))
+		case entry.Type().IsRegular() && c.order != "":
+			// Queue the file so it can be copied in the configured order
+			var fileInfo os.FileInfo
+			fileInfo, loopErr = entry.Info()
+			if loopErr != nil {
+				loopErr = c.skipFailed(entryRel, loopErr)
+			} else {
+				c.queue = append(c.queue, queuedFile{rel: entryRel, size: fileInfo.Size(), modTime: fileInfo.ModTime()})
+				c.queued[entryRel] = true
+			}
+		case entry.Type().IsRegular():
+			slots <- struct{}{}
+			wg.Add(1)
+			go func(rel string) {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+				lowerThreadPriority(c.sync.Pair.Niceness)
+				if err := c.skipFailed(rel, c.copyFileSafely(rel)); err != nil {
+					c.setErr(err)
+				}
+			}(entryRel)
+		}
+
+		if loopErr != nil {
+			break
+		}
+	}
+
+	wg.Wait()
+	if loopErr != nil {
+		return loopErr
+	}
+	if err := c.failed(); err != nil {
+		return err
+	}
+
+	if c.delete {
+		if err := c.deleteExtraneous(rel, destDir, entries); err != nil {
+			return err
+		}
+	}
+
+	if c.order == orderNewestFirst {
+		c.dirTimes[rel] = info.ModTime()
+	}
+
+	// Restore the directory's permissions and modification time last, since
+	// copying its contents changes them
+	if c.dryRun {
+		return nil
+	}
+	if c.order != "" {
+		c.dirs = append(c.dirs, queuedDir{rel: rel, info: info})
+		return nil
+	}
+	os.Chmod(destDir, info.Mode().Perm())
+	os.Chtimes(destDir, info.ModTime(), info.ModTime())
+
+	// Checkpoint the directory so a resumed run can skip it
+	if c.sync.journal != nil && c.runID != "" {
+		c.sync.journal.checkpoint(c.runID, c.completed.complete(rel))
+	}
+	return nil
+}
+
+// copyFile copies a single regular file if it's missing or changed in the destination
+func (c *fileCopier) copyFile(rel string) error {
+	srcPath := filepath.Join(c.source, rel)
+	destRel, err := c.destRel(rel)
+	if err != nil {
+		return err
+	}
+	destPath := filepath.Join(c.dest, destRel)
+
+	// Filter plugins can skip files
+	for _, filter := range c.filters {
+		include, err := filter.include(c.ctx, rel)
+		if err != nil {
+			return err
+		}
+		if !include {
+			return nil
+		}
+	}
+
+	// Files matching a route go to the route's destination instead
+	route, routed := routeFor(c.sync.Pair.Routes, rel)
+	if routed {
+		destPath = filepath.Join(route.root(c.dest), destRel)
+		if !c.dryRun {
+			if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+				return err
+			}
+		}
+	}
+
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&c.bytesTotal, srcInfo.Size())
+
+	// Encrypted files go through the transform path, with or without a transform
+	if transform, ok := transformFor(c.sync.Pair.Transforms, rel); ok || c.cipher != nil {
+		defer c.copySlot()()
+		return c.transformFile(rel, destPath, srcInfo, transform)
+	}
+
+	// Skip files the manifest says were already copied, without touching
+	// the destination, unless the initial sync is still checking them all
+	key := filepath.ToSlash(rel)
+	manifestKey := c.destKey(rel, destPath)
+	if c.manifest != nil && !c.initial && c.manifest.unchanged(manifestKey, destPath, srcInfo) {
+		return nil
+	}
+
+	// Skip files whose size and modification time already match, like
+	// rsync's quick check, or whose content matches with checksum
+	destInfo, statErr := os.Lstat(destPath)
+	if statErr == nil && destInfo.Mode().IsRegular() {
+		same := destInfo.Size() == srcInfo.Size() && destInfo.ModTime().Equal(srcInfo.ModTime())
+		if c.checksums != nil {
+			if same, err = c.sameContent(srcPath, destPath, srcInfo, destInfo); err != nil {
+				return err
+			}
+			if same && !c.dryRun && !destInfo.ModTime().Equal(srcInfo.ModTime()) {
+				c.matchModTime(srcPath, destPath, srcInfo)
+			}
+		}
+		if same {
+			if c.manifest != nil && !c.dryRun {
+				c.manifest.record(manifestKey, destPath, srcInfo, nil)
+			}
+			return nil
+		}
+	}
+	defer c.copySlot()()
+
+	if ok, err := c.fileHooks(rel); !ok {
+		return err
+	}
+
+	// Link to an identical file instead of copying, if there is one
+	var sum string
+	if c.dedup != nil && !c.dryRun {
+		if sum, err = c.checksums.sha256(srcPath, srcInfo); err != nil {
+			return err
+		}
+		if existing, ok := c.dedup.candidate(sum, srcInfo.Size()); ok {
+			// Linking fails across filesystems, and then the file is copied
+			if linked, err := c.dedup.link(existing, destPath); err == nil {
+				if c.manifest != nil {
+					c.manifest.record(manifestKey, destPath, srcInfo, nil)
+				}
+				if linked {
+					atomic.AddInt64(&c.copied, 1)
+					c.sync.recordChange(key, changeAction(statErr == nil))
+					c.sync.appendOutput(filepath.ToSlash(rel) + " (linked to " + existing + ")")
+				}
+				return nil
+			}
+		}
+	}
+
+	deltaNote := ""
+	if !c.dryRun {
+		// Hash the content on its way through for the manifest and the
+		// checksum cache
+		var h, checksum hash.Hash
+		if c.checksums != nil {
+			checksum = newHash(c.checksums.Algorithm)
+		}
+		switch {
+		case c.manifest != nil && checksum != nil:
+			h = teeHash{Hash: sha256.New(), other: checksum}
+		case c.manifest != nil:
+			h = sha256.New()
+		default:
+			h = checksum
+		}
+		if c.useDelta(destPath) {
+			written, err := deltaCopy(srcPath, destPath, srcInfo, deltaBlockSize, h)
+			if err != nil {
+				return err
+			}
+			deltaNote = fmt.Sprintf(" (delta: wrote %d of %d bytes)", written, srcInfo.Size())
+			atomic.AddInt64(&c.bytesCopied, written)
+		} else {
+			if err := copyFileContents(c.ctx, srcPath, destPath, srcInfo, c.bufferSize, c.bandwidth, h); err != nil {
+				return err
+			}
+			atomic.AddInt64(&c.bytesCopied, srcInfo.Size())
+		}
+		if c.manifest != nil {
+			c.manifest.record(manifestKey, destPath, srcInfo, h)
+		}
+		if checksum != nil {
+			c.recordChecksums(srcPath, destPath, srcInfo, hex.EncodeToString(checksum.Sum(nil)))
+		}
+		if sum != "" {
+			c.dedup.record(sum, destPath)
+		}
+	}
+
+	// Sample plain copies so some can be read back after the run
+	if c.verify {
+		c.transferMu.Lock()
+		c.transferred.add(transferredFile{source: srcPath, dest: destPath})
+		c.transferMu.Unlock()
+	}
+
+	atomic.AddInt64(&c.copied, 1)
+	c.sync.recordChange(key, changeAction(statErr == nil))
+	if routed {
+		c.sync.appendOutput(filepath.ToSlash(rel) + " => " + route.Destination + deltaNote)
+	} else {
+		c.sync.appendOutput(filepath.ToSlash(rel) + deltaNote)
+	}
+	return nil
+}
+
+// useDelta reports whether an existing destination file is big enough to
+// update with a delta copy rather than copying it again. Hardlinked files,
+// such as deduplicated ones, are always replaced.
+func (c *fileCopier) useDelta(destPath string) bool {
+	if !c.delta {
+		return false
+	}
+	info, err := os.Lstat(destPath)
+
+	// Updating a file in place would change every link to it as well
+	return err == nil && info.Mode().IsRegular() && info.Size() >= deltaMinSize && linkCount(info) == 1
+}
+
+// transformFile copies a file through its transform and the pair's
+// encryption, unless the file hasn't changed since it was last transformed
+// the same way. transform is empty for files that are only encrypted.
+func (c *fileCopier) transformFile(rel, destPath string, srcInfo os.FileInfo, transform TransformConfig) error {
+	key := filepath.ToSlash(rel)
+	transformKey := c.destKey(rel, destPath)
+	cacheKey := transform.cacheKey()
+	if c.cipher != nil {
+		cacheKey += "|" + c.cipher.cacheKey()
+	}
+	if c.transforms.fresh(transformKey, cacheKey, destPath, srcInfo) {
+		return nil
+	}
+
+	if ok, err := c.fileHooks(rel); !ok {
+		return err
+	}
+	_, statErr := os.Lstat(destPath)
+
+	if !c.dryRun {
+		srcPath := filepath.Join(c.source, rel)
+		var run func(io.Reader, io.Writer) error
+		switch {
+		case transform.Plugin != "":
+			plug, err := c.sync.plugins.get(pluginTransform, transform.Plugin)
+			if err != nil {
+				return err
+			}
+			run = pluginTransformer(c.ctx, plug, rel)
+		case transform.Command != "":
+			run = commandTransform(transform.Command, srcPath, destPath)
+		}
+		if c.cipher != nil {
+			run = c.cipher.encrypting(run)
+		}
+
+		if err := transformFile(srcPath, destPath, srcInfo, run); err != nil {
+			return err
+		}
+		c.transforms.record(transformKey, cacheKey, destPath, srcInfo)
+		atomic.AddInt64(&c.bytesCopied, srcInfo.Size())
+	}
+
+	atomic.AddInt64(&c.copied, 1)
+	c.sync.recordChange(key, changeAction(statErr == nil))
+	switch {
+	case c.cipher != nil && len(transform.Patterns) > 0:
+		c.sync.appendOutput(key + " (transformed, encrypted)")
+	case c.cipher != nil:
+		c.sync.appendOutput(key + " (encrypted)")
+	default:
+		c.sync.appendOutput(key + " (transformed)")
+	}
+	return nil
+}
+
+// copySymlink recreates a symlink in the destination
+func (c *fileCopier) copySymlink(rel string) error {
+	srcPath := filepath.Join(c.source, rel)
+	destPath, err := c.destPath(rel)
+	if err != nil {
+		return err
+	}
+
+	target, err := os.Readlink(srcPath)
+	if err != nil {
+		return err
+	}
+	linkTarget := target
+	if c.encryptNames() {
+		if linkTarget, err = c.cipher.encryptPath(target); err != nil {
+			return err
+		}
+	}
+
+	existing, readErr := os.Readlink(destPath)
+	if readErr == nil && existing == linkTarget {
+		return nil
+	}
+	_, statErr := os.Lstat(destPath)
+
+	if !c.dryRun {
+		os.Remove(destPath)
+		if err := os.Symlink(linkTarget, destPath); err != nil {
+			return err
+		}
+	}
+
+	atomic.AddInt64(&c.copied, 1)
+	c.sync.recordChange(filepath.ToSlash(rel), changeAction(statErr == nil))
+	c.sync.appendOutput(filepath.ToSlash(rel) + " -> " + target)
+	return nil
+}
+
+// deleteExtraneous removes entries from destDir, the destination of the
+// directory at rel, that no longer exist in the source. Excluded and
+// protected paths are kept.
+func (c *fileCopier) deleteExtraneous(rel, destDir string, entries []os.DirEntry) error {
+	destEntries, err := os.ReadDir(destDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	inSource := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		destRel, err := c.destRel(filepath.Join(rel, entry.Name()))
+		if err != nil {
+			return err
+		}
+		inSource[c.nameKey(filepath.Base(destRel))] = true
+	}
+
+	for _, entry := range destEntries {
+		entryRel := filepath.Join(rel, entry.Name())
+		slashRel := filepath.ToSlash(entryRel)
+		if inSource[c.nameKey(entry.Name())] || c.excludes[slashRel] || c.protected[slashRel] || (c.skipHidden && isHidden(entry.Name())) {
+			continue
+		}
+
+		if !c.dryRun {
+			if err := os.RemoveAll(filepath.Join(destDir, entry.Name())); err != nil {
+				return err
+			}
+		}
+
+		c.deleted++
+		c.sync.recordChange(slashRel, ChangeDeleted)
+		c.sync.appendOutput("deleting " + slashRel)
+	}
+
+	return nil
+}
+
+// copyFileContents copies src to dest through a temporary file, so an
+// interrupted copy never leaves a truncated file in place. With a bufferSize
+// of 0 the copy is left to the OS (which may avoid user-space copies
+// entirely); otherwise a buffer of that size is used. With a limiter, reads
+// are paced to its bandwidth limit. If h is set, the content is also
+// written to it.
+func copyFileContents(ctx context.Context, src, dest string, info os.FileInfo, bufferSize int, limiter *bandwidthLimiter, h hash.Hash) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var in io.Reader = f
+	if limiter != nil {
+		in = throttledReader{ctx: ctx, r: f, limiter: limiter}
+	}
+	if h != nil {
+		in = io.TeeReader(in, h)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if bufferSize > 0 {
+		// Hide ReadFrom/WriteTo so the buffer is actually used
+		_, err = io.CopyBuffer(struct{ io.Writer }{tmp}, struct{ io.Reader }{in}, make([]byte, bufferSize))
+	} else {
+		_, err = io.Copy(tmp, in)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	os.Chmod(tmpPath, info.Mode().Perm())
+	os.Chtimes(tmpPath, info.ModTime(), info.ModTime())
+
+	if err := os.Rename(tmpPath, dest); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
+
+// CopyFile copies a single file the way the built-in copier does, using a
+// buffer of bufferSize bytes, or letting the OS decide if it's 0
+func CopyFile(src, dest string, bufferSize int) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	return copyFileContents(context.Background(), src, dest, info, bufferSize, nil, nil)
+}
+
+// displayPath formats a path relative to the source root for messages
+func displayPath(rel string) string {
+	if rel == "" {
+		return "/"
+	}
+	return "/" + strings.TrimPrefix(filepath.ToSlash(rel), "/")
+}
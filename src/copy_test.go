@@ -0,0 +1,156 @@
+package dirsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSyncWithFileCopy tests the built-in copier used when rsync isn't available
+func TestSyncWithFileCopy(t *testing.T) {
+	destDir, err := os.MkdirTemp("", "dirsync_test_copy_dest")
+	if err != nil {
+		t.Fatalf("Failed to create destination directory: %v", err)
+	}
+	defer os.RemoveAll(destDir)
+
+	linkPath := filepath.Join(testSourceDir, "link.txt")
+	if err := os.Symlink("file1.txt", linkPath); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	defer os.Remove(linkPath)
+
+	testSync := NewSync(testSourceDir, destDir, 60)
+	if err := testSync.syncWithFileCopy(context.Background(), testSourceDir, destDir); err != nil {
+		t.Fatalf("syncWithFileCopy failed: %v", err)
+	}
+
+	// Verify files were copied with their content and modification time
+	for _, name := range []string{"file1.txt", "file2.txt", "subdir/file3.txt"} {
+		srcInfo, err := os.Stat(filepath.Join(testSourceDir, name))
+		if err != nil {
+			t.Fatalf("Failed to stat source file %s: %v", name, err)
+		}
+
+		destInfo, err := os.Stat(filepath.Join(destDir, name))
+		if err != nil {
+			t.Errorf("File %s was not copied to destination", name)
+			continue
+		}
+
+		if destInfo.Size() != srcInfo.Size() || !destInfo.ModTime().Equal(srcInfo.ModTime()) {
+			t.Errorf("File %s size or modification time not preserved", name)
+		}
+	}
+
+	// Verify the symlink was recreated rather than followed
+	if target, err := os.Readlink(filepath.Join(destDir, "link.txt")); err != nil || target != "file1.txt" {
+		t.Errorf("Expected symlink to file1.txt, got %s (%v)", target, err)
+	}
+
+	// A second run shouldn't copy anything
+	testSync.Output = ""
+	if err := testSync.syncWithFileCopy(context.Background(), testSourceDir, destDir); err != nil {
+		t.Fatalf("syncWithFileCopy failed on second run: %v", err)
+	}
+
+	if !strings.Contains(testSync.Output, "Copied 0 files") {
+		t.Errorf("Expected no files to be copied on second run, output: %s", testSync.Output)
+	}
+}
+
+// TestFileCopyLoopDetection tests that a directory seen before is skipped
+func TestFileCopyLoopDetection(t *testing.T) {
+	destDir, err := os.MkdirTemp("", "dirsync_test_loop_dest")
+	if err != nil {
+		t.Fatalf("Failed to create destination directory: %v", err)
+	}
+	defer os.RemoveAll(destDir)
+
+	info, err := os.Stat(filepath.Join(testSourceDir, "subdir"))
+	if err != nil {
+		t.Fatalf("Failed to stat subdir: %v", err)
+	}
+
+	id, ok := getFileID(info)
+	if !ok {
+		t.Skip("Device and inode numbers not available on this platform")
+	}
+
+	testSync := NewSync(testSourceDir, destDir, 60)
+	c := newFileCopier(testSync, testSourceDir, destDir)
+	c.visited[id] = "elsewhere"
+
+	// Pretend subdir was already entered via another path, as with a bind mount loop
+	if err := c.copyDir(""); err != nil {
+		t.Fatalf("copyDir failed: %v", err)
+	}
+
+	if !strings.Contains(testSync.Output, "Loop detected: /subdir") {
+		t.Errorf("Expected loop diagnostic in output, got: %s", testSync.Output)
+	}
+
+	if _, err := os.Stat(filepath.Join(destDir, "subdir", "file3.txt")); !os.IsNotExist(err) {
+		t.Errorf("Looping subtree should not be copied")
+	}
+
+	if _, err := os.Stat(filepath.Join(destDir, "file1.txt")); err != nil {
+		t.Errorf("Rest of the tree should still be copied: %v", err)
+	}
+}
+
+// TestFileCopyDeleteAndDryRun tests deleting extraneous files and dry runs
+func TestFileCopyDeleteAndDryRun(t *testing.T) {
+	destDir := t.TempDir()
+
+	testSync := NewSync(testSourceDir, destDir, 60)
+	if err := testSync.syncWithFileCopy(context.Background(), testSourceDir, destDir); err != nil {
+		t.Fatalf("syncWithFileCopy failed: %v", err)
+	}
+
+	extra := filepath.Join(destDir, "subdir", "extra.txt")
+	if err := os.WriteFile(extra, []byte("extra"), 0644); err != nil {
+		t.Fatalf("Failed to create extra file: %v", err)
+	}
+	partial := filepath.Join(destDir, ".partial")
+	if err := os.Mkdir(partial, 0755); err != nil {
+		t.Fatalf("Failed to create partial dir: %v", err)
+	}
+
+	// Nothing is deleted by default
+	if err := testSync.syncWithFileCopy(context.Background(), testSourceDir, destDir); err != nil {
+		t.Fatalf("syncWithFileCopy failed: %v", err)
+	}
+	if _, err := os.Stat(extra); err != nil {
+		t.Fatalf("Extra file should be kept without delete: %v", err)
+	}
+
+	// A dry run only reports the deletion
+	testSync.Pair.Delete = true
+	testSync.Pair.DryRun = true
+	testSync.Pair.PartialDir = ".partial"
+	testSync.Output = ""
+	if err := testSync.syncWithFileCopy(context.Background(), testSourceDir, destDir); err != nil {
+		t.Fatalf("syncWithFileCopy failed: %v", err)
+	}
+	if _, err := os.Stat(extra); err != nil {
+		t.Errorf("Dry run should not delete files: %v", err)
+	}
+	if !strings.Contains(testSync.Output, "deleting subdir/extra.txt") || !strings.Contains(testSync.Output, "Would delete 1 files") {
+		t.Errorf("Expected dry run to report the deletion, output: %s", testSync.Output)
+	}
+
+	// A real run deletes the file but keeps the partial directory
+	testSync.Pair.DryRun = false
+	if err := testSync.syncWithFileCopy(context.Background(), testSourceDir, destDir); err != nil {
+		t.Fatalf("syncWithFileCopy failed: %v", err)
+	}
+	if _, err := os.Stat(extra); !os.IsNotExist(err) {
+		t.Errorf("Expected extra file to be deleted")
+	}
+	if _, err := os.Stat(partial); err != nil {
+		t.Errorf("Partial directory should be protected from deletion: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package dirsync
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// bytesPerGB is the gigabyte cloud providers bill by
+	bytesPerGB = 1 << 30
+
+	// costWindow is how far back transfers are counted for the monthly projection
+	costWindow = 30 * 24 * time.Hour
+
+	// minCostSpan keeps a pair with only a few runs from having its first
+	// transfers extrapolated to an absurd month
+	minCostSpan = 24 * time.Hour
+)
+
+var (
+	// rsyncSentLine matches rsync's summary, e.g.
+	// "sent 1,234,567 bytes  received 35 bytes  2,469,204.00 bytes/sec"
+	rsyncSentLine = regexp.MustCompile(`^sent ([\d,.]+) bytes\s+received`)
+
+	// rsyncTotalLine matches e.g. "total size is 12,345,678  speedup is 10.00"
+	rsyncTotalLine = regexp.MustCompile(`^total size is ([\d,.]+)`)
+)
+
+// CostConfig prices a pair's destination, such as a bucket mounted with
+// rclone, so what the pair costs can be estimated from its runs
+type CostConfig struct {
+	// TransferPerGB is the price of uploading a GB to the destination
+	TransferPerGB float64 `json:"transfer_per_gb"`
+
+	// StoragePerGBMonth is the price of storing a GB for a month in the
+	// destination's storage class
+	StoragePerGBMonth float64 `json:"storage_per_gb_month"`
+
+	// StorageClass names the storage class the prices are for, for display
+	StorageClass string `json:"storage_class,omitempty"`
+
+	// Currency the prices are in, for display (default USD)
+	Currency string `json:"currency,omitempty"`
+}
+
+// CostEstimate is what a pair has cost over the last 30 days and is
+// projected to cost per month
+type CostEstimate struct {
+	SyncID       string `json:"sync_id"`
+	StorageClass string `json:"storage_class,omitempty"`
+	Currency     string `json:"currency"`
+
+	// StoredBytes is the size of the destination after the last run that reported it
+	StoredBytes int64 `json:"stored_bytes"`
+
+	// TransferredBytes were copied by the runs of the last 30 days
+	TransferredBytes int64   `json:"transferred_bytes"`
+	TransferCost     float64 `json:"transfer_cost"`
+
+	// Days of runs the projection is based on, up to 30
+	Days float64 `json:"days"`
+
+	MonthlyTransferCost float64 `json:"monthly_transfer_cost"`
+	MonthlyStorageCost  float64 `json:"monthly_storage_cost"`
+	MonthlyTotal        float64 `json:"monthly_total"`
+}
+
+// EstimateCost works out a pair's costs from its runs, oldest first. Until
+// there are 30 days of runs, transfers are extrapolated from the runs so far,
+// which overstates the month while a new destination is being filled.
+func EstimateCost(syncID string, cost CostConfig, runs []RunRecord, now time.Time) CostEstimate {
+	estimate := CostEstimate{
+		SyncID:       syncID,
+		StorageClass: cost.StorageClass,
+		Currency:     cost.Currency,
+	}
+	if estimate.Currency == "" {
+		estimate.Currency = "USD"
+	}
+
+	since := now.Add(-costWindow)
+	first := now
+	for _, run := range runs {
+		if run.SyncID != syncID || run.EndTime.IsZero() {
+			continue
+		}
+		if run.TotalBytes > 0 {
+			estimate.StoredBytes = run.TotalBytes
+		}
+		if run.StartTime.Before(since) {
+			continue
+		}
+		if run.StartTime.Before(first) {
+			first = run.StartTime
+		}
+		estimate.TransferredBytes += run.BytesTransferred
+	}
+
+	span := now.Sub(first)
+	if span < minCostSpan {
+		span = minCostSpan
+	}
+	estimate.Days = span.Hours() / 24
+
+	gbTransferred := float64(estimate.TransferredBytes) / bytesPerGB
+	estimate.TransferCost = gbTransferred * cost.TransferPerGB
+	estimate.MonthlyTransferCost = estimate.TransferCost * float64(costWindow) / float64(span)
+	estimate.MonthlyStorageCost = float64(estimate.StoredBytes) / bytesPerGB * cost.StoragePerGBMonth
+	estimate.MonthlyTotal = estimate.MonthlyTransferCost + estimate.MonthlyStorageCost
+
+	return estimate
+}
+
+// parseRsyncTotals picks the bytes sent and the total size out of rsync's
+// summary lines
+func parseRsyncTotals(line string, stats *TransferStats) {
+	if m := rsyncSentLine.FindStringSubmatch(line); m != nil {
+		stats.BytesTransferred = parseRsyncNumber(m[1])
+	} else if m := rsyncTotalLine.FindStringSubmatch(line); m != nil {
+		stats.TotalBytes = parseRsyncNumber(m[1])
+	}
+}
+
+// parseRsyncNumber parses a byte count with rsync's digit grouping
+func parseRsyncNumber(s string) int64 {
+	n, _ := strconv.ParseInt(strings.NewReplacer(",", "", ".", "").Replace(s), 10, 64)
+	return n
+}
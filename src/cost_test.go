@@ -0,0 +1,95 @@
+package dirsync
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestEstimateCost tests projecting a month of costs from a pair's runs
+func TestEstimateCost(t *testing.T) {
+	now := time.Now()
+	run := func(id string, age time.Duration, transferred, total int64) RunRecord {
+		start := now.Add(-age)
+		return RunRecord{SyncID: id, StartTime: start, EndTime: start.Add(time.Minute), Success: true,
+			TransferStats: TransferStats{BytesTransferred: transferred, TotalBytes: total}}
+	}
+
+	runs := []RunRecord{
+		run("a", 40*24*time.Hour, 100*bytesPerGB, 100*bytesPerGB), // before the window
+		run("a", 15*24*time.Hour, 2*bytesPerGB, 101*bytesPerGB),
+		run("b", 10*24*time.Hour, 50*bytesPerGB, 50*bytesPerGB),
+		run("a", 5*24*time.Hour, 3*bytesPerGB, 103*bytesPerGB),
+	}
+
+	estimate := EstimateCost("a", CostConfig{TransferPerGB: 0.09, StoragePerGBMonth: 0.004}, runs, now)
+	if estimate.TransferredBytes != 5*bytesPerGB {
+		t.Errorf("Expected 5 GB transferred in the last 30 days, got %d", estimate.TransferredBytes)
+	}
+	if estimate.StoredBytes != 103*bytesPerGB {
+		t.Errorf("Expected the latest total size to be stored, got %d", estimate.StoredBytes)
+	}
+	if estimate.Currency != "USD" {
+		t.Errorf("Expected USD by default, got %q", estimate.Currency)
+	}
+
+	// 5 GB over 15 days is 10 GB a month
+	checks := map[string][2]float64{
+		"transfer cost":         {estimate.TransferCost, 0.45},
+		"monthly transfer cost": {estimate.MonthlyTransferCost, 0.9},
+		"monthly storage cost":  {estimate.MonthlyStorageCost, 0.412},
+		"monthly total":         {estimate.MonthlyTotal, 1.312},
+	}
+	for name, check := range checks {
+		if math.Abs(check[0]-check[1]) > 1e-9 {
+			t.Errorf("Expected %s %v, got %v", name, check[1], check[0])
+		}
+	}
+}
+
+// TestParseRsyncTotals tests reading transfer sizes from rsync's summary
+func TestParseRsyncTotals(t *testing.T) {
+	var stats TransferStats
+	parseRsyncTotals("sent 1,234,567 bytes  received 35 bytes  2,469,204.00 bytes/sec", &stats)
+	parseRsyncTotals("total size is 12,345,678  speedup is 10.00", &stats)
+	parseRsyncTotals("file1.txt", &stats)
+
+	if stats.BytesTransferred != 1234567 || stats.TotalBytes != 12345678 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+// TestSyncRecordsTransferStats tests that runs record what they copied
+func TestSyncRecordsTransferStats(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	os.WriteFile(filepath.Join(sourceDir, "a.txt"), []byte("12345"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "b.txt"), []byte("1234567890"), 0644)
+
+	history, _ := NewHistory("", 0)
+	testSync := NewSync(sourceDir, destDir, 60)
+	testSync.history = history
+
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	// Only the changed file is transferred the second time
+	os.WriteFile(filepath.Join(sourceDir, "a.txt"), []byte("1234"), 0644)
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	runs := history.ListRuns(testSync.ID)
+	if len(runs) != 2 {
+		t.Fatalf("Expected 2 runs, got %d", len(runs))
+	}
+	if got := runs[0].TransferStats; got.BytesTransferred != 15 || got.TotalBytes != 15 {
+		t.Errorf("Expected 15 of 15 bytes transferred by the first run, got %+v", got)
+	}
+	if got := runs[1].TransferStats; got.BytesTransferred != 4 || got.TotalBytes != 14 {
+		t.Errorf("Expected 4 of 14 bytes transferred by the second run, got %+v", got)
+	}
+}
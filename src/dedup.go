@@ -0,0 +1,176 @@
+package dirsync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// dedupIndexFile records the content of copied files for deduplicating
+// within the destination, in the root of the destination
+const dedupIndexFile = ".dirsync-dedup.json"
+
+// dedupEntry is a destination file known to hold some content
+type dedupEntry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// deduplicator hardlinks destination files to identical files that already
+// exist, either elsewhere in the destination or in a content-addressed store
+type deduplicator struct {
+	// store is the dedup store's directory, or "" to deduplicate within
+	// the destination using the index
+	store string
+
+	Files map[string]dedupEntry `json:"files"`
+	path  string
+	dirty bool
+	mu    sync.Mutex
+}
+
+// newDeduplicator sets up deduplication for a pair, loading the index from
+// the destination unless the pair uses a dedup store
+func newDeduplicator(pair PairConfig, dest string) *deduplicator {
+	if pair.DedupStore != "" {
+		store := pair.DedupStore
+		if !filepath.IsAbs(store) {
+			store = filepath.Join(dest, store)
+		}
+		return &deduplicator{store: store}
+	}
+
+	d := &deduplicator{
+		Files: make(map[string]dedupEntry),
+		path:  filepath.Join(dest, dedupIndexFile),
+	}
+	if data, err := os.ReadFile(d.path); err == nil {
+		json.Unmarshal(data, d)
+		if d.Files == nil {
+			d.Files = make(map[string]dedupEntry)
+		}
+	}
+	return d
+}
+
+// hashContent returns the SHA-256 of a file's content
+func hashContent(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// storePath is where content with the given hash lives in the dedup store
+func (d *deduplicator) storePath(sum string) string {
+	return filepath.Join(d.store, sum[:2], sum)
+}
+
+// candidate returns an existing file with the given content, if there is one
+func (d *deduplicator) candidate(sum string, size int64) (string, bool) {
+	if d.store != "" {
+		path := d.storePath(sum)
+		info, err := os.Lstat(path)
+		return path, err == nil && info.Mode().IsRegular() && info.Size() == size
+	}
+
+	d.mu.Lock()
+	entry, ok := d.Files[sum]
+	d.mu.Unlock()
+	if !ok || entry.Size != size {
+		return "", false
+	}
+
+	// The file may have been changed or removed since it was recorded
+	info, err := os.Lstat(entry.Path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != entry.Size || !info.ModTime().Equal(entry.ModTime) {
+		return "", false
+	}
+	return entry.Path, true
+}
+
+// link replaces dest with a hardlink to existing, in one step so dest is
+// never missing. It returns false if dest already is that file.
+func (d *deduplicator) link(existing, dest string) (bool, error) {
+	existingInfo, err := os.Stat(existing)
+	if err != nil {
+		return false, err
+	}
+	if destInfo, err := os.Lstat(dest); err == nil && os.SameFile(existingInfo, destInfo) {
+		return false, nil
+	}
+
+	tmp := filepath.Join(filepath.Dir(dest), "."+filepath.Base(dest)+".link")
+	os.Remove(tmp)
+	if err := os.Link(existing, tmp); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+	return true, nil
+}
+
+// record remembers that dest holds the content with the given hash, so
+// later copies of it can be linked to dest
+func (d *deduplicator) record(sum, dest string) {
+	if d.store != "" {
+		// Keep the first copy of each content in the store
+		path := d.storePath(sum)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+			os.Link(dest, path)
+		}
+		return
+	}
+
+	info, err := os.Lstat(dest)
+	if err != nil {
+		return
+	}
+
+	d.mu.Lock()
+	d.Files[sum] = dedupEntry{Path: dest, Size: info.Size(), ModTime: info.ModTime()}
+	d.dirty = true
+	d.mu.Unlock()
+}
+
+// save writes the index back to the destination if it changed
+func (d *deduplicator) save() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.store != "" || !d.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	tmp := d.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, d.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	d.dirty = false
+	return nil
+}
@@ -0,0 +1,89 @@
+package dirsync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sameFile reports whether two paths are hardlinks to the same file
+func sameFile(t *testing.T, a, b string) bool {
+	t.Helper()
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", a, err)
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", b, err)
+	}
+	return os.SameFile(aInfo, bInfo)
+}
+
+// TestSyncWithDedup tests hardlinking identical files within the destination
+func TestSyncWithDedup(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	os.MkdirAll(filepath.Join(sourceDir, "2024"), 0755)
+	os.MkdirAll(filepath.Join(sourceDir, "2025"), 0755)
+	os.WriteFile(filepath.Join(sourceDir, "2024", "logo.png"), []byte("same logo"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "2025", "logo.png"), []byte("same logo"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "2025", "notes.txt"), []byte("different"), 0644)
+
+	testSync := NewSync(sourceDir, destDir, 60)
+	testSync.Pair.Dedup = true
+	testSync.Pair.Delete = true
+
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	if !sameFile(t, filepath.Join(destDir, "2024", "logo.png"), filepath.Join(destDir, "2025", "logo.png")) {
+		t.Errorf("Expected identical files to be hardlinked")
+	}
+	if sameFile(t, filepath.Join(destDir, "2024", "logo.png"), filepath.Join(destDir, "2025", "notes.txt")) {
+		t.Errorf("Expected different files to stay separate")
+	}
+	if !strings.Contains(testSync.Output, "(linked to ") {
+		t.Errorf("Expected the link in the output, got %q", testSync.Output)
+	}
+
+	// Already linked files aren't linked again, and the index survives deleting
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if strings.Contains(testSync.Output, "(linked to ") {
+		t.Errorf("Expected nothing to be linked again, got %q", testSync.Output)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, dedupIndexFile)); err != nil {
+		t.Errorf("Expected the dedup index to survive deleting: %v", err)
+	}
+}
+
+// TestSyncWithDedupStore tests hardlinking files to a shared dedup store
+func TestSyncWithDedupStore(t *testing.T) {
+	sourceDir := t.TempDir()
+	backups := t.TempDir()
+	store := filepath.Join(backups, "store")
+	os.WriteFile(filepath.Join(sourceDir, "data.bin"), []byte("backed up every day"), 0644)
+
+	// Two snapshots of the same source share the store
+	for _, day := range []string{"monday", "tuesday"} {
+		testSync := NewSync(sourceDir, filepath.Join(backups, day), 60)
+		testSync.Pair.Dedup = true
+		testSync.Pair.DedupStore = store
+		if err := testSync.SyncDirectories(); err != nil {
+			t.Fatalf("SyncDirectories failed: %v", err)
+		}
+	}
+
+	if !sameFile(t, filepath.Join(backups, "monday", "data.bin"), filepath.Join(backups, "tuesday", "data.bin")) {
+		t.Errorf("Expected both snapshots to link to the stored copy")
+	}
+
+	sum, _ := hashContent(filepath.Join(sourceDir, "data.bin"))
+	if _, err := os.Stat(filepath.Join(store, sum[:2], sum)); err != nil {
+		t.Errorf("Expected the content in the store: %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package dirsync
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ErrDeleteLimit is returned by runs that would delete more of the
+// destination than the pair's max_delete_percent allows
+var ErrDeleteLimit = errors.New("too many files would be deleted")
+
+// checkDeleteLimit fails before anything is changed if deleting the paths
+// missing from the source would remove more than the pair's
+// MaxDeletePercent of the files in the destination, such as when the source
+// is an empty mount point
+func (c *fileCopier) checkDeleteLimit() error {
+	limit := c.sync.Pair.MaxDeletePercent
+	if !c.delete || limit <= 0 {
+		return nil
+	}
+
+	deleted, total, err := c.countDeletions("")
+	if err != nil {
+		return err
+	}
+	if total == 0 {
+		return nil
+	}
+
+	percent := float64(deleted) * 100 / float64(total)
+	if percent > limit {
+		return fmt.Errorf("%w: %d of %d files in the destination (%.1f%%, max_delete_percent is %g)", ErrDeleteLimit, deleted, total, percent, limit)
+	}
+	return nil
+}
+
+// countDeletions counts the files below the directory at rel in the
+// destination, and how many of them deleteExtraneous would remove
+func (c *fileCopier) countDeletions(rel string) (deleted, total int64, err error) {
+	destRel, err := c.destRel(rel)
+	if err != nil {
+		return 0, 0, err
+	}
+	destEntries, err := os.ReadDir(filepath.Join(c.dest, destRel))
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	sources, err := readDir(filepath.Join(c.source, rel))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Map destination names back to the source's, including the names
+	// files that differ only in case are renamed to
+	var renamed map[string]string
+	if c.caseFold && c.sync.Pair.CaseCollisions == caseCollisionsRename {
+		_, renamed = caseCollisions(names)
+	}
+	inSource := make(map[string]string, len(sources))
+	for _, name := range names {
+		destName := name
+		if r, ok := renamed[name]; ok {
+			destName = r
+		}
+		if c.encryptNames() {
+			if destName, err = c.cipher.encryptName(destName); err != nil {
+				return 0, 0, err
+			}
+		}
+		inSource[c.nameKey(destName)] = name
+	}
+
+	for _, entry := range destEntries {
+		slashRel := filepath.ToSlash(filepath.Join(rel, entry.Name()))
+		if c.excludes[slashRel] || c.protected[slashRel] || (c.skipHidden && isHidden(entry.Name())) {
+			continue
+		}
+
+		name, ok := inSource[c.nameKey(entry.Name())]
+		switch {
+		case !ok:
+			files, err := countFiles(filepath.Join(c.dest, destRel, entry.Name()))
+			if err != nil {
+				return 0, 0, err
+			}
+			deleted += files
+			total += files
+		case entry.IsDir() && sources[name].IsDir():
+			d, t, err := c.countDeletions(filepath.Join(rel, name))
+			if err != nil {
+				return 0, 0, err
+			}
+			deleted += d
+			total += t
+		case !entry.IsDir():
+			total++
+		}
+	}
+	return deleted, total, nil
+}
+
+// countFiles counts the files at path, which is a file or a directory
+func countFiles(path string) (int64, error) {
+	var n int64
+	err := filepath.WalkDir(path, func(_ string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
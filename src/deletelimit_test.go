@@ -0,0 +1,48 @@
+package dirsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDeleteLimit tests that runs deleting more of the destination than
+// max_delete_percent allows fail before anything is deleted
+func TestDeleteLimit(t *testing.T) {
+	destDir := t.TempDir()
+	testSync := NewSync(testSourceDir, destDir, 60)
+	if err := testSync.syncWithFileCopy(context.Background(), testSourceDir, destDir); err != nil {
+		t.Fatalf("syncWithFileCopy failed: %v", err)
+	}
+
+	extra := filepath.Join(destDir, "old", "extra.txt")
+	os.MkdirAll(filepath.Dir(extra), 0755)
+	if err := os.WriteFile(extra, []byte("extra"), 0644); err != nil {
+		t.Fatalf("Failed to create extra file: %v", err)
+	}
+
+	testSync.Pair.Delete = true
+	testSync.Pair.MaxDeletePercent = 1
+	err := testSync.syncWithFileCopy(context.Background(), testSourceDir, destDir)
+	if !errors.Is(err, ErrDeleteLimit) {
+		t.Fatalf("Expected the delete limit to stop the run, got %v", err)
+	}
+	if _, err := os.Stat(extra); err != nil {
+		t.Errorf("Expected nothing to be deleted: %v", err)
+	}
+
+	// An empty source, such as an unmounted disk, would delete everything
+	testSync.Pair.MaxDeletePercent = 50
+	if err := testSync.syncWithFileCopy(context.Background(), t.TempDir(), destDir); !errors.Is(err, ErrDeleteLimit) {
+		t.Errorf("Expected an empty source to hit the delete limit, got %v", err)
+	}
+
+	if err := testSync.syncWithFileCopy(context.Background(), testSourceDir, destDir); err != nil {
+		t.Fatalf("Expected deleting one file to stay within the limit: %v", err)
+	}
+	if _, err := os.Stat(extra); !os.IsNotExist(err) {
+		t.Errorf("Expected the extra file to be deleted")
+	}
+}
@@ -0,0 +1,275 @@
+package dirsync
+
+import (
+	"crypto/sha256"
+	"hash"
+	"io"
+	"os"
+)
+
+const (
+	// deltaBlockSize is the size of the blocks destination files are split
+	// into when looking for unchanged data
+	deltaBlockSize = 64 * 1024
+
+	// deltaMinSize is the smallest destination file worth a delta copy;
+	// smaller files are simply copied again
+	deltaMinSize = 4 * deltaBlockSize
+)
+
+// rollingChecksum is rsync's weak checksum of a window of bytes, which can
+// be moved along by one byte without reading the whole window again
+type rollingChecksum struct {
+	a, b uint32
+	n    uint32
+}
+
+// newRollingChecksum computes the checksum of block
+func newRollingChecksum(block []byte) rollingChecksum {
+	var a, b uint32
+	n := uint32(len(block))
+	for i, x := range block {
+		a += uint32(x)
+		b += (n - uint32(i)) * uint32(x)
+	}
+	return rollingChecksum{a: a & 0xffff, b: b & 0xffff, n: n}
+}
+
+// roll moves the window one byte on, dropping out and adding in
+func (r *rollingChecksum) roll(out, in byte) {
+	r.a = (r.a - uint32(out) + uint32(in)) & 0xffff
+	r.b = (r.b - r.n*uint32(out) + r.a) & 0xffff
+}
+
+// sum returns the checksum
+func (r rollingChecksum) sum() uint32 {
+	return r.a | r.b<<16
+}
+
+// blockSignatures describe the blocks of an existing destination file: a
+// weak checksum to find candidate matches quickly and a strong hash to
+// confirm them
+type blockSignatures struct {
+	blockSize int
+	weak      map[uint32][]int
+	strong    [][sha256.Size]byte
+
+	// The last block is shorter if the file isn't a multiple of the block size
+	lastSize int
+}
+
+// readSignatures computes the signatures of every block in r
+func readSignatures(r io.Reader, blockSize int) (*blockSignatures, error) {
+	sigs := &blockSignatures{blockSize: blockSize, weak: make(map[uint32][]int)}
+	block := make([]byte, blockSize)
+
+	for {
+		n, err := io.ReadFull(r, block)
+		if n > 0 {
+			index := len(sigs.strong)
+			sigs.strong = append(sigs.strong, sha256.Sum256(block[:n]))
+			sigs.lastSize = n
+			if n == blockSize {
+				weak := newRollingChecksum(block).sum()
+				sigs.weak[weak] = append(sigs.weak[weak], index)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sigs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// match returns the index of a destination block identical to window
+func (sigs *blockSignatures) match(weak uint32, window []byte) (int, bool) {
+	candidates := sigs.weak[weak]
+	if len(candidates) == 0 {
+		return 0, false
+	}
+
+	strong := sha256.Sum256(window)
+	for _, index := range candidates {
+		if sigs.strong[index] == strong {
+			return index, true
+		}
+	}
+	return 0, false
+}
+
+// deltaWriter updates a destination file in place, skipping blocks that
+// already hold the right data at the right offset
+type deltaWriter struct {
+	file      *os.File
+	blockSize int
+	written   int64
+}
+
+// literal writes data that has no match in the destination at offset
+func (w *deltaWriter) literal(data []byte, offset int64) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if _, err := w.file.WriteAt(data, offset); err != nil {
+		return err
+	}
+	w.written += int64(len(data))
+	return nil
+}
+
+// block writes data that matches destination block index, unless that
+// block is already at offset
+func (w *deltaWriter) block(index int, data []byte, offset int64) error {
+	if int64(index)*int64(w.blockSize) == offset {
+		return nil
+	}
+	return w.literal(data, offset)
+}
+
+// deltaCopy makes dest identical to src by looking for src's data in dest's
+// blocks with a rolling checksum, as rsync does, and writing only what
+// differs. dest is updated in place, so unlike a full copy an interrupted
+// delta copy leaves a partly updated file behind. It returns the number of
+// bytes written. The source is also written to h, if not nil.
+func deltaCopy(src, dest string, info os.FileInfo, blockSize int, h hash.Hash) (int64, error) {
+	out, err := os.OpenFile(dest, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	sigs, err := readSignatures(out, blockSize)
+	if err != nil {
+		return 0, err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	var r io.Reader = in
+	if h != nil {
+		r = io.TeeReader(in, h)
+	}
+
+	w := &deltaWriter{file: out, blockSize: blockSize}
+	if err := sigs.apply(r, w); err != nil {
+		return w.written, err
+	}
+
+	if err := out.Truncate(info.Size()); err != nil {
+		return w.written, err
+	}
+	if err := out.Close(); err != nil {
+		return w.written, err
+	}
+
+	os.Chmod(dest, info.Mode().Perm())
+	os.Chtimes(dest, info.ModTime(), info.ModTime())
+	return w.written, nil
+}
+
+// apply streams src through the rolling checksum, passing unmatched data
+// and matched blocks to w
+func (sigs *blockSignatures) apply(src io.Reader, w *deltaWriter) error {
+	bs := sigs.blockSize
+
+	// data holds source bytes from offset base on: data[lit:start] is
+	// unmatched so far and data[start:start+bs] is the current window
+	data := make([]byte, 0, 4*bs)
+	var base int64
+	start, lit := 0, 0
+	eof := false
+
+	var sum rollingChecksum
+	haveSum := false
+
+	// fill makes sure there are at least n bytes from start on, unless the
+	// source ends first, making room by writing out the pending literal
+	fill := func(n int) error {
+		if len(data)-start >= n || eof {
+			return nil
+		}
+
+		if start > 0 {
+			if err := w.literal(data[lit:start], base+int64(lit)); err != nil {
+				return err
+			}
+			remaining := copy(data, data[start:])
+			data = data[:remaining]
+			base += int64(start)
+			start, lit = 0, 0
+		}
+
+		for len(data)-start < n && !eof {
+			if len(data) == cap(data) {
+				grown := make([]byte, len(data), 2*cap(data))
+				copy(grown, data)
+				data = grown
+			}
+			read, err := src.Read(data[len(data):cap(data)])
+			data = data[:len(data)+read]
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for {
+		if err := fill(bs); err != nil {
+			return err
+		}
+		if len(data)-start < bs {
+			break
+		}
+
+		window := data[start : start+bs]
+		if !haveSum {
+			sum = newRollingChecksum(window)
+			haveSum = true
+		}
+
+		if index, ok := sigs.match(sum.sum(), window); ok {
+			if err := w.literal(data[lit:start], base+int64(lit)); err != nil {
+				return err
+			}
+			if err := w.block(index, window, base+int64(start)); err != nil {
+				return err
+			}
+			start += bs
+			lit = start
+			haveSum = false
+			continue
+		}
+
+		// Move the window on by a byte
+		if err := fill(bs + 1); err != nil {
+			return err
+		}
+		if len(data)-start < bs+1 {
+			break
+		}
+		sum.roll(data[start], data[start+bs])
+		start++
+	}
+
+	// The end of the source may match the destination's short last block
+	tail := data[start:]
+	last := len(sigs.strong) - 1
+	if last >= 0 && len(tail) > 0 && len(tail) == sigs.lastSize && sigs.lastSize < bs &&
+		sha256.Sum256(tail) == sigs.strong[last] {
+		if err := w.literal(data[lit:start], base+int64(lit)); err != nil {
+			return err
+		}
+		return w.block(last, tail, base+int64(start))
+	}
+
+	return w.literal(data[lit:], base+int64(lit))
+}
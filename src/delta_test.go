@@ -0,0 +1,113 @@
+package dirsync
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRollingChecksum tests that rolling the checksum matches computing it afresh
+func TestRollingChecksum(t *testing.T) {
+	data := make([]byte, 300)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	const window = 64
+	sum := newRollingChecksum(data[:window])
+	for i := 1; i+window <= len(data); i++ {
+		sum.roll(data[i-1], data[i+window-1])
+		if want := newRollingChecksum(data[i : i+window]).sum(); sum.sum() != want {
+			t.Fatalf("Rolled checksum at %d is %08x, expected %08x", i, sum.sum(), want)
+		}
+	}
+}
+
+// TestDeltaCopy tests updating a destination file with only the changed data
+func TestDeltaCopy(t *testing.T) {
+	const blockSize = 1024
+	random := rand.New(rand.NewSource(2))
+	original := make([]byte, 20*blockSize+100)
+	random.Read(original)
+
+	changed := func(edit func([]byte) []byte) []byte {
+		return edit(append([]byte(nil), original...))
+	}
+
+	tests := []struct {
+		name       string
+		src        []byte
+		maxWritten int64
+	}{
+		{"unchanged", original, 0},
+		{"one block changed", changed(func(b []byte) []byte {
+			copy(b[5*blockSize+10:], "changed")
+			return b
+		}), blockSize},
+		{"bytes inserted", changed(func(b []byte) []byte {
+			return append(b[:3*blockSize+7], append([]byte("inserted"), b[3*blockSize+7:]...)...)
+		}), int64(len(original))},
+		{"truncated", original[:10*blockSize+5], 5},
+		{"appended", append(append([]byte(nil), original...), "more data"...), blockSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src")
+			dest := filepath.Join(dir, "dest")
+			os.WriteFile(src, tt.src, 0644)
+			os.WriteFile(dest, original, 0644)
+
+			info, _ := os.Stat(src)
+			written, err := deltaCopy(src, dest, info, blockSize, nil)
+			if err != nil {
+				t.Fatalf("deltaCopy failed: %v", err)
+			}
+
+			got, _ := os.ReadFile(dest)
+			if !bytes.Equal(got, tt.src) {
+				t.Fatalf("Destination differs from source after delta copy")
+			}
+			if written > tt.maxWritten {
+				t.Errorf("Expected at most %d bytes written, wrote %d", tt.maxWritten, written)
+			}
+		})
+	}
+}
+
+// TestSyncWithDeltaCopy tests that the built-in copier updates big files with a delta copy
+func TestSyncWithDeltaCopy(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+
+	data := make([]byte, deltaMinSize+deltaBlockSize/2)
+	rand.New(rand.NewSource(3)).Read(data)
+	srcPath := filepath.Join(sourceDir, "disk.img")
+	os.WriteFile(srcPath, data, 0644)
+
+	testSync := NewSync(sourceDir, destDir, 60)
+	testSync.Pair.DeltaCopy = true
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	copy(data[deltaBlockSize+100:], "changed")
+	os.WriteFile(srcPath, data, 0644)
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(srcPath, later, later)
+
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	got, _ := os.ReadFile(filepath.Join(destDir, "disk.img"))
+	if !bytes.Equal(got, data) {
+		t.Fatalf("Destination differs from source after delta copy")
+	}
+	if want := "disk.img (delta: wrote 65536 of"; !strings.Contains(testSync.Output, want) {
+		t.Errorf("Expected output to contain %q, got %q", want, testSync.Output)
+	}
+}
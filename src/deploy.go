@@ -0,0 +1,252 @@
+package dirsync
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DeployConfig turns a pair into a deployment: each run syncs into a new
+// release directory under the destination, and a symlink is switched to it
+// once the run succeeds, so whatever serves the destination never sees a
+// half-copied site
+type DeployConfig struct {
+	// Keep is how many releases are kept, including the current one
+	Keep int `json:"keep"`
+
+	// Link is the name of the symlink in the destination pointing at the
+	// current release
+	Link string `json:"link"`
+}
+
+const (
+	// releasesDir holds the releases of a deployed pair, inside its destination
+	releasesDir = "releases"
+
+	// releaseTimeFormat names releases so they sort by when they were made
+	releaseTimeFormat = "20060102-150405.000"
+
+	defaultDeployKeep = 5
+	defaultDeployLink = "current"
+)
+
+// Validate checks that the link is a plain name next to the releases
+func (c *DeployConfig) Validate() error {
+	link := c.linkName()
+	switch {
+	case c.Keep < 0:
+		return fmt.Errorf("deploy keep %d is negative", c.Keep)
+	case strings.ContainsAny(link, `/\`) || link == "." || link == "..":
+		return fmt.Errorf("deploy link %q must be a name, not a path", link)
+	case link == releasesDir:
+		return fmt.Errorf("deploy link can't be named %q", releasesDir)
+	}
+	return nil
+}
+
+// linkName returns the name of the current release's symlink
+func (c *DeployConfig) linkName() string {
+	if c.Link == "" {
+		return defaultDeployLink
+	}
+	return c.Link
+}
+
+// keep returns how many releases are kept
+func (c *DeployConfig) keep() int {
+	if c.Keep <= 0 {
+		return defaultDeployKeep
+	}
+	return c.Keep
+}
+
+// currentRelease returns the path of the release the link in dest points
+// to, or "" if nothing was deployed yet
+func currentRelease(dest string, deploy *DeployConfig) string {
+	target, err := os.Readlink(filepath.Join(dest, deploy.linkName()))
+	if err != nil {
+		return ""
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(dest, target)
+	}
+	return target
+}
+
+// runDestination returns the directory the run in progress writes to: the
+// pair's destination, or the release being deployed
+func (s *Sync) runDestination() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.target != "" {
+		return s.target
+	}
+	return s.DestinationPath
+}
+
+// startRelease creates a new release directory for the run and makes it
+// where the run writes to. Dry runs compare against the current release
+// instead, so they show what the next deployment would change.
+func (s *Sync) startRelease() (string, error) {
+	if s.Pair.DryRun {
+		s.mu.Lock()
+		s.target = currentRelease(s.DestinationPath, s.Pair.Deploy)
+		s.mu.Unlock()
+		return "", nil
+	}
+
+	dir := filepath.Join(s.DestinationPath, releasesDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	// Runs within the same millisecond get a numbered release, which still
+	// sorts after the first
+	name := time.Now().UTC().Format(releaseTimeFormat)
+	release := filepath.Join(dir, name)
+	for i := 2; ; i++ {
+		err := os.Mkdir(release, 0755)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+		release = filepath.Join(dir, fmt.Sprintf("%s-%d", name, i))
+	}
+
+	s.mu.Lock()
+	s.target = release
+	s.mu.Unlock()
+	s.appendOutput("Deploying to release " + filepath.Base(release))
+	return release, nil
+}
+
+// finishRelease switches the link to the release if the run succeeded and
+// removes releases beyond the pair's limit. The release is removed instead
+// if the run failed or was paused, leaving the current release in place.
+func (s *Sync) finishRelease(release string, runErr error) error {
+	s.mu.Lock()
+	s.target = ""
+	paused := s.Paused
+	s.mu.Unlock()
+
+	if release == "" {
+		return nil
+	}
+	if runErr != nil || paused {
+		if err := os.RemoveAll(release); err != nil {
+			log.Printf("[%s] Error removing unfinished release %s: %v", s.ID, release, err)
+		}
+		return nil
+	}
+
+	if err := switchLink(s.DestinationPath, s.Pair.Deploy.linkName(), release); err != nil {
+		return fmt.Errorf("failed to switch to release %s: %w", filepath.Base(release), err)
+	}
+	s.appendOutput(fmt.Sprintf("Switched %s to release %s", s.Pair.Deploy.linkName(), filepath.Base(release)))
+
+	removed, err := pruneReleases(filepath.Join(s.DestinationPath, releasesDir), s.Pair.Deploy.keep(), release)
+	if err != nil {
+		log.Printf("[%s] Error removing old releases: %v", s.ID, err)
+	}
+	if removed > 0 {
+		s.appendOutput(fmt.Sprintf("Removed %d old releases", removed))
+	}
+	return nil
+}
+
+// switchLink points the link named name in dest at release. The new link
+// is created next to the old one and renamed over it, so the switch is
+// atomic and the link never goes missing.
+func switchLink(dest, name, release string) error {
+	target, err := filepath.Rel(dest, release)
+	if err != nil {
+		return err
+	}
+
+	link := filepath.Join(dest, name)
+	if info, err := os.Lstat(link); err == nil && info.Mode()&os.ModeSymlink == 0 {
+		return fmt.Errorf("%s exists and is not a symlink", link)
+	}
+
+	tmp := link + ".dirsync-tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// parseRelease splits a release's name into the time it was made and its
+// number, 1 for the first release made at that time. Other names aren't
+// releases, so anything else kept in the releases directory is left alone.
+func parseRelease(name string) (time.Time, int, bool) {
+	stamp, number := name, 1
+	if len(name) > len(releaseTimeFormat) {
+		stamp = name[:len(releaseTimeFormat)]
+		suffix, ok := strings.CutPrefix(name[len(releaseTimeFormat):], "-")
+		n, err := strconv.Atoi(suffix)
+		if !ok || err != nil || n < 2 || strconv.Itoa(n) != suffix {
+			return time.Time{}, 0, false
+		}
+		number = n
+	}
+	made, err := time.Parse(releaseTimeFormat, stamp)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	return made, number, true
+}
+
+// pruneReleases removes all but the newest keep releases in dir, never
+// removing current, and returns how many were removed
+func pruneReleases(dir string, keep int, current string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	type release struct {
+		name   string
+		made   time.Time
+		number int
+	}
+	var releases []release
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if made, number, ok := parseRelease(entry.Name()); ok {
+			releases = append(releases, release{entry.Name(), made, number})
+		}
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		if !releases[i].made.Equal(releases[j].made) {
+			return releases[i].made.After(releases[j].made)
+		}
+		return releases[i].number > releases[j].number
+	})
+
+	removed := 0
+	for i, release := range releases {
+		path := filepath.Join(dir, release.name)
+		if i < keep || path == current {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,103 @@
+package dirsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDeploy tests that runs sync into new releases, switch the current
+// link and keep only the newest releases
+func TestDeploy(t *testing.T) {
+	destDir := t.TempDir()
+	testSync := NewSync(testSourceDir, destDir, 60)
+	testSync.Pair.Deploy = &DeployConfig{Keep: 2}
+
+	var releases []string
+	for i := 0; i < 3; i++ {
+		if err := testSync.Run(context.Background()); err != nil {
+			t.Fatalf("Run %d failed: %v", i, err)
+		}
+		current := currentRelease(destDir, testSync.Pair.Deploy)
+		if current == "" || (len(releases) > 0 && current == releases[len(releases)-1]) {
+			t.Fatalf("Expected run %d to switch to a new release, got %q", i, current)
+		}
+		releases = append(releases, current)
+	}
+
+	content, err := os.ReadFile(filepath.Join(destDir, "current", "file1.txt"))
+	if err != nil || string(content) != "Test file 1 content" {
+		t.Errorf("Expected the source through the current link, got %q, %v", content, err)
+	}
+	if target, _ := os.Readlink(filepath.Join(destDir, "current")); filepath.IsAbs(target) {
+		t.Errorf("Expected a relative link, got %s", target)
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(destDir, releasesDir))
+	if len(entries) != 2 {
+		t.Errorf("Expected 2 releases to be kept, got %d", len(entries))
+	}
+	if _, err := os.Stat(releases[0]); !os.IsNotExist(err) {
+		t.Errorf("Expected the oldest release to be removed")
+	}
+
+	// A failed run leaves the current release alone
+	release, err := testSync.startRelease()
+	if err != nil {
+		t.Fatalf("startRelease failed: %v", err)
+	}
+	testSync.finishRelease(release, errors.New("copy failed"))
+	if _, err := os.Stat(release); !os.IsNotExist(err) {
+		t.Errorf("Expected the failed release to be removed")
+	}
+	if current := currentRelease(destDir, testSync.Pair.Deploy); current != releases[2] {
+		t.Errorf("Expected the link to stay on %s, got %s", releases[2], current)
+	}
+}
+
+// TestDeployConfig tests checking deploy settings and refusing to replace
+// a directory with the link
+func TestDeployConfig(t *testing.T) {
+	for _, config := range []DeployConfig{{Keep: -1}, {Link: "www/current"}, {Link: releasesDir}} {
+		if err := config.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", config)
+		}
+	}
+
+	destDir := t.TempDir()
+	os.MkdirAll(filepath.Join(destDir, "current"), 0755)
+	if err := switchLink(destDir, "current", filepath.Join(destDir, releasesDir, "1")); err == nil {
+		t.Errorf("Expected a directory in the link's place to be left alone")
+	}
+}
+
+// TestPruneReleases tests removing the oldest releases, ordering releases
+// made in the same millisecond by number and leaving other directories alone
+func TestPruneReleases(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{
+		"20240501-120000.000", "20240501-120000.000-2", "20240501-120000.000-10",
+		"20240502-090000.000", "backup-of-site", "20240502-090000.000-old", "20240502-090000.000-01",
+	}
+	for _, name := range names {
+		os.Mkdir(filepath.Join(dir, name), 0755)
+	}
+
+	current := filepath.Join(dir, "20240501-120000.000")
+	removed, err := pruneReleases(dir, 2, current)
+	if err != nil || removed != 1 {
+		t.Fatalf("Expected 1 release to be removed, got %d, %v", removed, err)
+	}
+
+	kept := []string{"20240501-120000.000", "20240501-120000.000-10", "20240502-090000.000", "backup-of-site", "20240502-090000.000-old", "20240502-090000.000-01"}
+	for _, name := range kept {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Expected %s to be kept: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "20240501-120000.000-2")); !os.IsNotExist(err) {
+		t.Errorf("Expected the oldest release that isn't current to be removed")
+	}
+}
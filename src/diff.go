@@ -0,0 +1,276 @@
+package dirsync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// maxDiffEntries is how many differences a diff lists before it stops
+// walking, so a preview of a first sync into an empty destination stays small
+const maxDiffEntries = 10000
+
+// ErrDiffUnsupported is returned by Diff for pairs whose destination doesn't
+// mirror the source's names and contents
+var ErrDiffUnsupported = errors.New("diff preview isn't available for pairs with encryption, transforms or routes")
+
+// DiffReport compares a pair's source with its destination without changing
+// either, so a manual sync can be previewed
+type DiffReport struct {
+	SyncID      string `json:"sync_id"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+
+	// New files are only in the source, and modified ones differ in size or
+	// modification time with the source being newer
+	New      []DiffEntry `json:"new"`
+	Modified []DiffEntry `json:"modified"`
+
+	// Extra paths are only in the destination, and are deleted by pairs
+	// with delete. Directories are listed once, not with their contents.
+	Extra []DiffEntry `json:"extra"`
+
+	// Conflicting files changed in the destination after the source, or are
+	// a file on one side and a directory on the other. A sync overwrites
+	// them with the source.
+	Conflicting []DiffEntry `json:"conflicting"`
+
+	// BytesToCopy is the size of the new, modified and conflicting files
+	BytesToCopy int64 `json:"bytes_to_copy"`
+
+	// Truncated is set when there were more than maxDiffEntries differences
+	// and the rest weren't looked for
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// DiffEntry is a path that differs between the source and destination,
+// relative to their roots, with what each side has
+type DiffEntry struct {
+	Path          string     `json:"path"`
+	IsDir         bool       `json:"is_dir,omitempty"`
+	SourceSize    int64      `json:"source_size,omitempty"`
+	SourceModTime *time.Time `json:"source_mod_time,omitempty"`
+	DestSize      int64      `json:"dest_size,omitempty"`
+	DestModTime   *time.Time `json:"dest_mod_time,omitempty"`
+}
+
+// differ walks a source and destination side by side
+type differ struct {
+	sync      *Sync
+	source    string
+	dest      string
+	excludes  map[string]bool
+	protected map[string]bool
+	device    uint64
+	report    *DiffReport
+	entries   int
+}
+
+// Diff compares the pair's source with its destination, or with the current
+// release of a deployed pair, honoring its excludes, skip_hidden and
+// one_filesystem settings. Filters and hooks aren't run.
+func (s *Sync) Diff() (*DiffReport, error) {
+	if s.Pair.Encryption != nil || len(s.Pair.Transforms) > 0 || len(s.Pair.Routes) > 0 {
+		return nil, ErrDiffUnsupported
+	}
+
+	dest := s.DestinationPath
+	if s.Pair.Deploy != nil {
+		dest = currentRelease(s.DestinationPath, s.Pair.Deploy)
+	}
+
+	s.mu.RLock()
+	excludes := make(map[string]bool, len(s.excludedDirs))
+	for _, dir := range s.excludedDirs {
+		excludes[dir] = true
+	}
+	s.mu.RUnlock()
+
+	d := &differ{
+		sync:      s,
+		source:    s.SourcePath,
+		dest:      dest,
+		excludes:  excludes,
+		protected: protectedPaths(s.Pair),
+		report: &DiffReport{
+			SyncID:      s.ID,
+			Source:      s.SourcePath,
+			Destination: dest,
+			New:         []DiffEntry{},
+			Modified:    []DiffEntry{},
+			Extra:       []DiffEntry{},
+			Conflicting: []DiffEntry{},
+		},
+	}
+
+	info, err := os.Stat(s.SourcePath)
+	if err != nil {
+		return nil, err
+	}
+	if id, ok := getFileID(info); ok {
+		d.device = id.dev
+	}
+
+	if err := d.compareDir(""); err != nil {
+		return nil, err
+	}
+	return d.report, nil
+}
+
+// add records a difference, or marks the report truncated once the limit
+// is reached
+func (d *differ) add(list *[]DiffEntry, entry DiffEntry) {
+	if d.entries >= maxDiffEntries {
+		d.report.Truncated = true
+		return
+	}
+	d.entries++
+	*list = append(*list, entry)
+}
+
+// readDir lists a directory by name, treating a missing one as empty
+func readDir(dir string) (map[string]os.FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make(map[string]os.FileInfo, len(entries))
+	for _, entry := range entries {
+		// Entries can disappear while we look at them
+		if info, err := entry.Info(); err == nil {
+			infos[entry.Name()] = info
+		}
+	}
+	return infos, nil
+}
+
+// skipped reports whether the entry at rel is left out of syncing
+func (d *differ) skipped(rel string, info os.FileInfo) bool {
+	slashRel := filepath.ToSlash(rel)
+	switch {
+	case slashRel == LockFile, d.excludes[slashRel], d.protected[slashRel]:
+		return true
+	case d.sync.Pair.SkipHidden && isHidden(info.Name()):
+		return true
+	}
+	return false
+}
+
+// compareDir compares the directory at rel on both sides
+func (d *differ) compareDir(rel string) error {
+	sources, err := readDir(filepath.Join(d.source, rel))
+	if err != nil {
+		return err
+	}
+	dests := map[string]os.FileInfo{}
+	if d.dest != "" {
+		if dests, err = readDir(filepath.Join(d.dest, rel)); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		src := sources[name]
+		entryRel := filepath.Join(rel, name)
+		if d.skipped(entryRel, src) {
+			continue
+		}
+		if err := d.compareEntry(entryRel, src, dests[name]); err != nil {
+			return err
+		}
+		if d.report.Truncated {
+			return nil
+		}
+	}
+
+	extras := make([]string, 0)
+	for name := range dests {
+		if _, ok := sources[name]; !ok {
+			extras = append(extras, name)
+		}
+	}
+	sort.Strings(extras)
+	for _, name := range extras {
+		dst := dests[name]
+		entryRel := filepath.Join(rel, name)
+		if d.skipped(entryRel, dst) {
+			continue
+		}
+		d.add(&d.report.Extra, destEntry(entryRel, dst))
+	}
+	return nil
+}
+
+// compareEntry compares a source entry with the destination's, which is nil
+// if it's missing
+func (d *differ) compareEntry(rel string, src, dst os.FileInfo) error {
+	entry := DiffEntry{Path: filepath.ToSlash(rel), IsDir: src.IsDir()}
+	if !src.IsDir() {
+		modTime := src.ModTime()
+		entry.SourceSize, entry.SourceModTime = src.Size(), &modTime
+	}
+
+	if dst != nil && dst.IsDir() != src.IsDir() {
+		conflict := destEntry(rel, dst)
+		conflict.IsDir = src.IsDir()
+		conflict.SourceSize, conflict.SourceModTime = entry.SourceSize, entry.SourceModTime
+		d.report.BytesToCopy += entry.SourceSize
+		d.add(&d.report.Conflicting, conflict)
+		return nil
+	}
+
+	if src.IsDir() {
+		// Stay on the source's filesystem if asked
+		if id, ok := getFileID(src); ok && d.sync.Pair.OneFilesystem && id.dev != d.device {
+			return nil
+		}
+		return d.compareDir(rel)
+	}
+
+	switch {
+	case dst == nil:
+		d.report.BytesToCopy += src.Size()
+		d.add(&d.report.New, entry)
+		return nil
+	case src.Mode()&os.ModeSymlink != 0:
+		srcTarget, _ := os.Readlink(filepath.Join(d.source, rel))
+		dstTarget, _ := os.Readlink(filepath.Join(d.dest, rel))
+		if srcTarget == dstTarget {
+			return nil
+		}
+	case src.Size() == dst.Size() && src.ModTime().Equal(dst.ModTime()):
+		return nil
+	}
+
+	modTime := dst.ModTime()
+	entry.DestSize, entry.DestModTime = dst.Size(), &modTime
+	d.report.BytesToCopy += src.Size()
+	if dst.ModTime().After(src.ModTime()) {
+		d.add(&d.report.Conflicting, entry)
+	} else {
+		d.add(&d.report.Modified, entry)
+	}
+	return nil
+}
+
+// destEntry describes a path as the destination has it
+func destEntry(rel string, dst os.FileInfo) DiffEntry {
+	entry := DiffEntry{Path: filepath.ToSlash(rel), IsDir: dst.IsDir()}
+	if !dst.IsDir() {
+		modTime := dst.ModTime()
+		entry.DestSize, entry.DestModTime = dst.Size(), &modTime
+	}
+	return entry
+}
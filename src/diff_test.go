@@ -0,0 +1,80 @@
+package dirsync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestDiff tests comparing a source with its destination
+func TestDiff(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	past := time.Now().Add(-time.Hour)
+
+	write := func(dir, rel, content string, modTime time.Time) {
+		path := filepath.Join(dir, rel)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(content), 0644)
+		os.Chtimes(path, modTime, modTime)
+	}
+	write(sourceDir, "same.txt", "same", past)
+	write(destDir, "same.txt", "same", past)
+	write(sourceDir, "docs/new.txt", "new", past)
+	write(sourceDir, "changed.txt", "changed in the source", time.Now())
+	write(destDir, "changed.txt", "old", past)
+	write(sourceDir, "edited.txt", "old", past)
+	write(destDir, "edited.txt", "edited in the destination", time.Now())
+	write(sourceDir, "kind", "a file", past)
+	write(destDir, "kind/file.txt", "a directory", past)
+	write(destDir, "old/stale.txt", "removed from the source", past)
+	write(sourceDir, ".hidden", "hidden", past)
+	write(destDir, LockFile, "", past)
+
+	testSync := NewSync(sourceDir, destDir, 60)
+	testSync.Pair.SkipHidden = true
+	report, err := testSync.Diff()
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+
+	paths := func(entries []DiffEntry) []string {
+		var paths []string
+		for _, entry := range entries {
+			paths = append(paths, entry.Path)
+		}
+		return paths
+	}
+	check := func(name string, entries []DiffEntry, want ...string) {
+		got := paths(entries)
+		if len(got) != len(want) {
+			t.Errorf("Expected %s %v, got %v", name, want, got)
+			return
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("Expected %s %v, got %v", name, want, got)
+			}
+		}
+	}
+	check("new", report.New, "docs/new.txt")
+	check("modified", report.Modified, "changed.txt")
+	check("conflicting", report.Conflicting, "edited.txt", "kind")
+	check("extra", report.Extra, "old")
+
+	if want := int64(len("new") + len("changed in the source") + len("old") + len("a file")); report.BytesToCopy != want {
+		t.Errorf("Expected %d bytes to copy, got %d", want, report.BytesToCopy)
+	}
+	if !report.Extra[0].IsDir {
+		t.Errorf("Expected the extra directory to be listed as one")
+	}
+	if entries, _ := os.ReadDir(destDir); len(entries) != 6 {
+		t.Errorf("Expected the destination to be left alone, got %d entries", len(entries))
+	}
+
+	testSync.Pair.Routes = []RouteConfig{{Patterns: []string{"*.jpg"}, Destination: "photos"}}
+	if _, err := testSync.Diff(); err != ErrDiffUnsupported {
+		t.Errorf("Expected routed pairs to be unsupported, got %v", err)
+	}
+}
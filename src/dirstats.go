@@ -0,0 +1,179 @@
+package dirsync
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// maxLargestFiles is how many of the largest files directory statistics list
+const maxLargestFiles = 10
+
+// dirStatsMaxAge is how long directory statistics are served before they are
+// scanned again. They are also scanned again after each run.
+var dirStatsMaxAge = 15 * time.Minute
+
+// ageBuckets group files by how long ago they were modified
+var ageBuckets = []struct {
+	label string
+	age   time.Duration
+}{
+	{"day", 24 * time.Hour},
+	{"week", 7 * 24 * time.Hour},
+	{"month", 30 * 24 * time.Hour},
+	{"year", 365 * 24 * time.Hour},
+	{"older", 0},
+}
+
+// PairStats is what a pair's source and destination hold, from the last
+// background scan
+type PairStats struct {
+	SyncID      string    `json:"sync_id"`
+	Source      DirStats  `json:"source"`
+	Destination DirStats  `json:"destination"`
+	ScannedAt   time.Time `json:"scanned_at"`
+
+	// Scanning is set while a newer scan is in progress
+	Scanning bool `json:"scanning,omitempty"`
+}
+
+// DirStats summarizes the files in a directory tree
+type DirStats struct {
+	Path       string `json:"path"`
+	Files      int64  `json:"files"`
+	Dirs       int64  `json:"dirs"`
+	TotalBytes int64  `json:"total_bytes"`
+
+	// Largest are the biggest files, largest first
+	Largest []FileSize `json:"largest"`
+
+	// Modified counts files by when they were last modified, in the last
+	// day, week, month and year, or longer ago
+	Modified []AgeBucket `json:"modified"`
+
+	// Error is why the scan stopped early, such as a missing destination
+	Error string `json:"error,omitempty"`
+}
+
+// FileSize is a file and its size, relative to the scanned directory
+type FileSize struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// AgeBucket counts the files last modified within an age
+type AgeBucket struct {
+	Within string `json:"within"`
+	Files  int64  `json:"files"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// dirStatsCache holds a pair's last directory statistics
+type dirStatsCache struct {
+	stats    *PairStats
+	scanning bool
+}
+
+// DirStats returns the statistics from the pair's last directory scan, and
+// starts a scan in the background if there is none yet, or it is older
+// than dirStatsMaxAge or the last run. It returns nil until the first scan
+// is done.
+func (s *Sync) DirStats() *PairStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cached := s.dirStats.stats
+	stale := cached == nil || time.Since(cached.ScannedAt) > dirStatsMaxAge || s.LastSync.After(cached.ScannedAt)
+	if stale && !s.dirStats.scanning {
+		s.dirStats.scanning = true
+		go s.scanDirStats()
+	}
+
+	if cached == nil {
+		return nil
+	}
+	stats := *cached
+	stats.Scanning = s.dirStats.scanning
+	return &stats
+}
+
+// scanDirStats scans the source and destination and caches the result
+func (s *Sync) scanDirStats() {
+	now := time.Now()
+	stats := &PairStats{
+		SyncID:      s.ID,
+		Source:      scanDir(s.SourcePath, now),
+		Destination: scanDir(s.DestinationPath, now),
+		ScannedAt:   now,
+	}
+
+	s.mu.Lock()
+	s.dirStats.stats = stats
+	s.dirStats.scanning = false
+	s.mu.Unlock()
+}
+
+// scanDir walks root without following symlinks and summarizes its files
+func scanDir(root string, now time.Time) DirStats {
+	stats := DirStats{Path: root, Largest: []FileSize{}, Modified: make([]AgeBucket, len(ageBuckets))}
+	for i, bucket := range ageBuckets {
+		stats.Modified[i].Within = bucket.label
+	}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Skip unreadable subdirectories, but report a missing root
+			if path == root {
+				return err
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if path != root {
+				stats.Dirs++
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		stats.Files++
+		stats.TotalBytes += info.Size()
+
+		age := now.Sub(info.ModTime())
+		for i, bucket := range ageBuckets {
+			if bucket.age == 0 || age < bucket.age {
+				stats.Modified[i].Files++
+				stats.Modified[i].Bytes += info.Size()
+				break
+			}
+		}
+
+		if info.Mode().IsRegular() {
+			rel, _ := filepath.Rel(root, path)
+			stats.addLargest(FileSize{Path: filepath.ToSlash(rel), Size: info.Size()})
+		}
+		return nil
+	})
+	if err != nil {
+		stats.Error = err.Error()
+	}
+	return stats
+}
+
+// addLargest keeps file if it is among the largest seen so far
+func (d *DirStats) addLargest(file FileSize) {
+	if len(d.Largest) == maxLargestFiles && file.Size <= d.Largest[len(d.Largest)-1].Size {
+		return
+	}
+	i := sort.Search(len(d.Largest), func(i int) bool { return d.Largest[i].Size < file.Size })
+	d.Largest = append(d.Largest, FileSize{})
+	copy(d.Largest[i+1:], d.Largest[i:])
+	d.Largest[i] = file
+	if len(d.Largest) > maxLargestFiles {
+		d.Largest = d.Largest[:maxLargestFiles]
+	}
+}
@@ -0,0 +1,76 @@
+package dirsync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestScanDir tests summarizing a directory tree
+func TestScanDir(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	write := func(rel string, size int, age time.Duration) {
+		path := filepath.Join(dir, rel)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(strings.Repeat("x", size)), 0644)
+		os.Chtimes(path, now.Add(-age), now.Add(-age))
+	}
+	write("recent.txt", 10, time.Hour)
+	write("docs/weekly.txt", 30, 3*24*time.Hour)
+	write("docs/old/archive.txt", 20, 2*365*24*time.Hour)
+	for i := 0; i < maxLargestFiles; i++ {
+		write(filepath.Join("small", string(rune('a'+i))), 1, time.Hour)
+	}
+
+	stats := scanDir(dir, now)
+	if stats.Files != 13 || stats.Dirs != 3 || stats.TotalBytes != 70 || stats.Error != "" {
+		t.Errorf("Unexpected totals: %+v", stats)
+	}
+	if len(stats.Largest) != maxLargestFiles || stats.Largest[0] != (FileSize{"docs/weekly.txt", 30}) || stats.Largest[1].Path != "docs/old/archive.txt" || stats.Largest[2].Path != "recent.txt" {
+		t.Errorf("Expected the largest files first, got %+v", stats.Largest)
+	}
+
+	want := []AgeBucket{{"day", 11, 20}, {"week", 1, 30}, {"month", 0, 0}, {"year", 0, 0}, {"older", 1, 20}}
+	for i, bucket := range want {
+		if stats.Modified[i] != bucket {
+			t.Errorf("Expected %+v, got %+v", bucket, stats.Modified[i])
+		}
+	}
+
+	if missing := scanDir(filepath.Join(dir, "missing"), now); missing.Error == "" {
+		t.Errorf("Expected a missing directory to be reported")
+	}
+}
+
+// TestDirStatsCache tests that directory statistics are scanned in the
+// background and scanned again after a run
+func TestDirStatsCache(t *testing.T) {
+	s := NewSync(testSourceDir, t.TempDir(), 60)
+
+	deadline := time.Now().Add(5 * time.Second)
+	stats := s.DirStats()
+	for stats == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		stats = s.DirStats()
+	}
+	if stats == nil {
+		t.Fatalf("Expected a background scan to finish")
+	}
+	if stats.Source.Files != 3 || stats.Destination.Files != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	if err := s.SyncDirectories(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	for stats.Destination.Files == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		stats = s.DirStats()
+	}
+	if stats.Destination.Files != 3 {
+		t.Errorf("Expected the destination to be scanned again after the run, got %+v", stats.Destination)
+	}
+}
@@ -0,0 +1,428 @@
+package dirsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// encryptedMagic starts every encrypted file, followed by the salt its
+	// key is derived with
+	encryptedMagic = "DSENC2"
+
+	// fileSaltSize is the length of the random salt each file's key is
+	// derived from
+	fileSaltSize = 32
+
+	// encryptChunkSize is how much plaintext each sealed chunk holds, so
+	// files of any size are encrypted without holding them in memory
+	encryptChunkSize = 64 * 1024
+
+	// chunkCounterOffset is where the chunk counter starts in each chunk's
+	// nonce, which ends with the flag marking the last chunk
+	chunkCounterOffset = 7
+
+	// maxNameLength is the longest name most filesystems allow, which
+	// limits how long a name can be before encrypting it
+	maxNameLength = 255
+)
+
+// errNotEncrypted is returned when decrypting a file that wasn't encrypted
+var errNotEncrypted = errors.New("file is not encrypted")
+
+// EncryptionConfig encrypts every file the pair writes to the destination
+// with AES-256-GCM, so a destination on someone else's disk or cloud
+// storage only ever holds ciphertext. Files are decrypted with RestoreDir.
+type EncryptionConfig struct {
+	// KeyFile holds the 256-bit key as 64 hex digits, e.g. from
+	// "openssl rand -hex 32"
+	KeyFile string `json:"key_file"`
+
+	// EncryptNames also encrypts file and directory names, and symlink
+	// targets, so the destination doesn't reveal what the files are called
+	EncryptNames bool `json:"encrypt_names,omitempty"`
+}
+
+// Validate checks that a key file is given
+func (e EncryptionConfig) Validate() error {
+	if e.KeyFile == "" {
+		return errors.New("encryption has no key_file")
+	}
+	return nil
+}
+
+// LoadEncryptionKey reads a 256-bit key written as 64 hex digits from path
+func LoadEncryptionKey(path string) ([]byte, error) {
+	return loadHexKey(path, "encryption")
+}
+
+// loadHexKey reads a 256-bit key written as 64 hex digits from path, naming
+// what it's for in errors
+func loadHexKey(path, purpose string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s key: %w", purpose, err)
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%s key in %s must be 64 hex digits", purpose, path)
+	}
+	return key, nil
+}
+
+// fileCipher encrypts and decrypts files with one key. Every file is
+// sealed with its own key, derived from the key and a random salt, so
+// nonces never repeat under a key however many files are encrypted.
+type fileCipher struct {
+	key []byte
+
+	// names encrypts file names, with nameIV deriving each name's nonce
+	// from the name so the same name always encrypts the same way
+	names  cipher.AEAD
+	nameIV []byte
+
+	// keyID identifies the key without revealing it, so files are
+	// encrypted again when the key changes
+	keyID string
+}
+
+// newFileCipher sets up AES-256-GCM with key
+func newFileCipher(key []byte) (*fileCipher, error) {
+	if _, err := aes.NewCipher(key); err != nil {
+		return nil, err
+	}
+
+	// Names use their own keys, derived from the key
+	nameBlock, err := aes.NewCipher(deriveKey(key, "dirsync name key"))
+	if err != nil {
+		return nil, err
+	}
+	names, err := cipher.NewGCM(nameBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(key)
+	return &fileCipher{
+		key:    key,
+		names:  names,
+		nameIV: deriveKey(key, "dirsync name iv"),
+		keyID:  hex.EncodeToString(sum[:8]),
+	}, nil
+}
+
+// deriveKey derives a key for the given purpose from key
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// fileAEAD returns the cipher of the file whose key is derived with salt
+func (fc *fileCipher) fileAEAD(salt []byte) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, fc.key, salt, []byte("dirsync file key")), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// cacheKey identifies the encryption in the transform cache, so files are
+// encrypted again when the key changes
+func (fc *fileCipher) cacheKey() string {
+	return "aes-256-gcm-hkdf:" + fc.keyID
+}
+
+// chunkNonce builds the nonce of chunk n. Marking the last chunk means a
+// truncated file fails to decrypt rather than silently losing its end.
+func chunkNonce(n uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint32(nonce[chunkCounterOffset:], n)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encrypt writes the encrypted form of r to w
+func (fc *fileCipher) encrypt(r io.Reader, w io.Writer) error {
+	salt := make([]byte, fileSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	aead, err := fc.fileAEAD(salt)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append([]byte(encryptedMagic), salt...)); err != nil {
+		return err
+	}
+
+	// The file's key is never used again, so the nonces only need to
+	// differ between its chunks
+	in := bufio.NewReaderSize(r, encryptChunkSize)
+	chunk := make([]byte, encryptChunkSize)
+	sealed := make([]byte, 0, encryptChunkSize+aead.Overhead())
+
+	for n := uint32(0); ; n++ {
+		size, err := io.ReadFull(in, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		// The chunk is the last one if nothing follows it
+		_, peekErr := in.Peek(1)
+		last := peekErr == io.EOF
+
+		sealed = aead.Seal(sealed[:0], chunkNonce(n, last), chunk[:size], nil)
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		if peekErr != nil {
+			return peekErr
+		}
+	}
+}
+
+// decrypt writes the decrypted form of r to w, failing if the file was
+// tampered with or truncated
+func (fc *fileCipher) decrypt(r io.Reader, w io.Writer) error {
+	magic := make([]byte, len(encryptedMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return errNotEncrypted
+	}
+
+	if !bytes.Equal(magic, []byte(encryptedMagic)) {
+		return errNotEncrypted
+	}
+	salt := make([]byte, fileSaltSize)
+	if _, err := io.ReadFull(r, salt); err != nil {
+		return errors.New("encrypted file is truncated")
+	}
+	aead, err := fc.fileAEAD(salt)
+	if err != nil {
+		return err
+	}
+
+	in := bufio.NewReaderSize(r, encryptChunkSize+aead.Overhead())
+	chunk := make([]byte, encryptChunkSize+aead.Overhead())
+	var plain []byte
+
+	for n := uint32(0); ; n++ {
+		size, err := io.ReadFull(in, chunk)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			if err == io.EOF {
+				return errors.New("encrypted file is truncated")
+			}
+			return err
+		}
+
+		_, peekErr := in.Peek(1)
+		last := peekErr == io.EOF
+
+		plain, err = aead.Open(plain[:0], chunkNonce(n, last), chunk[:size], nil)
+		if err != nil {
+			return errors.New("encrypted file is corrupt, truncated or encrypted with another key")
+		}
+		if _, err := w.Write(plain); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		if peekErr != nil {
+			return peekErr
+		}
+	}
+}
+
+// encrypting wraps a transform so its output is encrypted, or encrypts the
+// file as it is if there is no transform
+func (fc *fileCipher) encrypting(transform func(io.Reader, io.Writer) error) func(io.Reader, io.Writer) error {
+	if transform == nil {
+		return fc.encrypt
+	}
+
+	return func(r io.Reader, w io.Writer) error {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(transform(r, pw))
+		}()
+
+		err := fc.encrypt(pr, w)
+		pr.CloseWithError(err)
+		return err
+	}
+}
+
+// encryptName encrypts a single file name. The nonce is derived from the
+// name, so a name always encrypts to the same result and the copier finds
+// the file again on the next run; it is stored with the name for decrypting.
+func (fc *fileCipher) encryptName(name string) (string, error) {
+	mac := hmac.New(sha256.New, fc.nameIV)
+	mac.Write([]byte(name))
+	nonce := mac.Sum(nil)[:fc.names.NonceSize()]
+
+	sealed := fc.names.Seal(nonce, nonce, []byte(name), nil)
+	encrypted := base64.RawURLEncoding.EncodeToString(sealed)
+	if len(encrypted) > maxNameLength {
+		return "", fmt.Errorf("%s: name is too long to encrypt", name)
+	}
+	return encrypted, nil
+}
+
+// decryptName decrypts a name from encryptName. It fails for names that
+// weren't encrypted with this key.
+func (fc *fileCipher) decryptName(name string) (string, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(name)
+	if err != nil || len(sealed) < fc.names.NonceSize() {
+		return "", errNotEncrypted
+	}
+
+	nonceSize := fc.names.NonceSize()
+	plain, err := fc.names.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", errNotEncrypted
+	}
+	return string(plain), nil
+}
+
+// encryptPath encrypts every name in a relative path or symlink target.
+// "." and ".." are kept, so relative symlinks still resolve in the
+// destination.
+func (fc *fileCipher) encryptPath(path string) (string, error) {
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	for i, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			continue
+		}
+		encrypted, err := fc.encryptName(part)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = encrypted
+	}
+	return filepath.FromSlash(strings.Join(parts, "/")), nil
+}
+
+// decryptPath decrypts every encrypted name in a path from encryptPath,
+// keeping names that weren't encrypted as they are
+func (fc *fileCipher) decryptPath(path string) string {
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	for i, part := range parts {
+		if name, err := fc.decryptName(part); err == nil {
+			parts[i] = name
+		}
+	}
+	return filepath.FromSlash(strings.Join(parts, "/"))
+}
+
+// RestoreDir copies an encrypted destination back to target, decrypting
+// every file with key. Encrypted file names and symlink targets are
+// decrypted as well. Files that aren't encrypted are copied as they are and
+// dirsync's own bookkeeping files are skipped. Permissions and modification
+// times are kept. It returns the number of files restored.
+func RestoreDir(source, target string, key []byte) (int, error) {
+	fc, err := newFileCipher(key)
+	if err != nil {
+		return 0, err
+	}
+
+	// Directories get their permissions and modification times last, since
+	// restoring their contents changes them
+	type restoredDir struct {
+		path string
+		info os.FileInfo
+	}
+	var dirs []restoredDir
+
+	restored := 0
+	err = filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".dirsync-") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(target, fc.decryptPath(rel))
+
+		switch {
+		case d.IsDir():
+			dirs = append(dirs, restoredDir{dest, info})
+			return os.MkdirAll(dest, info.Mode().Perm()|0700)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			os.Remove(dest)
+			if err := os.Symlink(fc.decryptPath(link), dest); err != nil {
+				return err
+			}
+			restored++
+			return nil
+		case !info.Mode().IsRegular():
+			return nil
+		}
+
+		err = transformFile(path, dest, info, fc.decrypt)
+		if err == errNotEncrypted {
+			err = copyFileContents(context.Background(), path, dest, info, 0, nil, nil)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		restored++
+		return nil
+	})
+	if err != nil {
+		return restored, err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if err := os.Chmod(d.path, d.info.Mode().Perm()); err != nil {
+			return restored, err
+		}
+		if err := os.Chtimes(d.path, d.info.ModTime(), d.info.ModTime()); err != nil {
+			return restored, err
+		}
+	}
+	return restored, nil
+}
@@ -0,0 +1,261 @@
+package dirsync
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestKey writes a key file for the tests and returns its path
+func writeTestKey(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte(strings.Repeat("0123456789abcdef", 4)+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return path
+}
+
+// TestEncryptRoundTrip tests encrypting and decrypting files of various sizes
+func TestEncryptRoundTrip(t *testing.T) {
+	key, err := LoadEncryptionKey(writeTestKey(t))
+	if err != nil {
+		t.Fatalf("LoadEncryptionKey failed: %v", err)
+	}
+	fc, _ := newFileCipher(key)
+
+	for _, size := range []int{0, 1, encryptChunkSize, 2*encryptChunkSize + 17} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		var sealed, opened bytes.Buffer
+		if err := fc.encrypt(bytes.NewReader(plain), &sealed); err != nil {
+			t.Fatalf("encrypt failed: %v", err)
+		}
+		if size > 16 && bytes.Contains(sealed.Bytes(), plain[:16]) {
+			t.Errorf("Expected no plaintext in the encrypted file")
+		}
+		if err := fc.decrypt(bytes.NewReader(sealed.Bytes()), &opened); err != nil {
+			t.Fatalf("decrypt of %d bytes failed: %v", size, err)
+		}
+		if !bytes.Equal(opened.Bytes(), plain) {
+			t.Errorf("Decrypted %d bytes differ from the original", size)
+		}
+
+		// Dropping the last chunk must be noticed
+		if size > encryptChunkSize {
+			truncated := sealed.Bytes()[:len(encryptedMagic)+fileSaltSize+encryptChunkSize+16]
+			if err := fc.decrypt(bytes.NewReader(truncated), &bytes.Buffer{}); err == nil {
+				t.Errorf("Expected a truncated file to fail to decrypt")
+			}
+		}
+	}
+
+	if err := fc.decrypt(strings.NewReader("plain text"), &bytes.Buffer{}); err != errNotEncrypted {
+		t.Errorf("Expected errNotEncrypted, got %v", err)
+	}
+}
+
+// TestEncryptPerFileKeys tests sealing every file with its own key
+func TestEncryptPerFileKeys(t *testing.T) {
+	key, _ := LoadEncryptionKey(writeTestKey(t))
+	fc, _ := newFileCipher(key)
+
+	plain := []byte("the same contents")
+	var first, second bytes.Buffer
+	fc.encrypt(bytes.NewReader(plain), &first)
+	fc.encrypt(bytes.NewReader(plain), &second)
+	header := len(encryptedMagic) + fileSaltSize
+	if bytes.Equal(first.Bytes()[:header], second.Bytes()[:header]) || bytes.Equal(first.Bytes()[header:], second.Bytes()[header:]) {
+		t.Errorf("Expected each file to get its own salt and ciphertext")
+	}
+
+	for _, sealed := range []*bytes.Buffer{&first, &second} {
+		var opened bytes.Buffer
+		if err := fc.decrypt(sealed, &opened); err != nil || opened.String() != string(plain) {
+			t.Errorf("Expected each file to decrypt, got %q, %v", opened.String(), err)
+		}
+	}
+}
+
+// TestEncryptedNamesDontLeak tests that no file in the destination, including
+// dirsync's own caches, holds a plain name
+func TestEncryptedNamesDontLeak(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	keyFile := writeTestKey(t)
+	os.MkdirAll(filepath.Join(sourceDir, "private"), 0755)
+	os.WriteFile(filepath.Join(sourceDir, "private", "secret.txt"), []byte("same"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "private", "twin.txt"), []byte("same"), 0644)
+
+	// A transform cache from before names were encrypted
+	os.WriteFile(filepath.Join(destDir, transformCacheFile), []byte(`{"files": {"private/secret.txt": {"command": "old"}}}`), 0644)
+
+	testSync := NewSync(sourceDir, destDir, 60)
+	testSync.Pair.Manifest = true
+	testSync.Pair.Checksum = true
+	testSync.Pair.Dedup = true
+	testSync.Pair.Encryption = &EncryptionConfig{KeyFile: keyFile, EncryptNames: true}
+	for i := 0; i < 2; i++ {
+		if err := testSync.SyncDirectories(); err != nil {
+			t.Fatalf("SyncDirectories failed: %v", err)
+		}
+	}
+	if strings.Contains(testSync.Output, "(encrypted)") {
+		t.Errorf("Expected the second run to find the files in the cache, got %q", testSync.Output)
+	}
+
+	filepath.WalkDir(destDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		data, _ := os.ReadFile(path)
+		for _, name := range []string{"private", "secret", "twin"} {
+			if strings.Contains(string(data), name) {
+				t.Errorf("Expected %s not to mention %s, got %s", path, name, data)
+			}
+		}
+		return nil
+	})
+}
+
+// TestRestoreDirMetadata tests restoring directories' permissions and
+// modification times
+func TestRestoreDirMetadata(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	keyFile := writeTestKey(t)
+	os.MkdirAll(filepath.Join(sourceDir, "docs", "old"), 0755)
+	os.WriteFile(filepath.Join(sourceDir, "docs", "old", "a.txt"), []byte("a"), 0644)
+	os.Chmod(filepath.Join(sourceDir, "docs"), 0750)
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	os.Chtimes(filepath.Join(sourceDir, "docs", "old"), old, old)
+
+	testSync := NewSync(sourceDir, destDir, 60)
+	testSync.Pair.Encryption = &EncryptionConfig{KeyFile: keyFile}
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	key, _ := LoadEncryptionKey(keyFile)
+	restoreDir := t.TempDir()
+	if _, err := RestoreDir(destDir, restoreDir, key); err != nil {
+		t.Fatalf("RestoreDir failed: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(restoreDir, "docs"))
+	if err != nil || info.Mode().Perm() != 0750 {
+		t.Errorf("Expected docs to be restored with mode 0750, got %v, %v", info, err)
+	}
+	info, err = os.Stat(filepath.Join(restoreDir, "docs", "old"))
+	if err != nil || !info.ModTime().Equal(old) {
+		t.Errorf("Expected docs/old to be restored with its modification time, got %v, %v", info, err)
+	}
+}
+
+// TestSyncWithEncryption tests encrypting a pair's files and restoring them
+func TestSyncWithEncryption(t *testing.T) {
+	destDir := t.TempDir()
+	keyFile := writeTestKey(t)
+
+	testSync := NewSync(testSourceDir, destDir, 60)
+	testSync.Pair.Encryption = &EncryptionConfig{KeyFile: keyFile}
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(destDir, "subdir", "file3.txt"))
+	if err != nil {
+		t.Fatalf("Expected file3.txt in the destination: %v", err)
+	}
+	if !strings.HasPrefix(string(data), encryptedMagic) {
+		t.Errorf("Expected file3.txt to be encrypted")
+	}
+	if !strings.Contains(testSync.Output, "file1.txt (encrypted)") {
+		t.Errorf("Expected encrypted files in the output, got %q", testSync.Output)
+	}
+
+	// Unchanged files aren't encrypted again
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if strings.Contains(testSync.Output, "(encrypted)") {
+		t.Errorf("Expected nothing to be encrypted again, got %q", testSync.Output)
+	}
+
+	key, _ := LoadEncryptionKey(keyFile)
+	restoreDir := t.TempDir()
+	restored, err := RestoreDir(destDir, restoreDir, key)
+	if err != nil {
+		t.Fatalf("RestoreDir failed: %v", err)
+	}
+	if restored != 3 {
+		t.Errorf("Expected 3 files restored, got %d", restored)
+	}
+
+	original, _ := os.ReadFile(filepath.Join(testSourceDir, "subdir", "file3.txt"))
+	got, _ := os.ReadFile(filepath.Join(restoreDir, "subdir", "file3.txt"))
+	if !bytes.Equal(got, original) {
+		t.Errorf("Restored file3.txt differs from the source")
+	}
+	if _, err := os.Stat(filepath.Join(restoreDir, transformCacheFile)); !os.IsNotExist(err) {
+		t.Errorf("Expected bookkeeping files not to be restored")
+	}
+
+	// A missing key fails the run rather than copying in the clear
+	testSync.Pair.Encryption.KeyFile = filepath.Join(t.TempDir(), "missing")
+	if err := testSync.SyncDirectories(); err == nil {
+		t.Errorf("Expected a missing key to fail the sync")
+	}
+}
+
+// TestSyncWithEncryptedNames tests that file names and symlink targets are
+// encrypted in the destination and restored
+func TestSyncWithEncryptedNames(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	keyFile := writeTestKey(t)
+	os.MkdirAll(filepath.Join(sourceDir, "private"), 0755)
+	os.WriteFile(filepath.Join(sourceDir, "private", "secret.txt"), []byte("secret"), 0644)
+	os.Symlink(filepath.Join("private", "secret.txt"), filepath.Join(sourceDir, "link"))
+
+	testSync := NewSync(sourceDir, destDir, 60)
+	testSync.Pair.Delete = true
+	testSync.Pair.Encryption = &EncryptionConfig{KeyFile: keyFile, EncryptNames: true}
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	filepath.WalkDir(destDir, func(path string, d os.DirEntry, err error) error {
+		if name := d.Name(); strings.Contains(name, "private") || strings.Contains(name, "secret") || name == "link" {
+			t.Errorf("Expected %s to be encrypted", path)
+		}
+		if d.Type()&os.ModeSymlink != 0 {
+			if target, _ := os.Readlink(path); strings.Contains(target, "secret") {
+				t.Errorf("Expected the symlink target %s to be encrypted", target)
+			}
+		}
+		return nil
+	})
+
+	// Names encrypt the same way every time, so nothing is copied or deleted again
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if strings.Contains(testSync.Output, "(encrypted)") || !strings.Contains(testSync.Output, "Deleted 0 files") {
+		t.Errorf("Expected the second run to change nothing, got %q", testSync.Output)
+	}
+
+	key, _ := LoadEncryptionKey(keyFile)
+	restoreDir := t.TempDir()
+	if _, err := RestoreDir(destDir, restoreDir, key); err != nil {
+		t.Fatalf("RestoreDir failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(restoreDir, "link"))
+	if err != nil || string(data) != "secret" {
+		t.Errorf("Expected the restored link to resolve to secret.txt, got %q, %v", data, err)
+	}
+}
@@ -0,0 +1,137 @@
+package dirsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"syscall"
+)
+
+// Errors that runs fail with, so callers can tell failures apart with
+// errors.Is instead of reading messages
+var (
+	// ErrSourceMissing is returned when the pair's source doesn't exist,
+	// such as an unmounted disk
+	ErrSourceMissing = errors.New("source path does not exist")
+
+	// ErrPermission is returned when a path can't be read or written
+	ErrPermission = errors.New("permission denied")
+
+	// ErrDiskFull is returned when the destination runs out of space
+	ErrDiskFull = errors.New("no space left on device")
+
+	// ErrOverlappingPaths is returned when the pair's destination is its
+	// source, lies inside it or contains it, which would copy the source
+	// into itself
+	ErrOverlappingPaths = errors.New("source and destination overlap")
+)
+
+// RsyncExitError is returned when rsync exits with an error. Failures rsync
+// reports as running out of space or being denied access also match
+// ErrDiskFull and ErrPermission.
+type RsyncExitError struct {
+	Code int
+
+	// Err is ErrDiskFull or ErrPermission if rsync's output says so
+	Err error
+}
+
+// Error implements error
+func (e *RsyncExitError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("rsync exited with code %d: %v", e.Code, e.Err)
+	}
+	return fmt.Sprintf("rsync exited with code %d", e.Code)
+}
+
+// Unwrap returns the class of the failure, if rsync's output showed one
+func (e *RsyncExitError) Unwrap() error {
+	return e.Err
+}
+
+// newRsyncExitError classifies an rsync failure by its exit code and output
+func newRsyncExitError(code int, output string) *RsyncExitError {
+	e := &RsyncExitError{Code: code}
+	switch {
+	case strings.Contains(output, "No space left on device"), strings.Contains(output, "Disk quota exceeded"):
+		e.Err = ErrDiskFull
+	case strings.Contains(output, "Permission denied"):
+		e.Err = ErrPermission
+	}
+	return e
+}
+
+// classifiedError keeps the message of an error while also matching the
+// class it was found to be
+type classifiedError struct {
+	err   error
+	class error
+}
+
+// Error implements error
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns both the error and its class, for errors.Is and errors.As
+func (e *classifiedError) Unwrap() []error {
+	return []error{e.err, e.class}
+}
+
+// classifyError makes errors from the file system match ErrPermission or
+// ErrDiskFull. Other errors are returned as they are.
+func classifyError(err error) error {
+	if err == nil || errors.Is(err, ErrPermission) || errors.Is(err, ErrDiskFull) {
+		return err
+	}
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		return &classifiedError{err: err, class: ErrPermission}
+	case errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT):
+		return &classifiedError{err: err, class: ErrDiskFull}
+	}
+	return err
+}
+
+// Error classes of failed runs, as given by ErrorClass
+const (
+	ErrorClassSourceMissing = "source_missing"
+	ErrorClassPermission    = "permission"
+	ErrorClassDiskFull      = "disk_full"
+	ErrorClassDeleteLimit   = "delete_limit"
+	ErrorClassOverlap       = "overlapping_paths"
+	ErrorClassRsyncExit     = "rsync_exit"
+	ErrorClassCanceled      = "canceled"
+	ErrorClassOther         = "other"
+)
+
+// ErrorClass returns the class of a run's error, such as "disk_full", for
+// the API, history and notifications, or "" for nil
+func ErrorClass(err error) string {
+	var exitErr *RsyncExitError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrSourceMissing):
+		return ErrorClassSourceMissing
+	case errors.Is(err, ErrOverlappingPaths):
+		return ErrorClassOverlap
+	case errors.Is(err, ErrTooManyFailures):
+		return ErrorClassTooManyFailures
+	case errors.Is(err, ErrPartialFailure):
+		return ErrorClassPartialFailure
+	case errors.Is(err, ErrDiskFull):
+		return ErrorClassDiskFull
+	case errors.Is(err, ErrPermission):
+		return ErrorClassPermission
+	case errors.Is(err, ErrDeleteLimit):
+		return ErrorClassDeleteLimit
+	case errors.As(err, &exitErr):
+		return ErrorClassRsyncExit
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return ErrorClassCanceled
+	}
+	return ErrorClassOther
+}
@@ -0,0 +1,68 @@
+package dirsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+	"testing"
+)
+
+// TestErrorClass tests classifying the errors runs fail with
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err   error
+		class string
+	}{
+		{nil, ""},
+		{fmt.Errorf("%w: /mnt/photos", ErrSourceMissing), ErrorClassSourceMissing},
+		{classifyError(&fs.PathError{Op: "open", Path: "/mnt/backup/a", Err: syscall.EACCES}), ErrorClassPermission},
+		{classifyError(fmt.Errorf("copy: %w", &fs.PathError{Op: "write", Path: "/mnt/backup/a", Err: syscall.ENOSPC})), ErrorClassDiskFull},
+		{newRsyncExitError(11, "rsync: write failed on \"/mnt/backup/a\": No space left on device (28)"), ErrorClassDiskFull},
+		{newRsyncExitError(23, "rsync: opendir \"/data/private\" failed: Permission denied (13)"), ErrorClassPermission},
+		{newRsyncExitError(12, "rsync: connection unexpectedly closed"), ErrorClassRsyncExit},
+		{fmt.Errorf("%w: 3 of 4 files", ErrDeleteLimit), ErrorClassDeleteLimit},
+		{context.Canceled, ErrorClassCanceled},
+		{errors.New("hook failed"), ErrorClassOther},
+	}
+
+	for _, tt := range tests {
+		if class := ErrorClass(tt.err); class != tt.class {
+			t.Errorf("Expected %v to be %q, got %q", tt.err, tt.class, class)
+		}
+	}
+
+	// Classified errors keep their message and what they wrap
+	pathErr := &fs.PathError{Op: "write", Path: "/mnt/backup/a", Err: syscall.ENOSPC}
+	err := classifyError(pathErr)
+	var unwrapped *fs.PathError
+	if err.Error() != pathErr.Error() || !errors.Is(err, ErrDiskFull) || !errors.As(err, &unwrapped) {
+		t.Errorf("Expected the path error classified as disk full, got %v", err)
+	}
+
+	var exitErr *RsyncExitError
+	if err := newRsyncExitError(11, "No space left on device"); !errors.As(error(err), &exitErr) || exitErr.Code != 11 || !errors.Is(err, ErrDiskFull) {
+		t.Errorf("Expected an rsync exit error with code 11 matching ErrDiskFull, got %v", err)
+	}
+}
+
+// TestRunErrorClass tests that failed runs record their error class in the
+// history and status
+func TestRunErrorClass(t *testing.T) {
+	manager := NewSyncManager(Options{})
+	s := manager.AddSync("/non/existent/path", t.TempDir(), 60)
+
+	err := s.Run(context.Background())
+	if !errors.Is(err, ErrSourceMissing) {
+		t.Fatalf("Expected ErrSourceMissing, got %v", err)
+	}
+
+	runs := manager.History.ListRuns(s.ID)
+	if len(runs) != 1 || runs[0].ErrorClass != ErrorClassSourceMissing {
+		t.Errorf("Expected a run failed with source_missing, got %+v", runs)
+	}
+	if class := s.GetStatus()["last_error_class"]; class != ErrorClassSourceMissing {
+		t.Errorf("Expected the status to show source_missing, got %v", class)
+	}
+}
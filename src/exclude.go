@@ -0,0 +1,102 @@
+package dirsync
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// resolvePath returns an absolute, symlink-resolved version of path. Parts of
+// the path that don't exist yet are kept as-is on top of the longest existing
+// prefix, so destinations that haven't been created can still be compared.
+func resolvePath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+
+	rest := ""
+	current := abs
+	for {
+		if resolved, err := filepath.EvalSymlinks(current); err == nil {
+			return filepath.Join(resolved, rest)
+		}
+
+		parent := filepath.Dir(current)
+		if parent == current {
+			return abs
+		}
+		rest = filepath.Join(filepath.Base(current), rest)
+		current = parent
+	}
+}
+
+// DestinationWithin reports whether destination is source itself or lies
+// inside it once symlinks are resolved, returning its path relative to source
+func DestinationWithin(source, destination string) (string, bool) {
+	return pathWithin(resolvePath(source), resolvePath(destination))
+}
+
+// checkOverlap returns ErrOverlappingPaths if destination is source, lies
+// inside it or contains it once symlinks are resolved
+func checkOverlap(source, destination string) error {
+	src, dst := resolvePath(source), resolvePath(destination)
+	if rel, ok := pathWithin(src, dst); ok && rel == "." {
+		return fmt.Errorf("%w: destination %s is the source", ErrOverlappingPaths, destination)
+	} else if ok {
+		return fmt.Errorf("%w: destination %s is inside source %s", ErrOverlappingPaths, destination, source)
+	}
+	if _, ok := pathWithin(dst, src); ok {
+		return fmt.Errorf("%w: destination %s contains source %s", ErrOverlappingPaths, destination, source)
+	}
+	return nil
+}
+
+// pathWithin returns the path of target relative to root if target lies
+// inside root (or is root itself)
+func pathWithin(root, target string) (string, bool) {
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		return "", false
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+// UpdateProtectiveExcludes excludes every destination that can be reached from
+// a pair's source, so a sync never backs up another pair's backup or one of
+// its own routes. A pair whose own destination is inside its source doesn't
+// run at all.
+func (sm *SyncManager) UpdateProtectiveExcludes() {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	for _, s := range sm.Syncs {
+		source := resolvePath(s.SourcePath)
+		var excludes []string
+
+		for _, other := range sm.Syncs {
+			for _, destPath := range other.destinations() {
+				if other == s && destPath == s.DestinationPath {
+					continue
+				}
+				rel, ok := pathWithin(source, resolvePath(destPath))
+				if !ok || rel == "." {
+					continue
+				}
+
+				log.Printf("Warning: destination %s of pair %s is inside source %s of pair %s, excluding it from the sync",
+					destPath, other.ID, s.SourcePath, s.ID)
+				excludes = append(excludes, filepath.ToSlash(rel))
+			}
+		}
+
+		s.mu.Lock()
+		s.excludedDirs = excludes
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,190 @@
+package dirsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestPathWithin tests checking whether a path lies inside another
+func TestPathWithin(t *testing.T) {
+	tests := []struct {
+		root     string
+		target   string
+		expected string
+		within   bool
+	}{
+		{"/data", "/data/backup", "backup", true},
+		{"/data", "/data", ".", true},
+		{"/data", "/data2/backup", "", false},
+		{"/data/photos", "/data", "", false},
+	}
+
+	for _, tt := range tests {
+		rel, ok := pathWithin(tt.root, tt.target)
+		if ok != tt.within || rel != tt.expected {
+			t.Errorf("pathWithin(%s, %s) = %s, %v; expected %s, %v", tt.root, tt.target, rel, ok, tt.expected, tt.within)
+		}
+	}
+}
+
+// TestResolvePath tests resolving symlinks, including in paths that don't exist yet
+func TestResolvePath(t *testing.T) {
+	baseDir, err := os.MkdirTemp("", "dirsync_test_resolve")
+	if err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	realDir := filepath.Join(baseDir, "real")
+	linkDir := filepath.Join(baseDir, "link")
+	os.MkdirAll(realDir, 0755)
+	if err := os.Symlink(realDir, linkDir); err != nil {
+		t.Skipf("Symlinks not supported: %v", err)
+	}
+
+	resolvedReal := resolvePath(realDir)
+
+	if resolved := resolvePath(linkDir); resolved != resolvedReal {
+		t.Errorf("Expected %s, got %s", resolvedReal, resolved)
+	}
+
+	expected := filepath.Join(resolvedReal, "not", "created")
+	if resolved := resolvePath(filepath.Join(linkDir, "not", "created")); resolved != expected {
+		t.Errorf("Expected %s, got %s", expected, resolved)
+	}
+}
+
+// TestUpdateProtectiveExcludes tests excluding destinations reachable from a source
+func TestUpdateProtectiveExcludes(t *testing.T) {
+	manager := NewSyncManager(Options{})
+
+	backupDir := filepath.Join(testSourceDir, "backup")
+	sync1 := manager.AddSync(testSourceDir, testDestDir, 60)
+	sync2 := manager.AddSync(testDestDir, backupDir, 60)
+
+	manager.UpdateProtectiveExcludes()
+
+	if len(sync1.excludedDirs) != 1 || sync1.excludedDirs[0] != "backup" {
+		t.Fatalf("Expected backup to be excluded from sync1, got %v", sync1.excludedDirs)
+	}
+
+	if len(sync2.excludedDirs) != 0 {
+		t.Errorf("Expected no excludes for sync2, got %v", sync2.excludedDirs)
+	}
+
+	found := false
+	for _, arg := range sync1.rsyncArgs(testSourceDir + "/") {
+		if arg == "--exclude=/backup/" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected --exclude=/backup/ in rsync args")
+	}
+}
+
+// TestOverlappingPathsRefused tests that runs refuse a destination that is,
+// lies inside or contains the source, including through symlinks
+func TestOverlappingPathsRefused(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	os.MkdirAll(source, 0755)
+	os.WriteFile(filepath.Join(source, "file.txt"), []byte("data"), 0644)
+	os.Symlink(source, filepath.Join(dir, "link"))
+
+	manager := NewSyncManager(Options{})
+	for _, dest := range []string{source, filepath.Join(source, "backup"), filepath.Join(dir, "link", "backup"), dir} {
+		s := manager.AddSync(source, dest, 60)
+		if err := s.Run(context.Background()); !errors.Is(err, ErrOverlappingPaths) || ErrorClass(err) != ErrorClassOverlap {
+			t.Errorf("Expected a sync to %s to be refused, got %v", dest, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(source, "backup")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be copied into the source")
+	}
+
+	if err := checkOverlap(source, filepath.Join(dir, "sourcebackup")); err != nil {
+		t.Errorf("Expected a sibling destination to be allowed, got %v", err)
+	}
+}
+
+// TestSkipHidden tests leaving out dotfiles and dot-directories
+func TestSkipHidden(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	os.MkdirAll(filepath.Join(sourceDir, ".cache"), 0755)
+	os.MkdirAll(filepath.Join(sourceDir, "docs"), 0755)
+	os.WriteFile(filepath.Join(sourceDir, ".cache", "blob"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "docs", ".secret"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "docs", "note.txt"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(destDir, ".profile"), []byte("kept"), 0644)
+
+	testSync := NewSync(sourceDir, destDir, 60)
+	testSync.Pair.SkipHidden = true
+	testSync.Pair.Delete = true
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(destDir, "docs", "note.txt")); err != nil {
+		t.Errorf("Expected visible files to be copied: %v", err)
+	}
+	for _, hidden := range []string{".cache", filepath.Join("docs", ".secret")} {
+		if _, err := os.Stat(filepath.Join(destDir, hidden)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be skipped", hidden)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(destDir, ".profile")); err != nil {
+		t.Errorf("Expected hidden files in the destination to be safe from deleting")
+	}
+
+	found := false
+	for _, arg := range testSync.rsyncArgs(sourceDir + "/") {
+		found = found || arg == "--exclude=.*"
+	}
+	if !found {
+		t.Errorf("Expected --exclude=.* in rsync args")
+	}
+}
+
+// TestOneFilesystem tests that directories on other filesystems are
+// created empty instead of copied
+func TestOneFilesystem(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	os.MkdirAll(filepath.Join(sourceDir, "volume"), 0755)
+	os.WriteFile(filepath.Join(sourceDir, "volume", "huge.img"), []byte("x"), 0644)
+
+	testSync := NewSync(sourceDir, destDir, 60)
+	testSync.Pair.OneFilesystem = true
+
+	// Pretend the source is on another device, so its subdirectories look
+	// like mount points; a real mount needs privileges tests don't have
+	c := newFileCopier(testSync, sourceDir, destDir)
+	info, _ := os.Stat(sourceDir)
+	if _, ok := getFileID(info); !ok {
+		t.Skip("Devices aren't exposed on this platform")
+	}
+	c.device = ^uint64(0)
+	if err := c.copyDir("volume"); err != nil {
+		t.Fatalf("copyDir failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(destDir, "volume")); err != nil {
+		t.Errorf("Expected the mount point to be created: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "volume", "huge.img")); !os.IsNotExist(err) {
+		t.Errorf("Expected the mounted filesystem not to be copied")
+	}
+
+	found := false
+	for _, arg := range testSync.rsyncArgs(sourceDir + "/") {
+		found = found || arg == "--one-file-system"
+	}
+	if !found {
+		t.Errorf("Expected --one-file-system in rsync args")
+	}
+}
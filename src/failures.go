@@ -0,0 +1,150 @@
+package dirsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// maxFileFailures is how many failed files a run lists; all of them are
+// counted
+const maxFileFailures = 1000
+
+// ErrPartialFailure is returned by runs that copied everything but some
+// files, which are listed in the run's failures
+var ErrPartialFailure = errors.New("some files could not be copied")
+
+// ErrTooManyFailures is returned by runs stopped because more files failed
+// than the pair's max_errors, such as when a mount went away
+var ErrTooManyFailures = errors.New("too many files failed")
+
+// ErrorClassTooManyFailures is the error class of runs stopped by max_errors
+const ErrorClassTooManyFailures = "too_many_failures"
+
+// ErrorClassPartialFailure is the error class of runs that failed to copy
+// some files, and ErrorClassVanished that of files removed from the source
+// while the run copied them
+const (
+	ErrorClassPartialFailure = "partial_failure"
+	ErrorClassVanished       = "vanished"
+)
+
+// FileFailure is a file a run couldn't copy, relative to the source root
+type FileFailure struct {
+	Path       string `json:"path"`
+	Error      string `json:"error"`
+	ErrorClass string `json:"error_class"`
+}
+
+// fileFailureClass returns the class of an error copying a single file
+func fileFailureClass(err error) string {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrorClassVanished
+	}
+	return ErrorClass(classifyError(err))
+}
+
+// skippable reports whether copying a file failed in a way that only
+// concerns that file, so the run can go on with the others: it can't be
+// accessed or vanished, or any error with continueOnError except running
+// out of space and stopping the run
+func (c *fileCopier) skippable(err error) bool {
+	if errors.Is(err, fs.ErrPermission) || errors.Is(err, fs.ErrNotExist) {
+		return true
+	}
+	if !c.continueOnError {
+		return false
+	}
+	switch {
+	case errors.Is(err, errSyncPaused), errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(classifyError(err), ErrDiskFull):
+		return false
+	}
+	return true
+}
+
+// recordFailure adds a file that couldn't be copied to the run's failures.
+// Every failure is counted, but only the first maxFileFailures are kept. It
+// returns ErrTooManyFailures once more files failed than the pair's
+// MaxErrors.
+func (s *Sync) recordFailure(failure FileFailure) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.FailureCount++
+	if len(s.stats.Failures) < maxFileFailures {
+		s.stats.Failures = append(s.stats.Failures, failure)
+	}
+
+	if limit := s.Pair.MaxErrors; limit > 0 && s.stats.FailureCount > limit {
+		return fmt.Errorf("%w: more than max_errors of %d, last %s: %s", ErrTooManyFailures, limit, failure.Path, failure.Error)
+	}
+	return nil
+}
+
+// partialFailure returns ErrPartialFailure with the run's first failure if
+// it failed to copy any files, and nil otherwise
+func (s *Sync) partialFailure() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.stats.FailureCount == 0 {
+		return nil
+	}
+	first := s.stats.Failures[0]
+	return fmt.Errorf("%w: %d failed, first %s: %s", ErrPartialFailure, s.stats.FailureCount, first.Path, first.Error)
+}
+
+// skipFailed records err as a failure of the path at rel and returns nil if
+// it only concerns that path, or ErrTooManyFailures past the pair's
+// max_errors, and returns err otherwise
+func (c *fileCopier) skipFailed(rel string, err error) error {
+	if err == nil || !c.skippable(err) {
+		return err
+	}
+
+	path := filepath.ToSlash(rel)
+	c.sync.appendOutput("failed: " + path + ": " + err.Error())
+	return c.sync.recordFailure(FileFailure{Path: path, Error: err.Error(), ErrorClass: fileFailureClass(err)})
+}
+
+var (
+	// rsyncFileError matches rsync's errors about single files, such as
+	// `rsync: [sender] send_files failed to open "/data/a.txt": Permission denied (13)`
+	rsyncFileError = regexp.MustCompile(`^rsync: .*"([^"]+)".*: (.+) \((\d+)\)$`)
+
+	// rsyncVanished matches rsync's warning about files removed from the
+	// source during the transfer
+	rsyncVanished = regexp.MustCompile(`^file has vanished: "([^"]+)"$`)
+)
+
+// parseRsyncFailure parses a line of rsync's error output about a file it
+// couldn't transfer, with the path made relative to source
+func parseRsyncFailure(line, source string) (FileFailure, bool) {
+	relative := func(path string) string {
+		if rel, err := filepath.Rel(source, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+		return path
+	}
+
+	if m := rsyncVanished.FindStringSubmatch(line); m != nil {
+		return FileFailure{Path: relative(m[1]), Error: "file has vanished", ErrorClass: ErrorClassVanished}, true
+	}
+
+	m := rsyncFileError.FindStringSubmatch(line)
+	if m == nil {
+		return FileFailure{}, false
+	}
+	failure := FileFailure{Path: relative(m[1]), Error: m[2], ErrorClass: ErrorClassOther}
+	switch m[3] {
+	case "2":
+		failure.ErrorClass = ErrorClassVanished
+	case "13", "1":
+		failure.ErrorClass = ErrorClassPermission
+	}
+	return failure, true
+}
@@ -0,0 +1,161 @@
+package dirsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// vanishingHook removes gone.txt from the source just before it's copied
+type vanishingHook struct {
+	NopHook
+}
+
+func (vanishingHook) OnFile(ctx context.Context, event HookEvent) error {
+	if event.File == "gone.txt" {
+		return os.Remove(filepath.Join(event.Source, event.File))
+	}
+	return nil
+}
+
+// TestPartialFailure tests that files that can't be copied are recorded
+// while the rest are copied, failing the run as a partial failure
+func TestPartialFailure(t *testing.T) {
+	RegisterHook("test-vanishing", vanishingHook{})
+
+	sourceDir, destDir := t.TempDir(), t.TempDir()
+	for _, name := range []string{"gone.txt", "keep.txt"} {
+		if err := os.WriteFile(filepath.Join(sourceDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	manager := NewSyncManager(Options{})
+	s := manager.AddPair(PairConfig{Source: sourceDir, Destination: destDir, Hooks: []string{"test-vanishing"}}, 60)
+	err := s.Run(context.Background())
+	if !errors.Is(err, ErrPartialFailure) {
+		t.Fatalf("Expected a partial failure, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "keep.txt")); err != nil {
+		t.Errorf("Expected the other files to be copied: %v", err)
+	}
+
+	run := manager.History.ListRuns(s.ID)[0]
+	if run.Success || run.ErrorClass != ErrorClassPartialFailure || run.FailureCount != 1 || len(run.Failures) != 1 {
+		t.Fatalf("Expected a run with one failed file, got %+v", run)
+	}
+	if failure := run.Failures[0]; failure.Path != "gone.txt" || failure.ErrorClass != ErrorClassVanished || failure.Error == "" {
+		t.Errorf("Expected gone.txt to have vanished, got %+v", failure)
+	}
+}
+
+// TestParseRsyncFailure tests reading failed files from rsync's errors
+func TestParseRsyncFailure(t *testing.T) {
+	tests := []struct {
+		line  string
+		path  string
+		class string
+	}{
+		{`rsync: [sender] send_files failed to open "/data/private/key.pem": Permission denied (13)`, "private/key.pem", ErrorClassPermission},
+		{`rsync: opendir "/data/locked" failed: Permission denied (13)`, "locked", ErrorClassPermission},
+		{`file has vanished: "/data/tmp/upload.part"`, "tmp/upload.part", ErrorClassVanished},
+		{`rsync: link_stat "/data/gone.txt" failed: No such file or directory (2)`, "gone.txt", ErrorClassVanished},
+		{`rsync: write failed on "/mnt/backup/big.iso": No space left on device (28)`, "/mnt/backup/big.iso", ErrorClassOther},
+	}
+	for _, tt := range tests {
+		failure, ok := parseRsyncFailure(tt.line, "/data")
+		if !ok || failure.Path != tt.path || failure.ErrorClass != tt.class {
+			t.Errorf("Expected %s to fail with %s, got %+v (%v)", tt.path, tt.class, failure, ok)
+		}
+	}
+
+	if _, ok := parseRsyncFailure("rsync error: some files/attrs were not transferred (code 23)", "/data"); ok {
+		t.Errorf("Expected the summary line not to name a file")
+	}
+}
+
+// failingHook fails the copy of files whose names start with "bad"
+type failingHook struct {
+	NopHook
+}
+
+func (failingHook) OnFile(ctx context.Context, event HookEvent) error {
+	if strings.HasPrefix(filepath.Base(event.File), "bad") {
+		return errors.New("checksum mismatch")
+	}
+	return nil
+}
+
+// TestContinueOnError tests that other errors only stop the run without
+// continue_on_error
+func TestContinueOnError(t *testing.T) {
+	RegisterHook("test-failing", failingHook{})
+
+	sourceDir := t.TempDir()
+	os.Mkdir(filepath.Join(sourceDir, "sub"), 0755)
+	for _, name := range []string{"bad.txt", "sub/good.txt"} {
+		if err := os.WriteFile(filepath.Join(sourceDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	manager := NewSyncManager(Options{})
+	s := manager.AddPair(PairConfig{Source: sourceDir, Destination: t.TempDir(), Hooks: []string{"test-failing"}}, 60)
+	if err := s.Run(context.Background()); err == nil || errors.Is(err, ErrPartialFailure) {
+		t.Fatalf("Expected the hook's error to stop the run, got %v", err)
+	}
+
+	s.Pair.ContinueOnError = true
+	if err := s.Run(context.Background()); !errors.Is(err, ErrPartialFailure) {
+		t.Fatalf("Expected a partial failure, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.DestinationPath, "sub", "good.txt")); err != nil {
+		t.Errorf("Expected the other files to be copied: %v", err)
+	}
+	runs := manager.History.ListRuns(s.ID)
+	run := runs[len(runs)-1]
+	if run.FailureCount != 1 || run.Failures[0].Path != "bad.txt" || run.Failures[0].Error != "checksum mismatch" || run.Failures[0].ErrorClass != ErrorClassOther {
+		t.Errorf("Expected bad.txt to be recorded, got %+v", run.Failures)
+	}
+
+	// Running out of space still stops the run
+	c := newFileCopier(s, sourceDir, s.DestinationPath)
+	if c.skippable(&os.PathError{Op: "write", Path: "a", Err: syscall.ENOSPC}) || c.skippable(context.Canceled) {
+		t.Errorf("Expected disk full and cancellation to stop the run")
+	}
+}
+
+// TestMaxErrors tests that a run stops once more files failed than
+// max_errors allows
+func TestMaxErrors(t *testing.T) {
+	RegisterHook("test-failing", failingHook{})
+
+	sourceDir := t.TempDir()
+	for _, name := range []string{"bad1.txt", "bad2.txt", "bad3.txt", "good.txt"} {
+		if err := os.WriteFile(filepath.Join(sourceDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	manager := NewSyncManager(Options{})
+	s := manager.AddPair(PairConfig{Source: sourceDir, Destination: t.TempDir(), Hooks: []string{"test-failing"},
+		ContinueOnError: true, MaxErrors: 1}, 60)
+	err := s.Run(context.Background())
+	if !errors.Is(err, ErrTooManyFailures) || ErrorClass(err) != ErrorClassTooManyFailures {
+		t.Fatalf("Expected the run to stop after too many failures, got %v", err)
+	}
+	runs := manager.History.ListRuns(s.ID)
+	if run := runs[len(runs)-1]; run.FailureCount < 2 || run.ErrorClass != ErrorClassTooManyFailures {
+		t.Errorf("Expected the run to stop from the second failure, got %+v", run)
+	}
+
+	// A handful of failures within the limit only fail those files
+	s.Pair.MaxErrors = 5
+	if err := s.Run(context.Background()); !errors.Is(err, ErrPartialFailure) {
+		t.Errorf("Expected a partial failure, got %v", err)
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package dirsync
+
+import "os"
+
+// fileID identifies a file or directory on a device
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// getFileID returns the device and inode of a file, if the platform exposes them
+func getFileID(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
+
+// linkCount returns the number of hard links to a file
+func linkCount(info os.FileInfo) uint64 {
+	return 1
+}
@@ -0,0 +1,32 @@
+//go:build unix
+
+package dirsync
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID identifies a file or directory on a device
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// getFileID returns the device and inode of a file, if the platform exposes them
+func getFileID(info os.FileInfo) (fileID, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+}
+
+// linkCount returns the number of hard links to a file
+func linkCount(info os.FileInfo) uint64 {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 1
+	}
+	return uint64(stat.Nlink)
+}
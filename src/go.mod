@@ -1,3 +1,13 @@
-module dirsync
+module github.com/gkarolyi/dirsync/src
 
 go 1.21
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0
+	github.com/tetratelabs/wazero v1.8.2
+	golang.org/x/crypto v0.33.0
+	golang.org/x/sys v0.30.0
+	lukechampine.com/blake3 v1.3.0
+)
+
+require github.com/klauspost/cpuid/v2 v2.0.12 // indirect
@@ -0,0 +1,98 @@
+package dirsync
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/cespare/xxhash/v2"
+	"lukechampine.com/blake3"
+)
+
+// Hash algorithms pairs can compare and verify files with, set with
+// hash_algorithm. The manifest and deduplication always use SHA-256.
+const (
+	// hashSHA256 is the default
+	hashSHA256 = "sha256"
+
+	hashMD5 = "md5"
+
+	// hashXXHash is the 64-bit xxHash, much faster than the others but
+	// not cryptographic
+	hashXXHash = "xxhash"
+
+	// hashBLAKE3 is cryptographic and faster than SHA-256 on most CPUs
+	hashBLAKE3 = "blake3"
+)
+
+// HashAlgorithms are the valid hash_algorithm settings
+var HashAlgorithms = []string{hashSHA256, hashMD5, hashXXHash, hashBLAKE3}
+
+// ValidHashAlgorithm reports whether algorithm is a known hash_algorithm
+// setting; empty means the default
+func ValidHashAlgorithm(algorithm string) bool {
+	if algorithm == "" {
+		return true
+	}
+	for _, a := range HashAlgorithms {
+		if algorithm == a {
+			return true
+		}
+	}
+	return false
+}
+
+// hashAlgorithm returns the pair's hash algorithm, SHA-256 by default
+func (p PairConfig) hashAlgorithm() string {
+	if p.HashAlgorithm == "" {
+		return hashSHA256
+	}
+	return p.HashAlgorithm
+}
+
+// newHash returns a hash for the algorithm, SHA-256 if it's unknown
+func newHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case hashMD5:
+		return md5.New()
+	case hashXXHash:
+		return xxhash.New()
+	case hashBLAKE3:
+		return blake3.New(32, nil)
+	}
+	return sha256.New()
+}
+
+// hashFile returns the hash of the file at path with the algorithm
+func hashFile(path, algorithm string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := newHash(algorithm)
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// teeHash feeds what is written to it to a second hash as well, so a copy
+// can be hashed with two algorithms in one pass. Sum is the first hash's.
+type teeHash struct {
+	hash.Hash
+	other hash.Hash
+}
+
+func (t teeHash) Write(p []byte) (int, error) {
+	t.other.Write(p)
+	return t.Hash.Write(p)
+}
+
+func (t teeHash) Reset() {
+	t.Hash.Reset()
+	t.other.Reset()
+}
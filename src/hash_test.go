@@ -0,0 +1,67 @@
+package dirsync
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestHashAlgorithms tests each algorithm against its hash of no data
+func TestHashAlgorithms(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty")
+	os.WriteFile(path, nil, 0644)
+
+	want := map[string]string{
+		"":         "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		hashSHA256: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		hashMD5:    "d41d8cd98f00b204e9800998ecf8427e",
+		hashXXHash: "ef46db3751d8e999",
+		hashBLAKE3: "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262",
+	}
+	for algorithm, sum := range want {
+		got, err := hashFile(path, algorithm)
+		if err != nil {
+			t.Fatalf("hashFile failed: %v", err)
+		}
+		if hex.EncodeToString(got) != sum {
+			t.Errorf("Expected %q to hash to %s, got %x", algorithm, sum, got)
+		}
+		if !ValidHashAlgorithm(algorithm) {
+			t.Errorf("Expected %q to be valid", algorithm)
+		}
+	}
+	if ValidHashAlgorithm("crc32") {
+		t.Errorf("Expected an unknown algorithm to be rejected")
+	}
+}
+
+// TestChecksumAlgorithm tests comparing and verifying with another
+// algorithm, and starting the cache over when the algorithm changes
+func TestChecksumAlgorithm(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	os.WriteFile(filepath.Join(sourceDir, "a.txt"), []byte("a"), 0644)
+
+	testSync := NewSync(sourceDir, destDir, 60)
+	testSync.Pair.Checksum = true
+	testSync.Pair.Manifest = true
+	testSync.Pair.VerifySample = 1
+	testSync.Pair.HashAlgorithm = hashXXHash
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	srcPath := filepath.Join(sourceDir, "a.txt")
+	cache := loadChecksumCache(destDir, hashXXHash)
+	if sum := cache.Files[srcPath].Hash; len(sum) != 16 {
+		t.Errorf("Expected an xxHash in the cache, got %q", sum)
+	}
+	if sum := loadManifest(destDir).Files["a.txt"].Hash; len(sum) != 64 {
+		t.Errorf("Expected the manifest to keep SHA-256, got %q", sum)
+	}
+
+	if cache := loadChecksumCache(destDir, hashBLAKE3); len(cache.Files) != 0 {
+		t.Errorf("Expected the cache to start over for another algorithm, got %+v", cache.Files)
+	}
+}
@@ -0,0 +1,401 @@
+package dirsync
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// defaultHistoryLimit is how many runs are kept when no limit is configured
+const defaultHistoryLimit = 1000
+
+// Note is a freeform annotation attached to a run or a pair
+type Note struct {
+	Time time.Time `json:"time"`
+	Text string    `json:"text"`
+}
+
+// RunRecord describes a single execution of a sync
+type RunRecord struct {
+	ID        string    `json:"id"`
+	SyncID    string    `json:"sync_id"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Success   bool      `json:"success"`
+	Error     string    `json:"error,omitempty"`
+	Notes     []Note    `json:"notes,omitempty"`
+	TransferStats
+
+	// ErrorClass is the kind of failure, such as "disk_full", for
+	// reacting to failures without reading Error
+	ErrorClass string `json:"error_class,omitempty"`
+
+	// Interrupted is set for runs the process stopped during
+	Interrupted bool `json:"interrupted,omitempty"`
+
+	// Skipped is why a run didn't copy anything, such as a maintenance
+	// window of the destination. Skipped runs count as successful.
+	Skipped string `json:"skipped,omitempty"`
+
+	// Output is what the run printed, up to the last maxRunOutput bytes
+	Output string `json:"output,omitempty"`
+}
+
+// maxRunOutput is how much of a run's output is kept in the history
+const maxRunOutput = 32 << 10
+
+// TransferStats counts the data a run copied and the size of what the
+// destination holds afterwards, lists the paths it changed, and keeps the
+// output of the commands it ran on the destination host
+type TransferStats struct {
+	BytesTransferred int64 `json:"bytes_transferred,omitempty"`
+	TotalBytes       int64 `json:"total_bytes,omitempty"`
+
+	// Changes lists the first changed paths, up to the change limit, and
+	// ChangeCount counts them all
+	Changes     []Change `json:"changes,omitempty"`
+	ChangeCount int      `json:"change_count,omitempty"`
+
+	// FilesCopied counts the paths created or updated
+	FilesCopied int `json:"files_copied,omitempty"`
+
+	RemoteCommands []RemoteCommandResult `json:"remote_commands,omitempty"`
+
+	// Failures lists the first files that couldn't be copied, up to
+	// maxFileFailures, and FailureCount counts them all
+	Failures     []FileFailure `json:"failures,omitempty"`
+	FailureCount int           `json:"failure_count,omitempty"`
+}
+
+// PairTotals adds up a pair's finished runs. They are kept apart from the
+// runs, so they still count runs dropped beyond the history limit. Skipped
+// runs aren't counted.
+type PairTotals struct {
+	Runs             int64   `json:"runs"`
+	Failures         int64   `json:"failures"`
+	BytesTransferred int64   `json:"bytes_transferred"`
+	FilesCopied      int64   `json:"files_copied"`
+	DurationSeconds  float64 `json:"duration_seconds"`
+
+	// AverageDurationSeconds and FailureRate are worked out from the
+	// above by GetPairTotals
+	AverageDurationSeconds float64 `json:"average_duration_seconds"`
+	FailureRate            float64 `json:"failure_rate"`
+}
+
+// add counts a finished run
+func (t *PairTotals) add(run *RunRecord) {
+	t.Runs++
+	if !run.Success {
+		t.Failures++
+	}
+	t.BytesTransferred += run.BytesTransferred
+	t.FilesCopied += int64(run.FilesCopied)
+	if !run.EndTime.IsZero() {
+		t.DurationSeconds += run.EndTime.Sub(run.StartTime).Seconds()
+	}
+}
+
+// History keeps a record of past runs and notes, optionally persisted to a JSON file
+type History struct {
+	Runs       []*RunRecord           `json:"runs"`
+	PairNotes  map[string][]Note      `json:"pair_notes"`
+	PairTotals map[string]*PairTotals `json:"pair_totals"`
+	path       string
+	limit      int
+	mu         sync.RWMutex
+}
+
+// NewHistory creates a History, loading existing records from path if it is set
+func NewHistory(path string, limit int) (*History, error) {
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+
+	h := &History{
+		Runs:       make([]*RunRecord, 0),
+		PairNotes:  make(map[string][]Note),
+		PairTotals: make(map[string]*PairTotals),
+		path:       path,
+		limit:      limit,
+	}
+
+	if path == "" {
+		return h, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, fmt.Errorf("error parsing history %s: %w", path, err)
+	}
+	if h.PairNotes == nil {
+		h.PairNotes = make(map[string][]Note)
+	}
+
+	// Histories from before totals were kept start from the runs they have
+	if h.PairTotals == nil {
+		h.PairTotals = make(map[string]*PairTotals)
+		for _, run := range h.Runs {
+			if !run.EndTime.IsZero() && run.Skipped == "" {
+				h.pairTotals(run.SyncID).add(run)
+			}
+		}
+	}
+
+	return h, nil
+}
+
+// newRunID returns a unique identifier for a run
+func newRunID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// StartRun records the start of a run and returns its record. On a nil
+// History the record is returned without being stored.
+func (h *History) StartRun(syncID string) *RunRecord {
+	run := &RunRecord{
+		ID:        newRunID(),
+		SyncID:    syncID,
+		StartTime: time.Now(),
+	}
+
+	h.add(run)
+	return run
+}
+
+// add stores a run, dropping the oldest beyond the limit
+func (h *History) add(run *RunRecord) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	h.Runs = append(h.Runs, run)
+	if len(h.Runs) > h.limit {
+		h.Runs = h.Runs[len(h.Runs)-h.limit:]
+	}
+	h.mu.Unlock()
+}
+
+// SkipRun records a run that was skipped without starting, with the
+// reason, and persists the history
+func (h *History) SkipRun(syncID, reason string) (*RunRecord, error) {
+	now := time.Now()
+	run := &RunRecord{
+		ID:        newRunID(),
+		SyncID:    syncID,
+		StartTime: now,
+		EndTime:   now,
+		Success:   true,
+		Skipped:   reason,
+	}
+
+	if h == nil {
+		return run, nil
+	}
+	h.add(run)
+	return run, h.save()
+}
+
+// FinishRun records the outcome of a run and persists the history
+func (h *History) FinishRun(run *RunRecord, runErr error) error {
+	return h.finish(run, TransferStats{}, "", runErr)
+}
+
+// finish records the outcome of a run with what it transferred and printed,
+// and persists the history
+func (h *History) finish(run *RunRecord, stats TransferStats, output string, runErr error) error {
+	if len(output) > maxRunOutput {
+		output = "...\n" + output[len(output)-maxRunOutput:]
+	}
+
+	if h == nil {
+		finishRun(run, stats, runErr)
+		run.Output = output
+		return nil
+	}
+
+	h.mu.Lock()
+	finishRun(run, stats, runErr)
+	run.Output = output
+	h.pairTotals(run.SyncID).add(run)
+	h.mu.Unlock()
+
+	return h.save()
+}
+
+// finishRun fills in the outcome of a run
+func finishRun(run *RunRecord, stats TransferStats, runErr error) {
+	run.TransferStats = stats
+	run.EndTime = time.Now()
+	run.Success = runErr == nil
+	if runErr != nil {
+		run.Error = runErr.Error()
+		run.ErrorClass = ErrorClass(runErr)
+	}
+}
+
+// pairTotals returns the totals of a pair, adding them if needed. h.mu must be
+// held for writing.
+func (h *History) pairTotals(syncID string) *PairTotals {
+	t := h.PairTotals[syncID]
+	if t == nil {
+		t = &PairTotals{}
+		h.PairTotals[syncID] = t
+	}
+	return t
+}
+
+// GetPairTotals returns what a pair's runs have added up to, with the average
+// duration and failure rate worked out
+func (h *History) GetPairTotals(syncID string) PairTotals {
+	if h == nil {
+		return PairTotals{}
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var t PairTotals
+	if stored := h.PairTotals[syncID]; stored != nil {
+		t = *stored
+	}
+	if t.Runs > 0 {
+		t.AverageDurationSeconds = t.DurationSeconds / float64(t.Runs)
+		t.FailureRate = float64(t.Failures) / float64(t.Runs)
+	}
+	return t
+}
+
+// GetRun returns a copy of the run with the given ID
+func (h *History) GetRun(id string) (RunRecord, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, run := range h.Runs {
+		if run.ID == id {
+			return *run, true
+		}
+	}
+	return RunRecord{}, false
+}
+
+// snapshot returns a copy of a run in the history, taken under the lock so
+// notes being added to it at the same time aren't torn
+func (h *History) snapshot(run *RunRecord) RunRecord {
+	if h == nil {
+		return *run
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	copied := *run
+	copied.Notes = append([]Note(nil), run.Notes...)
+	return copied
+}
+
+// ListRuns returns copies of all runs, optionally only those of one sync
+func (h *History) ListRuns(syncID string) []RunRecord {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	runs := make([]RunRecord, 0, len(h.Runs))
+	for _, run := range h.Runs {
+		if syncID == "" || run.SyncID == syncID {
+			runs = append(runs, *run)
+		}
+	}
+	return runs
+}
+
+// AnnotateRun attaches a note to a run
+func (h *History) AnnotateRun(runID, text string) (bool, error) {
+	h.mu.Lock()
+	var found bool
+	for _, run := range h.Runs {
+		if run.ID == runID {
+			run.Notes = append(run.Notes, Note{Time: time.Now(), Text: text})
+			found = true
+			break
+		}
+	}
+	h.mu.Unlock()
+
+	if !found {
+		return false, nil
+	}
+	return true, h.save()
+}
+
+// AnnotatePair attaches a note to a pair
+func (h *History) AnnotatePair(syncID, text string) error {
+	h.mu.Lock()
+	h.PairNotes[syncID] = append(h.PairNotes[syncID], Note{Time: time.Now(), Text: text})
+	h.mu.Unlock()
+
+	return h.save()
+}
+
+// GetPairNotes returns the notes attached to a pair
+func (h *History) GetPairNotes(syncID string) []Note {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return append([]Note(nil), h.PairNotes[syncID]...)
+}
+
+// AllPairNotes returns the notes attached to every pair
+func (h *History) AllPairNotes() map[string][]Note {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	notes := make(map[string][]Note, len(h.PairNotes))
+	for id, pairNotes := range h.PairNotes {
+		notes[id] = append([]Note(nil), pairNotes...)
+	}
+	return notes
+}
+
+// save writes the history to disk, if a path is configured
+func (h *History) save() error {
+	if h.path == "" {
+		return nil
+	}
+
+	h.mu.RLock()
+	data, err := json.MarshalIndent(h, "", "  ")
+	h.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash can't leave a truncated history
+	tmp, err := os.CreateTemp(filepath.Dir(h.path), ".history-*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), h.path)
+}
@@ -0,0 +1,166 @@
+package dirsync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestHistoryRecordsRuns tests recording runs and persisting them
+func TestHistoryRecordsRuns(t *testing.T) {
+	historyDir, err := os.MkdirTemp("", "dirsync_test_history")
+	if err != nil {
+		t.Fatalf("Failed to create history directory: %v", err)
+	}
+	defer os.RemoveAll(historyDir)
+
+	historyPath := filepath.Join(historyDir, "history.json")
+	history, err := NewHistory(historyPath, 0)
+	if err != nil {
+		t.Fatalf("NewHistory failed: %v", err)
+	}
+
+	run1 := history.StartRun("pair1")
+	history.FinishRun(run1, nil)
+
+	run2 := history.StartRun("pair2")
+	history.FinishRun(run2, errors.New("disk full"))
+
+	if run1.ID == run2.ID {
+		t.Errorf("Run IDs should be unique")
+	}
+
+	if runs := history.ListRuns(""); len(runs) != 2 {
+		t.Errorf("Expected 2 runs, got %d", len(runs))
+	}
+
+	runs := history.ListRuns("pair2")
+	if len(runs) != 1 || runs[0].Success || runs[0].Error != "disk full" {
+		t.Errorf("Expected one failed run for pair2, got %+v", runs)
+	}
+
+	// Annotate and reload from disk
+	if found, err := history.AnnotateRun(run1.ID, "verified restore"); !found || err != nil {
+		t.Fatalf("AnnotateRun failed: %v, %v", found, err)
+	}
+	if found, _ := history.AnnotateRun("nonexistent", "note"); found {
+		t.Errorf("AnnotateRun should not find a non-existent run")
+	}
+	if err := history.AnnotatePair("pair2", "disk swapped"); err != nil {
+		t.Fatalf("AnnotatePair failed: %v", err)
+	}
+
+	reloaded, err := NewHistory(historyPath, 0)
+	if err != nil {
+		t.Fatalf("Failed to reload history: %v", err)
+	}
+
+	run, ok := reloaded.GetRun(run1.ID)
+	if !ok {
+		t.Fatalf("Run %s not found after reload", run1.ID)
+	}
+	if len(run.Notes) != 1 || run.Notes[0].Text != "verified restore" {
+		t.Errorf("Expected run note after reload, got %+v", run.Notes)
+	}
+
+	if notes := reloaded.GetPairNotes("pair2"); len(notes) != 1 || notes[0].Text != "disk swapped" {
+		t.Errorf("Expected pair note after reload, got %+v", notes)
+	}
+}
+
+// TestHistoryLimit tests that only the most recent runs are kept
+func TestHistoryLimit(t *testing.T) {
+	history, _ := NewHistory("", 3)
+
+	var last *RunRecord
+	for i := 0; i < 5; i++ {
+		last = history.StartRun("pair")
+	}
+
+	runs := history.ListRuns("")
+	if len(runs) != 3 {
+		t.Fatalf("Expected 3 runs, got %d", len(runs))
+	}
+
+	if runs[2].ID != last.ID {
+		t.Errorf("Expected the most recent run to be kept")
+	}
+}
+
+// TestHistoryPairTotals tests that pair totals add up all finished runs,
+// including ones dropped beyond the limit, and survive a reload
+func TestHistoryPairTotals(t *testing.T) {
+	historyPath := filepath.Join(t.TempDir(), "history.json")
+	history, _ := NewHistory(historyPath, 2)
+
+	for i := 0; i < 4; i++ {
+		run := history.StartRun("pair")
+		run.StartTime = run.StartTime.Add(-2 * time.Second)
+		var runErr error
+		if i == 3 {
+			runErr = errors.New("disk full")
+		}
+		history.finish(run, TransferStats{BytesTransferred: 100, FilesCopied: 2}, "", runErr)
+	}
+	history.SkipRun("pair", "maintenance")
+
+	check := func(h *History) {
+		t.Helper()
+		totals := h.GetPairTotals("pair")
+		if totals.Runs != 4 || totals.Failures != 1 || totals.BytesTransferred != 400 || totals.FilesCopied != 8 {
+			t.Errorf("Unexpected totals: %+v", totals)
+		}
+		if totals.FailureRate != 0.25 || totals.AverageDurationSeconds < 2 || totals.AverageDurationSeconds > 3 {
+			t.Errorf("Unexpected failure rate or average duration: %+v", totals)
+		}
+	}
+	check(history)
+
+	reloaded, err := NewHistory(historyPath, 2)
+	if err != nil {
+		t.Fatalf("Failed to reload history: %v", err)
+	}
+	check(reloaded)
+
+	if totals := reloaded.GetPairTotals("other"); totals != (PairTotals{}) {
+		t.Errorf("Expected no totals for a pair without runs, got %+v", totals)
+	}
+}
+
+// TestHistorySnapshot tests copying a run while notes are added to it, as
+// happens when a run is annotated while its notification goes out
+func TestHistorySnapshot(t *testing.T) {
+	history, err := NewHistory(filepath.Join(t.TempDir(), "history.json"), 0)
+	if err != nil {
+		t.Fatalf("NewHistory failed: %v", err)
+	}
+	run := history.StartRun("pair1")
+	history.FinishRun(run, nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			history.AnnotateRun(run.ID, "note")
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		snapshot := history.snapshot(run)
+		for _, note := range snapshot.Notes {
+			if note.Text != "note" {
+				t.Fatalf("Expected whole notes in the snapshot, got %+v", note)
+			}
+		}
+	}
+	<-done
+
+	if got := history.snapshot(run); len(got.Notes) != 50 {
+		t.Errorf("Expected all 50 notes, got %d", len(got.Notes))
+	}
+	var none *History
+	if got := none.snapshot(run); got.ID != run.ID {
+		t.Errorf("Expected a copy of the run without a history")
+	}
+}
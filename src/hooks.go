@@ -0,0 +1,194 @@
+package dirsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrSkipFile is returned by a hook's OnFile to leave a file uncopied
+// without failing the run
+var ErrSkipFile = errors.New("skip file")
+
+// Hook extends what happens during a run. Hooks are registered by name with
+// RegisterHook and enabled per pair in its hooks list. Pairs with hooks
+// always use the built-in copier, so OnFile sees every file.
+type Hook interface {
+	// OnSyncStart is called before a run copies anything; an error fails the run
+	OnSyncStart(ctx context.Context, event HookEvent) error
+
+	// OnFile is called before a new or changed file is copied. ErrSkipFile
+	// skips the file, any other error fails the run.
+	OnFile(ctx context.Context, event HookEvent) error
+
+	// OnSyncEnd is called when the run is done, with its error if it failed
+	OnSyncEnd(ctx context.Context, event HookEvent, runErr error)
+}
+
+// HookEvent describes the run, and for OnFile the file, a hook is called for
+type HookEvent struct {
+	SyncID      string
+	Name        string
+	Source      string
+	Destination string
+
+	// File is the path of the file being copied relative to the source,
+	// only set for OnFile
+	File string
+}
+
+// NopHook implements Hook doing nothing, for embedding in hooks that only
+// need some of the methods
+type NopHook struct{}
+
+// OnSyncStart implements Hook
+func (NopHook) OnSyncStart(context.Context, HookEvent) error { return nil }
+
+// OnFile implements Hook
+func (NopHook) OnFile(context.Context, HookEvent) error { return nil }
+
+// OnSyncEnd implements Hook
+func (NopHook) OnSyncEnd(context.Context, HookEvent, error) {}
+
+var (
+	hooksMu sync.RWMutex
+	hooks   = make(map[string]Hook)
+)
+
+// RegisterHook makes a hook available to pairs under name, replacing any
+// hook registered with the same name. Compiled-in hooks usually register
+// themselves from an init function.
+func RegisterHook(name string, hook Hook) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	hooks[name] = hook
+}
+
+// LookupHook returns the hook registered under name
+func LookupHook(name string) (Hook, bool) {
+	hooksMu.RLock()
+	defer hooksMu.RUnlock()
+	hook, ok := hooks[name]
+	return hook, ok
+}
+
+// HookNames returns the names of all registered hooks, sorted
+func HookNames() []string {
+	hooksMu.RLock()
+	defer hooksMu.RUnlock()
+
+	names := make([]string, 0, len(hooks))
+	for name := range hooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pairHooks looks up the hooks enabled for the pair
+func (s *Sync) pairHooks() ([]Hook, error) {
+	var enabled []Hook
+	for _, name := range s.Pair.Hooks {
+		hook, ok := LookupHook(name)
+		if !ok {
+			return nil, fmt.Errorf("hook %q is not registered", name)
+		}
+		enabled = append(enabled, hook)
+	}
+	return enabled, nil
+}
+
+// fileHooks runs the OnFile hooks for the file at rel and reports whether
+// it should be copied
+func (c *fileCopier) fileHooks(rel string) (bool, error) {
+	for _, hook := range c.hooks {
+		err := hook.OnFile(c.ctx, c.sync.hookEvent(rel))
+		if errors.Is(err, ErrSkipFile) {
+			c.sync.appendOutput("Skipped by hook: " + filepath.ToSlash(rel))
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// hookEvent returns the event passed to the sync's hooks
+func (s *Sync) hookEvent(file string) HookEvent {
+	return HookEvent{SyncID: s.ID, Name: s.Name(), Source: s.SourcePath, Destination: s.DestinationPath, File: file}
+}
+
+// ExecHook is a hook that runs a shell command for every event, so hooks can
+// be written in any language. The command gets the event in DIRSYNC_EVENT
+// (start, file or end), the pair in DIRSYNC_SYNC_ID, DIRSYNC_SOURCE and
+// DIRSYNC_DESTINATION, the full path of the file in DIRSYNC_FILE and the
+// run's error in DIRSYNC_ERROR, with its class from ErrorClass in
+// DIRSYNC_ERROR_CLASS. For files, exit status 1 skips the file;
+// any other non-zero status fails the run.
+type ExecHook struct {
+	Command string
+}
+
+// OnSyncStart implements Hook
+func (h ExecHook) OnSyncStart(ctx context.Context, event HookEvent) error {
+	_, err := h.run(ctx, "start", event, "", "")
+	return err
+}
+
+// OnFile implements Hook
+func (h ExecHook) OnFile(ctx context.Context, event HookEvent) error {
+	code, err := h.run(ctx, "file", event, "", "")
+	if code == 1 {
+		return ErrSkipFile
+	}
+	return err
+}
+
+// OnSyncEnd implements Hook
+func (h ExecHook) OnSyncEnd(ctx context.Context, event HookEvent, runErr error) {
+	errText := ""
+	if runErr != nil {
+		errText = runErr.Error()
+	}
+	h.run(ctx, "end", event, errText, ErrorClass(runErr))
+}
+
+// run runs the command for an event and returns its exit code
+func (h ExecHook) run(ctx context.Context, name string, event HookEvent, errText, errClass string) (int, error) {
+	file := ""
+	if event.File != "" {
+		file = filepath.Join(event.Source, event.File)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", h.Command)
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(),
+		"DIRSYNC_EVENT="+name,
+		"DIRSYNC_SYNC_ID="+event.SyncID,
+		"DIRSYNC_PAIR_NAME="+event.Name,
+		"DIRSYNC_SOURCE="+event.Source,
+		"DIRSYNC_DESTINATION="+event.Destination,
+		"DIRSYNC_FILE="+file,
+		"DIRSYNC_ERROR="+errText,
+		"DIRSYNC_ERROR_CLASS="+errClass,
+	)
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), fmt.Errorf("%s hook failed: %v: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	if err != nil {
+		return -1, fmt.Errorf("%s hook failed: %v", name, err)
+	}
+	return 0, nil
+}
@@ -0,0 +1,97 @@
+package dirsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingHook records the events it sees and skips file2.txt
+type recordingHook struct {
+	NopHook
+	mu     sync.Mutex
+	events []string
+}
+
+func (h *recordingHook) OnSyncStart(ctx context.Context, event HookEvent) error {
+	h.record("start")
+	return nil
+}
+
+func (h *recordingHook) OnFile(ctx context.Context, event HookEvent) error {
+	h.record("file " + filepath.ToSlash(event.File))
+	if event.File == "file2.txt" {
+		return ErrSkipFile
+	}
+	return nil
+}
+
+func (h *recordingHook) OnSyncEnd(ctx context.Context, event HookEvent, runErr error) {
+	h.record("end")
+}
+
+func (h *recordingHook) record(event string) {
+	h.mu.Lock()
+	h.events = append(h.events, event)
+	h.mu.Unlock()
+}
+
+// TestSyncWithHooks tests calling a registered hook during a run
+func TestSyncWithHooks(t *testing.T) {
+	hook := &recordingHook{}
+	RegisterHook("test-recording", hook)
+
+	destDir := t.TempDir()
+	testSync := NewSync(testSourceDir, destDir, 60)
+	testSync.Pair.Hooks = []string{"test-recording"}
+
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	if len(hook.events) != 5 || hook.events[0] != "start" || hook.events[4] != "end" {
+		t.Errorf("Unexpected hook events: %v", hook.events)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "file2.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected file2.txt to be skipped by the hook")
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "file1.txt")); err != nil {
+		t.Errorf("Expected file1.txt to be copied: %v", err)
+	}
+
+	// Unchanged files aren't passed to hooks again
+	hook.events = nil
+	testSync.SyncDirectories()
+	if strings.Join(hook.events, ",") != "start,file file2.txt,end" {
+		t.Errorf("Unexpected hook events on second run: %v", hook.events)
+	}
+
+	testSync.Pair.Hooks = []string{"missing"}
+	if err := testSync.SyncDirectories(); err == nil || !strings.Contains(err.Error(), "not registered") {
+		t.Errorf("Expected error for unregistered hook, got %v", err)
+	}
+}
+
+// TestExecHook tests hooks that run shell commands
+func TestExecHook(t *testing.T) {
+	hook := ExecHook{Command: `case "$DIRSYNC_EVENT:$DIRSYNC_FILE" in *.exe) exit 1;; *virus*) echo infected >&2; exit 2;; esac`}
+	event := HookEvent{SyncID: "a:b", Source: "/src", Destination: "/dst"}
+
+	if err := hook.OnSyncStart(context.Background(), event); err != nil {
+		t.Errorf("Expected start to succeed, got %v", err)
+	}
+
+	event.File = "setup.exe"
+	if err := hook.OnFile(context.Background(), event); !errors.Is(err, ErrSkipFile) {
+		t.Errorf("Expected ErrSkipFile for exit status 1, got %v", err)
+	}
+
+	event.File = "virus.txt"
+	if err := hook.OnFile(context.Background(), event); err == nil || !strings.Contains(err.Error(), "infected") {
+		t.Errorf("Expected error with the hook's output, got %v", err)
+	}
+}
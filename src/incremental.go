@@ -0,0 +1,53 @@
+package dirsync
+
+// Phases of pairs with incremental set
+const (
+	// phaseInitial runs copy everything, checking each file against the
+	// destination, until one completes
+	phaseInitial = "initial"
+
+	// phaseIncremental runs trust the manifest the initial sync built, only
+	// copying files whose size or modification time changed since
+	phaseIncremental = "incremental"
+)
+
+// syncPhase returns the phase of a pair with incremental set, or "" for
+// other pairs. The phase comes from the manifest in the destination, read
+// once and then kept up to date by the runs.
+func (s *Sync) syncPhase() string {
+	if !s.Pair.Incremental {
+		return ""
+	}
+
+	s.mu.RLock()
+	phase := s.phase
+	s.mu.RUnlock()
+	if phase != "" {
+		return phase
+	}
+
+	phase = loadManifest(s.DestinationPath).phase()
+	s.mu.Lock()
+	s.phase = phase
+	s.mu.Unlock()
+	return phase
+}
+
+// setSyncPhase records the phase of a pair with incremental set
+func (s *Sync) setSyncPhase(phase string) {
+	s.mu.Lock()
+	s.phase = phase
+	s.mu.Unlock()
+}
+
+// phase returns the phase a manifest is in: incremental once a complete
+// run has finished the initial sync
+func (m *manifest) phase() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.InitialSync.IsZero() {
+		return phaseInitial
+	}
+	return phaseIncremental
+}
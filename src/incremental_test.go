@@ -0,0 +1,64 @@
+package dirsync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestIncrementalSync tests that the initial sync checks every file, later
+// runs trust the manifest, and the phase shows in the status
+func TestIncrementalSync(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	os.WriteFile(filepath.Join(sourceDir, "a.txt"), []byte("a"), 0644)
+
+	testSync := NewSync(sourceDir, destDir, 60)
+	testSync.Pair.Incremental = true
+	if phase := testSync.GetStatus()["sync_phase"]; phase != phaseInitial {
+		t.Fatalf("Expected the initial phase before the first run, got %v", phase)
+	}
+
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if loadManifest(destDir).phase() != phaseIncremental {
+		t.Fatalf("Expected the manifest to record the initial sync")
+	}
+	if phase := testSync.GetStatus()["sync_phase"]; phase != phaseIncremental {
+		t.Errorf("Expected the incremental phase after a complete run, got %v", phase)
+	}
+
+	// Incremental runs skip files the manifest knows without looking at
+	// the destination
+	os.Remove(filepath.Join(destDir, "a.txt"))
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected a.txt to be skipped in the incremental phase")
+	}
+
+	// A manifest left by an initial sync that didn't finish isn't trusted
+	m := loadManifest(destDir)
+	m.InitialSync = time.Time{}
+	m.dirty = true
+	if err := m.save(); err != nil {
+		t.Fatalf("Failed to save the manifest: %v", err)
+	}
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "a.txt")); err != nil {
+		t.Errorf("Expected the initial sync to copy a.txt again: %v", err)
+	}
+	if phase := testSync.GetStatus()["sync_phase"]; phase != phaseIncremental {
+		t.Errorf("Expected the incremental phase again, got %v", phase)
+	}
+
+	plain := NewSync(sourceDir, destDir, 60)
+	if phase := plain.GetStatus()["sync_phase"]; phase != "" {
+		t.Errorf("Expected no phase without incremental, got %v", phase)
+	}
+}
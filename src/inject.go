@@ -0,0 +1,86 @@
+package dirsync
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Failures that can be injected into a pair's next run
+const (
+	failureTransferError = "transfer_error"
+	failureSlowTransfer  = "slow_transfer"
+	failureDiskFull      = "disk_full"
+)
+
+// defaultSlowTransferDelay is how long a simulated slow transfer stalls by default
+const defaultSlowTransferDelay = 30 * time.Second
+
+// InjectedFailure is a simulated failure applied to a sync's next run
+type InjectedFailure struct {
+	Kind  string
+	Delay time.Duration
+}
+
+// NewInjectedFailure validates a failure kind and optional delay in seconds
+func NewInjectedFailure(kind, delay string) (*InjectedFailure, error) {
+	failure := &InjectedFailure{Kind: kind}
+
+	switch kind {
+	case failureTransferError, failureDiskFull:
+	case failureSlowTransfer:
+		failure.Delay = defaultSlowTransferDelay
+		if delay != "" {
+			seconds, err := strconv.Atoi(delay)
+			if err != nil || seconds < 0 {
+				return nil, fmt.Errorf("invalid delay: %s", delay)
+			}
+			failure.Delay = time.Duration(seconds) * time.Second
+		}
+	default:
+		return nil, fmt.Errorf("unknown failure: %s", kind)
+	}
+
+	return failure, nil
+}
+
+// InjectFailure makes the next run of the sync fail (or stall) in the given way
+func (s *Sync) InjectFailure(failure *InjectedFailure) {
+	s.mu.Lock()
+	s.injectedFailure = failure
+	s.mu.Unlock()
+}
+
+// takeInjectedFailure returns and clears the failure injected into the next run
+func (s *Sync) takeInjectedFailure() *InjectedFailure {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	failure := s.injectedFailure
+	s.injectedFailure = nil
+	return failure
+}
+
+// apply simulates the failure. Errors are returned for failures that should
+// abort the run; a slow transfer only stalls it.
+func (f *InjectedFailure) apply(s *Sync) error {
+	log.Printf("[%s] Applying injected failure: %s", s.ID, f.Kind)
+	s.appendOutput("Simulating failure: " + f.Kind)
+
+	switch f.Kind {
+	case failureTransferError:
+		return errors.New("simulated transfer error")
+	case failureDiskFull:
+		return fmt.Errorf("simulated write failure: %w", syscall.ENOSPC)
+	case failureSlowTransfer:
+		deadline := time.Now().Add(f.Delay)
+		for time.Now().Before(deadline) && !s.isPaused() {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	return nil
+}
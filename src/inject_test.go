@@ -0,0 +1,65 @@
+package dirsync
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestNewInjectedFailure tests validating injected failures
+func TestNewInjectedFailure(t *testing.T) {
+	if _, err := NewInjectedFailure("unknown", ""); err == nil {
+		t.Errorf("Expected error for unknown failure, got nil")
+	}
+
+	if _, err := NewInjectedFailure(failureSlowTransfer, "abc"); err == nil {
+		t.Errorf("Expected error for invalid delay, got nil")
+	}
+
+	failure, err := NewInjectedFailure(failureSlowTransfer, "")
+	if err != nil || failure.Delay != defaultSlowTransferDelay {
+		t.Errorf("Expected default delay, got %v (%v)", failure, err)
+	}
+
+	failure, err = NewInjectedFailure(failureSlowTransfer, "5")
+	if err != nil || failure.Delay != 5*time.Second {
+		t.Errorf("Expected 5s delay, got %v (%v)", failure, err)
+	}
+}
+
+// TestInjectedFailureAppliesToNextRun tests that an injected failure fails only the next run
+func TestInjectedFailureAppliesToNextRun(t *testing.T) {
+	destDir, err := os.MkdirTemp("", "dirsync_test_inject_dest")
+	if err != nil {
+		t.Fatalf("Failed to create destination directory: %v", err)
+	}
+	defer os.RemoveAll(destDir)
+
+	manager := NewSyncManager(Options{})
+	testSync := manager.AddSync(testSourceDir, destDir, 60)
+
+	failure, _ := NewInjectedFailure(failureDiskFull, "")
+	testSync.InjectFailure(failure)
+
+	err = testSync.SyncDirectories()
+	if !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("Expected simulated disk full error, got %v", err)
+	}
+
+	if !strings.Contains(testSync.LastError, "Injected failure") {
+		t.Errorf("Expected LastError to mention the injected failure, got %s", testSync.LastError)
+	}
+
+	runs := manager.History.ListRuns(testSync.ID)
+	if len(runs) != 1 || runs[0].Success {
+		t.Errorf("Expected a failed run in the history, got %+v", runs)
+	}
+
+	// The next run should succeed again
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Errorf("Expected next run to succeed, got %v", err)
+	}
+}
@@ -0,0 +1,351 @@
+package dirsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// interruptedError is recorded for runs the process died during
+	interruptedError = "Interrupted: dirsync stopped before the run finished"
+
+	// checkpointInterval limits how often progress is written to the
+	// journal, so checkpoints stay cheap on trees of small directories
+	checkpointInterval = 5 * time.Second
+)
+
+// stagingFile matches the temporary files the built-in copier writes before
+// renaming them into place, e.g. ".photo.jpg.tmp123456" or ".photo.jpg.link"
+var stagingFile = regexp.MustCompile(`^\..+\.(tmp\d+|link)$`)
+
+// JournalEntry is a run in progress
+type JournalEntry struct {
+	RunID       string    `json:"run_id"`
+	SyncID      string    `json:"sync_id"`
+	Destination string    `json:"destination"`
+	PartialDir  string    `json:"partial_dir,omitempty"`
+	StartTime   time.Time `json:"start_time"`
+
+	// Completed are directories the built-in copier has finished copying,
+	// relative to the source, which a resumed run skips
+	Completed []string `json:"completed,omitempty"`
+}
+
+// Journal records the runs in progress in a file, so runs that were still
+// going when the process died can be found when it starts again
+type Journal struct {
+	path        string
+	active      map[string]JournalEntry
+	interrupted []JournalEntry
+	lastSaved   time.Time
+	mu          sync.Mutex
+}
+
+// OpenJournal opens the journal at path. Runs left in it by a previous
+// process were interrupted and are returned by Interrupted.
+func OpenJournal(path string) (*Journal, error) {
+	j := &Journal{path: path, active: make(map[string]JournalEntry)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &j.interrupted); err != nil {
+		return nil, fmt.Errorf("error parsing journal %s: %w", path, err)
+	}
+	return j, nil
+}
+
+// Interrupted returns the runs that were in progress when the previous
+// process stopped
+func (j *Journal) Interrupted() []JournalEntry {
+	if j == nil {
+		return nil
+	}
+	return j.interrupted
+}
+
+// begin records that a run started
+func (j *Journal) begin(entry JournalEntry) {
+	if j == nil {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.active[entry.RunID] = entry
+	if err := j.save(); err != nil {
+		log.Printf("[%s] Error writing run journal: %v", entry.SyncID, err)
+	}
+}
+
+// end records that a run finished, however it ended
+func (j *Journal) end(runID string) {
+	if j == nil {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	delete(j.active, runID)
+	if err := j.save(); err != nil {
+		log.Printf("Error writing run journal: %v", err)
+	}
+}
+
+// checkpoint records the directories a run has completed so far. The
+// journal is written at most every checkpointInterval, so a crash loses at
+// most that much progress.
+func (j *Journal) checkpoint(runID string, completed []string) {
+	if j == nil {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry, ok := j.active[runID]
+	if !ok {
+		return
+	}
+	entry.Completed = completed
+	j.active[runID] = entry
+
+	if time.Since(j.lastSaved) < checkpointInterval {
+		return
+	}
+	if err := j.save(); err != nil {
+		log.Printf("[%s] Error writing run journal: %v", entry.SyncID, err)
+	}
+}
+
+// save writes the active runs to the journal in one step, replacing any
+// runs left by the previous process once they have been recovered
+func (j *Journal) save() error {
+	entries := make([]JournalEntry, 0, len(j.active)+len(j.interrupted))
+	entries = append(entries, j.interrupted...)
+	for _, entry := range j.active {
+		entries = append(entries, entry)
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	j.lastSaved = time.Now()
+
+	tmp := j.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// recovered forgets the interrupted runs once they have been dealt with
+func (j *Journal) recovered() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.interrupted = nil
+	return j.save()
+}
+
+// markInterrupted records a run the process died during as failed, adding
+// it to the history if it was never saved there
+func (h *History) markInterrupted(entry JournalEntry) error {
+	h.mu.Lock()
+	var run *RunRecord
+	for _, r := range h.Runs {
+		if r.ID == entry.RunID {
+			run = r
+			break
+		}
+	}
+	if run == nil {
+		run = &RunRecord{ID: entry.RunID, SyncID: entry.SyncID, StartTime: entry.StartTime}
+		h.Runs = append(h.Runs, run)
+		if len(h.Runs) > h.limit {
+			h.Runs = h.Runs[len(h.Runs)-h.limit:]
+		}
+	}
+	run.EndTime = time.Now()
+	run.Success = false
+	run.Error = interruptedError
+	run.Interrupted = true
+	h.mu.Unlock()
+
+	return h.save()
+}
+
+// cleanStaging removes the temporary files an interrupted run left in the
+// destination. Only files written since the run started are touched, so
+// files that merely look like staging files are safe. It returns the number
+// of files removed.
+func cleanStaging(dest string, since time.Time) (int, error) {
+	removed := 0
+	err := filepath.WalkDir(dest, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() || !stagingFile.MatchString(d.Name()) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil || info.ModTime().Before(since) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
+
+// RecoverInterrupted deals with the runs that were in progress when the
+// previous process died: they are marked as interrupted in the history and
+// the temporary files they left in their destinations are removed. With
+// resume, pairs that were interrupted run again right away, skipping the
+// directories the interrupted run had completed, and every other pair waits
+// for them before its first run; otherwise their partial files are removed
+// as well, since no run is about to pick them up. It must be
+// called before Start, and returns the interrupted runs.
+func (sm *SyncManager) RecoverInterrupted(resume bool) []JournalEntry {
+	interrupted := sm.Journal.Interrupted()
+	if len(interrupted) == 0 {
+		return nil
+	}
+
+	resumed := make(map[*Sync]bool)
+	for _, entry := range interrupted {
+		log.Printf("[%s] Run %s started %s was interrupted", entry.SyncID, entry.RunID, entry.StartTime.Format(time.RFC3339))
+
+		if err := sm.History.markInterrupted(entry); err != nil {
+			log.Printf("[%s] Error saving history: %v", entry.SyncID, err)
+		}
+
+		if removed, err := cleanStaging(entry.Destination, entry.StartTime); err != nil {
+			log.Printf("[%s] Error removing temporary files: %v", entry.SyncID, err)
+		} else if removed > 0 {
+			log.Printf("[%s] Removed %d temporary files left by the interrupted run", entry.SyncID, removed)
+		}
+
+		if s := sm.GetSyncByID(entry.SyncID); s != nil && resume {
+			resumed[s] = true
+			s.mu.Lock()
+			s.resumeCompleted = entry.Completed
+			s.mu.Unlock()
+			continue
+		}
+
+		if entry.PartialDir != "" {
+			if removed, err := cleanStalePartials(entry.Destination, entry.PartialDir, 0); err != nil {
+				log.Printf("[%s] Error removing partial files: %v", entry.SyncID, err)
+			} else if removed > 0 {
+				log.Printf("[%s] Removed %d partial files left by the interrupted run", entry.SyncID, removed)
+			}
+		}
+	}
+
+	if err := sm.Journal.recovered(); err != nil {
+		log.Printf("Error writing run journal: %v", err)
+	}
+
+	if len(resumed) > 0 {
+		sm.prioritize(resumed)
+	}
+	return interrupted
+}
+
+// prioritize runs the given syncs right away, holding every other sync's
+// first run back until they have all finished one run
+func (sm *SyncManager) prioritize(first map[*Sync]bool) {
+	var wg sync.WaitGroup
+	released := make(chan struct{})
+
+	sm.mu.RLock()
+	for _, s := range sm.Syncs {
+		s.mu.Lock()
+		if first[s] {
+			wg.Add(1)
+			s.NextSyncTime = time.Now()
+			s.Paused = false
+			s.firstRunDone = wg.Done
+			s.Output = "Resuming interrupted run"
+		} else {
+			s.waitBeforeFirstRun = released
+		}
+		s.mu.Unlock()
+	}
+	sm.mu.RUnlock()
+
+	go func() {
+		wg.Wait()
+		close(released)
+	}()
+}
+
+// completedDirs tracks the directories a run of the built-in copier has
+// finished, keeping only the outermost ones since a completed directory
+// implies its subdirectories
+type completedDirs struct {
+	dirs map[string]bool
+	mu   sync.Mutex
+}
+
+// newCompletedDirs starts tracking from the directories an interrupted run completed
+func newCompletedDirs(resumed []string) *completedDirs {
+	cd := &completedDirs{dirs: make(map[string]bool, len(resumed))}
+	for _, dir := range resumed {
+		cd.dirs[dir] = true
+	}
+	return cd
+}
+
+// done reports whether rel was completed by an interrupted run
+func (cd *completedDirs) done(rel string) bool {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	return cd.dirs[filepath.ToSlash(rel)]
+}
+
+// complete marks rel as completed and returns all completed directories
+func (cd *completedDirs) complete(rel string) []string {
+	rel = filepath.ToSlash(rel)
+
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	for dir := range cd.dirs {
+		if strings.HasPrefix(dir, rel+"/") {
+			delete(cd.dirs, dir)
+		}
+	}
+	cd.dirs[rel] = true
+
+	list := make([]string, 0, len(cd.dirs))
+	for dir := range cd.dirs {
+		list = append(list, dir)
+	}
+	sort.Strings(list)
+	return list
+}
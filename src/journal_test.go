@@ -0,0 +1,199 @@
+package dirsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestJournalFindsInterruptedRuns tests that runs left in the journal are
+// reported by the next process
+func TestJournalFindsInterruptedRuns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.json")
+
+	journal, err := OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal failed: %v", err)
+	}
+	journal.begin(JournalEntry{RunID: "finished", SyncID: "a:b"})
+	journal.begin(JournalEntry{RunID: "running", SyncID: "c:d"})
+	journal.end("finished")
+
+	// The process dies here, and the next one opens the journal
+	journal, err = OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal failed: %v", err)
+	}
+	interrupted := journal.Interrupted()
+	if len(interrupted) != 1 || interrupted[0].RunID != "running" {
+		t.Errorf("Expected the unfinished run to be interrupted, got %+v", interrupted)
+	}
+}
+
+// TestRecoverInterrupted tests recording and cleaning up after interrupted runs
+func TestRecoverInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	destDir := filepath.Join(dir, "dest")
+	os.MkdirAll(filepath.Join(destDir, "sub", ".partial"), 0755)
+
+	start := time.Now().Add(-time.Minute)
+	old := start.Add(-time.Hour)
+
+	// A staging file from the interrupted run, an older file that only looks
+	// like one, and a partial file
+	staging := filepath.Join(destDir, "sub", ".big.iso.tmp123456")
+	lookalike := filepath.Join(destDir, ".notes.link")
+	partial := filepath.Join(destDir, "sub", ".partial", "big.iso")
+	for _, path := range []string{staging, lookalike, partial} {
+		os.WriteFile(path, []byte("data"), 0644)
+	}
+	os.Chtimes(lookalike, old, old)
+
+	journalPath := filepath.Join(dir, "journal.json")
+	journal, _ := OpenJournal(journalPath)
+	journal.begin(JournalEntry{RunID: "run1", SyncID: "gone:" + destDir, Destination: destDir, PartialDir: ".partial", StartTime: start})
+
+	journal, _ = OpenJournal(journalPath)
+	manager := NewSyncManager(Options{Journal: journal})
+	recovered := manager.RecoverInterrupted(false)
+	if len(recovered) != 1 {
+		t.Fatalf("Expected 1 interrupted run, got %d", len(recovered))
+	}
+
+	run, ok := manager.History.GetRun("run1")
+	if !ok || !run.Interrupted || run.Success || run.Error != interruptedError {
+		t.Errorf("Expected the run to be recorded as interrupted, got %+v", run)
+	}
+
+	if _, err := os.Stat(staging); !os.IsNotExist(err) {
+		t.Errorf("Expected the staging file to be removed")
+	}
+	if _, err := os.Stat(lookalike); err != nil {
+		t.Errorf("Expected a file older than the run to be kept: %v", err)
+	}
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Errorf("Expected the partial file to be removed when not resuming")
+	}
+
+	// Recovered runs aren't reported again
+	journal, _ = OpenJournal(journalPath)
+	if len(journal.Interrupted()) != 0 {
+		t.Errorf("Expected the journal to be cleared, got %+v", journal.Interrupted())
+	}
+}
+
+// TestResumeInterrupted tests that interrupted pairs run before the others
+func TestResumeInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	journalPath := filepath.Join(dir, "journal.json")
+
+	manager := NewSyncManager(Options{})
+	resumed := manager.AddSync(testSourceDir, filepath.Join(dir, "resumed"), 3600)
+	other := manager.AddSync(testSourceDir, filepath.Join(dir, "other"), 3600)
+	resumed.NextSyncTime = time.Now().Add(time.Hour)
+
+	journal, _ := OpenJournal(journalPath)
+	journal.begin(JournalEntry{RunID: "run1", SyncID: resumed.ID, Destination: resumed.DestinationPath, StartTime: time.Now()})
+	journal, _ = OpenJournal(journalPath)
+	manager.Journal = journal
+
+	manager.RecoverInterrupted(true)
+	if other.waitBeforeFirstRun == nil || resumed.firstRunDone == nil {
+		t.Fatalf("Expected the other pair to wait for the resumed one")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	manager.Start(ctx, 3600)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		resumed.mu.RLock()
+		resumedSync := resumed.LastSync
+		resumed.mu.RUnlock()
+		other.mu.RLock()
+		otherSync := other.LastSync
+		other.mu.RUnlock()
+
+		if !resumedSync.IsZero() && !otherSync.IsZero() {
+			if otherSync.Before(resumedSync) {
+				t.Errorf("Expected the resumed pair to finish before the other pair")
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Expected both pairs to run")
+}
+
+// TestCompletedDirs tests that only the outermost completed directories are kept
+func TestCompletedDirs(t *testing.T) {
+	cd := newCompletedDirs(nil)
+	cd.complete("a/b")
+	cd.complete("c")
+	completed := cd.complete("a")
+
+	if len(completed) != 2 || completed[0] != "a" || completed[1] != "c" {
+		t.Errorf("Expected [a c], got %v", completed)
+	}
+	if !cd.done("a") || cd.done("a/b") {
+		t.Errorf("Expected a to be done and a/b to be folded into it")
+	}
+}
+
+// TestResumeSkipsCompletedDirs tests that a resumed run skips the
+// directories the interrupted run had checkpointed
+func TestResumeSkipsCompletedDirs(t *testing.T) {
+	dir := t.TempDir()
+	sourceDir := filepath.Join(dir, "source")
+	destDir := filepath.Join(dir, "dest")
+	for _, name := range []string{"done/file.txt", "todo/file.txt"} {
+		os.MkdirAll(filepath.Join(sourceDir, filepath.Dir(name)), 0755)
+		os.WriteFile(filepath.Join(sourceDir, name), []byte(name), 0644)
+	}
+	journalPath := filepath.Join(dir, "journal.json")
+
+	journal, _ := OpenJournal(journalPath)
+	journal.begin(JournalEntry{RunID: "run1", SyncID: sourceDir + ":" + destDir, Destination: destDir, StartTime: time.Now(), Completed: []string{"done"}})
+	journal, _ = OpenJournal(journalPath)
+
+	manager := NewSyncManager(Options{Journal: journal})
+	s := manager.AddSync(sourceDir, destDir, 3600)
+	manager.RecoverInterrupted(true)
+
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "done", "file.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected the checkpointed directory to be skipped")
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "todo", "file.txt")); err != nil {
+		t.Errorf("Expected the remaining directory to be copied: %v", err)
+	}
+
+	// The next run starts from the beginning again
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "done", "file.txt")); err != nil {
+		t.Errorf("Expected a full run after the resumed one: %v", err)
+	}
+}
+
+// TestCheckpointSaved tests that checkpoints reach the journal file
+func TestCheckpointSaved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.json")
+
+	journal, _ := OpenJournal(path)
+	journal.begin(JournalEntry{RunID: "run1", SyncID: "a:b"})
+	journal.lastSaved = time.Time{}
+	journal.checkpoint("run1", []string{"photos"})
+
+	journal, _ = OpenJournal(path)
+	interrupted := journal.Interrupted()
+	if len(interrupted) != 1 || len(interrupted[0].Completed) != 1 || interrupted[0].Completed[0] != "photos" {
+		t.Errorf("Expected the checkpoint to be saved, got %+v", interrupted)
+	}
+}
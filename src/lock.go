@@ -0,0 +1,43 @@
+package dirsync
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LockFile is the name of the file that holds off runs of a pair while it
+// is in the root of its source or destination, so other tools or people can
+// take over a directory for a while without dirsync touching it
+const LockFile = ".dirsync-lock"
+
+// lockPollInterval is how often a locked pair checks whether its lock file
+// is gone
+var lockPollInterval = 10 * time.Second
+
+// findLockFile returns the path of the lock file in the pair's source or
+// destination root, or "" if neither is locked
+func (s *Sync) findLockFile() string {
+	for _, dir := range []string{s.SourcePath, s.DestinationPath} {
+		path := filepath.Join(dir, LockFile)
+		if _, err := os.Lstat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// checkLock records whether the pair is locked and reports if it is. The
+// output only changes when the pair becomes locked, so a lock held for
+// hours doesn't bury the last run's output.
+func (s *Sync) checkLock() bool {
+	lock := s.findLockFile()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lock != "" && s.lockedBy != lock {
+		s.Output += "\nWaiting for " + lock + " to be removed before syncing"
+	}
+	s.lockedBy = lock
+	return lock != ""
+}
@@ -0,0 +1,74 @@
+package dirsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLockFile tests that a lock file in the source or destination holds
+// off runs until it is removed
+func TestLockFile(t *testing.T) {
+	for _, locked := range []string{"source", "destination"} {
+		sourceDir := t.TempDir()
+		destDir := t.TempDir()
+		os.WriteFile(filepath.Join(sourceDir, "file.txt"), []byte("content"), 0644)
+
+		lock := filepath.Join(sourceDir, LockFile)
+		if locked == "destination" {
+			lock = filepath.Join(destDir, LockFile)
+		}
+		os.WriteFile(lock, nil, 0644)
+
+		syncManager := NewSyncManager(Options{})
+		testSync := syncManager.AddSync(sourceDir, destDir, 60)
+		if err := testSync.Run(context.Background()); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+
+		if _, err := os.Stat(filepath.Join(destDir, "file.txt")); !os.IsNotExist(err) {
+			t.Errorf("Expected nothing to be copied while the %s is locked", locked)
+		}
+		if runs := syncManager.History.ListRuns(""); len(runs) != 0 {
+			t.Errorf("Expected no run to be recorded while locked, got %d", len(runs))
+		}
+		status := testSync.GetStatus()
+		if status["locked_by"] != lock || !strings.Contains(status["output"].(string), "Waiting for "+lock) {
+			t.Errorf("Expected the status to show the lock, got %v", status)
+		}
+
+		os.Remove(lock)
+		if err := testSync.Run(context.Background()); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(destDir, "file.txt")); err != nil {
+			t.Errorf("Expected the pair to sync once the lock is removed: %v", err)
+		}
+		if testSync.GetStatus()["locked_by"] != "" {
+			t.Errorf("Expected the lock to be cleared")
+		}
+	}
+}
+
+// TestLockFileRetry tests that a locked pair checks again soon instead of
+// waiting for its next scheduled run
+func TestLockFileRetry(t *testing.T) {
+	lockPollInterval = 20 * time.Millisecond
+	defer func() { lockPollInterval = 10 * time.Second }()
+
+	destDir := t.TempDir()
+	lock := filepath.Join(destDir, LockFile)
+	os.WriteFile(lock, nil, 0644)
+
+	testSync := NewSync(testSourceDir, destDir, 3600)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	testSync.Start(ctx, 3600)
+
+	time.Sleep(50 * time.Millisecond)
+	os.Remove(lock)
+	waitForSync(t, testSync)
+}
@@ -0,0 +1,463 @@
+package dirsync
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaintenanceConfig points a pair at an iCalendar (ICS) calendar of its
+// destination system's maintenance windows. Runs that would start during a
+// window are skipped.
+type MaintenanceConfig struct {
+	// URL is an http(s) URL or the path of an .ics file
+	URL string `json:"url"`
+
+	// Refresh is how many seconds the calendar is cached (default 3600)
+	Refresh int `json:"refresh"`
+
+	// Match only counts events whose summary contains it, ignoring case,
+	// for calendars that mix maintenance with other events
+	Match string `json:"match"`
+}
+
+const (
+	defaultMaintenanceRefresh = 3600
+
+	// maxOccurrences bounds how far a repeating event is followed
+	maxOccurrences = 100000
+)
+
+// Validate checks that the calendar has a location
+func (c *MaintenanceConfig) Validate() error {
+	if c.URL == "" {
+		return fmt.Errorf("maintenance has no url")
+	}
+	if c.Refresh < 0 {
+		return fmt.Errorf("maintenance refresh %d is negative", c.Refresh)
+	}
+	return nil
+}
+
+// IsRemote reports whether the calendar is fetched over HTTP rather than
+// read from a file
+func (c *MaintenanceConfig) IsRemote() bool {
+	return strings.HasPrefix(c.URL, "http://") || strings.HasPrefix(c.URL, "https://")
+}
+
+// maintenanceWindow is a published maintenance event. Repeating events have
+// a rule giving their later occurrences.
+type maintenanceWindow struct {
+	Summary  string
+	Start    time.Time
+	Duration time.Duration
+	Rule     *recurrence
+}
+
+// recurrence is the part of an RRULE dirsync understands: daily, weekly or
+// monthly repeats with an interval, an end and, for weekly rules, the days
+type recurrence struct {
+	Freq     string
+	Interval int
+	Count    int
+	Until    time.Time
+	ByDay    []time.Weekday
+}
+
+// activeAt returns the end of the occurrence of the window that t falls in
+func (w maintenanceWindow) activeAt(t time.Time) (time.Time, bool) {
+	if w.Rule == nil {
+		end := w.Start.Add(w.Duration)
+		return end, !t.Before(w.Start) && t.Before(end)
+	}
+
+	active, end := false, time.Time{}
+	w.Rule.each(w.Start, func(start time.Time) bool {
+		if start.After(t) {
+			return false
+		}
+		if e := start.Add(w.Duration); t.Before(e) {
+			active, end = true, e
+			return false
+		}
+		return true
+	})
+	return end, active
+}
+
+// each calls fn with the start of each occurrence in order, until fn
+// returns false or the rule ends
+func (r *recurrence) each(first time.Time, fn func(time.Time) bool) {
+	count := 0
+	emit := func(start time.Time) bool {
+		if start.Before(first) {
+			return true
+		}
+		if (!r.Until.IsZero() && start.After(r.Until)) || (r.Count > 0 && count >= r.Count) {
+			return false
+		}
+		count++
+		return fn(start)
+	}
+
+	for i := 0; i < maxOccurrences; i++ {
+		switch {
+		case r.Freq == "WEEKLY" && len(r.ByDay) > 0:
+			// Occurrences on each listed day of every interval'th week,
+			// starting from the week of the first
+			week := first.AddDate(0, 0, -int(first.Weekday())+7*r.Interval*i)
+			for day := time.Sunday; day <= time.Saturday; day++ {
+				if !containsWeekday(r.ByDay, day) {
+					continue
+				}
+				start := week.AddDate(0, 0, int(day))
+				if !emit(start) {
+					return
+				}
+			}
+		case r.Freq == "DAILY":
+			if !emit(first.AddDate(0, 0, r.Interval*i)) {
+				return
+			}
+		case r.Freq == "WEEKLY":
+			if !emit(first.AddDate(0, 0, 7*r.Interval*i)) {
+				return
+			}
+		case r.Freq == "MONTHLY":
+			if !emit(first.AddDate(0, r.Interval*i, 0)) {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// containsWeekday reports whether days includes day
+func containsWeekday(days []time.Weekday, day time.Weekday) bool {
+	for _, d := range days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+var icsWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// parseICS reads the events of an iCalendar file. Cancelled events and
+// events without a length are left out.
+func parseICS(r io.Reader) ([]maintenanceWindow, error) {
+	lines, err := unfoldICS(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var windows []maintenanceWindow
+	var event map[string]icsProperty
+	for _, line := range lines {
+		switch {
+		case line == "BEGIN:VEVENT":
+			event = make(map[string]icsProperty)
+		case line == "END:VEVENT" && event != nil:
+			window, ok, err := eventWindow(event)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				windows = append(windows, window)
+			}
+			event = nil
+		case event != nil:
+			prop := parseICSProperty(line)
+			if _, seen := event[prop.name]; !seen {
+				event[prop.name] = prop
+			}
+		}
+	}
+	return windows, nil
+}
+
+// unfoldICS splits an iCalendar file into lines, joining long lines that
+// were folded onto several
+func unfoldICS(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// icsProperty is a content line such as "DTSTART;TZID=Europe/London:20240501T020000"
+type icsProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// parseICSProperty splits a content line into its name, parameters and value
+func parseICSProperty(line string) icsProperty {
+	head, value, _ := strings.Cut(line, ":")
+	parts := strings.Split(head, ";")
+	prop := icsProperty{name: strings.ToUpper(parts[0]), params: make(map[string]string), value: value}
+	for _, param := range parts[1:] {
+		if key, val, ok := strings.Cut(param, "="); ok {
+			prop.params[strings.ToUpper(key)] = strings.Trim(val, `"`)
+		}
+	}
+	return prop
+}
+
+// eventWindow turns an event's properties into a window
+func eventWindow(event map[string]icsProperty) (maintenanceWindow, bool, error) {
+	if strings.EqualFold(event["STATUS"].value, "CANCELLED") {
+		return maintenanceWindow{}, false, nil
+	}
+
+	dtstart, ok := event["DTSTART"]
+	if !ok {
+		return maintenanceWindow{}, false, nil
+	}
+	start, allDay, err := parseICSTime(dtstart)
+	if err != nil {
+		return maintenanceWindow{}, false, err
+	}
+
+	window := maintenanceWindow{Summary: unescapeICS(event["SUMMARY"].value), Start: start}
+	switch {
+	case event["DTEND"].value != "":
+		end, _, err := parseICSTime(event["DTEND"])
+		if err != nil {
+			return maintenanceWindow{}, false, err
+		}
+		window.Duration = end.Sub(start)
+	case event["DURATION"].value != "":
+		if window.Duration, err = parseICSDuration(event["DURATION"].value); err != nil {
+			return maintenanceWindow{}, false, err
+		}
+	case allDay:
+		window.Duration = 24 * time.Hour
+	}
+	if window.Duration <= 0 {
+		return maintenanceWindow{}, false, nil
+	}
+
+	if rrule := event["RRULE"].value; rrule != "" {
+		if window.Rule, err = parseRRule(rrule, start.Location()); err != nil {
+			return maintenanceWindow{}, false, err
+		}
+	}
+	return window, true, nil
+}
+
+// parseICSTime parses a DATE-TIME in UTC, in a TZID time zone or floating
+// in local time, or a DATE, which it reports as all day
+func parseICSTime(prop icsProperty) (time.Time, bool, error) {
+	loc := time.Local
+	if tzid := prop.params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+
+	value := prop.value
+	switch {
+	case prop.params["VALUE"] == "DATE" || len(value) == 8:
+		t, err := time.ParseInLocation("20060102", value, loc)
+		return t, true, err
+	case strings.HasSuffix(value, "Z"):
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, err
+}
+
+var icsDurationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseICSDuration parses a duration such as "PT2H30M" or "P1D"
+func parseICSDuration(value string) (time.Duration, error) {
+	m := icsDurationPattern.FindStringSubmatch(value)
+	if m == nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var d time.Duration
+	for i, unit := range units {
+		if m[i+2] != "" {
+			n, _ := strconv.Atoi(m[i+2])
+			d += time.Duration(n) * unit
+		}
+	}
+	if m[1] == "-" {
+		d = -d
+	}
+	return d, nil
+}
+
+// parseRRule parses the supported parts of a recurrence rule
+func parseRRule(value string, loc *time.Location) (*recurrence, error) {
+	rule := &recurrence{Interval: 1}
+	for _, part := range strings.Split(value, ";") {
+		key, val, _ := strings.Cut(part, "=")
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(val)
+		case "INTERVAL":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid RRULE interval %q", val)
+			}
+			rule.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid RRULE count %q", val)
+			}
+			rule.Count = n
+		case "UNTIL":
+			until, _, err := parseICSTime(icsProperty{value: val, params: map[string]string{}})
+			if err != nil {
+				return nil, fmt.Errorf("invalid RRULE until %q", val)
+			}
+			rule.Until = until.In(loc)
+		case "BYDAY":
+			for _, day := range strings.Split(val, ",") {
+				weekday, ok := icsWeekdays[strings.ToUpper(day)]
+				if !ok {
+					return nil, fmt.Errorf("unsupported RRULE day %q", day)
+				}
+				rule.ByDay = append(rule.ByDay, weekday)
+			}
+		}
+	}
+
+	switch rule.Freq {
+	case "DAILY", "WEEKLY", "MONTHLY":
+	default:
+		return nil, fmt.Errorf("unsupported RRULE frequency %q", rule.Freq)
+	}
+	return rule, nil
+}
+
+// unescapeICS undoes the escaping of text values
+func unescapeICS(s string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
+
+// maintenanceCalendar caches a pair's maintenance calendar between runs
+type maintenanceCalendar struct {
+	config  MaintenanceConfig
+	fetched time.Time
+	windows []maintenanceWindow
+	mu      sync.Mutex
+}
+
+// load returns the calendar's windows, fetching it again once the cached
+// copy is older than the refresh interval. If fetching fails, the windows
+// fetched last time are used.
+func (c *maintenanceCalendar) load() ([]maintenanceWindow, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	refresh := c.config.Refresh
+	if refresh <= 0 {
+		refresh = defaultMaintenanceRefresh
+	}
+	if !c.fetched.IsZero() && time.Since(c.fetched) < time.Duration(refresh)*time.Second {
+		return c.windows, nil
+	}
+
+	windows, err := fetchMaintenanceCalendar(c.config)
+	if err != nil {
+		return c.windows, err
+	}
+	c.windows, c.fetched = windows, time.Now()
+	return windows, nil
+}
+
+// fetchMaintenanceCalendar reads and parses the calendar, keeping only the
+// events that match
+func fetchMaintenanceCalendar(config MaintenanceConfig) ([]maintenanceWindow, error) {
+	var body io.ReadCloser
+	if config.IsRemote() {
+		client := &http.Client{Timeout: 30 * time.Second}
+		resp, err := client.Get(config.URL)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s returned status %d", config.URL, resp.StatusCode)
+		}
+		body = resp.Body
+	} else {
+		f, err := os.Open(config.URL)
+		if err != nil {
+			return nil, err
+		}
+		body = f
+	}
+	defer body.Close()
+
+	windows, err := parseICS(body)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", config.URL, err)
+	}
+
+	if config.Match == "" {
+		return windows, nil
+	}
+	var matching []maintenanceWindow
+	for _, window := range windows {
+		if strings.Contains(strings.ToLower(window.Summary), strings.ToLower(config.Match)) {
+			matching = append(matching, window)
+		}
+	}
+	return matching, nil
+}
+
+// maintenanceAt returns the maintenance window the pair's destination is in
+// at t, if any. A calendar that can't be read doesn't hold off runs.
+func (s *Sync) maintenanceAt(t time.Time) (maintenanceWindow, time.Time, bool) {
+	if s.Pair.Maintenance == nil {
+		return maintenanceWindow{}, time.Time{}, false
+	}
+
+	s.mu.Lock()
+	if s.maintenance == nil || s.maintenance.config != *s.Pair.Maintenance {
+		s.maintenance = &maintenanceCalendar{config: *s.Pair.Maintenance}
+	}
+	calendar := s.maintenance
+	s.mu.Unlock()
+
+	windows, err := calendar.load()
+	if err != nil {
+		log.Printf("[%s] Error reading maintenance calendar: %v", s.ID, err)
+	}
+	for _, window := range windows {
+		if end, ok := window.activeAt(t); ok {
+			return window, end, true
+		}
+	}
+	return maintenanceWindow{}, time.Time{}, false
+}
@@ -0,0 +1,133 @@
+package dirsync
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testMaintenanceICS = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:NAS firmware\r\n" +
+	"  upgrade\r\n" +
+	"DTSTART:20240501T020000Z\r\n" +
+	"DTEND:20240501T040000Z\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Weekly maintenance\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20240505T010000\r\n" +
+	"DURATION:PT1H30M\r\n" +
+	"RRULE:FREQ=WEEKLY;BYDAY=SU,WE;COUNT=6\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Cancelled maintenance\r\n" +
+	"STATUS:CANCELLED\r\n" +
+	"DTSTART:20240601T000000Z\r\n" +
+	"DTEND:20240602T000000Z\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Team offsite\r\n" +
+	"DTSTART;VALUE=DATE:20240610\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+// TestParseICS tests reading maintenance windows from a calendar
+func TestParseICS(t *testing.T) {
+	windows, err := parseICS(strings.NewReader(testMaintenanceICS))
+	if err != nil {
+		t.Fatalf("parseICS failed: %v", err)
+	}
+	if len(windows) != 3 {
+		t.Fatalf("Expected 3 windows without the cancelled one, got %d", len(windows))
+	}
+	if windows[0].Summary != "NAS firmware upgrade" || windows[0].Duration != 2*time.Hour {
+		t.Errorf("Unexpected first window: %+v", windows[0])
+	}
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("Time zone data isn't available")
+	}
+	tests := []struct {
+		at     time.Time
+		active bool
+	}{
+		{time.Date(2024, 5, 1, 3, 0, 0, 0, time.UTC), true},
+		{time.Date(2024, 5, 1, 4, 0, 0, 0, time.UTC), false},
+		{time.Date(2024, 5, 5, 2, 0, 0, 0, berlin), true},  // first Sunday
+		{time.Date(2024, 5, 8, 1, 15, 0, 0, berlin), true}, // the Wednesday after
+		{time.Date(2024, 5, 9, 1, 15, 0, 0, berlin), false},
+		{time.Date(2024, 5, 22, 2, 29, 0, 0, berlin), true},  // sixth occurrence
+		{time.Date(2024, 5, 26, 1, 15, 0, 0, berlin), false}, // past the count
+		{time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC), false},
+		{time.Date(2024, 6, 10, 12, 0, 0, 0, time.Local), true},
+	}
+	for _, tt := range tests {
+		active := false
+		for _, window := range windows {
+			if _, ok := window.activeAt(tt.at); ok {
+				active = true
+			}
+		}
+		if active != tt.active {
+			t.Errorf("Expected maintenance at %v to be %v", tt.at, tt.active)
+		}
+	}
+
+	if _, err := parseICS(strings.NewReader("BEGIN:VEVENT\nDTSTART:20240501T020000Z\nDURATION:PT1H\nRRULE:FREQ=HOURLY\nEND:VEVENT\n")); err == nil {
+		t.Errorf("Expected unsupported repeats to be rejected")
+	}
+}
+
+// TestMaintenanceSkipsRuns tests that runs during a maintenance window are
+// skipped and recorded with the reason
+func TestMaintenanceSkipsRuns(t *testing.T) {
+	now := time.Now().UTC()
+	calendar := fmt.Sprintf("BEGIN:VCALENDAR\nBEGIN:VEVENT\nSUMMARY:Storage upgrade\nDTSTART:%s\nDTEND:%s\nEND:VEVENT\nEND:VCALENDAR\n",
+		now.Add(-time.Hour).Format("20060102T150405Z"), now.Add(time.Hour).Format("20060102T150405Z"))
+
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(calendar))
+	}))
+	defer ts.Close()
+
+	destDir := t.TempDir()
+	syncManager := NewSyncManager(Options{})
+	testSync := syncManager.AddSync(testSourceDir, destDir, 60)
+	testSync.Pair.Maintenance = &MaintenanceConfig{URL: ts.URL, Match: "upgrade"}
+
+	for i := 0; i < 2; i++ {
+		if err := testSync.Run(context.Background()); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("Expected the calendar to be cached, got %d requests", requests)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "file1.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be copied during maintenance")
+	}
+
+	runs := syncManager.History.ListRuns(testSync.ID)
+	if len(runs) != 2 || !runs[0].Success || !strings.Contains(runs[0].Skipped, `"Storage upgrade"`) {
+		t.Fatalf("Expected skipped runs with the reason, got %+v", runs)
+	}
+
+	// Events that don't match don't hold off runs
+	testSync.Pair.Maintenance = &MaintenanceConfig{URL: ts.URL, Match: "network"}
+	if err := testSync.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "file1.txt")); err != nil {
+		t.Errorf("Expected the run to go ahead outside maintenance: %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package dirsync
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// manifestFile records the files the built-in copier has copied, in the
+// root of the destination
+const manifestFile = ".dirsync-manifest.json"
+
+// manifestEntry describes the source file a destination file was copied from
+type manifestEntry struct {
+	Dest    string    `json:"dest"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+
+	// Hash is the SHA-256 of the content when it was copied, if known
+	Hash string `json:"hash,omitempty"`
+}
+
+// manifest remembers copied files between runs, so files whose size and
+// modification time haven't changed are skipped without even looking at
+// the destination
+type manifest struct {
+	Files map[string]manifestEntry `json:"files"`
+
+	// InitialSync is when the first complete run finished, after which
+	// incremental pairs trust the manifest
+	InitialSync time.Time `json:"initial_sync,omitempty"`
+
+	path  string
+	seen  map[string]bool
+	dirty bool
+	mu    sync.Mutex
+}
+
+// loadManifest reads the manifest from the destination, starting an empty
+// one if there is none
+func loadManifest(dest string) *manifest {
+	m := &manifest{
+		Files: make(map[string]manifestEntry),
+		path:  filepath.Join(dest, manifestFile),
+		seen:  make(map[string]bool),
+	}
+
+	if data, err := os.ReadFile(m.path); err == nil {
+		json.Unmarshal(data, m)
+		if m.Files == nil {
+			m.Files = make(map[string]manifestEntry)
+		}
+	}
+
+	return m
+}
+
+// unchanged reports whether the file at key was copied to destPath from the
+// current version of the source file
+func (m *manifest) unchanged(key, destPath string, srcInfo os.FileInfo) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.seen[key] = true
+	entry, ok := m.Files[key]
+	return ok && entry.Dest == destPath && entry.Size == srcInfo.Size() && entry.ModTime.Equal(srcInfo.ModTime())
+}
+
+// record remembers that destPath holds the current version of the source
+// file, with the hash of its content if it was computed
+func (m *manifest) record(key, destPath string, srcInfo os.FileInfo, h hash.Hash) {
+	entry := manifestEntry{Dest: destPath, Size: srcInfo.Size(), ModTime: srcInfo.ModTime()}
+	if h != nil {
+		entry.Hash = hex.EncodeToString(h.Sum(nil))
+	}
+
+	m.mu.Lock()
+	m.Files[key] = entry
+	m.seen[key] = true
+	m.dirty = true
+	m.mu.Unlock()
+}
+
+// prune forgets files that weren't seen during a complete run, because they
+// no longer exist in the source
+func (m *manifest) prune() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key := range m.Files {
+		if !m.seen[key] {
+			delete(m.Files, key)
+			m.dirty = true
+		}
+	}
+}
+
+// finishInitialSync records that a complete run finished, if none had
+func (m *manifest) finishInitialSync() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.InitialSync.IsZero() {
+		m.InitialSync = time.Now().UTC()
+		m.dirty = true
+	}
+}
+
+// save writes the manifest back to the destination if it changed
+func (m *manifest) save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	m.dirty = false
+	return nil
+}
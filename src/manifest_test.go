@@ -0,0 +1,60 @@
+package dirsync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestSyncWithManifest tests skipping files recorded in the manifest
+func TestSyncWithManifest(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(sourceDir, name), []byte("content of "+name), 0644); err != nil {
+			t.Fatalf("Failed to create source file: %v", err)
+		}
+	}
+
+	testSync := NewSync(sourceDir, destDir, 60)
+	testSync.Pair.Manifest = true
+	testSync.Pair.Delete = true
+
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	m := loadManifest(destDir)
+	if entry, ok := m.Files["a.txt"]; !ok || len(entry.Hash) != 64 {
+		t.Fatalf("Expected a.txt with its hash in the manifest, got %+v", m.Files)
+	}
+
+	// Unchanged files are skipped without looking at the destination
+	os.Remove(filepath.Join(destDir, "a.txt"))
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected a.txt to be skipped because of the manifest")
+	}
+
+	// Changed files are copied again, and removed files are forgotten
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(sourceDir, "a.txt"), later, later)
+	os.Remove(filepath.Join(sourceDir, "b.txt"))
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "a.txt")); err != nil {
+		t.Errorf("Expected changed a.txt to be copied again: %v", err)
+	}
+
+	m = loadManifest(destDir)
+	if _, ok := m.Files["b.txt"]; ok {
+		t.Errorf("Expected b.txt to be removed from the manifest")
+	}
+	if _, err := os.Stat(filepath.Join(destDir, manifestFile)); err != nil {
+		t.Errorf("Expected the manifest to survive deleting: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package dirsync
+
+import (
+	"math/rand"
+	"strings"
+	"sync"
+)
+
+const (
+	// defaultOutputLimit is how much of a running sync's output is kept in
+	// memory when no memory limit is given
+	defaultOutputLimit = 1 << 20
+
+	// minOutputLimit keeps enough output to see what a run is doing even
+	// with a very low memory limit
+	minOutputLimit = 32 << 10
+)
+
+// outputLimit returns how many bytes of the running sync's output are kept.
+// With a memory limit, each pair keeps 1 KB of output per MB.
+func (s *Sync) outputLimit() int {
+	if s.maxMemoryMB <= 0 {
+		return defaultOutputLimit
+	}
+	limit := s.maxMemoryMB << 10
+	if limit < minOutputLimit {
+		return minOutputLimit
+	}
+	if limit > defaultOutputLimit {
+		return defaultOutputLimit
+	}
+	return limit
+}
+
+// outputBuilder collects a run's output, keeping only the last lines once
+// it grows past its limit, so a run over millions of files doesn't hold
+// a line for each of them. Trimming happens once the output reaches twice
+// the limit, so appending stays cheap, and String doesn't copy. rsync's
+// stdout and stderr are read at the same time, so it's safe for concurrent use.
+type outputBuilder struct {
+	mu    sync.Mutex
+	limit int
+	b     strings.Builder
+}
+
+// newOutputBuilder starts an output builder with the output so far
+func newOutputBuilder(limit int, output string) *outputBuilder {
+	ob := &outputBuilder{limit: limit}
+	ob.WriteString(output)
+	return ob
+}
+
+// WriteString appends s, dropping the oldest lines if the output got too long
+func (ob *outputBuilder) WriteString(s string) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.b.WriteString(s)
+	if ob.limit <= 0 || ob.b.Len() <= 2*ob.limit {
+		return
+	}
+
+	output := ob.b.String()
+	tail := output[len(output)-ob.limit:]
+	if i := strings.IndexByte(tail, '\n'); i >= 0 {
+		tail = tail[i+1:]
+	}
+	ob.b = strings.Builder{}
+	ob.b.Grow(2 * ob.limit)
+	ob.b.WriteString("...\n")
+	ob.b.WriteString(tail)
+}
+
+// String returns the output kept so far
+func (ob *outputBuilder) String() string {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	return ob.b.String()
+}
+
+// transferSample keeps a random sample of the files a run transferred for
+// verification, of at most size files however many were transferred
+type transferSample struct {
+	size  int
+	total int
+	files []transferredFile
+}
+
+// newTransferSample returns a sample of up to size files
+func newTransferSample(size int) *transferSample {
+	return &transferSample{size: size}
+}
+
+// add counts a transferred file, keeping it with the same chance as every
+// other file transferred so far (reservoir sampling)
+func (ts *transferSample) add(file transferredFile) {
+	ts.total++
+	if len(ts.files) < ts.size {
+		ts.files = append(ts.files, file)
+		return
+	}
+	if i := rand.Intn(ts.total); i < ts.size {
+		ts.files[i] = file
+	}
+}
@@ -0,0 +1,108 @@
+package dirsync
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestOutputBuilder tests keeping only the last lines of long output
+func TestOutputBuilder(t *testing.T) {
+	ob := newOutputBuilder(1024, "Starting sync\n")
+	for i := 0; i < 10000; i++ {
+		ob.WriteString(fmt.Sprintf("file%05d.txt\n", i))
+	}
+
+	output := ob.String()
+	if len(output) > 2*1024+4 {
+		t.Errorf("Expected at most twice the limit, got %d bytes", len(output))
+	}
+	if !strings.HasPrefix(output, "...\nfile") || !strings.HasSuffix(output, "file09999.txt\n") {
+		t.Errorf("Expected the last whole lines after a marker, got %q", output)
+	}
+
+	short := newOutputBuilder(1024, "a\n")
+	short.WriteString("b\n")
+	if got := short.String(); got != "a\nb\n" {
+		t.Errorf("Expected short output to be kept whole, got %q", got)
+	}
+}
+
+// TestOutputBuilderConcurrent tests writing from stdout and stderr readers at
+// once without losing lines
+func TestOutputBuilderConcurrent(t *testing.T) {
+	ob := newOutputBuilder(0, "")
+	var wg sync.WaitGroup
+	for _, prefix := range []string{"out", "ERROR: err"} {
+		wg.Add(1)
+		go func(prefix string) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				ob.WriteString(fmt.Sprintf("%s %d\n", prefix, i))
+				_ = ob.String()
+			}
+		}(prefix)
+	}
+	wg.Wait()
+
+	if lines := strings.Count(ob.String(), "\n"); lines != 2000 {
+		t.Errorf("Expected 2000 lines, got %d", lines)
+	}
+}
+
+// TestAppendOutputLimit tests capping a running sync's output by its
+// memory limit, and picking up output set directly
+func TestAppendOutputLimit(t *testing.T) {
+	testSync := NewSync(testSourceDir, testDestDir, 60)
+	testSync.maxMemoryMB = 64
+	if limit := testSync.outputLimit(); limit != 64<<10 {
+		t.Errorf("Expected 64 KB of output for 64 MB, got %d", limit)
+	}
+
+	for i := 0; i < 100000; i++ {
+		testSync.appendOutput(fmt.Sprintf("file%06d.txt", i))
+	}
+	if n := len(testSync.Output); n > 2*testSync.outputLimit()+4 {
+		t.Errorf("Expected output to stay within twice the limit, got %d bytes", n)
+	}
+
+	testSync.Output = "Starting sync"
+	testSync.appendOutput("file.txt")
+	if testSync.Output != "Starting sync\nfile.txt\n" {
+		t.Errorf("Expected appended output to follow output set directly, got %q", testSync.Output)
+	}
+
+	if limit := (&Sync{maxMemoryMB: 1}).outputLimit(); limit != minOutputLimit {
+		t.Errorf("Expected the minimum output for a tiny limit, got %d", limit)
+	}
+	if limit := (&Sync{}).outputLimit(); limit != defaultOutputLimit {
+		t.Errorf("Expected the default output without a limit, got %d", limit)
+	}
+}
+
+// TestTransferSample tests keeping a fixed number of transferred files
+func TestTransferSample(t *testing.T) {
+	sample := newTransferSample(5)
+	for i := 0; i < 1000; i++ {
+		sample.add(transferredFile{source: fmt.Sprintf("src/%d", i)})
+	}
+	if len(sample.files) != 5 || sample.total != 1000 {
+		t.Errorf("Expected 5 of 1000 files, got %d of %d", len(sample.files), sample.total)
+	}
+
+	// Files late in a run are as likely to be picked as early ones
+	late := 0
+	for i := 0; i < 200; i++ {
+		sample := newTransferSample(1)
+		for j := 0; j < 10; j++ {
+			sample.add(transferredFile{source: fmt.Sprint(j)})
+		}
+		if sample.files[0].source >= "5" {
+			late++
+		}
+	}
+	if late < 50 || late > 150 {
+		t.Errorf("Expected about half the picks from the second half, got %d of 200", late)
+	}
+}
@@ -0,0 +1,97 @@
+package dirsync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"unicode"
+)
+
+// Slug turns a name into a lowercase string usable in URL paths and logs,
+// keeping letters and digits and joining the words between with dashes
+func Slug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// ID returns the ID of the pair: the slug of its name, or a short hash of
+// names without letters or digits. Pairs without a name are identified by
+// "source:destination".
+func (p PairConfig) ID() string {
+	if p.Name == "" {
+		return p.pathID()
+	}
+	if slug := Slug(p.Name); slug != "" {
+		return slug
+	}
+	sum := sha256.Sum256([]byte(p.Name))
+	return hex.EncodeToString(sum[:4])
+}
+
+// pathID returns the "source:destination" ID pairs have without a name
+func (p PairConfig) pathID() string {
+	return p.Source + ":" + p.Destination
+}
+
+// Name returns the pair's name, or its ID if it has none
+func (s *Sync) Name() string {
+	if s.Pair.Name != "" {
+		return s.Pair.Name
+	}
+	return s.ID
+}
+
+// hasID reports whether id identifies the pair, by its ID or by the
+// "source:destination" it had before it was named or its paths were cleaned
+func (s *Sync) hasID(id string) bool {
+	return id == s.ID || id == s.SourcePath+":"+s.DestinationPath ||
+		(s.Pair.PreviousID != "" && id == s.Pair.PreviousID)
+}
+
+// renamePair moves the runs, notes and totals of a pair to a new ID, such as
+// when a pair is given a name, and persists the history if anything moved
+func (h *History) renamePair(from, to string) error {
+	h.mu.Lock()
+	moved := false
+	for _, run := range h.Runs {
+		if run.SyncID == from {
+			run.SyncID = to
+			moved = true
+		}
+	}
+
+	if notes, ok := h.PairNotes[from]; ok {
+		h.PairNotes[to] = append(notes, h.PairNotes[to]...)
+		delete(h.PairNotes, from)
+		moved = true
+	}
+
+	if totals, ok := h.PairTotals[from]; ok {
+		t := h.pairTotals(to)
+		t.Runs += totals.Runs
+		t.Failures += totals.Failures
+		t.BytesTransferred += totals.BytesTransferred
+		t.FilesCopied += totals.FilesCopied
+		t.DurationSeconds += totals.DurationSeconds
+		delete(h.PairTotals, from)
+		moved = true
+	}
+	h.mu.Unlock()
+
+	if !moved {
+		return nil
+	}
+	return h.save()
+}
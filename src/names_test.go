@@ -0,0 +1,90 @@
+package dirsync
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestPairID tests the IDs of named and unnamed pairs
+func TestPairID(t *testing.T) {
+	tests := []struct {
+		pair PairConfig
+		want string
+	}{
+		{PairConfig{Source: "/home/me/Documents", Destination: "/mnt/nas/docs"}, "/home/me/Documents:/mnt/nas/docs"},
+		{PairConfig{Name: "Documents → NAS", Source: "/a", Destination: "/b"}, "documents-nas"},
+		{PairConfig{Name: "  Photos 2024!  ", Source: "/a", Destination: "/b"}, "photos-2024"},
+		{PairConfig{Name: "→", Source: "/a", Destination: "/b"}, "16166003"},
+	}
+	for _, test := range tests {
+		if got := test.pair.ID(); got != test.want {
+			t.Errorf("Expected ID %q for %+v, got %q", test.want, test.pair, got)
+		}
+	}
+}
+
+// TestNamedPairKeepsHistory tests that naming a pair moves its history to
+// the new ID, and that it's still found by its paths
+func TestNamedPairKeepsHistory(t *testing.T) {
+	historyPath := filepath.Join(t.TempDir(), "history.json")
+	history, _ := NewHistory(historyPath, 0)
+	dest := t.TempDir()
+	pathID := testSourceDir + ":" + dest
+
+	run := history.StartRun(pathID)
+	history.finish(run, TransferStats{BytesTransferred: 10}, "", nil)
+	history.AnnotatePair(pathID, "first disk")
+
+	manager := NewSyncManager(Options{History: history})
+	s := manager.AddPair(PairConfig{Name: "Documents", Source: testSourceDir, Destination: dest}, 60)
+
+	if s.ID != "documents" || s.Name() != "Documents" || s.GetStatus()["name"] != "Documents" {
+		t.Errorf("Expected the pair to be identified by its name, got %q (%q)", s.ID, s.Name())
+	}
+	if manager.GetSyncByID(pathID) != s || manager.GetSyncByID("documents") != s {
+		t.Errorf("Expected the pair to be found by its ID and its paths")
+	}
+
+	reloaded, err := NewHistory(historyPath, 0)
+	if err != nil {
+		t.Fatalf("Failed to reload history: %v", err)
+	}
+	if runs := reloaded.ListRuns("documents"); len(runs) != 1 || runs[0].ID != run.ID {
+		t.Errorf("Expected the run under the new ID, got %+v", runs)
+	}
+	if notes := reloaded.GetPairNotes("documents"); len(notes) != 1 {
+		t.Errorf("Expected the note under the new ID, got %v", notes)
+	}
+	if totals := reloaded.GetPairTotals("documents"); totals.Runs != 1 || totals.BytesTransferred != 10 {
+		t.Errorf("Expected the totals under the new ID, got %+v", totals)
+	}
+	if runs := reloaded.ListRuns(pathID); len(runs) != 0 {
+		t.Errorf("Expected nothing left under the old ID, got %+v", runs)
+	}
+
+	// Pairs running after it may still list it by its paths
+	after := manager.AddPair(PairConfig{Source: dest, Destination: t.TempDir(), After: []string{pathID}}, 60)
+	manager.chainRun(RunRecord{SyncID: "documents", Success: true})
+	if waiting := after.GetStatus()["waiting_for"].([]string); len(waiting) != 0 {
+		t.Errorf("Expected the chained pair to be started, still waiting for %v", waiting)
+	}
+}
+
+// TestCleanedPairKeepsHistory tests that a pair keeps the history of the
+// paths it was configured with before they were cleaned
+func TestCleanedPairKeepsHistory(t *testing.T) {
+	history, _ := NewHistory("", 0)
+	dest := t.TempDir()
+	previousID := testSourceDir + "/:" + dest + "/"
+	history.finish(history.StartRun(previousID), TransferStats{}, "", nil)
+
+	manager := NewSyncManager(Options{History: history})
+	s := manager.AddPair(PairConfig{Source: testSourceDir, Destination: dest, PreviousID: previousID}, 60)
+
+	if runs := history.ListRuns(s.ID); len(runs) != 1 {
+		t.Errorf("Expected the run under the cleaned ID, got %+v", runs)
+	}
+	if manager.GetSyncByID(previousID) != s {
+		t.Errorf("Expected the pair to be found by its previous ID")
+	}
+}
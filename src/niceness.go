@@ -0,0 +1,110 @@
+package dirsync
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// NicenessConfig lowers the CPU and IO priority of a pair's transfers, so
+// big runs don't make the host unresponsive
+type NicenessConfig struct {
+	// CPU is the nice value rsync runs at, from 0 (normal) to 19 (lowest)
+	CPU int `json:"cpu"`
+
+	// IO is the IO scheduling class: "idle" only uses the disk when nothing
+	// else does, and "best-effort" or "best-effort:N" with N from 0
+	// (highest) to 7 (lowest) shares it. IO priorities are only supported
+	// on Linux.
+	IO string `json:"io"`
+}
+
+// IO scheduling classes, as used by ionice and ioprio_set
+const (
+	ioClassBestEffort = 2
+	ioClassIdle       = 3
+)
+
+// Validate checks the nice value and IO class
+func (c *NicenessConfig) Validate() error {
+	if c.CPU < 0 || c.CPU > 19 {
+		return fmt.Errorf("niceness cpu %d isn't between 0 and 19", c.CPU)
+	}
+	if _, _, err := c.ioPriority(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ioPriority returns the IO scheduling class and level, or a class of 0
+// without an IO priority
+func (c *NicenessConfig) ioPriority() (class, level int, err error) {
+	name, levelText, hasLevel := strings.Cut(c.IO, ":")
+	switch {
+	case c.IO == "":
+		return 0, 0, nil
+	case name == "idle" && !hasLevel:
+		return ioClassIdle, 0, nil
+	case name == "best-effort" && !hasLevel:
+		return ioClassBestEffort, 4, nil
+	case name == "best-effort":
+		level, err := strconv.Atoi(levelText)
+		if err != nil || level < 0 || level > 7 {
+			return 0, 0, fmt.Errorf("niceness io level %q isn't between 0 and 7", levelText)
+		}
+		return ioClassBestEffort, level, nil
+	}
+	return 0, 0, fmt.Errorf("niceness io %q isn't idle, best-effort or best-effort:N", c.IO)
+}
+
+// command returns the command line that runs name with args under nice
+// and ionice, leaving out either if it isn't installed
+func (c *NicenessConfig) command(name string, args []string) (string, []string) {
+	if c == nil {
+		return name, args
+	}
+
+	var prefix []string
+	if class, level, _ := c.ioPriority(); class != 0 {
+		if _, err := exec.LookPath("ionice"); err != nil {
+			log.Printf("ionice not found, running %s without an IO priority", name)
+		} else {
+			prefix = append(prefix, "ionice", "-c", strconv.Itoa(class))
+			if class == ioClassBestEffort {
+				prefix = append(prefix, "-n", strconv.Itoa(level))
+			}
+		}
+	}
+	if c.CPU > 0 {
+		if _, err := exec.LookPath("nice"); err != nil {
+			log.Printf("nice not found, running %s at normal priority", name)
+		} else {
+			prefix = append(prefix, "nice", "-n", strconv.Itoa(c.CPU))
+		}
+	}
+
+	if len(prefix) == 0 {
+		return name, args
+	}
+	return prefix[0], append(append(prefix[1:], name), args...)
+}
+
+// runNiced runs fn in a goroutine on a thread of its own with the lowered
+// priority, for the built-in copier. Goroutines fn starts lower their own
+// threads with lowerThreadPriority.
+func runNiced(c *NicenessConfig, fn func() error) error {
+	if c == nil {
+		return fn()
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		if err := lowerThreadPriority(c); err != nil {
+			log.Printf("Error lowering the priority of the file copy: %v", err)
+		}
+		result <- fn()
+	}()
+	return <-result
+}
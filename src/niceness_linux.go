@@ -0,0 +1,36 @@
+//go:build linux
+
+package dirsync
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// ioprioWhoProcess makes ioprio_set act on a single thread when given a
+// thread ID
+const ioprioWhoProcess = 1
+
+// lowerThreadPriority lowers the CPU and IO priority of the calling
+// goroutine's thread. The goroutine stays locked to the thread, which is
+// never unlocked, so the thread exits with the goroutine instead of going
+// back to run others at the lowered priority.
+func lowerThreadPriority(c *NicenessConfig) error {
+	if c == nil {
+		return nil
+	}
+	runtime.LockOSThread()
+	tid := syscall.Gettid()
+
+	if c.CPU > 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, c.CPU); err != nil {
+			return err
+		}
+	}
+	if class, level, _ := c.ioPriority(); class != 0 {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(class<<13|level)); errno != 0 {
+			return errno
+		}
+	}
+	return nil
+}
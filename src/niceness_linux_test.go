@@ -0,0 +1,29 @@
+//go:build linux
+
+package dirsync
+
+import (
+	"syscall"
+	"testing"
+)
+
+// TestLowerThreadPriority tests lowering the priority of one thread without
+// touching the rest of the process
+func TestLowerThreadPriority(t *testing.T) {
+	nice := make(chan int)
+	go func() {
+		if err := lowerThreadPriority(&NicenessConfig{CPU: 7}); err != nil {
+			t.Errorf("Error lowering priority: %v", err)
+		}
+		// The raw syscall returns 20 minus the nice value
+		prio, _ := syscall.Getpriority(syscall.PRIO_PROCESS, syscall.Gettid())
+		nice <- 20 - prio
+	}()
+	if got := <-nice; got != 7 {
+		t.Errorf("Expected the thread to run at nice 7, got %d", got)
+	}
+
+	if prio, _ := syscall.Getpriority(syscall.PRIO_PROCESS, 0); 20-prio != 0 {
+		t.Errorf("Expected the process to keep its priority, got nice %d", 20-prio)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package dirsync
+
+// lowerThreadPriority does nothing on systems without per-thread
+// priorities; the built-in copier runs at the daemon's priority there
+func lowerThreadPriority(c *NicenessConfig) error {
+	return nil
+}
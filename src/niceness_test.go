@@ -0,0 +1,70 @@
+package dirsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestNicenessValidate tests rejecting nice values and IO classes ionice
+// doesn't take
+func TestNicenessValidate(t *testing.T) {
+	for _, c := range []NicenessConfig{{}, {CPU: 19, IO: "idle"}, {IO: "best-effort"}, {IO: "best-effort:7"}} {
+		if err := c.Validate(); err != nil {
+			t.Errorf("Expected %+v to be valid, got %v", c, err)
+		}
+	}
+	for _, c := range []NicenessConfig{{CPU: -5}, {CPU: 20}, {IO: "realtime"}, {IO: "best-effort:8"}, {IO: "idle:3"}} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", c)
+		}
+	}
+}
+
+// TestNicenessCommand tests running rsync under ionice and nice, and
+// without them when they aren't installed
+func TestNicenessCommand(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"nice", "ionice"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), 0755)
+	}
+	t.Setenv("PATH", dir)
+
+	c := &NicenessConfig{CPU: 10, IO: "best-effort:6"}
+	name, args := c.command("rsync", []string{"-a", "src/", "dest"})
+	want := []string{"-c", "2", "-n", "6", "nice", "-n", "10", "rsync", "-a", "src/", "dest"}
+	if name != "ionice" || !reflect.DeepEqual(args, want) {
+		t.Errorf("Expected ionice %q, got %s %q", want, name, args)
+	}
+
+	c = &NicenessConfig{IO: "idle"}
+	if name, args := c.command("rsync", []string{"-a"}); name != "ionice" || !reflect.DeepEqual(args, []string{"-c", "3", "rsync", "-a"}) {
+		t.Errorf("Expected the idle class only, got %s %q", name, args)
+	}
+
+	t.Setenv("PATH", t.TempDir())
+	c = &NicenessConfig{CPU: 10, IO: "idle"}
+	if name, args := c.command("rsync", []string{"-a"}); name != "rsync" || !reflect.DeepEqual(args, []string{"-a"}) {
+		t.Errorf("Expected rsync as it is without nice and ionice, got %s %q", name, args)
+	}
+}
+
+// TestNicenessFileCopy tests that the built-in copier still copies
+// everything at a lower priority
+func TestNicenessFileCopy(t *testing.T) {
+	destDir := t.TempDir()
+	testSync := NewSync(testSourceDir, destDir, 60)
+	testSync.Pair.Niceness = &NicenessConfig{CPU: 10, IO: "idle"}
+
+	err := runNiced(testSync.Pair.Niceness, func() error {
+		return testSync.syncWithFileCopy(context.Background(), testSourceDir, destDir)
+	})
+	if err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "subdir/file3.txt")); err != nil {
+		t.Errorf("Expected subdir/file3.txt to be copied: %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package dirsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// WebhookConfig describes an HTTP endpoint notified when runs finish. The
+// payload is the JSON-encoded NotificationData unless a Go template is given.
+type WebhookConfig struct {
+	URL         string            `json:"url"`
+	On          []string          `json:"on"`
+	Template    string            `json:"template"`
+	ContentType string            `json:"content_type"`
+	Headers     map[string]string `json:"headers"`
+}
+
+// NotificationData is what webhook templates have access to
+type NotificationData struct {
+	Event       string        `json:"event"`
+	SyncID      string        `json:"sync_id"`
+	Name        string        `json:"name"`
+	Source      string        `json:"source"`
+	Destination string        `json:"destination"`
+	Run         RunRecord     `json:"run"`
+	Duration    time.Duration `json:"duration"`
+	Output      string        `json:"output"`
+}
+
+// templateFuncs are available in webhook templates
+var templateFuncs = template.FuncMap{
+	// json encodes a value, e.g. to safely embed strings: {{json .Run.Error}}
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// wants reports whether the webhook should be sent for an event
+func (wh WebhookConfig) wants(event string) bool {
+	if len(wh.On) == 0 {
+		return true
+	}
+	for _, e := range wh.On {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the webhook's payload template
+func (wh WebhookConfig) Validate() error {
+	_, err := wh.parseTemplate()
+	return err
+}
+
+// parseTemplate parses the webhook's payload template
+func (wh WebhookConfig) parseTemplate() (*template.Template, error) {
+	tmpl, err := template.New("webhook").Funcs(templateFuncs).Parse(wh.Template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook template for %s: %w", wh.URL, err)
+	}
+	return tmpl, nil
+}
+
+// payload renders the webhook body for the given data
+func (wh WebhookConfig) payload(data NotificationData) ([]byte, error) {
+	if wh.Template == "" {
+		return json.Marshal(data)
+	}
+
+	tmpl, err := wh.parseTemplate()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("error rendering webhook template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// send posts the payload to the webhook
+func (wh WebhookConfig) send(data NotificationData) error {
+	body, err := wh.payload(data)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	contentType := wh.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range wh.Headers {
+		req.Header.Set(name, value)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// notify sends the configured webhooks for a finished run
+func (s *Sync) notify(run RunRecord) {
+	notifiers := s.plugins.notifiers()
+	if len(s.webhooks) == 0 && len(notifiers) == 0 {
+		return
+	}
+
+	event := "success"
+	if !run.Success {
+		event = "failure"
+	}
+
+	s.mu.RLock()
+	data := NotificationData{
+		Event:       event,
+		SyncID:      s.ID,
+		Name:        s.Name(),
+		Source:      s.SourcePath,
+		Destination: s.DestinationPath,
+		Run:         run,
+		Duration:    run.EndTime.Sub(run.StartTime),
+		Output:      s.Output,
+	}
+	s.mu.RUnlock()
+
+	for _, wh := range s.webhooks {
+		if !wh.wants(event) {
+			continue
+		}
+		if err := wh.send(data); err != nil {
+			log.Printf("[%s] Error sending webhook to %s: %v", s.ID, wh.URL, err)
+		}
+	}
+
+	if len(notifiers) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("[%s] Error encoding notification: %v", s.ID, err)
+		return
+	}
+	for _, notifier := range notifiers {
+		if err := notifier.runChecked(context.Background(), []string{pluginNotify}, bytes.NewReader(payload), nil); err != nil {
+			log.Printf("[%s] Error running notifier: %v", s.ID, err)
+		}
+	}
+}
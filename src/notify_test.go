@@ -0,0 +1,87 @@
+package dirsync
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestWebhookPayloadTemplate tests rendering webhook payloads from templates
+func TestWebhookPayloadTemplate(t *testing.T) {
+	data := NotificationData{
+		Event:       "failure",
+		SyncID:      "/src:/dst",
+		Source:      "/src",
+		Destination: "/dst",
+		Run:         RunRecord{ID: "abc", Error: `quote " in error`},
+	}
+
+	// Default payload is the JSON-encoded data
+	wh := WebhookConfig{URL: "http://example.com"}
+	body, err := wh.payload(data)
+	if err != nil {
+		t.Fatalf("payload failed: %v", err)
+	}
+
+	var decoded NotificationData
+	if err := json.Unmarshal(body, &decoded); err != nil || decoded.Run.ID != "abc" {
+		t.Errorf("Expected JSON-encoded data, got %s (%v)", body, err)
+	}
+
+	// Templated payload
+	wh.Template = `{"title": "{{upper .Event}}: {{.Source}}", "text": {{json .Run.Error}}}`
+	body, err = wh.payload(data)
+	if err != nil {
+		t.Fatalf("payload failed: %v", err)
+	}
+
+	var card map[string]string
+	if err := json.Unmarshal(body, &card); err != nil {
+		t.Fatalf("Templated payload is not valid JSON: %s (%v)", body, err)
+	}
+
+	if card["title"] != "FAILURE: /src" || card["text"] != `quote " in error` {
+		t.Errorf("Unexpected templated payload: %v", card)
+	}
+
+	// Invalid template
+	wh.Template = "{{.Broken"
+	if _, err := wh.parseTemplate(); err == nil {
+		t.Errorf("Expected error for invalid template, got nil")
+	}
+}
+
+// TestSyncNotify tests sending webhooks for the events they're configured for
+func TestSyncNotify(t *testing.T) {
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, r.Header.Get("Content-Type")+" "+string(body))
+	}))
+	defer ts.Close()
+
+	testSync := NewSync(testSourceDir, testDestDir, 60)
+	testSync.webhooks = []WebhookConfig{
+		{URL: ts.URL, On: []string{"failure"}, Template: "failed: {{.Run.Error}}", ContentType: "text/plain"},
+	}
+
+	run := RunRecord{ID: "run1"}
+	finishRun(&run, TransferStats{}, nil)
+	testSync.notify(run)
+
+	if len(bodies) != 0 {
+		t.Fatalf("Webhook configured for failures should not be sent on success, got %v", bodies)
+	}
+
+	run = RunRecord{ID: "run2"}
+	finishRun(&run, TransferStats{}, errors.New("disk full"))
+	testSync.notify(run)
+
+	if len(bodies) != 1 || !strings.HasPrefix(bodies[0], "text/plain failed: disk full") {
+		t.Errorf("Expected templated failure webhook, got %v", bodies)
+	}
+}
@@ -0,0 +1,135 @@
+package dirsync
+
+// PairConfig holds the structured configuration of a single sync pair
+type PairConfig struct {
+	// Name identifies the pair in logs, notifications and the API instead
+	// of its paths; see ID
+	Name string `json:"name,omitempty"`
+
+	// PreviousID is the "source:destination" the pair was configured with
+	// before its paths were cleaned up. The pair keeps its history and can
+	// still be looked up by it.
+	PreviousID string `json:"-"`
+
+	// Profile is the configuration profile the pair belongs to, if it's
+	// only active in one
+	Profile string `json:"-"`
+
+	Source        string             `json:"source"`
+	Destination   string             `json:"destination"`
+	WakeOnLAN     *WakeOnLANConfig   `json:"wake_on_lan,omitempty"`
+	CoalesceRuns  bool               `json:"coalesce_runs"`
+	QuickCheck    *QuickCheckConfig  `json:"quick_check,omitempty"`
+	PartialDir    string             `json:"partial_dir"`
+	PartialMaxAge int                `json:"partial_max_age"`
+	CopyBufferKB  int                `json:"copy_buffer_kb"`
+	CopyWorkers   int                `json:"copy_workers"`
+	TransferOrder string             `json:"transfer_order"`
+	Routes        []RouteConfig      `json:"routes,omitempty"`
+	Transforms    []TransformConfig  `json:"transforms,omitempty"`
+	Filters       []string           `json:"filters,omitempty"`
+	Hooks         []string           `json:"hooks,omitempty"`
+	Delete        bool               `json:"delete"`
+	VerifySample  int                `json:"verify_sample"`
+	Manifest      bool               `json:"manifest"`
+	Incremental   bool               `json:"incremental"`
+	Checksum      bool               `json:"checksum"`
+	HashAlgorithm string             `json:"hash_algorithm"`
+	HashWorkers   int                `json:"hash_workers"`
+	DeltaCopy     bool               `json:"delta_copy"`
+	Cost          *CostConfig        `json:"cost,omitempty"`
+	Dedup         bool               `json:"dedup"`
+	DedupStore    string             `json:"dedup_store"`
+	Bandwidth     *BandwidthConfig   `json:"bandwidth,omitempty"`
+	Niceness      *NicenessConfig    `json:"niceness,omitempty"`
+	Encryption    *EncryptionConfig  `json:"encryption,omitempty"`
+	Schedule      *ScheduleConfig    `json:"schedule,omitempty"`
+	SkipHidden    bool               `json:"skip_hidden"`
+	OneFilesystem bool               `json:"one_filesystem"`
+	Deploy        *DeployConfig      `json:"deploy,omitempty"`
+	Maintenance   *MaintenanceConfig `json:"maintenance,omitempty"`
+	RemoteHooks   *RemoteHookConfig  `json:"remote_hooks,omitempty"`
+	Report        *ReportConfig      `json:"report,omitempty"`
+
+	// ContinueOnError keeps the built-in copier going after any error
+	// copying a single file, collecting the failures, instead of only after
+	// files it can't access or that vanished. Running out of space still
+	// stops the run.
+	ContinueOnError bool `json:"continue_on_error"`
+
+	// CaseCollisions sets what happens to files whose names differ only in
+	// case when the destination ignores case: "fail" (the default) copies
+	// the first and fails the others, "rename" copies the others under new
+	// names and "ignore" lets the last one copied win
+	CaseCollisions string `json:"case_collisions"`
+
+	// MaxErrors stops a run once more than this many files failed, which
+	// usually means the source or destination went away (default 0, no
+	// limit)
+	MaxErrors int `json:"max_errors"`
+
+	// MaxDeletePercent fails runs of pairs with Delete that would delete
+	// more than this share of the destination's files (default 0, no limit)
+	MaxDeletePercent float64 `json:"max_delete_percent"`
+
+	// Tags group pairs, such as "photos" or "offsite", so the API can act
+	// on all pairs with a tag at once
+	Tags []string `json:"tags,omitempty"`
+
+	// Enabled set to false keeps the pair from running until it is enabled
+	// again through the API (default true)
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// After lists the IDs of pairs whose successful runs start this one,
+	// once all of them have succeeded since it last ran
+	After []string `json:"after,omitempty"`
+
+	// Priority orders pairs waiting to run behind MaxConcurrentRuns,
+	// highest first (default 0)
+	Priority int `json:"priority"`
+
+	// DryRun reports what a run would change without changing anything
+	DryRun bool `json:"-"`
+}
+
+// Options configures a SyncManager
+type Options struct {
+	// History records every run; an in-memory history is used if nil
+	History *History
+
+	// Webhooks are notified when runs finish
+	Webhooks []WebhookConfig
+
+	// OnProgress is called for every line of output from a running sync
+	OnProgress func(Progress)
+
+	// Plugins provide filters, transformers and notifiers
+	Plugins *Plugins
+
+	// OnRunFinished is called after every run, once it is recorded in the history
+	OnRunFinished func(RunRecord)
+
+	// Journal records runs in progress, so runs interrupted by the process
+	// dying can be recovered with RecoverInterrupted
+	Journal *Journal
+
+	// ChangeLimit is how many changed paths each run records in the
+	// history (default 100, negative for none)
+	ChangeLimit int
+
+	// MaxMemoryMB is how much memory the daemon should stay within, which
+	// caps how much of each running sync's output is kept (default 0, no
+	// limit)
+	MaxMemoryMB int
+
+	// MaxConcurrentRuns is how many pairs may run at once (default 0, no
+	// limit). Pairs beyond it wait their turn by priority.
+	MaxConcurrentRuns int
+}
+
+// Progress is a line of output from a running sync, such as a file that
+// was copied or a line printed by rsync
+type Progress struct {
+	SyncID string
+	Line   string
+}
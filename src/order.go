@@ -0,0 +1,245 @@
+package dirsync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transfer orders supported by the built-in copier
+const (
+	orderBreadthFirst  = "breadth_first"
+	orderSmallestFirst = "smallest_first"
+	orderNewestFirst   = "newest_first"
+)
+
+// TransferOrders lists the valid values of a pair's transfer_order
+var TransferOrders = []string{orderBreadthFirst, orderSmallestFirst, orderNewestFirst}
+
+// newestFirstRescanInterval is how often a newest-first copy looks for files
+// created since it started
+var newestFirstRescanInterval = time.Minute
+
+// ValidTransferOrder reports whether order is empty or a supported transfer order
+func ValidTransferOrder(order string) bool {
+	if order == "" {
+		return true
+	}
+	for _, o := range TransferOrders {
+		if o == order {
+			return true
+		}
+	}
+	return false
+}
+
+// queuedFile is a file found while walking the source, waiting to be copied
+type queuedFile struct {
+	rel     string
+	size    int64
+	modTime time.Time
+}
+
+// queuedDir is a directory whose permissions and modification time are
+// restored once all files have been copied
+type queuedDir struct {
+	rel  string
+	info os.FileInfo
+}
+
+// depth returns the number of directories above rel
+func depth(rel string) int {
+	return strings.Count(filepath.ToSlash(rel), "/")
+}
+
+// sortQueue sorts files into the given transfer order. Ties are broken by
+// path so the order is the same on every run.
+func sortQueue(files []queuedFile, order string) {
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		switch order {
+		case orderBreadthFirst:
+			if da, db := depth(a.rel), depth(b.rel); da != db {
+				return da < db
+			}
+		case orderSmallestFirst:
+			if a.size != b.size {
+				return a.size < b.size
+			}
+		case orderNewestFirst:
+			if !a.modTime.Equal(b.modTime) {
+				return a.modTime.After(b.modTime)
+			}
+		}
+		return a.rel < b.rel
+	})
+}
+
+// copyQueued copies the files collected by copyDir in the configured order,
+// then restores directory metadata from the deepest directory up
+func (c *fileCopier) copyQueued() error {
+	sortQueue(c.queue, c.order)
+
+	var wg sync.WaitGroup
+	var loopErr error
+	slots := c.fileSlots()
+	lastScan := time.Now()
+
+	for i := 0; i < len(c.queue); i++ {
+		// Files created during a long newest-first run jump the backlog
+		if c.order == orderNewestFirst && time.Since(lastScan) >= newestFirstRescanInterval {
+			if err := c.rescanNewFiles(i); err != nil {
+				loopErr = err
+				break
+			}
+			lastScan = time.Now()
+		}
+
+		f := c.queue[i]
+		if c.sync.isPaused() {
+			loopErr = errSyncPaused
+			break
+		}
+		if loopErr = c.ctx.Err(); loopErr != nil {
+			break
+		}
+		if c.failed() != nil {
+			break
+		}
+
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(rel string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			lowerThreadPriority(c.sync.Pair.Niceness)
+			if err := c.skipFailed(rel, c.copyFileSafely(rel)); err != nil {
+				c.setErr(err)
+			}
+		}(f.rel)
+	}
+
+	wg.Wait()
+	if loopErr != nil {
+		return loopErr
+	}
+	if err := c.failed(); err != nil {
+		return err
+	}
+
+	if c.dryRun {
+		return nil
+	}
+
+	for i := len(c.dirs) - 1; i >= 0; i-- {
+		dir := c.dirs[i]
+		destDir, err := c.destPath(dir.rel)
+		if err != nil {
+			return err
+		}
+		os.Chmod(destDir, dir.info.Mode().Perm())
+		os.Chtimes(destDir, dir.info.ModTime(), dir.info.ModTime())
+	}
+
+	return nil
+}
+
+// rescanNewFiles looks for files that weren't queued yet and merges them
+// into the part of the queue from index next on, so newly created files are
+// copied before the older backlog. Creating a file changes the modification
+// time of its directory, so only directories whose time changed since they
+// were read, and directories new since then, are read again: a rescan costs
+// a stat per directory instead of a walk of the whole source.
+func (c *fileCopier) rescanNewFiles(next int) error {
+	var changed []string
+	for rel, modTime := range c.dirTimes {
+		info, err := os.Stat(filepath.Join(c.source, rel))
+		if err != nil || info.ModTime().Equal(modTime) {
+			// Directories can disappear while we copy
+			continue
+		}
+		c.dirTimes[rel] = info.ModTime()
+		changed = append(changed, rel)
+	}
+	sort.Strings(changed)
+
+	var found []queuedFile
+	for _, rel := range changed {
+		if err := c.scanNewFiles(rel, &found); err != nil {
+			return err
+		}
+	}
+
+	if len(found) == 0 {
+		return nil
+	}
+
+	c.sync.appendOutput(fmt.Sprintf("Found %d new files, copying them first", len(found)))
+	pending := append(found, c.queue[next:]...)
+	sortQueue(pending, c.order)
+	c.queue = append(c.queue[:next], pending...)
+	return nil
+}
+
+// scanNewFiles adds the files in a source directory that weren't queued yet
+// to found, reading new subdirectories as well
+func (c *fileCopier) scanNewFiles(rel string, found *[]queuedFile) error {
+	entries, err := os.ReadDir(filepath.Join(c.source, rel))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		entryRel := filepath.Join(rel, entry.Name())
+		if c.skipHidden && isHidden(entry.Name()) {
+			continue
+		}
+
+		if entry.IsDir() {
+			if _, known := c.dirTimes[entryRel]; known || c.excludes[filepath.ToSlash(entryRel)] {
+				continue
+			}
+			if c.oneFS && c.otherFilesystem(entry) {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			c.dirTimes[entryRel] = info.ModTime()
+			if err := c.scanNewFiles(entryRel, found); err != nil {
+				return err
+			}
+			continue
+		}
+		if !entry.Type().IsRegular() || c.queued[entryRel] {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if !c.dryRun {
+			destDir, err := c.destPath(rel)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(destDir, 0755); err != nil {
+				return err
+			}
+		}
+		*found = append(*found, queuedFile{rel: entryRel, size: info.Size(), modTime: info.ModTime()})
+		c.queued[entryRel] = true
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package dirsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestValidTransferOrder tests accepting the supported transfer orders
+func TestValidTransferOrder(t *testing.T) {
+	for _, order := range append([]string{""}, TransferOrders...) {
+		if !ValidTransferOrder(order) {
+			t.Errorf("Expected %q to be valid", order)
+		}
+	}
+	if ValidTransferOrder("random") {
+		t.Errorf("Expected an unknown order to be rejected")
+	}
+}
+
+// TestSortQueue tests the supported transfer orders
+func TestSortQueue(t *testing.T) {
+	now := time.Now()
+	files := []queuedFile{
+		{rel: "a/b/deep.txt", size: 5, modTime: now.Add(-time.Hour)},
+		{rel: "big.txt", size: 100, modTime: now.Add(-2 * time.Hour)},
+		{rel: "a/mid.txt", size: 1, modTime: now},
+		{rel: "small.txt", size: 1, modTime: now.Add(-3 * time.Hour)},
+	}
+
+	tests := map[string][]string{
+		orderBreadthFirst:  {"big.txt", "small.txt", "a/mid.txt", "a/b/deep.txt"},
+		orderSmallestFirst: {"a/mid.txt", "small.txt", "a/b/deep.txt", "big.txt"},
+		orderNewestFirst:   {"a/mid.txt", "a/b/deep.txt", "big.txt", "small.txt"},
+	}
+
+	for order, want := range tests {
+		queue := append([]queuedFile(nil), files...)
+		sortQueue(queue, order)
+
+		var got []string
+		for _, f := range queue {
+			got = append(got, f.rel)
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: expected %v, got %v", order, want, got)
+		}
+	}
+}
+
+// TestSyncWithFileCopyOrdered tests that an ordered copy copies files in order
+func TestSyncWithFileCopyOrdered(t *testing.T) {
+	destDir := t.TempDir()
+
+	testSync := NewSync(testSourceDir, destDir, 60)
+	testSync.Pair.TransferOrder = orderBreadthFirst
+	if err := testSync.syncWithFileCopy(context.Background(), testSourceDir, destDir); err != nil {
+		t.Fatalf("syncWithFileCopy failed: %v", err)
+	}
+
+	// Files in the root are copied before files in subdirectories
+	root := strings.Index(testSync.Output, "file2.txt")
+	sub := strings.Index(testSync.Output, "subdir/file3.txt")
+	if root < 0 || sub < 0 || sub < root {
+		t.Errorf("Expected root files before subdirectory files, output: %s", testSync.Output)
+	}
+
+	// Directory modification times are still preserved
+	srcInfo, err := os.Stat(filepath.Join(testSourceDir, "subdir"))
+	if err != nil {
+		t.Fatalf("Failed to stat source subdir: %v", err)
+	}
+	destInfo, err := os.Stat(filepath.Join(destDir, "subdir"))
+	if err != nil {
+		t.Fatalf("Subdirectory was not copied: %v", err)
+	}
+	if !destInfo.ModTime().Equal(srcInfo.ModTime()) {
+		t.Errorf("Expected subdirectory modification time %v, got %v", srcInfo.ModTime(), destInfo.ModTime())
+	}
+}
+
+// TestNewestFirstPicksUpNewFiles tests that files created during a
+// newest-first copy are copied before the older backlog
+func TestNewestFirstPicksUpNewFiles(t *testing.T) {
+	oldInterval := newestFirstRescanInterval
+	newestFirstRescanInterval = 0
+	defer func() { newestFirstRescanInterval = oldInterval }()
+
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+
+	old := time.Now().Add(-time.Hour)
+	for _, name := range []string{"old1.log", "old2.log"} {
+		path := filepath.Join(sourceDir, name)
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create source file: %v", err)
+		}
+		os.Chtimes(path, old, old)
+	}
+
+	testSync := NewSync(sourceDir, destDir, 60)
+	testSync.Pair.TransferOrder = orderNewestFirst
+	c := newFileCopier(testSync, sourceDir, destDir)
+	if err := c.copyDir(""); err != nil {
+		t.Fatalf("copyDir failed: %v", err)
+	}
+
+	// A file shows up after the initial scan
+	if err := os.MkdirAll(filepath.Join(sourceDir, "today"), 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(sourceDir, "today", "new.log"), []byte("new"), 0644); err != nil {
+		t.Fatalf("Failed to create source file: %v", err)
+	}
+
+	if err := c.copyQueued(); err != nil {
+		t.Fatalf("copyQueued failed: %v", err)
+	}
+
+	newIdx := strings.Index(testSync.Output, "today/new.log")
+	oldIdx := strings.Index(testSync.Output, "old1.log")
+	if newIdx < 0 || oldIdx < 0 || newIdx > oldIdx {
+		t.Errorf("Expected the new file to be copied first, output: %s", testSync.Output)
+	}
+
+	if _, err := os.Stat(filepath.Join(destDir, "today", "new.log")); err != nil {
+		t.Errorf("New file was not copied: %v", err)
+	}
+}
+
+// TestRescanReadsChangedDirectories tests that a rescan only reads the
+// directories whose modification time changed, and new ones below them
+func TestRescanReadsChangedDirectories(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	old := time.Now().Add(-time.Hour)
+	for _, dir := range []string{"quiet", "busy"} {
+		os.MkdirAll(filepath.Join(sourceDir, dir), 0755)
+		path := filepath.Join(sourceDir, dir, "old.log")
+		os.WriteFile(path, []byte("old"), 0644)
+		os.Chtimes(path, old, old)
+		os.Chtimes(filepath.Join(sourceDir, dir), old, old)
+	}
+
+	testSync := NewSync(sourceDir, destDir, 60)
+	testSync.Pair.TransferOrder = orderNewestFirst
+	c := newFileCopier(testSync, sourceDir, destDir)
+	if err := c.copyDir(""); err != nil {
+		t.Fatalf("copyDir failed: %v", err)
+	}
+	if len(c.dirTimes) != 3 {
+		t.Fatalf("Expected the times of 3 directories, got %v", c.dirTimes)
+	}
+
+	// A file whose directory keeps its time isn't looked for, which shows
+	// unchanged directories aren't read
+	hidden := filepath.Join(sourceDir, "quiet", "unseen.log")
+	os.WriteFile(hidden, []byte("unseen"), 0644)
+	os.Chtimes(filepath.Join(sourceDir, "quiet"), old, old)
+
+	os.WriteFile(filepath.Join(sourceDir, "busy", "new.log"), []byte("new"), 0644)
+	os.MkdirAll(filepath.Join(sourceDir, "busy", "fresh", "deeper"), 0755)
+	os.WriteFile(filepath.Join(sourceDir, "busy", "fresh", "deeper", "newer.log"), []byte("newer"), 0644)
+
+	if err := c.rescanNewFiles(0); err != nil {
+		t.Fatalf("rescanNewFiles failed: %v", err)
+	}
+	var got []string
+	for _, f := range c.queue {
+		got = append(got, filepath.ToSlash(f.rel))
+	}
+	joined := strings.Join(got, ",")
+	for _, want := range []string{"busy/new.log", "busy/fresh/deeper/newer.log"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("Expected %s to be queued, got %v", want, got)
+		}
+	}
+	if strings.Contains(joined, "quiet/unseen.log") {
+		t.Errorf("Expected the unchanged directory not to be read, got %v", got)
+	}
+
+	// Nothing new is found twice
+	queued := len(c.queue)
+	if err := c.rescanNewFiles(0); err != nil {
+		t.Fatalf("rescanNewFiles failed: %v", err)
+	}
+	if len(c.queue) != queued {
+		t.Errorf("Expected no more files, queue grew from %d to %d", queued, len(c.queue))
+	}
+}
+
+// TestRescanSkipsExcludedAndHidden tests that rescans leave out what the
+// first walk would have
+func TestRescanSkipsExcludedAndHidden(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+
+	testSync := NewSync(sourceDir, destDir, 60)
+	testSync.Pair.TransferOrder = orderNewestFirst
+	testSync.Pair.SkipHidden = true
+	c := newFileCopier(testSync, sourceDir, destDir)
+	if err := c.copyDir(""); err != nil {
+		t.Fatalf("copyDir failed: %v", err)
+	}
+	c.excludes["cache"] = true
+
+	os.MkdirAll(filepath.Join(sourceDir, "cache"), 0755)
+	os.WriteFile(filepath.Join(sourceDir, "cache", "blob"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, ".secret"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "kept.txt"), []byte("x"), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(sourceDir, later, later)
+
+	if err := c.rescanNewFiles(0); err != nil {
+		t.Fatalf("rescanNewFiles failed: %v", err)
+	}
+	if len(c.queue) != 1 || c.queue[0].rel != "kept.txt" {
+		t.Errorf("Expected only kept.txt to be queued, got %+v", c.queue)
+	}
+	if err := c.copyQueued(); err != nil {
+		t.Fatalf("copyQueued failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "kept.txt")); err != nil {
+		t.Errorf("Expected kept.txt to be copied: %v", err)
+	}
+}
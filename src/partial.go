@@ -0,0 +1,117 @@
+package dirsync
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultPartialMaxAge is how long partial files are kept before they're considered stale
+const defaultPartialMaxAge = 24 * time.Hour
+
+// partialDirMarker is written into an absolute partial directory dirsync
+// created, which is the only kind of absolute one it cleans
+const partialDirMarker = ".dirsync-partial-dir"
+
+// partialMaxAge returns the configured age after which partial files are removed
+func (s *Sync) partialMaxAge() time.Duration {
+	if s.Pair.PartialMaxAge > 0 {
+		return time.Duration(s.Pair.PartialMaxAge) * time.Hour
+	}
+	return defaultPartialMaxAge
+}
+
+// ValidPartialDir reports whether partialDir is an absolute path or a
+// single dotted name like ".rsync-partial". rsync creates a relative
+// partial directory next to each file it transfers, so its name is looked
+// for everywhere in the destination, and a plain name like "tmp" would
+// match the user's own directories.
+func ValidPartialDir(partialDir string) bool {
+	if partialDir == "" || filepath.IsAbs(partialDir) {
+		return true
+	}
+	name := filepath.Clean(partialDir)
+	return strings.HasPrefix(name, ".") && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// preparePartialDir creates an absolute partial directory that doesn't
+// exist yet, marking it as dirsync's so its stale files can be cleaned.
+// Relative partial directories are created by rsync.
+func preparePartialDir(partialDir string) error {
+	if !filepath.IsAbs(partialDir) {
+		return nil
+	}
+	if _, err := os.Lstat(partialDir); !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(partialDir, 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(partialDir, partialDirMarker), nil, 0600)
+}
+
+// cleanStalePartials removes partial files older than maxAge from rsync's
+// partial directories. A relative partialDir is looked for in every directory
+// of the destination (as rsync does); an absolute one is cleaned directly,
+// but only if dirsync created it. Only files are removed, as rsync only keeps
+// files there, and the directories are left for rsync. It returns the number
+// of files removed.
+func cleanStalePartials(destPath, partialDir string, maxAge time.Duration) (int, error) {
+	if !ValidPartialDir(partialDir) {
+		return 0, fmt.Errorf("partial_dir %q isn't a dotted name like .rsync-partial, not cleaning it", partialDir)
+	}
+
+	var dirs []string
+	if filepath.IsAbs(partialDir) {
+		if _, err := os.Lstat(filepath.Join(partialDir, partialDirMarker)); os.IsNotExist(err) {
+			return 0, fmt.Errorf("partial_dir %s wasn't created by dirsync, not cleaning it", partialDir)
+		}
+		dirs = append(dirs, partialDir)
+	} else {
+		name := filepath.Clean(partialDir)
+		err := filepath.WalkDir(destPath, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && d.Name() == name && path != destPath {
+				dirs = append(dirs, path)
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || !info.Mode().IsRegular() || entry.Name() == partialDirMarker {
+				continue
+			}
+			if info.ModTime().Before(cutoff) {
+				if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+					return removed, err
+				}
+				removed++
+			}
+		}
+	}
+
+	return removed, nil
+}
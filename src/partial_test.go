@@ -0,0 +1,170 @@
+package dirsync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestCleanStalePartials tests removing stale partial files from the destination
+func TestCleanStalePartials(t *testing.T) {
+	destDir, err := os.MkdirTemp("", "dirsync_test_partial")
+	if err != nil {
+		t.Fatalf("Failed to create destination directory: %v", err)
+	}
+	defer os.RemoveAll(destDir)
+
+	partialDir := filepath.Join(destDir, "subdir", ".rsync-partial")
+	if err := os.MkdirAll(partialDir, 0755); err != nil {
+		t.Fatalf("Failed to create partial directory: %v", err)
+	}
+
+	staleFile := filepath.Join(partialDir, "stale.bin")
+	freshFile := filepath.Join(partialDir, "fresh.bin")
+	os.WriteFile(staleFile, []byte("stale"), 0644)
+	os.WriteFile(freshFile, []byte("fresh"), 0644)
+
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(staleFile, old, old); err != nil {
+		t.Fatalf("Failed to age stale file: %v", err)
+	}
+
+	removed, err := cleanStalePartials(destDir, ".rsync-partial", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("cleanStalePartials failed: %v", err)
+	}
+
+	if removed != 1 {
+		t.Errorf("Expected 1 file removed, got %d", removed)
+	}
+
+	if _, err := os.Stat(staleFile); !os.IsNotExist(err) {
+		t.Errorf("Stale partial file was not removed")
+	}
+
+	if _, err := os.Stat(freshFile); err != nil {
+		t.Errorf("Fresh partial file should be kept: %v", err)
+	}
+
+	// rsync created the partial directory, so it's left for rsync
+	os.Chtimes(freshFile, old, old)
+	if _, err := cleanStalePartials(destDir, ".rsync-partial", 24*time.Hour); err != nil {
+		t.Fatalf("cleanStalePartials failed: %v", err)
+	}
+
+	if _, err := os.Stat(partialDir); err != nil {
+		t.Errorf("Expected the empty partial directory to be kept: %v", err)
+	}
+}
+
+// TestCleanAbsolutePartialDir tests cleaning an absolute partial directory
+// only if dirsync created it
+func TestCleanAbsolutePartialDir(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+
+	// A directory of the user's is never cleaned
+	userDir := t.TempDir()
+	userFile := filepath.Join(userDir, "notes.txt")
+	os.WriteFile(userFile, []byte("notes"), 0644)
+	os.Chtimes(userFile, old, old)
+	if err := preparePartialDir(userDir); err != nil {
+		t.Fatalf("preparePartialDir failed: %v", err)
+	}
+	if _, err := cleanStalePartials(t.TempDir(), userDir, time.Hour); err == nil {
+		t.Errorf("Expected a partial_dir dirsync didn't create to be refused")
+	}
+	if _, err := os.Stat(userFile); err != nil {
+		t.Errorf("Expected the user's file to be left alone: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(userDir, partialDirMarker)); !os.IsNotExist(err) {
+		t.Errorf("Expected an existing directory not to be marked as dirsync's")
+	}
+
+	// One dirsync created is cleaned, keeping the directory and its marker
+	partialDir := filepath.Join(t.TempDir(), "partial")
+	if err := preparePartialDir(partialDir); err != nil {
+		t.Fatalf("preparePartialDir failed: %v", err)
+	}
+	stale := filepath.Join(partialDir, "big.iso")
+	os.WriteFile(stale, []byte("partial"), 0644)
+	os.Chtimes(stale, old, old)
+	os.Chtimes(filepath.Join(partialDir, partialDirMarker), old, old)
+	removed, err := cleanStalePartials(t.TempDir(), partialDir, time.Hour)
+	if err != nil || removed != 1 {
+		t.Fatalf("Expected 1 file removed, got %d, %v", removed, err)
+	}
+	if _, err := os.Stat(filepath.Join(partialDir, partialDirMarker)); err != nil {
+		t.Errorf("Expected the marker to be kept: %v", err)
+	}
+}
+
+// TestCleanPartialsKeepsUserData tests that directories of the user's
+// that could share the partial directory's name are left alone
+func TestCleanPartialsKeepsUserData(t *testing.T) {
+	destDir := t.TempDir()
+	userFile := filepath.Join(destDir, "projects", "tmp", "notes.txt")
+	os.MkdirAll(filepath.Dir(userFile), 0755)
+	os.WriteFile(userFile, []byte("notes"), 0644)
+	old := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(userFile, old, old)
+
+	if _, err := cleanStalePartials(destDir, "tmp", time.Hour); err == nil {
+		t.Errorf("Expected a plain partial_dir name to be refused")
+	}
+	if _, err := os.Stat(userFile); err != nil {
+		t.Errorf("Expected the user's tmp directory to be left alone: %v", err)
+	}
+
+	// Directories inside the partial directory aren't rsync's
+	nested := filepath.Join(destDir, ".rsync-partial", "kept", "file.txt")
+	os.MkdirAll(filepath.Dir(nested), 0755)
+	os.WriteFile(nested, []byte("kept"), 0644)
+	os.Chtimes(filepath.Dir(nested), old, old)
+	if _, err := cleanStalePartials(destDir, ".rsync-partial", time.Hour); err != nil {
+		t.Fatalf("cleanStalePartials failed: %v", err)
+	}
+	if _, err := os.Stat(nested); err != nil {
+		t.Errorf("Expected directories in the partial directory to be left alone: %v", err)
+	}
+
+	for _, dir := range []string{".rsync-partial", "/var/tmp/partial", ""} {
+		if !ValidPartialDir(dir) {
+			t.Errorf("Expected %q to be a valid partial_dir", dir)
+		}
+	}
+	for _, dir := range []string{"tmp", ".", "..", ".a/b"} {
+		if ValidPartialDir(dir) {
+			t.Errorf("Expected %q to be refused", dir)
+		}
+	}
+}
+
+// TestRsyncArgsPartialDir tests that the partial dir is passed to rsync
+func TestRsyncArgsPartialDir(t *testing.T) {
+	testSync := NewSync(testSourceDir, testDestDir, 60)
+
+	args := testSync.rsyncArgs(testSourceDir + "/")
+	for _, arg := range args {
+		if arg == "--partial-dir=.rsync-partial" {
+			t.Errorf("--partial-dir should not be set by default")
+		}
+	}
+
+	testSync.Pair.PartialDir = ".rsync-partial"
+	args = testSync.rsyncArgs(testSourceDir + "/")
+
+	found := false
+	for _, arg := range args {
+		if arg == "--partial-dir=.rsync-partial" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected --partial-dir in rsync args, got %v", args)
+	}
+
+	if args[len(args)-1] != testDestDir {
+		t.Errorf("Expected destination as last argument, got %s", args[len(args)-1])
+	}
+}
@@ -0,0 +1,212 @@
+package dirsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// Plugin kinds, taken from the file name: name.filter.wasm,
+// name.transform.wasm or name.notify.wasm
+const (
+	pluginFilter    = "filter"
+	pluginTransform = "transform"
+	pluginNotify    = "notify"
+)
+
+// Plugins are WebAssembly modules loaded from a directory that extend
+// dirsync without rebuilding it. They run as WASI commands with no access to
+// the filesystem or network:
+//
+//   - filters get the arguments "filter" and the file's path, and exit with
+//     0 to copy the file or 1 to skip it
+//   - transformers get "transform" and the file's path, read the file on
+//     stdin and write the transformed file to stdout
+//   - notifiers get "notify" and read the run's notification as JSON on stdin
+type Plugins struct {
+	runtime wazero.Runtime
+	plugins map[string]*plugin
+}
+
+// plugin is a single compiled plugin module
+type plugin struct {
+	name    string
+	kind    string
+	runtime wazero.Runtime
+	module  wazero.CompiledModule
+}
+
+// LoadPlugins compiles every plugin in dir
+func LoadPlugins(ctx context.Context, dir string) (*Plugins, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.wasm"))
+	if err != nil {
+		return nil, err
+	}
+
+	runtime := wazero.NewRuntime(ctx)
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		runtime.Close(ctx)
+		return nil, err
+	}
+
+	p := &Plugins{runtime: runtime, plugins: make(map[string]*plugin)}
+	for _, path := range paths {
+		name, kind, ok := parsePluginName(filepath.Base(path))
+		if !ok {
+			runtime.Close(ctx)
+			return nil, fmt.Errorf("plugin %s should be named <name>.filter.wasm, <name>.transform.wasm or <name>.notify.wasm", filepath.Base(path))
+		}
+
+		code, err := os.ReadFile(path)
+		if err != nil {
+			runtime.Close(ctx)
+			return nil, err
+		}
+
+		module, err := runtime.CompileModule(ctx, code)
+		if err != nil {
+			runtime.Close(ctx)
+			return nil, fmt.Errorf("error compiling plugin %s: %w", filepath.Base(path), err)
+		}
+
+		p.plugins[kind+"/"+name] = &plugin{name: name, kind: kind, runtime: runtime, module: module}
+	}
+
+	return p, nil
+}
+
+// parsePluginName splits a plugin's file name into its name and kind
+func parsePluginName(file string) (name, kind string, ok bool) {
+	base := strings.TrimSuffix(file, ".wasm")
+	dot := strings.LastIndex(base, ".")
+	if dot <= 0 {
+		return "", "", false
+	}
+
+	name, kind = base[:dot], base[dot+1:]
+	switch kind {
+	case pluginFilter, pluginTransform, pluginNotify:
+		return name, kind, true
+	}
+	return "", "", false
+}
+
+// Close frees the compiled plugins
+func (p *Plugins) Close(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	return p.runtime.Close(ctx)
+}
+
+// Names returns the loaded plugins as kind/name, sorted
+func (p *Plugins) Names() []string {
+	if p == nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(p.plugins))
+	for key := range p.plugins {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// get returns the plugin of the given kind and name
+func (p *Plugins) get(kind, name string) (*plugin, error) {
+	if p != nil {
+		if plug, ok := p.plugins[kind+"/"+name]; ok {
+			return plug, nil
+		}
+	}
+	return nil, fmt.Errorf("%s plugin %q is not loaded", kind, name)
+}
+
+// notifiers returns every loaded notifier plugin
+func (p *Plugins) notifiers() []*plugin {
+	if p == nil {
+		return nil
+	}
+
+	var notifiers []*plugin
+	for _, key := range p.Names() {
+		if plug := p.plugins[key]; plug.kind == pluginNotify {
+			notifiers = append(notifiers, plug)
+		}
+	}
+	return notifiers
+}
+
+// run instantiates the plugin with the given arguments and streams, and
+// returns its exit code and anything it wrote to stderr
+func (plug *plugin) run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) (uint32, string, error) {
+	if stdin == nil {
+		stdin = bytes.NewReader(nil)
+	}
+	if stdout == nil {
+		stdout = io.Discard
+	}
+
+	var stderr bytes.Buffer
+	config := wazero.NewModuleConfig().
+		WithName("").
+		WithArgs(append([]string{plug.name}, args...)...).
+		WithStdin(stdin).
+		WithStdout(stdout).
+		WithStderr(&stderr)
+
+	module, err := plug.runtime.InstantiateModule(ctx, plug.module, config)
+	if module != nil {
+		module.Close(ctx)
+	}
+
+	errOutput := strings.TrimSpace(stderr.String())
+
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), errOutput, nil
+	}
+	if err != nil {
+		return 0, errOutput, fmt.Errorf("%s plugin %s failed: %v: %s", plug.kind, plug.name, err, errOutput)
+	}
+	return 0, errOutput, nil
+}
+
+// runChecked runs the plugin and treats a non-zero exit code as an error
+func (plug *plugin) runChecked(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	code, errOutput, err := plug.run(ctx, args, stdin, stdout)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("%s plugin %s exited with status %d: %s", plug.kind, plug.name, code, errOutput)
+	}
+	return nil
+}
+
+// include runs a filter plugin and reports whether the file at rel should be copied
+func (plug *plugin) include(ctx context.Context, rel string) (bool, error) {
+	code, errOutput, err := plug.run(ctx, []string{pluginFilter, filepath.ToSlash(rel)}, nil, nil)
+	if err != nil {
+		return false, err
+	}
+
+	switch code {
+	case 0:
+		return true, nil
+	case 1:
+		return false, nil
+	}
+	return false, fmt.Errorf("filter plugin %s exited with status %d: %s", plug.name, code, errOutput)
+}
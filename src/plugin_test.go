@@ -0,0 +1,140 @@
+package dirsync
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var (
+	testPluginOnce sync.Once
+	testPluginPath string
+	testPluginErr  error
+)
+
+// buildTestPlugin compiles testdata/plugin to WebAssembly once per test run
+func buildTestPlugin(t *testing.T) string {
+	t.Helper()
+
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not available to build the test plugin")
+	}
+
+	testPluginOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "dirsync_test_plugin")
+		if err != nil {
+			testPluginErr = err
+			return
+		}
+		testPluginPath = filepath.Join(dir, "plugin.wasm")
+
+		cmd := exec.Command("go", "build", "-o", testPluginPath, "./testdata/plugin")
+		cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			testPluginErr = err
+			t.Logf("go build: %s", out)
+		}
+	})
+	if testPluginErr != nil {
+		t.Fatalf("Failed to build test plugin: %v", testPluginErr)
+	}
+
+	return testPluginPath
+}
+
+// loadTestPlugins installs the test plugin under the given file names and loads them
+func loadTestPlugins(t *testing.T, names ...string) *Plugins {
+	t.Helper()
+
+	code, err := os.ReadFile(buildTestPlugin(t))
+	if err != nil {
+		t.Fatalf("Failed to read test plugin: %v", err)
+	}
+
+	dir := t.TempDir()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), code, 0644); err != nil {
+			t.Fatalf("Failed to install plugin: %v", err)
+		}
+	}
+
+	plugins, err := LoadPlugins(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("LoadPlugins failed: %v", err)
+	}
+	t.Cleanup(func() { plugins.Close(context.Background()) })
+
+	return plugins
+}
+
+// TestParsePluginName tests taking a plugin's kind from its file name
+func TestParsePluginName(t *testing.T) {
+	if name, kind, ok := parsePluginName("strip.gps.transform.wasm"); !ok || name != "strip.gps" || kind != pluginTransform {
+		t.Errorf("Unexpected result: %s %s %v", name, kind, ok)
+	}
+	for _, file := range []string{"plugin.wasm", "x.unknown.wasm", ".filter.wasm"} {
+		if _, _, ok := parsePluginName(file); ok {
+			t.Errorf("Expected %s to be rejected", file)
+		}
+	}
+
+	if _, err := LoadPlugins(context.Background(), t.TempDir()); err != nil {
+		t.Errorf("Expected an empty plugins directory to load, got %v", err)
+	}
+}
+
+// TestSyncWithPlugins tests filter, transform and notify plugins
+func TestSyncWithPlugins(t *testing.T) {
+	plugins := loadTestPlugins(t, "skiptmp.filter.wasm", "upper.transform.wasm", "check.notify.wasm")
+
+	want := []string{"filter/skiptmp", "notify/check", "transform/upper"}
+	if got := plugins.Names(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected plugins %v, got %v", want, got)
+	}
+
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	for name, content := range map[string]string{"keep.txt": "hello", "scratch.tmp": "temp", "notes.md": "notes"} {
+		if err := os.WriteFile(filepath.Join(sourceDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create source file: %v", err)
+		}
+	}
+
+	manager := NewSyncManager(Options{Plugins: plugins})
+	testSync := manager.AddPair(PairConfig{
+		Source:      sourceDir,
+		Destination: destDir,
+		Filters:     []string{"skiptmp"},
+		Transforms:  []TransformConfig{{Patterns: []string{"*.txt"}, Plugin: "upper"}},
+	}, 60)
+
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(destDir, "keep.txt")); err != nil || string(data) != "HELLO" {
+		t.Errorf("Expected transformed keep.txt, got %q (%v)", data, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(destDir, "notes.md")); err != nil || string(data) != "notes" {
+		t.Errorf("Expected notes.md to be copied as is, got %q (%v)", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "scratch.tmp")); !os.IsNotExist(err) {
+		t.Errorf("Expected scratch.tmp to be filtered out")
+	}
+
+	// Plugin failures fail the run with the plugin's error output
+	os.WriteFile(filepath.Join(sourceDir, "keep.txt"), []byte("fail please"), 0644)
+	if err := testSync.SyncDirectories(); err == nil || !strings.Contains(err.Error(), "refusing to transform") {
+		t.Errorf("Expected transform plugin error, got %v", err)
+	}
+
+	// Unknown plugins are reported
+	testSync.Pair.Filters = []string{"missing"}
+	if err := testSync.SyncDirectories(); err == nil || !strings.Contains(err.Error(), "not loaded") {
+		t.Errorf("Expected error for missing filter plugin, got %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package dirsync
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// runSlots limits how many pairs run at once. Pairs waiting for a slot get
+// one in order of priority, highest first, then in the order they asked.
+type runSlots struct {
+	free    int
+	waiting []*slotWaiter
+	seq     uint64
+	mu      sync.Mutex
+}
+
+// slotWaiter is a pair waiting for a run slot
+type slotWaiter struct {
+	priority int
+	seq      uint64
+	ready    chan struct{}
+}
+
+// newRunSlots returns a limit of n runs at once, or nil for no limit
+func newRunSlots(n int) *runSlots {
+	if n <= 0 {
+		return nil
+	}
+	return &runSlots{free: n}
+}
+
+// acquire waits for a run slot, returning false if ctx is done first. A nil
+// runSlots has a slot for everyone.
+func (rs *runSlots) acquire(ctx context.Context, priority int) bool {
+	if rs == nil {
+		return true
+	}
+
+	rs.mu.Lock()
+	if rs.free > 0 && len(rs.waiting) == 0 {
+		rs.free--
+		rs.mu.Unlock()
+		return true
+	}
+
+	rs.seq++
+	w := &slotWaiter{priority: priority, seq: rs.seq, ready: make(chan struct{})}
+	rs.waiting = append(rs.waiting, w)
+	sort.SliceStable(rs.waiting, func(i, j int) bool {
+		if rs.waiting[i].priority != rs.waiting[j].priority {
+			return rs.waiting[i].priority > rs.waiting[j].priority
+		}
+		return rs.waiting[i].seq < rs.waiting[j].seq
+	})
+	rs.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return true
+	case <-ctx.Done():
+	}
+
+	// Give the slot back if it was handed over while giving up
+	rs.mu.Lock()
+	for i, other := range rs.waiting {
+		if other == w {
+			rs.waiting = append(rs.waiting[:i], rs.waiting[i+1:]...)
+			rs.mu.Unlock()
+			return false
+		}
+	}
+	rs.mu.Unlock()
+	rs.release()
+	return false
+}
+
+// release hands a run slot to the first pair waiting, or frees it
+func (rs *runSlots) release() {
+	if rs == nil {
+		return
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if len(rs.waiting) > 0 {
+		w := rs.waiting[0]
+		rs.waiting = rs.waiting[1:]
+		close(w.ready)
+		return
+	}
+	rs.free++
+}
+
+// waitForSlot waits for a run slot, marking the pair as queued meanwhile. It
+// returns false if ctx is done first.
+func (s *Sync) waitForSlot(ctx context.Context) bool {
+	s.mu.Lock()
+	s.queued = true
+	s.mu.Unlock()
+
+	ok := s.slots.acquire(ctx, s.Pair.Priority)
+
+	s.mu.Lock()
+	s.queued = false
+	s.mu.Unlock()
+	return ok
+}
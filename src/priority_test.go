@@ -0,0 +1,124 @@
+package dirsync
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForWaiters waits until n pairs are waiting for a run slot
+func waitForWaiters(t *testing.T, rs *runSlots, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		rs.mu.Lock()
+		waiting := len(rs.waiting)
+		rs.mu.Unlock()
+		if waiting == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d waiting pairs, got %d", n, waiting)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestRunSlotsPriority tests that pairs waiting for a run slot get one by
+// priority, then in the order they asked
+func TestRunSlotsPriority(t *testing.T) {
+	rs := newRunSlots(1)
+	ctx := context.Background()
+	if !rs.acquire(ctx, 0) {
+		t.Fatalf("Expected a free slot")
+	}
+
+	var (
+		order []string
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+	)
+	waiters := []struct {
+		name     string
+		priority int
+	}{{"media", -1}, {"photos", 0}, {"documents", 10}, {"music", 0}}
+	for i, w := range waiters {
+		wg.Add(1)
+		go func(name string, priority int) {
+			defer wg.Done()
+			rs.acquire(ctx, priority)
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			rs.release()
+		}(w.name, w.priority)
+		waitForWaiters(t, rs, i+1)
+	}
+
+	rs.release()
+	wg.Wait()
+
+	want := []string{"documents", "photos", "music", "media"}
+	for i := range want {
+		if i >= len(order) || order[i] != want[i] {
+			t.Fatalf("Expected runs in order %v, got %v", want, order)
+		}
+	}
+	if rs.free != 1 {
+		t.Errorf("Expected the slot to be free again, got %d free", rs.free)
+	}
+}
+
+// TestRunSlotsCancel tests that a pair giving up on a run slot leaves the
+// queue without taking one
+func TestRunSlotsCancel(t *testing.T) {
+	rs := newRunSlots(1)
+	rs.acquire(context.Background(), 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() { done <- rs.acquire(ctx, 0) }()
+	waitForWaiters(t, rs, 1)
+	cancel()
+	if <-done {
+		t.Errorf("Expected a cancelled wait not to get a slot")
+	}
+
+	rs.release()
+	if len(rs.waiting) != 0 || rs.free != 1 {
+		t.Errorf("Expected an empty queue and a free slot, got %d waiting and %d free", len(rs.waiting), rs.free)
+	}
+
+	// Without a limit there's always a slot
+	var unlimited *runSlots
+	if !unlimited.acquire(ctx, 0) {
+		t.Errorf("Expected a slot without a limit")
+	}
+	unlimited.release()
+}
+
+// TestQueuedPairNotStalled tests that the watchdog leaves pairs waiting for
+// a run slot alone
+func TestQueuedPairNotStalled(t *testing.T) {
+	manager := NewSyncManager(Options{MaxConcurrentRuns: 1})
+	s := manager.AddSync(testSourceDir, t.TempDir(), 60)
+	manager.slots.acquire(context.Background(), 0)
+
+	s.mu.Lock()
+	s.loopCancel = func() {}
+	s.loopRunning = true
+	s.NextSyncTime = time.Now().Add(-time.Hour)
+	s.mu.Unlock()
+
+	go s.waitForSlot(context.Background())
+	waitForWaiters(t, manager.slots, 1)
+
+	if reason := s.stalled(time.Now()); reason != "" {
+		t.Errorf("Expected a queued pair not to be stalled, got %q", reason)
+	}
+	if queued, _ := s.GetStatus()["queued"].(bool); !queued {
+		t.Errorf("Expected the status to show the pair as queued")
+	}
+	manager.slots.release()
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package dirsync
+
+import "os/exec"
+
+// setProcessGroup does nothing on systems without process groups
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the command; the processes it spawned are left
+// running on systems without process groups
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,27 @@
+//go:build unix
+
+package dirsync
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts cmd in a process group of its own, and makes
+// cancelling its context kill the whole group, so the processes it spawns,
+// such as rsync's receiver or ssh, stop with it instead of being orphaned
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
+}
+
+// killProcessGroup kills a command started with setProcessGroup and every
+// process in its group
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,64 @@
+//go:build unix
+
+package dirsync
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// processRunning reports whether the process with pid is alive, treating
+// zombies waiting to be reaped as stopped
+func processRunning(pid int) bool {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(stat))
+	return len(fields) > 2 && fields[2] != "Z"
+}
+
+// TestKillProcessGroup tests that cancelling a command also stops the
+// processes it spawned
+func TestKillProcessGroup(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("needs /proc to look at processes")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, "sh", "-c", "sleep 60 & echo $!; wait")
+	setProcessGroup(cmd)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("Failed to create stdout pipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start command: %v", err)
+	}
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read child pid: %v", err)
+	}
+	child, _ := strconv.Atoi(strings.TrimSpace(line))
+	if !processRunning(child) {
+		t.Fatalf("Expected the child %d to be running", child)
+	}
+
+	cancel()
+	cmd.Wait()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for processRunning(child) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the child %d to be killed with its parent", child)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,93 @@
+package dirsync
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// QuickCheckConfig skips runs when nothing at the top of the source has
+// changed since the last successful run, without walking the whole tree.
+// It relies on directory modification times, which change when entries are
+// added, removed or renamed in them but not when a file deeper down is
+// edited in place, so a full run is still made every FullScanInterval.
+type QuickCheckConfig struct {
+	// FullScanInterval is how many seconds may pass after a full run
+	// before one is made regardless (default 86400)
+	FullScanInterval int `json:"full_scan_interval"`
+}
+
+// defaultFullScanInterval is how often quick-checked pairs run in full when
+// no interval is configured, in seconds
+const defaultFullScanInterval = 24 * 60 * 60
+
+// Validate checks the full scan interval
+func (c *QuickCheckConfig) Validate() error {
+	if c.FullScanInterval < 0 {
+		return fmt.Errorf("quick_check full_scan_interval %d is negative", c.FullScanInterval)
+	}
+	return nil
+}
+
+// fullScanInterval returns how long a quick check can skip runs for
+func (c *QuickCheckConfig) fullScanInterval() time.Duration {
+	if c.FullScanInterval > 0 {
+		return time.Duration(c.FullScanInterval) * time.Second
+	}
+	return defaultFullScanInterval * time.Second
+}
+
+// topLevelFingerprint summarizes the source directory and its immediate
+// entries by name, mode, size and modification time, skipping excluded
+// directories and the lock file
+func topLevelFingerprint(root string, skip ...string) (string, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return "", err
+	}
+
+	skipped := map[string]bool{LockFile: true}
+	for _, dir := range skip {
+		skipped[filepath.FromSlash(dir)] = true
+	}
+
+	h := fnv.New64a()
+	fmt.Fprintf(h, ".\x00%d\x00%d\n", info.Mode(), info.ModTime().UnixNano())
+	for _, entry := range entries {
+		if skipped[entry.Name()] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00%d\n", entry.Name(), info.Mode(), info.Size(), info.ModTime().UnixNano())
+	}
+	return fmt.Sprintf("%x", h.Sum64()), nil
+}
+
+// quickCheckUnchanged reports whether the top of the source looks the same
+// as at the last successful full run, and that run was recent enough to
+// trust it, returning the current fingerprint for bookkeeping
+func (s *Sync) quickCheckUnchanged() (bool, string) {
+	s.mu.RLock()
+	last := s.lastQuickCheck
+	lastFull := s.lastFullScan
+	skip := s.excludedDirs
+	s.mu.RUnlock()
+
+	fingerprint, err := topLevelFingerprint(s.SourcePath, skip...)
+	if err != nil {
+		// Let the regular sync path report the problem
+		return false, ""
+	}
+
+	fresh := time.Since(lastFull) < s.Pair.QuickCheck.fullScanInterval()
+	return last != "" && last == fingerprint && fresh, fingerprint
+}
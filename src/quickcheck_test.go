@@ -0,0 +1,61 @@
+package dirsync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestQuickCheck tests that runs are skipped while the top of the source
+// is unchanged, until a full scan is due
+func TestQuickCheck(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	past := time.Now().Add(-time.Hour)
+	os.MkdirAll(filepath.Join(sourceDir, "docs"), 0755)
+	os.WriteFile(filepath.Join(sourceDir, "docs", "a.txt"), []byte("a"), 0644)
+	os.Chtimes(filepath.Join(sourceDir, "docs"), past, past)
+
+	testSync := NewSync(sourceDir, destDir, 60)
+	testSync.Pair.QuickCheck = &QuickCheckConfig{}
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	// An in-place edit deeper down goes unnoticed until the full scan
+	os.WriteFile(filepath.Join(sourceDir, "docs", "a.txt"), []byte("edited"), 0644)
+	os.Chtimes(filepath.Join(sourceDir, "docs"), past, past)
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if !strings.Contains(testSync.GetStatus()["output"].(string), "directories unchanged") {
+		t.Errorf("Expected the run to be skipped, got %q", testSync.GetStatus()["output"])
+	}
+	if data, _ := os.ReadFile(filepath.Join(destDir, "docs", "a.txt")); string(data) != "a" {
+		t.Errorf("Expected the destination to be left alone, got %q", data)
+	}
+
+	// A new file at the top changes the source's modification time
+	os.WriteFile(filepath.Join(sourceDir, "b.txt"), []byte("b"), 0644)
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "b.txt")); err != nil {
+		t.Errorf("Expected the new file to be copied: %v", err)
+	}
+
+	// Once the last full run is too old, the run goes ahead regardless
+	os.WriteFile(filepath.Join(sourceDir, "docs", "a.txt"), []byte("edited again"), 0644)
+	os.Chtimes(filepath.Join(sourceDir, "docs"), past, past)
+	testSync.mu.Lock()
+	testSync.lastFullScan = time.Now().Add(-25 * time.Hour)
+	testSync.mu.Unlock()
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(destDir, "docs", "a.txt")); string(data) != "edited again" {
+		t.Errorf("Expected the full scan to copy the edit, got %q", data)
+	}
+}
@@ -0,0 +1,36 @@
+package dirsync
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+)
+
+// panicError turns a recovered panic into an error, logging where it
+// happened so the bug can be found
+func (s *Sync) panicError(r interface{}) error {
+	log.Printf("[%s] Recovered from panic: %v\n%s", s.ID, r, debug.Stack())
+	return fmt.Errorf("internal error: %v", r)
+}
+
+// recoverRun, when deferred, turns a panic into the run's error and marks
+// the pair failed, so a bug hit by one pair leaves the daemon and the
+// other pairs running
+func (s *Sync) recoverRun(err *error) {
+	if r := recover(); r != nil {
+		*err = s.panicError(r)
+		s.setError((*err).Error())
+	}
+}
+
+// copyFileSafely copies a file like copyFile, turning a panic into an
+// error, for copies made in their own goroutine where a panic would
+// otherwise end the process
+func (c *fileCopier) copyFileSafely(rel string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("copying %s: %w", rel, c.sync.panicError(r))
+		}
+	}()
+	return c.copyFile(rel)
+}
@@ -0,0 +1,60 @@
+package dirsync
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// panickingHook panics at the start of a run or while copying file2.txt
+type panickingHook struct {
+	NopHook
+	onStart bool
+}
+
+func (h panickingHook) OnSyncStart(ctx context.Context, event HookEvent) error {
+	if h.onStart {
+		var counts map[string]int
+		counts["runs"]++
+	}
+	return nil
+}
+
+func (h panickingHook) OnFile(ctx context.Context, event HookEvent) error {
+	if event.File == "file2.txt" {
+		panic("bad pattern")
+	}
+	return nil
+}
+
+// TestRunRecoversFromPanics tests that a panic during a run fails only
+// that run, is recorded in the history, and leaves other pairs working
+func TestRunRecoversFromPanics(t *testing.T) {
+	RegisterHook("test-panic-start", panickingHook{onStart: true})
+	RegisterHook("test-panic-file", panickingHook{})
+
+	manager := NewSyncManager(Options{})
+	for _, hook := range []string{"test-panic-start", "test-panic-file"} {
+		s := manager.AddPair(PairConfig{Source: testSourceDir, Destination: t.TempDir(), Hooks: []string{hook}}, 60)
+
+		err := s.SyncDirectories()
+		if err == nil || !strings.Contains(err.Error(), "internal error") {
+			t.Errorf("%s: expected the panic to fail the run, got %v", hook, err)
+		}
+
+		status := s.GetStatus()
+		if status["is_syncing"].(bool) || !strings.Contains(status["last_error"].(string), "internal error") {
+			t.Errorf("%s: expected the pair to be marked failed, got %v", hook, status)
+		}
+
+		runs := manager.History.ListRuns(s.ID)
+		if len(runs) != 1 || runs[0].Success {
+			t.Errorf("%s: expected a failed run in the history, got %+v", hook, runs)
+		}
+	}
+
+	healthy := manager.AddSync(testSourceDir, t.TempDir(), 60)
+	if err := healthy.SyncDirectories(); err != nil {
+		t.Errorf("Expected other pairs to keep working, got %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+package dirsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RemoteHookConfig describes commands to run on the destination host over
+// SSH after a run, such as fixing ownership, invalidating a cache or
+// reloading a service that serves the synced files
+type RemoteHookConfig struct {
+	// Host is the SSH destination, as "host" or "user@host"
+	Host         string   `json:"host"`
+	Port         int      `json:"port"`
+	User         string   `json:"user"`
+	IdentityFile string   `json:"identity_file"`
+	Commands     []string `json:"commands"`
+
+	// KnownHosts is the known_hosts file to check the host key against,
+	// instead of the user's own
+	KnownHosts string `json:"known_hosts"`
+
+	// HostKeyChecking is "yes" to refuse unknown host keys, "accept-new" to
+	// trust a host's key the first time it's seen, or "no" to accept any
+	// key. ssh's own setting is used without it.
+	HostKeyChecking string `json:"host_key_checking"`
+
+	// ConnectTimeout is how many seconds connecting may take, so an
+	// unreachable host fails the hooks quickly instead of using up the
+	// timeout of the first command
+	ConnectTimeout int `json:"connect_timeout"`
+
+	// ControlPersist keeps the connection open for this many seconds after
+	// the last command, so the next command and later runs reuse it
+	// instead of connecting again. 0 connects for each command.
+	ControlPersist int `json:"control_persist"`
+
+	// AgentSocket is the socket of a running ssh-agent to authenticate
+	// with, so keys can stay encrypted on disk. Without it, ssh uses the
+	// agent in the daemon's own SSH_AUTH_SOCK, if any.
+	AgentSocket string `json:"agent_socket"`
+
+	// OnFailure also runs the commands after failed runs
+	OnFailure bool `json:"on_failure"`
+
+	// Timeout is how many seconds each command may take (default 300)
+	Timeout int `json:"timeout"`
+}
+
+// defaultRemoteTimeout is how long a remote command may take when no
+// timeout is configured
+const defaultRemoteTimeout = 300
+
+// sshCommand is the ssh client remote hooks are run with
+var sshCommand = "ssh"
+
+// sshControlDir holds the sockets of connections kept open for reuse. It
+// is empty until SetSSHControlDir is called, and connections aren't kept
+// open without it.
+var sshControlDir string
+
+// SetSSHControlDir creates dir, private to the current user, for the
+// sockets of SSH connections remote hooks keep open with control_persist.
+// A directory others can write to would let them take over the connections,
+// so an existing one has its permissions tightened, and one that isn't a
+// plain directory is refused.
+func SetSSHControlDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		if err := os.Chmod(dir, 0700); err != nil {
+			return err
+		}
+	}
+	sshControlDir = dir
+	return nil
+}
+
+// RemoteCommandResult is the outcome of a command run on the destination
+// host after a run
+type RemoteCommandResult struct {
+	Command  string `json:"command"`
+	Output   string `json:"output,omitempty"`
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Validate checks that the remote hooks have somewhere to run and
+// something to run
+func (c *RemoteHookConfig) Validate() error {
+	switch {
+	case c.Host == "":
+		return fmt.Errorf("remote_hooks has no host")
+	case len(c.Commands) == 0:
+		return fmt.Errorf("remote_hooks has no commands")
+	case c.Port < 0 || c.Port > 65535:
+		return fmt.Errorf("remote_hooks port %d is out of range", c.Port)
+	case c.HostKeyChecking != "" && c.HostKeyChecking != "yes" && c.HostKeyChecking != "accept-new" && c.HostKeyChecking != "no":
+		return fmt.Errorf("remote_hooks host_key_checking %q isn't yes, accept-new or no", c.HostKeyChecking)
+	case c.ConnectTimeout < 0 || c.ControlPersist < 0:
+		return fmt.Errorf("remote_hooks connect_timeout and control_persist can't be negative")
+	}
+	return nil
+}
+
+// sshArgs returns the arguments to ssh for running command on the host.
+// BatchMode keeps ssh from prompting for a password the daemon can't type.
+func (c *RemoteHookConfig) sshArgs(command string) []string {
+	args := []string{"-o", "BatchMode=yes"}
+	if c.Port != 0 {
+		args = append(args, "-p", strconv.Itoa(c.Port))
+	}
+	if c.User != "" {
+		args = append(args, "-l", c.User)
+	}
+	if c.IdentityFile != "" {
+		args = append(args, "-i", c.IdentityFile)
+	}
+	if c.KnownHosts != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+c.KnownHosts)
+	}
+	if c.HostKeyChecking != "" {
+		args = append(args, "-o", "StrictHostKeyChecking="+c.HostKeyChecking)
+	}
+	if c.ConnectTimeout > 0 {
+		args = append(args, "-o", "ConnectTimeout="+strconv.Itoa(c.ConnectTimeout))
+	}
+	if c.ControlPersist > 0 && sshControlDir != "" {
+		// %C is a hash of the connection's details, so each host and user
+		// gets its own master and the path stays short enough for a socket
+		args = append(args, "-o", "ControlMaster=auto",
+			"-o", "ControlPath="+filepath.Join(sshControlDir, "dirsync-%C"),
+			"-o", "ControlPersist="+strconv.Itoa(c.ControlPersist))
+	}
+	return append(args, "--", c.Host, command)
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// remoteCommand prefixes command with the run's details, so it can use
+// DIRSYNC_SYNC_ID, DIRSYNC_RUN_ID, DIRSYNC_DESTINATION and DIRSYNC_SUCCESS
+func remoteCommand(command string, event HookEvent, runID string, success bool) string {
+	return fmt.Sprintf("export DIRSYNC_SYNC_ID=%s DIRSYNC_RUN_ID=%s DIRSYNC_DESTINATION=%s DIRSYNC_SUCCESS=%t; %s",
+		shellQuote(event.SyncID), shellQuote(runID), shellQuote(event.Destination), success, command)
+}
+
+// runRemoteHooks runs the pair's remote commands one after another,
+// records what they printed in the run's stats and output, and returns an
+// error for the first command that failed. Later commands are skipped once
+// one fails, as they usually depend on it.
+func (s *Sync) runRemoteHooks(ctx context.Context, runID string, runErr error) error {
+	remote := s.Pair.RemoteHooks
+	if remote == nil || s.Pair.DryRun || (runErr != nil && !remote.OnFailure) {
+		return nil
+	}
+
+	timeout := remote.Timeout
+	if timeout <= 0 {
+		timeout = defaultRemoteTimeout
+	}
+
+	// ssh only says the key was refused when the agent isn't running
+	if remote.AgentSocket != "" {
+		if _, err := os.Stat(remote.AgentSocket); err != nil {
+			return fmt.Errorf("ssh-agent for %s isn't running: %v", remote.Host, err)
+		}
+	}
+
+	for _, command := range remote.Commands {
+		log.Printf("[%s] Running on %s: %s", s.ID, remote.Host, command)
+
+		cmdCtx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		full := remoteCommand(command, s.hookEvent(""), runID, runErr == nil)
+		cmd := exec.CommandContext(cmdCtx, sshCommand, remote.sshArgs(full)...)
+		if remote.AgentSocket != "" {
+			cmd.Env = append(os.Environ(), "SSH_AUTH_SOCK="+remote.AgentSocket)
+		}
+		out, err := cmd.CombinedOutput()
+		cancel()
+
+		result := RemoteCommandResult{Command: command, Output: strings.TrimSpace(string(out))}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitCode = exitErr.ExitCode()
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+
+		s.mu.Lock()
+		s.stats.RemoteCommands = append(s.stats.RemoteCommands, result)
+		s.mu.Unlock()
+
+		s.appendOutput(fmt.Sprintf("Ran on %s: %s", remote.Host, command))
+		if result.Output != "" {
+			s.appendOutput(result.Output)
+		}
+
+		if err != nil {
+			return fmt.Errorf("remote command %q on %s failed: %v", command, remote.Host, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package dirsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeSSH points remote hooks at a script that runs the command locally
+// instead of on a host, after recording the arguments it was given
+func fakeSSH(t *testing.T) (argsFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	argsFile = filepath.Join(dir, "args")
+	script := filepath.Join(dir, "ssh")
+	os.WriteFile(script, []byte(`#!/bin/sh
+echo "$@" >> "`+argsFile+`"
+for arg; do command="$arg"; done
+exec sh -c "$command"
+`), 0755)
+
+	old := sshCommand
+	sshCommand = script
+	t.Cleanup(func() { sshCommand = old })
+	return argsFile
+}
+
+// TestRemoteHookArgs tests the ssh command line for remote hooks
+func TestRemoteHookArgs(t *testing.T) {
+	remote := &RemoteHookConfig{Host: "backup@nas", Port: 2222, IdentityFile: "/keys/id_ed25519"}
+	want := []string{"-o", "BatchMode=yes", "-p", "2222", "-i", "/keys/id_ed25519", "--", "backup@nas", "uptime"}
+	if got := remote.sshArgs("uptime"); !reflect.DeepEqual(got, want) {
+		t.Errorf("sshArgs = %q, want %q", got, want)
+	}
+
+	sshControlDir = "/run/dirsync"
+	defer func() { sshControlDir = "" }()
+	remote = &RemoteHookConfig{Host: "nas", User: "backup", KnownHosts: "/keys/known_hosts", HostKeyChecking: "accept-new", ConnectTimeout: 10, ControlPersist: 600}
+	want = []string{"-o", "BatchMode=yes", "-l", "backup", "-o", "UserKnownHostsFile=/keys/known_hosts", "-o", "StrictHostKeyChecking=accept-new",
+		"-o", "ConnectTimeout=10", "-o", "ControlMaster=auto", "-o", "ControlPath=/run/dirsync/dirsync-%C", "-o", "ControlPersist=600", "--", "nas", "uptime"}
+	if got := remote.sshArgs("uptime"); !reflect.DeepEqual(got, want) {
+		t.Errorf("sshArgs = %q, want %q", got, want)
+	}
+
+	for _, config := range []RemoteHookConfig{{Commands: []string{"uptime"}}, {Host: "nas"}, {Host: "nas", Commands: []string{"uptime"}, Port: 70000},
+		{Host: "nas", Commands: []string{"uptime"}, HostKeyChecking: "ask"}, {Host: "nas", Commands: []string{"uptime"}, ConnectTimeout: -1}} {
+		if err := config.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", config)
+		}
+	}
+}
+
+// TestSetSSHControlDir tests keeping connection sockets in a private
+// directory, and not keeping connections open without one
+func TestSetSSHControlDir(t *testing.T) {
+	defer func() { sshControlDir = "" }()
+	remote := &RemoteHookConfig{Host: "nas", ControlPersist: 600}
+	if args := strings.Join(remote.sshArgs("uptime"), " "); strings.Contains(args, "ControlPath") {
+		t.Errorf("Expected no shared connection without a control directory, got %s", args)
+	}
+
+	dir := filepath.Join(t.TempDir(), "ssh")
+	os.MkdirAll(dir, 0777)
+	os.Chmod(dir, 0777)
+	if err := SetSSHControlDir(dir); err != nil {
+		t.Fatalf("SetSSHControlDir failed: %v", err)
+	}
+	if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("Expected the control directory to be private, got %v, %v", info, err)
+	}
+	if args := strings.Join(remote.sshArgs("uptime"), " "); !strings.Contains(args, "ControlPath="+filepath.Join(dir, "dirsync-%C")) {
+		t.Errorf("Expected the control socket in %s, got %s", dir, args)
+	}
+
+	link := filepath.Join(t.TempDir(), "link")
+	os.Symlink(t.TempDir(), link)
+	if err := SetSSHControlDir(link); err == nil {
+		t.Errorf("Expected a symlink to be refused")
+	}
+}
+
+// TestRemoteHooks tests that commands run on the destination host after a
+// run, with their output kept in the run's record
+func TestRemoteHooks(t *testing.T) {
+	argsFile := fakeSSH(t)
+
+	syncManager := NewSyncManager(Options{})
+	testSync := syncManager.AddSync(testSourceDir, t.TempDir(), 60)
+	testSync.Pair.RemoteHooks = &RemoteHookConfig{
+		Host:     "nas",
+		Commands: []string{`echo "reloaded $DIRSYNC_SYNC_ID $DIRSYNC_SUCCESS"`, "exit 3", "echo never"},
+	}
+
+	err := testSync.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), `remote command "exit 3" on nas failed`) {
+		t.Fatalf("Expected the failed remote command to fail the run, got %v", err)
+	}
+
+	runs := syncManager.History.ListRuns(testSync.ID)
+	if len(runs) != 1 {
+		t.Fatalf("Expected 1 run, got %d", len(runs))
+	}
+	results := runs[0].RemoteCommands
+	if len(results) != 2 {
+		t.Fatalf("Expected commands to stop after the failure, got %+v", results)
+	}
+	if results[0].Output != "reloaded "+testSync.ID+" true" || results[0].ExitCode != 0 {
+		t.Errorf("Unexpected result for the first command: %+v", results[0])
+	}
+	if results[1].ExitCode != 3 || results[1].Error == "" {
+		t.Errorf("Expected exit code 3 for the second command, got %+v", results[1])
+	}
+	if !strings.Contains(testSync.GetStatus()["output"].(string), "reloaded "+testSync.ID) {
+		t.Errorf("Expected the command's output in the sync's output")
+	}
+
+	args, _ := os.ReadFile(argsFile)
+	if !strings.Contains(string(args), "-o BatchMode=yes -- nas export DIRSYNC_SYNC_ID=") {
+		t.Errorf("Unexpected ssh arguments: %s", args)
+	}
+}
+
+// TestRemoteHooksAgent tests authenticating remote hooks with an ssh-agent
+func TestRemoteHooksAgent(t *testing.T) {
+	fakeSSH(t)
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	os.WriteFile(socket, nil, 0600)
+
+	syncManager := NewSyncManager(Options{})
+	testSync := syncManager.AddSync(testSourceDir, t.TempDir(), 60)
+	testSync.Pair.RemoteHooks = &RemoteHookConfig{Host: "nas", AgentSocket: socket, Commands: []string{`echo "$SSH_AUTH_SOCK"`}}
+
+	if err := testSync.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	runs := syncManager.History.ListRuns(testSync.ID)
+	if len(runs) != 1 || len(runs[0].RemoteCommands) != 1 || runs[0].RemoteCommands[0].Output != socket {
+		t.Errorf("Expected ssh to be given the agent's socket, got %+v", runs)
+	}
+
+	// Without the agent, nothing is run
+	testSync.Pair.RemoteHooks.AgentSocket = filepath.Join(t.TempDir(), "missing.sock")
+	if err := testSync.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "ssh-agent for nas isn't running") {
+		t.Errorf("Expected a missing agent to fail the run, got %v", err)
+	}
+}
+
+// TestRemoteHooksSkippedOnFailure tests that remote commands only run after
+// failed runs when asked to
+func TestRemoteHooksSkippedOnFailure(t *testing.T) {
+	argsFile := fakeSSH(t)
+
+	testSync := NewSync(filepath.Join(t.TempDir(), "missing"), t.TempDir(), 60)
+	testSync.Pair.RemoteHooks = &RemoteHookConfig{Host: "nas", Commands: []string{"true"}}
+	testSync.Run(context.Background())
+	if _, err := os.Stat(argsFile); !os.IsNotExist(err) {
+		t.Errorf("Expected no remote commands after a failed run")
+	}
+
+	testSync.Pair.RemoteHooks.OnFailure = true
+	testSync.Run(context.Background())
+	if _, err := os.Stat(argsFile); err != nil {
+		t.Errorf("Expected remote commands after a failed run with on_failure")
+	}
+}
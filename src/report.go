@@ -0,0 +1,151 @@
+package dirsync
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Version is the version of dirsync written into run reports. Release
+// builds set it with -ldflags "-X github.com/gkarolyi/dirsync/src.Version=1.2.3".
+var Version = "dev"
+
+// reportFile is the summary of the last successful run, in the root of the
+// destination
+const reportFile = ".dirsync-report.json"
+
+// reportFormat is the version of the report's layout
+const reportFormat = 1
+
+// ErrBadSignature is returned for a report that doesn't match its signature
+var ErrBadSignature = errors.New("report signature doesn't match")
+
+// ReportConfig writes a report of each successful run into the root of the
+// destination, so the backup medium itself records when and how it was
+// last updated
+type ReportConfig struct {
+	// KeyFile holds a key written as 64 hex digits to sign reports with
+	// HMAC-SHA256. Reports are unsigned without one.
+	KeyFile string `json:"key_file,omitempty"`
+}
+
+// RunReport is the summary of a run written to the destination. The same run
+// always gives the same report, byte for byte.
+type RunReport struct {
+	Format           int       `json:"format"`
+	SyncID           string    `json:"sync_id"`
+	RunID            string    `json:"run_id"`
+	StartTime        time.Time `json:"start_time"`
+	EndTime          time.Time `json:"end_time"`
+	FilesCopied      int       `json:"files_copied"`
+	ChangeCount      int       `json:"change_count"`
+	BytesTransferred int64     `json:"bytes_transferred"`
+	TotalBytes       int64     `json:"total_bytes"`
+
+	// ManifestHash is the SHA-256 of the destination's manifest after the
+	// run, for pairs that keep one
+	ManifestHash string `json:"manifest_hash,omitempty"`
+
+	Version string `json:"dirsync_version"`
+
+	// Signature is the HMAC-SHA256 of the report without its signature, if
+	// the report was signed
+	Signature string `json:"signature,omitempty"`
+}
+
+// sign returns the signature of the report with key
+func (r RunReport) sign(key []byte) string {
+	r.Signature = ""
+	data, _ := json.Marshal(r)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks that the report was signed with key
+func (r RunReport) Verify(key []byte) error {
+	if r.Signature == "" {
+		return errors.New("report isn't signed")
+	}
+	if !hmac.Equal([]byte(r.Signature), []byte(r.sign(key))) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// ReadReport reads the report of the last successful run from a destination
+func ReadReport(dest string) (*RunReport, error) {
+	data, err := os.ReadFile(filepath.Join(dest, reportFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var report RunReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("error parsing report: %w", err)
+	}
+	return &report, nil
+}
+
+// LoadReportKey reads a key for signing reports from path
+func LoadReportKey(path string) ([]byte, error) {
+	return loadHexKey(path, "report")
+}
+
+// writeReport writes the report of a successful run into the root of the
+// destination
+func (s *Sync) writeReport(run *RunRecord) error {
+	s.mu.RLock()
+	stats := s.stats
+	s.mu.RUnlock()
+
+	report := RunReport{
+		Format:           reportFormat,
+		SyncID:           s.ID,
+		RunID:            run.ID,
+		StartTime:        run.StartTime.UTC(),
+		EndTime:          time.Now().UTC(),
+		FilesCopied:      stats.FilesCopied,
+		ChangeCount:      stats.ChangeCount,
+		BytesTransferred: stats.BytesTransferred,
+		TotalBytes:       stats.TotalBytes,
+		Version:          Version,
+	}
+
+	if s.Pair.Manifest || s.Pair.Incremental {
+		if data, err := os.ReadFile(filepath.Join(s.DestinationPath, manifestFile)); err == nil {
+			sum := sha256.Sum256(data)
+			report.ManifestHash = hex.EncodeToString(sum[:])
+		}
+	}
+
+	if keyFile := s.Pair.Report.KeyFile; keyFile != "" {
+		key, err := LoadReportKey(keyFile)
+		if err != nil {
+			return err
+		}
+		report.Signature = report.sign(key)
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.DestinationPath, reportFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package dirsync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRunReport tests writing a signed report of each successful run into
+// the destination
+func TestRunReport(t *testing.T) {
+	manager := NewSyncManager(Options{})
+	keyFile := writeTestKey(t)
+	s := manager.AddPair(PairConfig{Source: testSourceDir, Destination: t.TempDir(), Manifest: true, Delete: true,
+		Report: &ReportConfig{KeyFile: keyFile}}, 60)
+
+	if err := s.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	report, err := ReadReport(s.DestinationPath)
+	if err != nil {
+		t.Fatalf("ReadReport failed: %v", err)
+	}
+	run := manager.History.ListRuns(s.ID)[0]
+	if report.RunID != run.ID || report.SyncID != s.ID || report.FilesCopied != run.FilesCopied || report.FilesCopied == 0 || report.Version != Version {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	if report.ManifestHash == "" || report.EndTime.Before(report.StartTime) {
+		t.Errorf("Expected a manifest hash and timings, got %+v", report)
+	}
+
+	key, _ := LoadReportKey(keyFile)
+	if err := report.Verify(key); err != nil {
+		t.Errorf("Expected the report to verify, got %v", err)
+	}
+	report.FilesCopied++
+	if err := report.Verify(key); err != ErrBadSignature {
+		t.Errorf("Expected a changed report to fail to verify, got %v", err)
+	}
+
+	// The report isn't deleted as missing from the source
+	if err := s.SyncDirectories(); err != nil {
+		t.Fatalf("Second SyncDirectories failed: %v", err)
+	}
+	second, err := ReadReport(s.DestinationPath)
+	if err != nil || second.RunID == run.ID || second.FilesCopied != 0 {
+		t.Errorf("Expected the second run's report, got %+v (%v)", second, err)
+	}
+
+	// A report that can't be signed fails the run
+	os.Remove(keyFile)
+	if err := s.SyncDirectories(); err == nil {
+		t.Errorf("Expected a missing key to fail the run")
+	}
+}
+
+// TestRunReportUnsigned tests that reports are only signed with a key, and
+// aren't written by failed runs
+func TestRunReportUnsigned(t *testing.T) {
+	s := NewSync(filepath.Join(t.TempDir(), "missing"), t.TempDir(), 60)
+	s.Pair.Report = &ReportConfig{}
+
+	if err := s.SyncDirectories(); err == nil {
+		t.Fatalf("Expected a missing source to fail the run")
+	}
+	if _, err := ReadReport(s.DestinationPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no report for a failed run, got %v", err)
+	}
+
+	s.SourcePath = testSourceDir
+	if err := s.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	report, err := ReadReport(s.DestinationPath)
+	if err != nil || report.Signature != "" || report.ManifestHash != "" {
+		t.Errorf("Expected an unsigned report without a manifest hash, got %+v (%v)", report, err)
+	}
+	if err := report.Verify(nil); err == nil {
+		t.Errorf("Expected an unsigned report not to verify")
+	}
+}
@@ -0,0 +1,83 @@
+package dirsync
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// RouteConfig sends files whose names match one of its patterns to a
+// different destination. A relative destination is a subpath of the pair's
+// destination; an absolute one can be on another disk or share.
+type RouteConfig struct {
+	Patterns    []string `json:"patterns"`
+	Destination string   `json:"destination"`
+}
+
+// Validate checks the route's patterns and destination
+func (r RouteConfig) Validate() error {
+	if r.Destination == "" {
+		return errors.New("route has no destination")
+	}
+	if len(r.Patterns) == 0 {
+		return errors.New("route has no patterns")
+	}
+	return validatePatterns("route", r.Patterns)
+}
+
+// validatePatterns checks that every pattern is a valid file name pattern
+func validatePatterns(kind string, patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid %s pattern %q", kind, pattern)
+		}
+	}
+	return nil
+}
+
+// matches reports whether a file name matches one of the route's patterns, ignoring case
+func (r RouteConfig) matches(name string) bool {
+	return matchesAny(r.Patterns, name)
+}
+
+// matchesAny reports whether a file name matches one of patterns, ignoring case
+func matchesAny(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(strings.ToLower(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// root returns the directory files on this route are copied into
+func (r RouteConfig) root(dest string) string {
+	if filepath.IsAbs(r.Destination) {
+		return r.Destination
+	}
+	return filepath.Join(dest, r.Destination)
+}
+
+// routeFor returns the first route matching the file at rel, if any
+func routeFor(routes []RouteConfig, rel string) (RouteConfig, bool) {
+	name := filepath.Base(rel)
+	for _, route := range routes {
+		if route.matches(name) {
+			return route, true
+		}
+	}
+	return RouteConfig{}, false
+}
+
+// destinations returns the pair's destination and any routes outside it
+func (s *Sync) destinations() []string {
+	dests := []string{s.DestinationPath}
+	for _, route := range s.Pair.Routes {
+		if filepath.IsAbs(route.Destination) {
+			dests = append(dests, route.Destination)
+		}
+	}
+	return dests
+}
@@ -0,0 +1,74 @@
+package dirsync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRouteFor tests matching files against routes
+func TestRouteFor(t *testing.T) {
+	routes := []RouteConfig{
+		{Patterns: []string{"*.cr2", "*.nef"}, Destination: "/nas/raw"},
+		{Patterns: []string{"*.jpg"}, Destination: "jpeg"},
+	}
+
+	tests := map[string]string{
+		"2024/IMG_0001.CR2": "/nas/raw",
+		"IMG_0002.jpg":      "jpeg",
+		"notes.txt":         "",
+	}
+
+	for rel, want := range tests {
+		route, ok := routeFor(routes, rel)
+		if want == "" {
+			if ok {
+				t.Errorf("%s: expected no route, got %s", rel, route.Destination)
+			}
+			continue
+		}
+		if !ok || route.Destination != want {
+			t.Errorf("%s: expected route to %s, got %v", rel, want, route.Destination)
+		}
+	}
+
+	if err := (RouteConfig{Patterns: []string{"[x"}, Destination: "x"}).Validate(); err == nil {
+		t.Errorf("Expected error for invalid pattern, got nil")
+	}
+	if err := (RouteConfig{Patterns: []string{"*.jpg"}}).Validate(); err == nil {
+		t.Errorf("Expected error for missing destination, got nil")
+	}
+}
+
+// TestSyncWithRoutes tests that routed files are copied to their route's destination
+func TestSyncWithRoutes(t *testing.T) {
+	destDir := t.TempDir()
+	routeDir := t.TempDir()
+
+	testSync := NewSync(testSourceDir, destDir, 60)
+	testSync.Pair.Routes = []RouteConfig{
+		{Patterns: []string{"file1.*"}, Destination: routeDir},
+		{Patterns: []string{"file3.txt"}, Destination: "routed"},
+	}
+
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+
+	expected := []string{
+		filepath.Join(routeDir, "file1.txt"),
+		filepath.Join(destDir, "file2.txt"),
+		filepath.Join(destDir, "routed", "subdir", "file3.txt"),
+	}
+	for _, path := range expected {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to exist: %v", path, err)
+		}
+	}
+
+	for _, path := range []string{filepath.Join(destDir, "file1.txt"), filepath.Join(destDir, "subdir", "file3.txt")} {
+		if _, err := os.Stat(path); err == nil {
+			t.Errorf("Routed file should not be copied to %s", path)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package dirsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRsync puts a script named rsync first on PATH, which records the
+// arguments of each run in $args and then runs script in place of copying
+// anything
+func fakeRsync(t *testing.T, script string) (argsFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	argsFile = filepath.Join(dir, "args")
+	os.WriteFile(filepath.Join(dir, "rsync"), []byte(`#!/bin/sh
+args="`+argsFile+`"
+echo "$@" >> "$args"
+`+script), 0755)
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return argsFile
+}
+
+// rsyncSource returns a source directory with a file in it, since runs
+// of empty sources are skipped
+func rsyncSource(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "file.txt"), []byte("file"), 0644)
+	return dir
+}
+
+// readArgs returns the arguments of each run of the fake rsync
+func readArgs(t *testing.T, argsFile string) []string {
+	t.Helper()
+	data, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatalf("Expected rsync to run: %v", err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+// TestRsyncItemizedOutput tests recording the changes and totals rsync
+// reports
+func TestRsyncItemizedOutput(t *testing.T) {
+	argsFile := fakeRsync(t, `echo "sending incremental file list"
+echo ">f+++++++++ docs/new.txt"
+echo ">f.st...... docs/changed.txt"
+echo "*deleting   old.txt"
+echo "sent 1,234 bytes  received 56 bytes  2,580.00 bytes/sec"
+echo "total size is 10,000  speedup is 8.10"
+`)
+
+	manager := NewSyncManager(Options{})
+	s := manager.AddPair(PairConfig{Source: rsyncSource(t), Destination: t.TempDir(), Delete: true}, 60)
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	args := readArgs(t, argsFile)
+	if len(args) != 1 || !strings.Contains(args[0], "--itemize-changes") || !strings.Contains(args[0], "--delete") {
+		t.Errorf("Expected one itemized rsync run with --delete, got %q", args)
+	}
+
+	run := manager.History.ListRuns(s.ID)[0]
+	if !run.Success || run.BytesTransferred != 1234 || run.TotalBytes != 10000 {
+		t.Errorf("Expected a successful run of 1234 of 10000 bytes, got %+v", run)
+	}
+	want := []Change{
+		{Path: "docs/new.txt", Action: ChangeCreated},
+		{Path: "docs/changed.txt", Action: ChangeUpdated, Attributes: []string{"size", "time"}},
+		{Path: "old.txt", Action: ChangeDeleted},
+	}
+	if run.ChangeCount != 3 || run.FilesCopied != 2 || len(run.Changes) != 3 {
+		t.Fatalf("Expected 3 changes and 2 copied files, got %+v", run.TransferStats)
+	}
+	for i, change := range want {
+		if got := run.Changes[i]; got.String() != change.String() {
+			t.Errorf("Expected change %d to be %q, got %q", i, change, got)
+		}
+	}
+	if !strings.Contains(run.Output, "docs/changed.txt (updated: size, time)") {
+		t.Errorf("Expected changes in the output in the built-in copier's form, got %q", run.Output)
+	}
+}
+
+// TestRsyncFailure tests reading failed files from rsync's errors, and
+// classifying its exit code
+func TestRsyncFailure(t *testing.T) {
+	sourceDir := rsyncSource(t)
+	fakeRsync(t, `echo ">f+++++++++ ok.txt"
+echo 'rsync: [sender] send_files failed to open "`+sourceDir+`/locked.txt": Permission denied (13)' >&2
+echo "rsync error: some files/attrs were not transferred (see previous errors) (code 23)" >&2
+exit 23
+`)
+
+	manager := NewSyncManager(Options{})
+	s := manager.AddPair(PairConfig{Source: sourceDir, Destination: t.TempDir()}, 60)
+	err := s.Run(context.Background())
+	var rsyncErr *RsyncExitError
+	if !errors.As(err, &rsyncErr) || rsyncErr.Code != 23 || !errors.Is(err, ErrPartialFailure) {
+		t.Fatalf("Expected a partial failure with exit code 23, got %v", err)
+	}
+
+	run := manager.History.ListRuns(s.ID)[0]
+	if run.Success || run.FailureCount != 1 || len(run.Failures) != 1 {
+		t.Fatalf("Expected a failed run with one failed file, got %+v", run)
+	}
+	if failure := run.Failures[0]; failure.Path != "locked.txt" || failure.ErrorClass != ErrorClassPermission {
+		t.Errorf("Expected locked.txt to fail with a permission error, got %+v", failure)
+	}
+	if !strings.Contains(run.Output, "ERROR: rsync error: some files/attrs were not transferred") {
+		t.Errorf("Expected rsync's errors in the output, got %q", run.Output)
+	}
+}
+
+// TestRsyncBandwidthRestart tests restarting rsync with the new limit when
+// the schedule moves into another window
+func TestRsyncBandwidthRestart(t *testing.T) {
+	// The first run waits to be restarted, the second finishes
+	argsFile := fakeRsync(t, `if [ "$(wc -l < "$args")" -eq 1 ]; then
+	sleep 10
+fi
+echo ">f+++++++++ big.iso"
+`)
+
+	oldInterval, oldClock := bandwidthCheckInterval, bandwidthClock
+	defer func() { bandwidthCheckInterval, bandwidthClock = oldInterval, oldClock }()
+	bandwidthCheckInterval = 10 * time.Millisecond
+
+	// It's night once rsync has started
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	night := time.Date(2024, 5, 1, 23, 0, 0, 0, time.Local)
+	bandwidthClock = func() time.Time {
+		if _, err := os.Stat(argsFile); err == nil {
+			return night
+		}
+		return day
+	}
+
+	manager := NewSyncManager(Options{})
+	s := manager.AddPair(PairConfig{
+		Source:      rsyncSource(t),
+		Destination: t.TempDir(),
+		Bandwidth:   &BandwidthConfig{Limit: 100, Schedule: []BandwidthWindow{{Start: "22:00", End: "06:00", Limit: 300}}},
+	}, 60)
+
+	start := time.Now()
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the first rsync to be stopped, the run took %s", elapsed)
+	}
+
+	args := readArgs(t, argsFile)
+	if len(args) != 2 || !strings.Contains(args[0], "--bwlimit=100") || !strings.Contains(args[1], "--bwlimit=300") {
+		t.Fatalf("Expected rsync to run with 100 KB/s and then 300 KB/s, got %q", args)
+	}
+	run := manager.History.ListRuns(s.ID)[0]
+	if !run.Success || !strings.Contains(run.Output, "Restarting rsync with bandwidth limit 300 KB/s") || run.FilesCopied != 1 {
+		t.Errorf("Expected a successful run restarted once, got %+v", run)
+	}
+}
@@ -0,0 +1,575 @@
+package dirsync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Built-in schedule types
+const (
+	scheduleInterval    = "interval"
+	scheduleCron        = "cron"
+	scheduleManual      = "manual"
+	scheduleWatch       = "watch"
+	scheduleTriggerFile = "trigger_file"
+	scheduleAdaptive    = "adaptive"
+)
+
+// defaultPollInterval is how often watch and trigger_file schedules look for
+// changes, in seconds
+const defaultPollInterval = 5
+
+// ScheduleConfig selects when a pair runs. Without it, a pair runs every
+// sync_interval seconds.
+type ScheduleConfig struct {
+	// Type is interval, cron, manual, watch, trigger_file or adaptive, or
+	// the name of a scheduler registered with RegisterScheduler
+	Type string `json:"type"`
+
+	// Interval is the number of seconds between runs for interval
+	// schedules, and the first interval of adaptive schedules (default:
+	// the global sync interval)
+	Interval int `json:"interval,omitempty"`
+
+	// MinInterval and MaxInterval bound the interval of adaptive
+	// schedules, in seconds (default: a quarter of and eight times the
+	// first interval)
+	MinInterval int `json:"min_interval,omitempty"`
+	MaxInterval int `json:"max_interval,omitempty"`
+
+	// BusyChanges is how many paths a run must change for an adaptive
+	// schedule to halve its interval (default 10)
+	BusyChanges int `json:"busy_changes,omitempty"`
+
+	// Cron is a five-field cron expression for cron schedules, e.g.
+	// "30 2 * * 1-5" for 02:30 on weekdays
+	Cron string `json:"cron,omitempty"`
+
+	// Path is the file whose appearance starts a run, for trigger_file
+	// schedules. It is removed when the run starts.
+	Path string `json:"path,omitempty"`
+
+	// PollInterval is how often watch and trigger_file schedules check
+	// for changes, in seconds (default 5)
+	PollInterval int `json:"poll_interval,omitempty"`
+
+	// QuietPeriod is how many seconds the source must stay unchanged
+	// before a watch schedule runs, so a burst of changes such as a build
+	// writing thousands of files starts one run once it's over (default 0,
+	// run as soon as a change is seen)
+	QuietPeriod int `json:"quiet_period,omitempty"`
+
+	// Watcher is how a watch schedule notices changes: "events" asks the
+	// OS to report them, "poll" compares the source every poll_interval,
+	// and "auto" (the default) uses events unless the source is on a
+	// network filesystem, where they aren't reported
+	Watcher string `json:"watcher,omitempty"`
+
+	// Targeted watch schedules sync only the paths reported as changed,
+	// with a full run every FullSyncInterval seconds (default 3600)
+	Targeted         bool `json:"targeted,omitempty"`
+	FullSyncInterval int  `json:"full_sync_interval,omitempty"`
+
+	// Ignore lists patterns of files and directories whose changes don't
+	// start runs of a watch schedule, like "*.swp" or "build/*", so noisy
+	// paths don't keep triggering syncs. They are still synced.
+	Ignore []string `json:"ignore,omitempty"`
+
+	// MaxDelay is how many seconds a watch schedule waits at most for the
+	// source to settle, so a source that never stops changing still runs
+	// (default 0, no limit)
+	MaxDelay int `json:"max_delay,omitempty"`
+}
+
+// Validate checks that the schedule can be built
+func (c ScheduleConfig) Validate() error {
+	_, err := NewScheduler(&c, 60)
+	return err
+}
+
+// pollInterval returns how often to check for changes
+func (c ScheduleConfig) pollInterval() time.Duration {
+	if c.PollInterval > 0 {
+		return time.Duration(c.PollInterval) * time.Second
+	}
+	return defaultPollInterval * time.Second
+}
+
+// Scheduler decides when a pair runs. Runs can also be started at any time
+// with TriggerSync.
+type Scheduler interface {
+	// Next returns when to run after a run that finished at last, or
+	// before the first run if last is zero. The zero time means to wait
+	// for a trigger.
+	Next(last time.Time) time.Time
+}
+
+// Watcher is a Scheduler that also starts runs itself, for example when
+// the source changes
+type Watcher interface {
+	Scheduler
+
+	// Watch calls trigger whenever s should run, until ctx is done
+	Watch(ctx context.Context, s *Sync, trigger func())
+}
+
+// RunObserver is a Scheduler that adapts to what runs find. Observe is
+// called after each run that started, before Next.
+type RunObserver interface {
+	Scheduler
+
+	// Observe is given the outcome of the run that just finished
+	Observe(run RunRecord)
+}
+
+// SchedulerFactory builds a scheduler from a pair's schedule. interval is
+// the global sync interval in seconds.
+type SchedulerFactory func(config ScheduleConfig, interval int) (Scheduler, error)
+
+var (
+	schedulers = map[string]SchedulerFactory{
+		scheduleInterval:    newIntervalScheduler,
+		scheduleCron:        newCronScheduler,
+		scheduleManual:      newManualScheduler,
+		scheduleWatch:       newWatchScheduler,
+		scheduleTriggerFile: newTriggerFileScheduler,
+		scheduleAdaptive:    newAdaptiveScheduler,
+	}
+	schedulersMu sync.RWMutex
+)
+
+// RegisterScheduler makes a schedule type available to pairs under name,
+// replacing any scheduler registered with the same name
+func RegisterScheduler(name string, factory SchedulerFactory) {
+	schedulersMu.Lock()
+	defer schedulersMu.Unlock()
+	schedulers[name] = factory
+}
+
+// SchedulerNames returns the names of all schedule types, sorted
+func SchedulerNames() []string {
+	schedulersMu.RLock()
+	defer schedulersMu.RUnlock()
+
+	names := make([]string, 0, len(schedulers))
+	for name := range schedulers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewScheduler builds the scheduler for a pair's schedule, running every
+// interval seconds if config is nil
+func NewScheduler(config *ScheduleConfig, interval int) (Scheduler, error) {
+	if config == nil {
+		return IntervalScheduler{Interval: time.Duration(interval) * time.Second}, nil
+	}
+
+	name := config.Type
+	if name == "" {
+		name = scheduleInterval
+	}
+
+	schedulersMu.RLock()
+	factory, ok := schedulers[name]
+	schedulersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown schedule type %q", config.Type)
+	}
+	return factory(*config, interval)
+}
+
+// IntervalScheduler runs a pair right away and then every Interval
+type IntervalScheduler struct {
+	Interval time.Duration
+}
+
+func newIntervalScheduler(config ScheduleConfig, interval int) (Scheduler, error) {
+	if config.Interval < 0 {
+		return nil, fmt.Errorf("schedule interval %d is negative", config.Interval)
+	}
+	if config.Interval > 0 {
+		interval = config.Interval
+	}
+	return IntervalScheduler{Interval: time.Duration(interval) * time.Second}, nil
+}
+
+// Next implements Scheduler
+func (is IntervalScheduler) Next(last time.Time) time.Time {
+	if last.IsZero() {
+		return time.Now()
+	}
+	return last.Add(is.Interval)
+}
+
+const (
+	// defaultBusyChanges is how many changed paths make a run busy for
+	// adaptive schedules
+	defaultBusyChanges = 10
+
+	// adaptiveIdleRuns is how many runs in a row must change nothing
+	// before an adaptive schedule doubles its interval
+	adaptiveIdleRuns = 3
+)
+
+// adaptiveScheduler runs a pair every interval, halving the interval after
+// runs that changed many paths and doubling it after several runs in a row
+// changed nothing, so cold data isn't scanned for nothing while busy data
+// stays fresh
+type adaptiveScheduler struct {
+	min, max time.Duration
+	busy     int
+
+	mu       sync.Mutex
+	interval time.Duration
+	idle     int
+}
+
+func newAdaptiveScheduler(config ScheduleConfig, interval int) (Scheduler, error) {
+	switch {
+	case config.Interval < 0, config.MinInterval < 0, config.MaxInterval < 0:
+		return nil, fmt.Errorf("adaptive schedule intervals can't be negative")
+	case config.BusyChanges < 0:
+		return nil, fmt.Errorf("adaptive schedule busy_changes %d is negative", config.BusyChanges)
+	}
+	if config.Interval > 0 {
+		interval = config.Interval
+	}
+
+	as := &adaptiveScheduler{
+		min:      time.Duration(interval) * time.Second / 4,
+		max:      time.Duration(interval) * time.Second * 8,
+		busy:     defaultBusyChanges,
+		interval: time.Duration(interval) * time.Second,
+	}
+	if config.MinInterval > 0 {
+		as.min = time.Duration(config.MinInterval) * time.Second
+	}
+	if config.MaxInterval > 0 {
+		as.max = time.Duration(config.MaxInterval) * time.Second
+	}
+	if config.BusyChanges > 0 {
+		as.busy = config.BusyChanges
+	}
+	if as.min < time.Second {
+		as.min = time.Second
+	}
+	if as.max < as.min {
+		return nil, fmt.Errorf("adaptive schedule max_interval is below min_interval")
+	}
+	as.interval = as.clamp(as.interval)
+	return as, nil
+}
+
+// clamp keeps d within the schedule's bounds
+func (as *adaptiveScheduler) clamp(d time.Duration) time.Duration {
+	if d < as.min {
+		return as.min
+	}
+	if d > as.max {
+		return as.max
+	}
+	return d
+}
+
+// Next implements Scheduler
+func (as *adaptiveScheduler) Next(last time.Time) time.Time {
+	if last.IsZero() {
+		return time.Now()
+	}
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	return last.Add(as.interval)
+}
+
+// Observe implements RunObserver. Failed and skipped runs say nothing
+// about how busy the source is, so they leave the interval alone.
+func (as *adaptiveScheduler) Observe(run RunRecord) {
+	if !run.Success || run.Skipped != "" {
+		return
+	}
+
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	switch {
+	case run.ChangeCount >= as.busy:
+		as.idle = 0
+		as.interval = as.clamp(as.interval / 2)
+	case run.ChangeCount > 0:
+		as.idle = 0
+	default:
+		as.idle++
+		if as.idle >= adaptiveIdleRuns {
+			as.idle = 0
+			as.interval = as.clamp(as.interval * 2)
+		}
+	}
+}
+
+// manualScheduler only runs a pair when it is triggered
+type manualScheduler struct{}
+
+func newManualScheduler(ScheduleConfig, int) (Scheduler, error) {
+	return manualScheduler{}, nil
+}
+
+// Next implements Scheduler
+func (manualScheduler) Next(time.Time) time.Time {
+	return time.Time{}
+}
+
+// watchScheduler runs a pair on startup and whenever its source changes,
+// found by comparing fingerprints of the source tree. With a quiet period,
+// changes are batched into one run once the source stops changing.
+type watchScheduler struct {
+	poll     time.Duration
+	quiet    time.Duration
+	maxDelay time.Duration
+	ignore   []string
+	watcher  string
+	targeted bool
+}
+
+func newWatchScheduler(config ScheduleConfig, _ int) (Scheduler, error) {
+	if config.QuietPeriod < 0 || config.MaxDelay < 0 || config.FullSyncInterval < 0 {
+		return nil, fmt.Errorf("watch schedule quiet_period, max_delay and full_sync_interval can't be negative")
+	}
+	if err := validatePatterns("watch ignore", config.Ignore); err != nil {
+		return nil, err
+	}
+	switch config.Watcher {
+	case "", watcherAuto, watcherEvents, watcherPoll:
+	default:
+		return nil, fmt.Errorf("watch schedule watcher %q isn't auto, events or poll", config.Watcher)
+	}
+	return watchScheduler{
+		poll:     config.pollInterval(),
+		quiet:    time.Duration(config.QuietPeriod) * time.Second,
+		maxDelay: time.Duration(config.MaxDelay) * time.Second,
+		ignore:   config.Ignore,
+		watcher:  config.Watcher,
+		targeted: config.Targeted,
+	}, nil
+}
+
+// Next implements Scheduler
+func (ws watchScheduler) Next(last time.Time) time.Time {
+	if last.IsZero() {
+		return time.Now()
+	}
+	return time.Time{}
+}
+
+// Watch implements Watcher
+func (ws watchScheduler) Watch(ctx context.Context, s *Sync, trigger func()) {
+	s.mu.RLock()
+	skip := s.excludedDirs
+	s.mu.RUnlock()
+
+	changes := ws.changes(ctx, s, skip)
+	ticker := time.NewTicker(ws.poll)
+	defer ticker.Stop()
+
+	// firstChange and lastChange are when the changes not run yet started
+	// and when the source was last seen changing
+	var firstChange, lastChange time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+			lastChange = time.Now()
+			if firstChange.IsZero() {
+				firstChange = lastChange
+				if ws.quiet > 0 {
+					log.Printf("[%s] Source changed, waiting %v for it to settle", s.ID, ws.quiet)
+				}
+			}
+		case <-ticker.C:
+		}
+		if firstChange.IsZero() {
+			continue
+		}
+
+		now := time.Now()
+		switch {
+		case now.Sub(lastChange) >= ws.quiet:
+			log.Printf("[%s] Source changed", s.ID)
+		case ws.maxDelay > 0 && now.Sub(firstChange) >= ws.maxDelay:
+			log.Printf("[%s] Source still changing after %v, running anyway", s.ID, ws.maxDelay)
+		default:
+			continue
+		}
+		firstChange = time.Time{}
+		trigger()
+	}
+}
+
+// triggerFileScheduler runs a pair whenever a trigger file appears, so
+// other programs can start runs without access to the API
+type triggerFileScheduler struct {
+	path string
+	poll time.Duration
+}
+
+func newTriggerFileScheduler(config ScheduleConfig, _ int) (Scheduler, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("trigger_file schedule has no path")
+	}
+	return triggerFileScheduler{path: config.Path, poll: config.pollInterval()}, nil
+}
+
+// Next implements Scheduler
+func (ts triggerFileScheduler) Next(time.Time) time.Time {
+	return time.Time{}
+}
+
+// Watch implements Watcher
+func (ts triggerFileScheduler) Watch(ctx context.Context, s *Sync, trigger func()) {
+	for sleepContext(ctx, ts.poll) {
+		if _, err := os.Stat(ts.path); err != nil {
+			continue
+		}
+		if err := os.Remove(ts.path); err != nil {
+			log.Printf("[%s] Error removing trigger file: %v", s.ID, err)
+			continue
+		}
+		log.Printf("[%s] Trigger file %s found", s.ID, ts.path)
+		trigger()
+	}
+}
+
+// cronScheduler runs a pair at the times matching a cron expression
+type cronScheduler struct {
+	minute, hour, dom, month, dow uint64
+
+	// domAny and dowAny are set for "*", since a restricted day of month
+	// and day of week match either, as in cron
+	domAny, dowAny bool
+}
+
+func newCronScheduler(config ScheduleConfig, _ int) (Scheduler, error) {
+	return parseCron(config.Cron)
+}
+
+// parseCron parses a five-field cron expression: minute, hour, day of
+// month, month and day of week. Fields can be "*", numbers, ranges
+// ("1-5"), steps ("*/15", "0-30/10") and lists of those ("1,15").
+func parseCron(expr string) (*cronScheduler, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+
+	cs := &cronScheduler{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	bounds := []struct {
+		field    *uint64
+		min, max int
+	}{
+		{&cs.minute, 0, 59},
+		{&cs.hour, 0, 23},
+		{&cs.dom, 1, 31},
+		{&cs.month, 1, 12},
+		{&cs.dow, 0, 7},
+	}
+	for i, b := range bounds {
+		bits, err := parseCronField(fields[i], b.min, b.max)
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %w", expr, err)
+		}
+		*b.field = bits
+	}
+
+	// Sunday is both 0 and 7
+	if cs.dow&(1<<7) != 0 {
+		cs.dow |= 1
+	}
+	return cs, nil
+}
+
+// parseCronField returns the values a cron field matches as a bit set
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rng = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			var err error
+			bounds := strings.SplitN(rng, "-", 2)
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// Next implements Scheduler
+func (cs *cronScheduler) Next(last time.Time) time.Time {
+	now := time.Now()
+	if last.Before(now) {
+		last = now
+	}
+
+	t := last.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case cs.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !cs.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case cs.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case cs.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	// The expression never matches, e.g. "0 0 31 2 *"
+	return time.Time{}
+}
+
+// dayMatches reports whether the day of t matches the day of month and day
+// of week fields
+func (cs *cronScheduler) dayMatches(t time.Time) bool {
+	dom := cs.dom&(1<<uint(t.Day())) != 0
+	dow := cs.dow&(1<<uint(t.Weekday())) != 0
+	if cs.domAny || cs.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,349 @@
+package dirsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestCronNext tests finding the next time matching a cron expression
+func TestCronNext(t *testing.T) {
+	from := time.Now().AddDate(1, 0, 0)
+	from = time.Date(from.Year(), time.March, 4, 10, 17, 30, 0, time.Local)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(from.Year(), time.March, 4, 10, 30, 0, 0, time.Local)},
+		{"0 2 * * *", time.Date(from.Year(), time.March, 5, 2, 0, 0, 0, time.Local)},
+		{"30 9 1 * *", time.Date(from.Year(), time.April, 1, 9, 30, 0, 0, time.Local)},
+		{"0 0 1 1 *", time.Date(from.Year()+1, time.January, 1, 0, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		cs, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("parseCron(%q) failed: %v", tt.expr, err)
+		}
+		if got := cs.Next(from); !got.Equal(tt.want) {
+			t.Errorf("Next for %q = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	// Day of week 7 is Sunday too
+	cs, _ := parseCron("0 12 * * 7")
+	if got := cs.Next(from); got.Weekday() != time.Sunday || got.Hour() != 12 {
+		t.Errorf("Expected a Sunday at noon, got %v", got)
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("Expected %q to be rejected", expr)
+		}
+	}
+}
+
+// TestNewScheduler tests building schedulers from pair schedules
+func TestNewScheduler(t *testing.T) {
+	scheduler, err := NewScheduler(nil, 60)
+	if err != nil {
+		t.Fatalf("NewScheduler failed: %v", err)
+	}
+	last := time.Now()
+	if got := scheduler.Next(last); !got.Equal(last.Add(time.Minute)) {
+		t.Errorf("Expected the global interval without a schedule, got %v", got)
+	}
+
+	scheduler, _ = NewScheduler(&ScheduleConfig{Interval: 10}, 60)
+	if got := scheduler.Next(last); !got.Equal(last.Add(10 * time.Second)) {
+		t.Errorf("Expected the pair's own interval, got %v", got)
+	}
+
+	scheduler, _ = NewScheduler(&ScheduleConfig{Type: "manual"}, 60)
+	if !scheduler.Next(time.Time{}).IsZero() || !scheduler.Next(last).IsZero() {
+		t.Errorf("Expected manual schedules never to run by themselves")
+	}
+
+	for _, config := range []ScheduleConfig{{Type: "hourly"}, {Type: "cron"}, {Type: "trigger_file"}} {
+		if err := config.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", config)
+		}
+	}
+}
+
+// TestManualScheduleTrigger tests that triggering wakes a pair that only
+// runs when triggered
+func TestManualScheduleTrigger(t *testing.T) {
+	testSync := NewSync(testSourceDir, t.TempDir(), 60)
+	testSync.Pair.Schedule = &ScheduleConfig{Type: "manual"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	testSync.Start(ctx, 60)
+
+	time.Sleep(100 * time.Millisecond)
+	testSync.mu.RLock()
+	ran := !testSync.LastSync.IsZero()
+	testSync.mu.RUnlock()
+	if ran {
+		t.Fatalf("Expected no run before a trigger")
+	}
+
+	testSync.TriggerSync()
+	waitForSync(t, testSync)
+}
+
+// TestWatchSchedule tests that changes to the source trigger a run
+func TestWatchSchedule(t *testing.T) {
+	sourceDir := t.TempDir()
+	testSync := NewSync(sourceDir, t.TempDir(), 60)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	triggered := make(chan struct{}, 1)
+	go watchScheduler{poll: 10 * time.Millisecond}.Watch(ctx, testSync, func() { triggered <- struct{}{} })
+
+	time.Sleep(50 * time.Millisecond)
+	os.WriteFile(filepath.Join(sourceDir, "new.txt"), []byte("new"), 0644)
+
+	select {
+	case <-triggered:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected a change to the source to trigger a run")
+	}
+}
+
+// TestWatchScheduleQuietPeriod tests batching a burst of changes into one
+// run once the source settles, and running a source that keeps changing
+// after the maximum delay
+func TestWatchScheduleQuietPeriod(t *testing.T) {
+	sourceDir := t.TempDir()
+	testSync := NewSync(sourceDir, t.TempDir(), 60)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	triggered := make(chan struct{}, 10)
+	ws := watchScheduler{poll: 10 * time.Millisecond, quiet: 200 * time.Millisecond}
+	go ws.Watch(ctx, testSync, func() { triggered <- struct{}{} })
+
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		os.WriteFile(filepath.Join(sourceDir, fmt.Sprintf("build%d.o", i)), []byte("object"), 0644)
+		time.Sleep(20 * time.Millisecond)
+	}
+	select {
+	case <-triggered:
+		if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+			t.Errorf("Expected the run to wait for the burst to settle, ran after %v", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the burst to trigger a run")
+	}
+	time.Sleep(300 * time.Millisecond)
+	if n := len(triggered); n != 0 {
+		t.Errorf("Expected one run for the burst, got %d more", n)
+	}
+
+	// A source that never settles runs after the maximum delay
+	cancel()
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	ws = watchScheduler{poll: 10 * time.Millisecond, quiet: time.Hour, maxDelay: 200 * time.Millisecond}
+	go ws.Watch(ctx, testSync, func() { triggered <- struct{}{} })
+	time.Sleep(50 * time.Millisecond)
+	deadline := time.After(5 * time.Second)
+	for i := 0; ; i++ {
+		os.WriteFile(filepath.Join(sourceDir, fmt.Sprintf("log%d.txt", i)), []byte("line"), 0644)
+		select {
+		case <-triggered:
+			return
+		case <-deadline:
+			t.Fatalf("Expected a run after the maximum delay")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+// TestWatchScheduleIgnore tests that changes to ignored paths don't
+// trigger runs, while other changes still do
+func TestWatchScheduleIgnore(t *testing.T) {
+	sourceDir := t.TempDir()
+	os.MkdirAll(filepath.Join(sourceDir, "build"), 0755)
+	testSync := NewSync(sourceDir, t.TempDir(), 60)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	triggered := make(chan struct{}, 10)
+	scheduler, err := NewScheduler(&ScheduleConfig{Type: "watch", PollInterval: 1, Ignore: []string{"*.swp", "build/*"}}, 60)
+	if err != nil {
+		t.Fatalf("NewScheduler failed: %v", err)
+	}
+	ws := scheduler.(watchScheduler)
+	ws.poll = 10 * time.Millisecond
+	go ws.Watch(ctx, testSync, func() { triggered <- struct{}{} })
+
+	time.Sleep(50 * time.Millisecond)
+	os.WriteFile(filepath.Join(sourceDir, ".notes.txt.SWP"), []byte("swap"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "build", "app.o"), []byte("object"), 0644)
+	select {
+	case <-triggered:
+		t.Fatalf("Expected changes to ignored paths not to trigger a run")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	os.WriteFile(filepath.Join(sourceDir, "notes.txt"), []byte("notes"), 0644)
+	select {
+	case <-triggered:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected a change to another file to trigger a run")
+	}
+
+	if _, err := NewScheduler(&ScheduleConfig{Type: "watch", Ignore: []string{"[*.swp"}}, 60); err == nil {
+		t.Errorf("Expected an invalid ignore pattern to be rejected")
+	}
+}
+
+// TestWatchers tests noticing changes with file system events and by
+// polling, including in directories created after watching started
+func TestWatchers(t *testing.T) {
+	for _, watcher := range []string{"events", "poll"} {
+		t.Run(watcher, func(t *testing.T) {
+			sourceDir := t.TempDir()
+			testSync := NewSync(sourceDir, t.TempDir(), 60)
+
+			scheduler, err := NewScheduler(&ScheduleConfig{Type: "watch", Watcher: watcher}, 60)
+			if err != nil {
+				t.Fatalf("NewScheduler failed: %v", err)
+			}
+			ws := scheduler.(watchScheduler)
+			ws.poll = 10 * time.Millisecond
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			triggered := make(chan struct{}, 10)
+			go ws.Watch(ctx, testSync, func() { triggered <- struct{}{} })
+			time.Sleep(50 * time.Millisecond)
+
+			wait := func(what string) {
+				t.Helper()
+				select {
+				case <-triggered:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("Expected %s to trigger a run", what)
+				}
+			}
+			os.Mkdir(filepath.Join(sourceDir, "new"), 0755)
+			wait("a new directory")
+			time.Sleep(50 * time.Millisecond)
+			os.WriteFile(filepath.Join(sourceDir, "new", "file.txt"), []byte("new"), 0644)
+			wait("a file in the new directory")
+		})
+	}
+
+	if _, err := NewScheduler(&ScheduleConfig{Type: "watch", Watcher: "inotify"}, 60); err == nil {
+		t.Errorf("Expected an unknown watcher to be rejected")
+	}
+}
+
+// TestTriggerFileSchedule tests that a trigger file starts a run and is removed
+func TestTriggerFileSchedule(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run-now")
+	testSync := NewSync(testSourceDir, t.TempDir(), 60)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	triggered := make(chan struct{}, 1)
+	go triggerFileScheduler{path: path, poll: 10 * time.Millisecond}.Watch(ctx, testSync, func() { triggered <- struct{}{} })
+
+	os.WriteFile(path, nil, 0644)
+	select {
+	case <-triggered:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the trigger file to trigger a run")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the trigger file to be removed")
+	}
+}
+
+// waitForSync waits for s to finish a run
+func waitForSync(t *testing.T, s *Sync) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.RLock()
+		ran := !s.LastSync.IsZero()
+		s.mu.RUnlock()
+		if ran {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Expected the sync to run")
+}
+
+// TestAdaptiveSchedule tests that adaptive schedules run more often after
+// busy runs and less often after idle ones, within their bounds
+func TestAdaptiveSchedule(t *testing.T) {
+	scheduler, err := NewScheduler(&ScheduleConfig{Type: "adaptive", Interval: 100, MinInterval: 30, MaxInterval: 500, BusyChanges: 5}, 60)
+	if err != nil {
+		t.Fatalf("NewScheduler failed: %v", err)
+	}
+	observer, ok := scheduler.(RunObserver)
+	if !ok {
+		t.Fatalf("Expected adaptive schedules to observe runs")
+	}
+
+	last := time.Now()
+	interval := func() time.Duration { return scheduler.Next(last).Sub(last) }
+	run := func(changes int) RunRecord {
+		r := RunRecord{Success: true}
+		r.ChangeCount = changes
+		return r
+	}
+
+	steps := []struct {
+		run  RunRecord
+		want time.Duration
+	}{
+		{run(5), 50 * time.Second},
+		{run(20), 30 * time.Second},
+		{run(0), 30 * time.Second},
+		{run(0), 30 * time.Second},
+		{run(0), 60 * time.Second},
+		{run(0), 60 * time.Second},
+		{run(1), 60 * time.Second},
+		{run(0), 60 * time.Second},
+		{run(0), 60 * time.Second},
+		{run(0), 120 * time.Second},
+		{RunRecord{Error: "failed"}, 120 * time.Second},
+		{RunRecord{Success: true, Skipped: "maintenance"}, 120 * time.Second},
+	}
+	for i, step := range steps {
+		observer.Observe(step.run)
+		if got := interval(); got != step.want {
+			t.Errorf("Step %d: expected an interval of %v, got %v", i, step.want, got)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		observer.Observe(run(0))
+	}
+	if got := interval(); got != 500*time.Second {
+		t.Errorf("Expected the interval to stop at the maximum, got %v", got)
+	}
+
+	for _, config := range []ScheduleConfig{
+		{Type: "adaptive", MinInterval: 600, MaxInterval: 60},
+		{Type: "adaptive", MinInterval: -1},
+		{Type: "adaptive", BusyChanges: -1},
+	} {
+		if err := config.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", config)
+		}
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package dirsync
 
 import (
 	"os"
@@ -43,9 +43,6 @@ func setup() {
 
 	// Create test files
 	createTestFiles()
-
-	// Create test config
-	createTestConfig()
 }
 
 // teardown cleans up the test environment
@@ -53,9 +50,6 @@ func teardown() {
 	// Remove test directories
 	os.RemoveAll(testSourceDir)
 	os.RemoveAll(testDestDir)
-
-	// Remove test config
-	os.Remove("test_config.json")
 }
 
 // createTestFiles creates test files in the source directory
@@ -85,17 +79,3 @@ func createTestFiles() {
 		}
 	}
 }
-
-// createTestConfig creates a test configuration file
-func createTestConfig() {
-	// Create a test config file with our temp directories
-	configContent := `{
-  "sync_interval": 5,
-  "sync_pairs": ["` + testSourceDir + `:` + testDestDir + `"],
-  "port": ":8090"
-}`
-
-	if err := os.WriteFile("test_config.json", []byte(configContent), 0644); err != nil {
-		panic("Failed to create test config: " + err.Error())
-	}
-}
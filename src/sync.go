@@ -1,7 +1,12 @@
-package main
+// Package dirsync is the sync engine behind the DirSync daemon. A
+// SyncManager runs a set of source/destination pairs on an interval using
+// rsync, or a built-in copier when rsync isn't available.
+package dirsync
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -14,16 +19,96 @@ import (
 
 // Sync represents a single directory synchronization task
 type Sync struct {
-	ID              string    `json:"id"`
-	SourcePath      string    `json:"source_path"`
-	DestinationPath string    `json:"destination_path"`
-	IsSyncing       bool      `json:"is_syncing"`
-	Paused          bool      `json:"paused"`
-	LastSync        time.Time `json:"last_sync"`
-	NextSyncTime    time.Time `json:"next_sync_time"`
-	Output          string    `json:"output"`
-	LastError       string    `json:"last_error"`
-	mu              sync.RWMutex
+	ID              string     `json:"id"`
+	SourcePath      string     `json:"source_path"`
+	DestinationPath string     `json:"destination_path"`
+	IsSyncing       bool       `json:"is_syncing"`
+	Paused          bool       `json:"paused"`
+	LastSync        time.Time  `json:"last_sync"`
+	NextSyncTime    time.Time  `json:"next_sync_time"`
+	Output          string     `json:"output"`
+	LastError       string     `json:"last_error"`
+	Pair            PairConfig `json:"-"`
+	lastFingerprint string
+	lastQuickCheck  string
+	lastFullScan    time.Time
+	excludedDirs    []string
+	history         *History
+	webhooks        []WebhookConfig
+	injectedFailure *InjectedFailure
+	onProgress      func(Progress)
+	plugins         *Plugins
+	onRunFinished   func(RunRecord)
+	transferred     *transferSample
+	stats           TransferStats
+	followUp        bool
+	journal         *Journal
+	maxChanges      int
+	maxMemoryMB     int
+
+	// output builds Output while a run appends to it
+	output *outputBuilder
+
+	// wake interrupts the wait for the next run when the sync is triggered
+	wake chan struct{}
+
+	// Set when interrupted runs are resumed ahead of the other pairs
+	firstRunDone       func()
+	waitBeforeFirstRun <-chan struct{}
+	resumeCompleted    []string
+
+	// runID is the ID of the run in progress, for checkpoints
+	runID string
+
+	// targets are the paths a watch schedule saw change since the last
+	// run, and targetsFull is set when the next run must sync everything.
+	// runTargets are the paths the run in progress syncs, or nil for all.
+	targets     map[string]bool
+	targetsFull bool
+	runTargets  []string
+
+	// phase is the phase of a pair with incremental set, "" until known
+	phase string
+
+	// target is the release a deploying run writes to
+	target string
+
+	// lockedBy is the lock file holding off runs, if any
+	lockedBy string
+
+	// maintenance caches the destination's maintenance calendar
+	maintenance *maintenanceCalendar
+
+	// lastRun is the outcome of the last run, for schedulers that adapt to
+	// it. It is nil if the last run didn't start, such as while locked.
+	lastRun *RunRecord
+
+	// dirStats caches the last scan of the source and destination
+	dirStats dirStatsCache
+
+	// slots limits how many pairs run at once, and queued is set while
+	// the pair waits for one
+	slots  *runSlots
+	queued bool
+
+	// disabled pairs don't run until enabled again
+	disabled bool
+
+	// upstreamDone holds the pairs the pair runs after that have succeeded
+	// since its last run started
+	upstreamDone map[string]bool
+
+	// The scheduling loop's state, for the watchdog. loopGen tells a
+	// restarted loop from the one it replaced, and heldBack is set while
+	// waiting for resumed runs of other pairs.
+	loopCancel        context.CancelFunc
+	loopGen           int
+	loopRunning       bool
+	loopPanic         string
+	heldBack          bool
+	schedulerRestarts int
+
+	mu sync.RWMutex
 }
 
 // NewSync creates a new Sync instance
@@ -39,55 +124,228 @@ func NewSync(sourcePath, destPath string, interval int) *Sync {
 		NextSyncTime:    time.Now(),
 		Output:          "",
 		LastError:       "",
+		Pair:            PairConfig{Source: sourcePath, Destination: destPath},
+		wake:            make(chan struct{}, 1),
 	}
 }
 
-// Start begins the sync process in a goroutine
-func (s *Sync) Start(interval int) {
-	go func() {
-		for {
+// Start begins the sync process in a goroutine, which runs until ctx is done
+func (s *Sync) Start(ctx context.Context, interval int) {
+	go s.loop(ctx, interval)
+}
+
+// loop runs the sync whenever the pair's scheduler says so, or every
+// interval seconds without a schedule, until ctx is done
+func (s *Sync) loop(ctx context.Context, interval int) {
+	schedule := chainSchedule(s.Pair)
+	scheduler, err := NewScheduler(schedule, interval)
+	if err != nil {
+		log.Printf("[%s] Not scheduling runs: %v", s.ID, err)
+		s.setError(err.Error())
+		return
+	}
+
+	// Schedulers are asked outside the lock, so one that panics doesn't
+	// leave the pair locked
+	first := scheduler.Next(time.Time{})
+
+	s.mu.Lock()
+	if s.wake == nil {
+		s.wake = make(chan struct{}, 1)
+	}
+	wake := s.wake
+	wait := s.waitBeforeFirstRun
+	// Pairs without a schedule keep the first run time they were given,
+	// resumed runs go first whatever the schedule says, and chained pairs
+	// whose upstream pairs are already done were triggered by them
+	chainReady := s.upstreamDone != nil && len(s.waitingFor()) == 0
+	if schedule != nil && s.firstRunDone == nil && !chainReady {
+		s.NextSyncTime = first
+	}
+	s.mu.Unlock()
+
+	if watcher, ok := scheduler.(Watcher); ok {
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					s.setError("Stopped watching for changes: " + s.panicError(r).Error())
+				}
+			}()
+			watcher.Watch(ctx, s, func() { s.TriggerSync() })
+		}()
+	}
+
+	// Let resumed runs go first
+	if wait != nil {
+		s.mu.Lock()
+		s.heldBack = true
+		s.mu.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+		}
+		s.mu.Lock()
+		s.heldBack = false
+		s.mu.Unlock()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+
+	for {
+		s.mu.RLock()
+		nextSync := s.NextSyncTime
+		paused := s.Paused || s.disabled
+		s.mu.RUnlock()
+
+		// If paused or disabled, wait a bit and check again
+		if paused {
+			if !sleepContext(ctx, 1*time.Second) {
+				return
+			}
+			continue
+		}
+
+		// Wait until the next sync time, or for a trigger
+		if nextSync.IsZero() {
+			log.Printf("[%s] Waiting for a trigger", s.ID)
+		} else {
+			log.Printf("[%s] Next sync in %v", s.ID, time.Until(nextSync))
+		}
+		if !waitForRun(ctx, nextSync, wake) {
+			return
+		}
+
+		// Check if paused, or if woken before the sync is due
+		s.mu.RLock()
+		paused = s.Paused || s.disabled
+		due := !s.NextSyncTime.IsZero() && !s.NextSyncTime.After(time.Now())
+		s.mu.RUnlock()
+
+		if !paused && due {
+			// Wait for a free run slot, checking again that the pair
+			// wasn't paused or disabled meanwhile
+			if !s.waitForSlot(ctx) {
+				return
+			}
 			s.mu.RLock()
-			nextSync := s.NextSyncTime
-			paused := s.Paused
+			paused = s.Paused || s.disabled
 			s.mu.RUnlock()
-
-			// If paused, wait a bit and check again
 			if paused {
-				time.Sleep(1 * time.Second)
+				s.slots.release()
 				continue
 			}
 
-			// Calculate time until next sync
-			waitTime := time.Until(nextSync)
-			log.Printf("[%s] Next sync in %v", s.ID, waitTime)
-
-			// Wait until next sync time
-			time.Sleep(waitTime)
+			// Perform the sync
+			s.mu.Lock()
+			s.lastRun = nil
+			s.mu.Unlock()
+			s.Run(ctx)
+			s.slots.release()
 
-			// Check if paused before starting sync
 			s.mu.RLock()
-			paused = s.Paused
+			last := s.lastRun
 			s.mu.RUnlock()
+			if observer, ok := scheduler.(RunObserver); ok && last != nil {
+				observer.Observe(*last)
+			}
 
-			if !paused {
-				// Perform the sync
-				s.SyncDirectories()
+			s.mu.Lock()
+			done := s.firstRunDone
+			s.firstRunDone = nil
+			s.mu.Unlock()
+			if done != nil {
+				done()
+			}
 
-				// Update next sync time
-				s.mu.Lock()
-				s.NextSyncTime = time.Now().Add(time.Duration(interval) * time.Second)
-				s.mu.Unlock()
+			// Update next sync time, running again right away if
+			// triggered during the run, or once the lock is likely gone
+			next := scheduler.Next(time.Now())
+			s.mu.Lock()
+			if s.followUp {
+				s.followUp = false
+				s.NextSyncTime = time.Now()
+			} else if s.lockedBy != "" {
+				s.NextSyncTime = time.Now().Add(lockPollInterval)
+			} else {
+				s.NextSyncTime = next
 			}
+			s.mu.Unlock()
 		}
-	}()
+	}
+}
+
+// waitForRun waits until next, or for a wake-up if next is zero or a
+// trigger comes first. It returns false if ctx is done.
+func waitForRun(ctx context.Context, next time.Time, wake <-chan struct{}) bool {
+	var due <-chan time.Time
+	if !next.IsZero() {
+		timer := time.NewTimer(time.Until(next))
+		defer timer.Stop()
+		due = timer.C
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-due:
+	case <-wake:
+	}
+	return ctx.Err() == nil
 }
 
-// TriggerSync triggers an immediate sync
-func (s *Sync) TriggerSync() {
+// TriggerResult says what a trigger did
+type TriggerResult string
+
+const (
+	// TriggerQueued means the sync will start shortly
+	TriggerQueued TriggerResult = "queued"
+
+	// TriggerFollowUp means the sync is running and will run once more when done
+	TriggerFollowUp TriggerResult = "follow_up"
+
+	// TriggerCoalesced means a run was already due, so the trigger was merged into it
+	TriggerCoalesced TriggerResult = "coalesced"
+
+	// TriggerDisabled means the pair is disabled, so nothing will run
+	TriggerDisabled TriggerResult = "disabled"
+)
+
+// TriggerSync runs the sync as soon as possible. Triggers while the sync
+// is running or already due are coalesced into a single run, so a burst of
+// triggers causes at most one run after the current one.
+func (s *Sync) TriggerSync() TriggerResult {
 	s.mu.Lock()
-	s.NextSyncTime = time.Now()
+	defer s.mu.Unlock()
+
+	if s.disabled {
+		return TriggerDisabled
+	}
+
+	wasPaused := s.Paused
 	s.Paused = false // Unpause if paused
-	s.mu.Unlock()
+
+	switch {
+	case s.IsSyncing && s.followUp:
+		return TriggerCoalesced
+	case s.IsSyncing:
+		s.followUp = true
+		return TriggerFollowUp
+	case !wasPaused && !s.NextSyncTime.IsZero() && !s.NextSyncTime.After(time.Now()):
+		return TriggerCoalesced
+	}
+
+	s.NextSyncTime = time.Now()
+	s.wakeUp()
+	return TriggerQueued
+}
+
+// wakeUp interrupts the wait for the next run. The caller must hold s.mu.
+func (s *Sync) wakeUp() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
 }
 
 // PauseSync pauses the sync process
@@ -108,26 +366,90 @@ func (s *Sync) ResumeSync() {
 	s.mu.Unlock()
 }
 
+// SetEnabled enables or disables the pair. Disabled pairs keep their
+// history and configuration but don't run, even when triggered, until
+// enabled again. A run in progress is left to finish.
+func (s *Sync) SetEnabled(enabled bool) {
+	s.mu.Lock()
+	s.disabled = !enabled
+	s.mu.Unlock()
+}
+
+// Enabled reports whether the pair is enabled
+func (s *Sync) Enabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !s.disabled
+}
+
 // GetStatus returns the current status of the sync
 func (s *Sync) GetStatus() map[string]interface{} {
+	phase := s.syncPhase()
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	lastErrorClass := ""
+	if s.lastRun != nil {
+		lastErrorClass = s.lastRun.ErrorClass
+	}
+
 	return map[string]interface{}{
-		"id":               s.ID,
-		"source_path":      s.SourcePath,
-		"destination_path": s.DestinationPath,
-		"is_syncing":       s.IsSyncing,
-		"paused":           s.Paused,
-		"last_sync":        s.LastSync,
-		"next_sync_time":   s.NextSyncTime,
-		"output":           s.Output,
-		"last_error":       s.LastError,
+		"id":                 s.ID,
+		"name":               s.Name(),
+		"tags":               s.Pair.Tags,
+		"profile":            s.Pair.Profile,
+		"source_path":        s.SourcePath,
+		"destination_path":   s.DestinationPath,
+		"is_syncing":         s.IsSyncing,
+		"paused":             s.Paused,
+		"enabled":            !s.disabled,
+		"last_sync":          s.LastSync,
+		"next_sync_time":     s.NextSyncTime,
+		"output":             s.Output,
+		"last_error":         s.LastError,
+		"last_error_class":   lastErrorClass,
+		"follow_up_queued":   s.followUp,
+		"locked_by":          s.lockedBy,
+		"totals":             s.history.GetPairTotals(s.ID),
+		"scheduler_restarts": s.schedulerRestarts,
+		"run_id":             s.runID,
+		"queued":             s.queued,
+		"waiting_for":        s.waitingFor(),
+		"sync_phase":         phase,
+	}
+}
+
+// sleepContext waits for d, returning false if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
 	}
 }
 
-// SyncDirectories synchronizes files from source to destination using rsync
+// SyncDirectories synchronizes files from source to destination once
 func (s *Sync) SyncDirectories() error {
+	return s.Run(context.Background())
+}
+
+// Run synchronizes files from source to destination using rsync, or the
+// built-in copier if rsync isn't available. Cancelling ctx stops the
+// transfer and fails the run.
+func (s *Sync) Run(ctx context.Context) (err error) {
+	// Keep a panic before or after the transfer from taking down the other
+	// pairs
+	defer s.recoverRun(&err)
+
 	// Check if paused before starting
 	s.mu.RLock()
 	paused := s.Paused
@@ -137,20 +459,220 @@ func (s *Sync) SyncDirectories() error {
 		return nil
 	}
 
+	// Leave the pair alone while a lock file says someone else has it
+	if s.checkLock() {
+		log.Printf("[%s] Locked by %s, skipping run", s.ID, s.findLockFile())
+		return nil
+	}
+
+	// Skip runs while the destination is down for maintenance, recording why
+	if window, end, ok := s.maintenanceAt(time.Now()); ok {
+		reason := fmt.Sprintf("Destination in maintenance window %q until %s", window.Summary, end.Format(time.RFC1123))
+		log.Printf("[%s] %s, skipping run", s.ID, reason)
+		run, err := s.history.SkipRun(s.ID, reason)
+		if err != nil {
+			log.Printf("[%s] Error saving history: %v", s.ID, err)
+		}
+		s.mu.Lock()
+		s.Output = reason + ", run skipped"
+		s.LastError = ""
+		s.lastRun = run
+		s.mu.Unlock()
+		if s.onRunFinished != nil {
+			s.onRunFinished(s.history.snapshot(run))
+		}
+		return nil
+	}
+
+	// Skip the run if the top of the source hasn't changed, without
+	// walking the tree, until a full run is due
+	// Neither skip is trusted until an incremental pair's initial sync is
+	// done
+	initial := s.syncPhase() == phaseInitial
+	var quickCheck string
+	if s.Pair.QuickCheck != nil && !s.Pair.DryRun && !initial {
+		var unchanged bool
+		unchanged, quickCheck = s.quickCheckUnchanged()
+		if unchanged {
+			log.Printf("[%s] Source directories unchanged since last sync, skipping run", s.ID)
+			s.mu.Lock()
+			s.LastSync = time.Now()
+			s.Output = fmt.Sprintf("Source %s directories unchanged since last sync, next full scan by %s",
+				s.SourcePath, s.lastFullScan.Add(s.Pair.QuickCheck.fullScanInterval()).Format(time.RFC1123))
+			s.LastError = ""
+			s.lastRun = &RunRecord{SyncID: s.ID, StartTime: s.LastSync, EndTime: s.LastSync, Success: true}
+			s.mu.Unlock()
+			return nil
+		}
+	}
+
+	// Skip the run entirely if the source hasn't changed, so an idle
+	// destination disk isn't woken up just to find nothing to do
+	var fingerprint string
+	if s.Pair.CoalesceRuns && !s.Pair.DryRun && !initial {
+		var unchanged bool
+		unchanged, fingerprint = s.sourceUnchanged()
+		if unchanged {
+			log.Printf("[%s] Source unchanged since last sync, skipping run", s.ID)
+			s.mu.Lock()
+			s.LastSync = time.Now()
+			s.Output = fmt.Sprintf("Source %s unchanged since last sync, destination not touched", s.SourcePath)
+			s.LastError = ""
+			s.lastRun = &RunRecord{SyncID: s.ID, StartTime: s.LastSync, EndTime: s.LastSync, Success: true}
+			s.mu.Unlock()
+			return nil
+		}
+	}
+
 	// Update status
 	s.mu.Lock()
 	s.IsSyncing = true
 	s.Output = fmt.Sprintf("Starting sync from %s to %s\n", s.SourcePath, s.DestinationPath)
 	s.LastError = ""
+	s.stats = TransferStats{}
+	s.upstreamDone = nil
 	s.mu.Unlock()
 
 	log.Printf("[%s] Starting sync from %s to %s using rsync", s.ID, s.SourcePath, s.DestinationPath)
 
+	// Record the run in the history and send notifications when it's done
+	run := s.history.StartRun(s.ID)
+	s.mu.Lock()
+	s.runID = run.ID
+	resumed := s.resumeCompleted
+	s.mu.Unlock()
+	s.journal.begin(JournalEntry{
+		RunID:       run.ID,
+		SyncID:      s.ID,
+		Destination: s.DestinationPath,
+		PartialDir:  s.Pair.PartialDir,
+		StartTime:   run.StartTime,
+		Completed:   resumed,
+	})
+	defer func() {
+		// Record a panic outside the transfer, such as in a hook, as the
+		// run's failure
+		if r := recover(); r != nil {
+			err = s.panicError(r)
+			s.setError(err.Error())
+		}
+
+		s.mu.RLock()
+		stats, output := s.stats, s.Output
+		s.mu.RUnlock()
+		if saveErr := s.history.finish(run, stats, output, err); saveErr != nil {
+			log.Printf("[%s] Error saving history: %v", s.ID, saveErr)
+		}
+		s.journal.end(run.ID)
+		finished := s.history.snapshot(run)
+		s.mu.Lock()
+		s.runID = ""
+		s.resumeCompleted = nil
+		last := finished
+		s.lastRun = &last
+		s.mu.Unlock()
+		s.notify(finished)
+		if s.onRunFinished != nil {
+			s.onRunFinished(finished)
+		}
+	}()
+
+	// Let the pair's hooks know about the run, and when it's done
+	pairHooks, err := s.pairHooks()
+	if err != nil {
+		s.setError(err.Error())
+		return err
+	}
+	defer func() {
+		for _, hook := range pairHooks {
+			hook.OnSyncEnd(context.WithoutCancel(ctx), s.hookEvent(""), err)
+		}
+	}()
+
+	// Let hooks, the history and notifications tell failures apart
+	defer func() {
+		err = classifyError(err)
+	}()
+	for _, hook := range pairHooks {
+		if err := hook.OnSyncStart(ctx, s.hookEvent("")); err != nil {
+			errMsg := fmt.Sprintf("Hook failed: %s", err)
+			log.Println(errMsg)
+			s.setError(errMsg)
+			return err
+		}
+	}
+
+	// Simulate a failure if one was injected through the debug API
+	if failure := s.takeInjectedFailure(); failure != nil {
+		if err := failure.apply(s); err != nil {
+			errMsg := fmt.Sprintf("Injected failure: %s", err)
+			log.Println(errMsg)
+			s.setError(errMsg)
+			return err
+		}
+	}
+
+	// Wake the destination host if configured, and let it sleep again afterwards
+	if s.Pair.WakeOnLAN != nil {
+		defer func() { s.suspendDestination(err) }()
+
+		if err := s.wakeDestination(); err != nil {
+			errMsg := fmt.Sprintf("Failed to wake destination: %s", err)
+			log.Println(errMsg)
+			s.setError(errMsg)
+			return err
+		}
+	}
+
+	// Run the pair's commands on the destination host once the run is
+	// done, before it's allowed to sleep again
+	if s.Pair.RemoteHooks != nil {
+		defer func() {
+			s.mu.RLock()
+			paused := s.Paused
+			s.mu.RUnlock()
+			if paused {
+				return
+			}
+
+			if remoteErr := s.runRemoteHooks(context.WithoutCancel(ctx), run.ID, err); remoteErr != nil && err == nil {
+				errMsg := fmt.Sprintf("Remote hook failed: %s", remoteErr)
+				log.Println(errMsg)
+				s.setError(errMsg)
+				err = remoteErr
+			}
+		}()
+	}
+
+	// Write the report of a successful run into the destination, while it's
+	// still awake
+	if s.Pair.Report != nil && !s.Pair.DryRun {
+		defer func() {
+			if err != nil {
+				return
+			}
+			if reportErr := s.writeReport(run); reportErr != nil {
+				errMsg := fmt.Sprintf("Failed to write run report: %s", reportErr)
+				log.Println(errMsg)
+				s.setError(errMsg)
+				err = reportErr
+			}
+		}()
+	}
+
 	// Make sure paths exist
 	if _, err := os.Stat(s.SourcePath); os.IsNotExist(err) {
 		errMsg := fmt.Sprintf("Source path does not exist: %s", s.SourcePath)
 		log.Println(errMsg)
 		s.setError(errMsg)
+		return fmt.Errorf("%w: %s", ErrSourceMissing, s.SourcePath)
+	}
+
+	// Never copy the source into itself, which recurses until the disk fills
+	if err := checkOverlap(s.SourcePath, s.DestinationPath); err != nil {
+		errMsg := fmt.Sprintf("Refusing to sync: %s", err)
+		log.Println(errMsg)
+		s.setError(errMsg)
 		return err
 	}
 
@@ -175,7 +697,7 @@ func (s *Sync) SyncDirectories() error {
 	}
 
 	// Create destination if it doesn't exist
-	if _, err := os.Stat(s.DestinationPath); os.IsNotExist(err) {
+	if _, err := os.Stat(s.DestinationPath); os.IsNotExist(err) && !s.Pair.DryRun {
 		log.Printf("[%s] Creating destination directory: %s", s.ID, s.DestinationPath)
 		if err := os.MkdirAll(s.DestinationPath, 0755); err != nil {
 			errMsg := fmt.Sprintf("Failed to create destination directory: %s", err)
@@ -190,19 +712,111 @@ func (s *Sync) SyncDirectories() error {
 		s.mu.Unlock()
 	}
 
-	// Check if rsync is available
-	_, err = exec.LookPath("rsync")
-	if err != nil {
-		errMsg := "rsync command not found. Please install rsync and try again."
-		log.Println(errMsg)
-		s.setError(errMsg)
+	// Deployments sync into a new release, which is switched to once the
+	// run succeeded
+	if s.Pair.Deploy != nil {
+		release, err := s.startRelease()
+		if err != nil {
+			errMsg := fmt.Sprintf("Failed to create release directory: %s", err)
+			log.Println(errMsg)
+			s.setError(errMsg)
+			return err
+		}
+		defer func() {
+			if deployErr := s.finishRelease(release, err); deployErr != nil && err == nil {
+				errMsg := fmt.Sprintf("Deploy failed: %s", deployErr)
+				log.Println(errMsg)
+				s.setError(errMsg)
+				err = deployErr
+			}
+		}()
+	}
+
+	return s.transfer(ctx, fingerprint, quickCheck)
+}
 
-		// Pause the sync until manually resumed
+// transfer copies the source to the run's destination with rsync or the
+// built-in copier. A panic while copying, such as in a filter or transform,
+// fails the run like any other error.
+func (s *Sync) transfer(ctx context.Context, fingerprint, quickCheck string) (err error) {
+	defer s.recoverRun(&err)
+
+	// Watched pairs can sync only the paths that changed, with a full run
+	// every so often
+	targets := s.takeTargets()
+	s.mu.Lock()
+	s.runTargets = targets
+	s.mu.Unlock()
+	defer func() {
 		s.mu.Lock()
-		s.Paused = true
+		s.runTargets = nil
 		s.mu.Unlock()
+	}()
 
-		return err
+	// Fall back to the built-in copier if rsync isn't available, or if the
+	// pair needs something rsync can't do
+	_, lookErr := exec.LookPath("rsync")
+	reason := s.builtinCopyReason()
+	if lookErr == nil && reason == "" && targets != nil {
+		// The built-in copier syncs single paths, deletions included
+		reason = fmt.Sprintf("Syncing %d changed paths,", len(targets))
+	}
+	if lookErr == nil && reason == "" && s.caseCollisionsMatter() {
+		// rsync would copy files that differ only in case over each other
+		reason = "Source has names that differ only in case,"
+	}
+	if lookErr != nil || reason != "" {
+		if lookErr != nil {
+			log.Printf("[%s] rsync command not found, falling back to built-in file copy", s.ID)
+			s.appendOutput("rsync command not found, using built-in file copy")
+		} else {
+			s.appendOutput(reason + " using built-in file copy")
+		}
+
+		err := runNiced(s.Pair.Niceness, func() error {
+			return s.syncWithFileCopy(ctx, s.SourcePath, s.runDestination())
+		})
+		if err != nil {
+			if err == errSyncPaused {
+				s.appendOutput("Sync paused by user")
+				s.mu.Lock()
+				s.IsSyncing = false
+				s.mu.Unlock()
+				return nil
+			}
+
+			errMsg := fmt.Sprintf("File copy error: %s", err)
+			log.Println(errMsg)
+			s.setError(errMsg)
+			return err
+		}
+
+		log.Printf("[%s] File copy completed successfully", s.ID)
+
+		// Read back a sample of the copied files
+		s.mu.Lock()
+		transferred := s.transferred
+		s.transferred = nil
+		s.mu.Unlock()
+		if err := s.verifySample(transferred); err != nil {
+			errMsg := fmt.Sprintf("Verification failed: %s", err)
+			log.Println(errMsg)
+			s.setError(errMsg)
+			return err
+		}
+
+		// Update status
+		s.mu.Lock()
+		s.IsSyncing = false
+		s.LastSync = time.Now()
+		s.Output += "\nSync completed successfully"
+		s.lastFingerprint = fingerprint
+		if targets == nil {
+			s.lastQuickCheck, s.lastFullScan = quickCheck, s.LastSync
+		}
+		s.mu.Unlock()
+
+		return nil
 	}
 
 	// Ensure source path ends with a slash to copy contents only
@@ -211,130 +825,231 @@ func (s *Sync) SyncDirectories() error {
 		sourcePath = sourcePath + "/"
 	}
 
-	// Prepare rsync command with verbose output
-	// -a: archive mode (preserves permissions, timestamps, etc.)
-	// -v: verbose
-	// -z: compress during transfer
-	// -P: show progress
-	// Note: --delete flag is NOT used to ensure we don't delete files in destination
-	cmd := exec.Command("rsync", "-avzP", sourcePath, s.DestinationPath)
+	// Clear out partial files left behind by interrupted runs
+	if s.Pair.PartialDir != "" && !s.Pair.DryRun {
+		if err := preparePartialDir(s.Pair.PartialDir); err != nil {
+			log.Printf("[%s] Error creating partial directory: %v", s.ID, err)
+		}
+		removed, err := cleanStalePartials(s.runDestination(), s.Pair.PartialDir, s.partialMaxAge())
+		if err != nil {
+			log.Printf("[%s] Error cleaning stale partial files: %v", s.ID, err)
+		} else if removed > 0 {
+			log.Printf("[%s] Removed %d stale partial files", s.ID, removed)
+			s.mu.Lock()
+			s.Output += fmt.Sprintf("\nRemoved %d stale partial files", removed)
+			s.mu.Unlock()
+		}
+	}
 
-	// Create pipes for stdout and stderr
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		errMsg := fmt.Sprintf("Failed to create stdout pipe: %s", err)
+	// Check what --delete would remove before rsync removes it
+	if err := newFileCopier(s, s.SourcePath, s.runDestination()).checkDeleteLimit(); err != nil {
+		errMsg := fmt.Sprintf("Delete limit: %s", err)
 		log.Println(errMsg)
 		s.setError(errMsg)
 		return err
 	}
 
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		errMsg := fmt.Sprintf("Failed to create stderr pipe: %s", err)
-		log.Println(errMsg)
-		s.setError(errMsg)
-		return err
+	// Create a buffer to store the output
+	outputBuffer := newOutputBuilder(s.outputLimit(), s.Output) // Include existing output
+	transferred := newTransferSample(s.Pair.VerifySample)
+
+	limit := s.Pair.Bandwidth.LimitAt(bandwidthClock())
+	if s.Pair.Bandwidth != nil {
+		outputBuffer.WriteString("Using " + describeLimit(limit) + "\n")
 	}
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		errMsg := fmt.Sprintf("Failed to start rsync: %s", err)
-		log.Println(errMsg)
-		s.setError(errMsg)
-		return err
+	// rsync can't change its bandwidth limit while it runs, so it's
+	// restarted with the new limit when the schedule moves into another
+	// window, and carries on from the files it already copied
+	var cmdErr error
+
+	// tooManyErr is set from the stderr reader when too many files failed
+	var failMu sync.Mutex
+	var tooManyErr error
+	tooMany := func() error {
+		failMu.Lock()
+		defer failMu.Unlock()
+		return tooManyErr
 	}
+	for {
+		name, args := s.Pair.Niceness.command("rsync", s.rsyncArgs(sourcePath))
+		cmd := exec.CommandContext(ctx, name, args...)
+		setProcessGroup(cmd)
+
+		// Create pipes for stdout and stderr
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			errMsg := fmt.Sprintf("Failed to create stdout pipe: %s", err)
+			log.Println(errMsg)
+			s.setError(errMsg)
+			return err
+		}
 
-	// Create a buffer to store the output
-	var outputBuffer strings.Builder
-	outputBuffer.WriteString(s.Output) // Include existing output
+		stderr, err := cmd.StderrPipe()
+		if err != nil {
+			errMsg := fmt.Sprintf("Failed to create stderr pipe: %s", err)
+			log.Println(errMsg)
+			s.setError(errMsg)
+			return err
+		}
+
+		// Start the command
+		if err := cmd.Start(); err != nil {
+			errMsg := fmt.Sprintf("Failed to start rsync: %s", err)
+			log.Println(errMsg)
+			s.setError(errMsg)
+			return err
+		}
 
-	// Create a channel to signal when reading is done
-	done := make(chan bool)
+		// Create a channel to signal when reading is done
+		done := make(chan bool)
 
-	// Create a channel to signal when to stop the command
-	stopCmd := make(chan bool, 1)
+		// Closed once this rsync is finished with, to stop the watchers
+		finished := make(chan struct{})
 
-	// Start a goroutine to check for pause state
-	go func() {
-		ticker := time.NewTicker(500 * time.Millisecond)
-		defer ticker.Stop()
+		// Create a channel to signal when to stop the command
+		stopCmd := make(chan bool, 1)
 
-		for {
-			select {
-			case <-done:
-				return
-			case <-ticker.C:
-				s.mu.RLock()
-				paused := s.Paused
-				s.mu.RUnlock()
-
-				if paused {
-					// Signal to stop the command
-					stopCmd <- true
+		// Start a goroutine to check for pause state
+		go func() {
+			ticker := time.NewTicker(500 * time.Millisecond)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-finished:
 					return
+				case <-ticker.C:
+					s.mu.RLock()
+					paused := s.Paused
+					s.mu.RUnlock()
+
+					if paused {
+						// Signal to stop the command
+						stopCmd <- true
+						return
+					}
 				}
 			}
-		}
-	}()
+		}()
+		limitChanged := s.Pair.Bandwidth.watchBandwidth(limit, finished)
+
+		// Read stdout in a goroutine
+		go func() {
+			scanner := bufio.NewScanner(stdout)
+			for scanner.Scan() {
+				line := scanner.Text()
+				log.Println("[" + s.ID + "] rsync: " + line)
+
+				// Show changes in the same form as the built-in copier
+				if change, ok := parseItemizedChange(line); ok {
+					s.addChange(change)
+					if s.Pair.VerifySample > 0 && change.Action != ChangeDeleted {
+						if file, ok := s.rsyncTransferredFile(change.Path); ok {
+							transferred.add(file)
+						}
+					}
+					line = change.String()
+				}
+				outputBuffer.WriteString(line + "\n")
 
-	// Read stdout in a goroutine
-	go func() {
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			line := scanner.Text()
-			outputBuffer.WriteString(line + "\n")
+				// Update status with current output
+				s.mu.Lock()
+				s.Output = outputBuffer.String()
+				parseRsyncTotals(line, &s.stats)
+				s.mu.Unlock()
+				s.reportProgress(line)
+			}
+			done <- true
+		}()
+
+		// Read stderr in a goroutine
+		go func() {
+			scanner := bufio.NewScanner(stderr)
+			for scanner.Scan() {
+				line := scanner.Text()
+				outputBuffer.WriteString("ERROR: " + line + "\n")
+				log.Println("[" + s.ID + "] rsync error: " + line)
+				if failure, ok := parseRsyncFailure(line, s.SourcePath); ok {
+					// Stop rsync once too many files failed
+					if err := s.recordFailure(failure); err != nil {
+						failMu.Lock()
+						if tooManyErr == nil {
+							tooManyErr = err
+							killProcessGroup(cmd)
+						}
+						failMu.Unlock()
+					}
+				}
 
-			// Update status with current output
+				// Update status with current output including errors
+				s.mu.Lock()
+				s.Output = outputBuffer.String()
+				s.mu.Unlock()
+				s.reportProgress("ERROR: " + line)
+			}
+			done <- true
+		}()
+
+		// Wait for either the command to finish or a stop signal
+		restart := false
+		select {
+		case <-stopCmd:
+			// Kill the command if paused
+			close(finished)
+			killProcessGroup(cmd)
+			cmd.Wait()
+			outputBuffer.WriteString("\nSync paused by user\n")
 			s.mu.Lock()
 			s.Output = outputBuffer.String()
+			s.IsSyncing = false
 			s.mu.Unlock()
-
-			log.Println("[" + s.ID + "] rsync: " + line)
-		}
-		done <- true
-	}()
-
-	// Read stderr in a goroutine
-	go func() {
-		scanner := bufio.NewScanner(stderr)
-		for scanner.Scan() {
-			line := scanner.Text()
-			outputBuffer.WriteString("ERROR: " + line + "\n")
-			log.Println("[" + s.ID + "] rsync error: " + line)
-
-			// Update status with current output including errors
+			return nil
+		case limit = <-limitChanged:
+			// Stop this rsync and read the rest of its output first
+			killProcessGroup(cmd)
+			<-done
+			<-done
+			cmd.Wait()
+			log.Printf("[%s] Restarting rsync with %s", s.ID, describeLimit(limit))
+			outputBuffer.WriteString("Restarting rsync with " + describeLimit(limit) + "\n")
 			s.mu.Lock()
 			s.Output = outputBuffer.String()
 			s.mu.Unlock()
-		}
-		done <- true
-	}()
+			restart = ctx.Err() == nil && tooMany() == nil
+		case <-done:
+			// Wait for both stdout and stderr to be fully read
+			<-done
 
-	// Wait for either the command to finish or a stop signal
-	var cmdErr error
-	select {
-	case <-stopCmd:
-		// Kill the command if paused
-		if cmd.Process != nil {
-			cmd.Process.Kill()
+			// Wait for the command to finish
+			cmdErr = cmd.Wait()
 		}
-		outputBuffer.WriteString("\nSync paused by user\n")
-		s.mu.Lock()
-		s.Output = outputBuffer.String()
-		s.IsSyncing = false
-		s.mu.Unlock()
-		return nil
-	case <-done:
-		// Wait for both stdout and stderr to be fully read
-		<-done
+		close(finished)
 
-		// Wait for the command to finish
-		cmdErr = cmd.Wait()
+		if !restart {
+			break
+		}
 	}
 
 	// Get the complete output
 	output := outputBuffer.String()
 
+	// Report cancellation rather than the signal that killed rsync
+	var exitErr *exec.ExitError
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		cmdErr = ctxErr
+	} else if err := tooMany(); err != nil {
+		cmdErr = err
+	} else if errors.As(cmdErr, &exitErr) {
+		rsyncErr := newRsyncExitError(exitErr.ExitCode(), output)
+
+		// rsync exits with 23 or 24 when it skipped files it reported
+		if code := rsyncErr.Code; (code == 23 || code == 24) && s.partialFailure() != nil {
+			rsyncErr.Err = ErrPartialFailure
+		}
+		cmdErr = rsyncErr
+	}
+
 	if cmdErr != nil {
 		errMsg := fmt.Sprintf("rsync error: %v", cmdErr)
 		log.Println(errMsg)
@@ -344,16 +1059,125 @@ func (s *Sync) SyncDirectories() error {
 
 	log.Printf("[%s] rsync completed successfully", s.ID)
 
+	// Read back a sample of the transferred files
+	if s.Pair.VerifySample > 0 {
+		if err := s.verifySample(transferred); err != nil {
+			errMsg := fmt.Sprintf("Verification failed: %s", err)
+			log.Println(errMsg)
+			s.setError(errMsg)
+			return err
+		}
+	}
+
 	// Update status
 	s.mu.Lock()
 	s.IsSyncing = false
 	s.LastSync = time.Now()
-	s.Output = output + "\nSync completed successfully"
+	s.Output += "\nSync completed successfully"
+	s.lastFingerprint = fingerprint
+	s.lastQuickCheck, s.lastFullScan = quickCheck, s.LastSync
 	s.mu.Unlock()
 
 	return nil
 }
 
+// builtinCopyReason explains why the pair needs the built-in copier even
+// when rsync is available, or returns "" if it doesn't
+func (s *Sync) builtinCopyReason() string {
+	switch {
+	case s.Pair.TransferOrder != "":
+		// rsync always sorts its file list
+		return fmt.Sprintf("Transferring files %s", strings.ReplaceAll(s.Pair.TransferOrder, "_", " "))
+	case len(s.Pair.Routes) > 0:
+		// rsync has a single destination
+		return "Routing files"
+	case len(s.Pair.Transforms) > 0:
+		return "Transforming files"
+	case s.Pair.Encryption != nil:
+		return "Encrypting files"
+	case s.Pair.Dedup:
+		return "Deduplicating files"
+	case s.Pair.Checksum:
+		// rsync --checksum hashes every file on every run
+		return "Comparing checksums"
+	case s.Pair.Incremental:
+		// rsync doesn't keep the manifest
+		return "Syncing incrementally"
+	case len(s.Pair.Filters) > 0:
+		return "Filtering files"
+	case len(s.Pair.Hooks) > 0:
+		// rsync can't ask hooks about each file
+		return "Running hooks"
+	}
+	return ""
+}
+
+// rsyncArgs builds the rsync command line for this sync
+func (s *Sync) rsyncArgs(sourcePath string) []string {
+	// -a: archive mode (preserves permissions, timestamps, etc.)
+	// -v: verbose
+	// -z: compress during transfer
+	// -P: show progress
+	// Note: --delete is only used if the pair asks for it, so by default
+	// nothing is ever deleted from the destination
+	args := []string{"-avzP"}
+
+	// List what changed for each path in a fixed format, for the run's
+	// change list
+	args = append(args, "--itemize-changes", "--out-format=%i %n%L")
+
+	// Limit the bandwidth to the schedule's current window
+	if limit := s.Pair.Bandwidth.LimitAt(bandwidthClock()); limit > 0 {
+		args = append(args, fmt.Sprintf("--bwlimit=%d", limit))
+	}
+
+	// Only delete files missing from the source when asked to
+	if s.Pair.Delete {
+		args = append(args, "--delete")
+	}
+	if s.Pair.DryRun {
+		args = append(args, "--dry-run")
+	}
+
+	// Keep partially transferred files out of the way until they are complete
+	if s.Pair.PartialDir != "" {
+		args = append(args, "--partial-dir="+s.Pair.PartialDir)
+	}
+
+	// Leave out dotfiles and dot-directories at any depth. Excluded files
+	// are also safe from --delete.
+	if s.Pair.SkipHidden {
+		args = append(args, "--exclude=.*")
+	}
+
+	// Don't cross into other filesystems mounted inside the source
+	if s.Pair.OneFilesystem {
+		args = append(args, "--one-file-system")
+	}
+
+	// Never copy a lock file, or delete one taken on the destination
+	args = append(args, "--exclude=/"+LockFile)
+	if s.Pair.Report != nil {
+		args = append(args, "--exclude=/"+reportFile)
+	}
+
+	// Never copy a destination that lives inside the source
+	s.mu.RLock()
+	for _, dir := range s.excludedDirs {
+		args = append(args, "--exclude=/"+dir+"/")
+	}
+	s.mu.RUnlock()
+
+	// Unchanged files in a new release are hard links to the current one
+	if s.Pair.Deploy != nil && !s.Pair.DryRun {
+		if current := currentRelease(s.DestinationPath, s.Pair.Deploy); current != "" {
+			args = append(args, "--link-dest="+current)
+		}
+	}
+
+	return append(args, sourcePath, s.runDestination())
+}
+
 // isDirEmpty checks if a directory is empty
 func isDirEmpty(dirPath string) (bool, error) {
 	f, err := os.Open(dirPath)
@@ -370,6 +1194,37 @@ func isDirEmpty(dirPath string) (bool, error) {
 	return false, err // Either not empty or error
 }
 
+// appendOutput adds a line to the sync's output
+func (s *Sync) appendOutput(line string) {
+	s.mu.Lock()
+	// Pick up output set directly since the last line
+	if s.output == nil || s.output.String() != s.Output {
+		s.output = newOutputBuilder(s.outputLimit(), s.Output)
+	}
+	if s.Output != "" && !strings.HasSuffix(s.Output, "\n") {
+		s.output.WriteString("\n")
+	}
+	s.output.WriteString(line + "\n")
+	s.Output = s.output.String()
+	s.mu.Unlock()
+
+	s.reportProgress(line)
+}
+
+// reportProgress passes a line of output to the progress callback, if any
+func (s *Sync) reportProgress(line string) {
+	if s.onProgress != nil {
+		s.onProgress(Progress{SyncID: s.ID, Line: line})
+	}
+}
+
+// isPaused reports whether the sync is paused
+func (s *Sync) isPaused() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Paused
+}
+
 // setError updates the status with an error message
 func (s *Sync) setError(errMsg string) {
 	s.mu.Lock()
@@ -381,20 +1236,58 @@ func (s *Sync) setError(errMsg string) {
 
 // SyncManager manages multiple Sync instances
 type SyncManager struct {
-	Syncs []*Sync
-	mu    sync.RWMutex
+	Syncs         []*Sync
+	History       *History
+	Webhooks      []WebhookConfig
+	OnProgress    func(Progress)
+	Plugins       *Plugins
+	OnRunFinished func(RunRecord)
+	Journal       *Journal
+	ChangeLimit   int
+	MaxMemoryMB   int
+	slots         *runSlots
+	mu            sync.RWMutex
+	running       sync.WaitGroup
 }
 
-// NewSyncManager creates a new SyncManager
-func NewSyncManager() *SyncManager {
+// NewSyncManager creates a new SyncManager from opts
+func NewSyncManager(opts Options) *SyncManager {
+	history := opts.History
+	if history == nil {
+		history, _ = NewHistory("", 0)
+	}
+
 	return &SyncManager{
-		Syncs: make([]*Sync, 0),
+		Syncs:         make([]*Sync, 0),
+		History:       history,
+		Webhooks:      opts.Webhooks,
+		OnProgress:    opts.OnProgress,
+		Plugins:       opts.Plugins,
+		OnRunFinished: opts.OnRunFinished,
+		Journal:       opts.Journal,
+		ChangeLimit:   opts.ChangeLimit,
+		MaxMemoryMB:   opts.MaxMemoryMB,
+		slots:         newRunSlots(opts.MaxConcurrentRuns),
 	}
 }
 
 // AddSync adds a new Sync to the manager
 func (sm *SyncManager) AddSync(sourcePath, destPath string, interval int) *Sync {
 	sync := NewSync(sourcePath, destPath, interval)
+	sync.history = sm.History
+	sync.webhooks = sm.Webhooks
+	sync.onProgress = sm.OnProgress
+	sync.plugins = sm.Plugins
+	sync.onRunFinished = func(run RunRecord) {
+		if sm.OnRunFinished != nil {
+			sm.OnRunFinished(run)
+		}
+		sm.chainRun(run)
+	}
+	sync.journal = sm.Journal
+	sync.maxChanges = sm.ChangeLimit
+	sync.maxMemoryMB = sm.MaxMemoryMB
+	sync.slots = sm.slots
 
 	sm.mu.Lock()
 	sm.Syncs = append(sm.Syncs, sync)
@@ -403,6 +1296,32 @@ func (sm *SyncManager) AddSync(sourcePath, destPath string, interval int) *Sync
 	return sync
 }
 
+// AddPair adds a new Sync for a structured pair configuration
+func (sm *SyncManager) AddPair(pair PairConfig, interval int) *Sync {
+	sync := sm.AddSync(pair.Source, pair.Destination, interval)
+	sync.Pair = pair
+	sync.disabled = pair.Enabled != nil && !*pair.Enabled
+
+	// Pairs keep the history they had under their paths as configured
+	if pair.PreviousID != "" && pair.PreviousID != sync.ID {
+		if err := sm.History.renamePair(pair.PreviousID, sync.ID); err != nil {
+			log.Printf("[%s] Error saving history: %v", sync.ID, err)
+		}
+	}
+
+	// Named pairs keep the history they had under their paths
+	if id := pair.ID(); id != sync.ID {
+		if err := sm.History.renamePair(sync.ID, id); err != nil {
+			log.Printf("[%s] Error saving history: %v", id, err)
+		}
+		sm.mu.Lock()
+		sync.ID = id
+		sm.mu.Unlock()
+	}
+
+	return sync
+}
+
 // GetAllStatus returns the status of all syncs
 func (sm *SyncManager) GetAllStatus() []map[string]interface{} {
 	sm.mu.RLock()
@@ -416,7 +1335,16 @@ func (sm *SyncManager) GetAllStatus() []map[string]interface{} {
 	return statuses
 }
 
-// GetSyncByID returns a sync by its ID
+// AllSyncs returns every sync, in the order they were added
+func (sm *SyncManager) AllSyncs() []*Sync {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	return append([]*Sync(nil), sm.Syncs...)
+}
+
+// GetSyncByID returns a sync by its ID. Named pairs are also found by the
+// "source:destination" they were identified by before.
 func (sm *SyncManager) GetSyncByID(id string) *Sync {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
@@ -426,67 +1354,87 @@ func (sm *SyncManager) GetSyncByID(id string) *Sync {
 			return sync
 		}
 	}
+	for _, sync := range sm.Syncs {
+		if sync.hasID(id) {
+			return sync
+		}
+	}
 
 	return nil
 }
 
-// TriggerAllSyncs triggers all syncs
-func (sm *SyncManager) TriggerAllSyncs() {
+// GetRun returns a run by its ID. A run still in progress has the output its
+// pair has printed so far.
+func (sm *SyncManager) GetRun(id string) (RunRecord, bool) {
+	run, ok := sm.History.GetRun(id)
+	if !ok || !run.EndTime.IsZero() {
+		return run, ok
+	}
+
+	if sync := sm.GetSyncByID(run.SyncID); sync != nil {
+		sync.mu.RLock()
+		if sync.runID == id {
+			run.Output = sync.Output
+		}
+		sync.mu.RUnlock()
+	}
+	return run, true
+}
+
+// TriggerAllSyncs triggers all syncs, returning what each trigger did by sync ID
+func (sm *SyncManager) TriggerAllSyncs() map[string]TriggerResult {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
 
+	results := make(map[string]TriggerResult, len(sm.Syncs))
 	for _, sync := range sm.Syncs {
-		sync.TriggerSync()
+		results[sync.ID] = sync.TriggerSync()
 	}
+	return results
 }
 
-// StartSyncProcess starts the synchronization process for all pairs
-func StartSyncProcess(syncManager *SyncManager, config *Config) {
-	log.Println("Starting sync process")
-
-	// Create a sync for each pair
-	for _, pair := range config.SyncPairs {
-		parts := strings.Split(pair, ":")
-		if len(parts) != 2 {
-			log.Printf("Invalid sync pair format: %s", pair)
-			continue
-		}
+// Start starts all syncs, which run every interval seconds until ctx is done
+func (sm *SyncManager) Start(ctx context.Context, interval int) {
+	// Keep destinations out of any source that contains them
+	sm.UpdateProtectiveExcludes()
 
-		sourcePath := parts[0]
-		destPath := parts[1]
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
 
-		// Create and start a new sync
-		sync := syncManager.AddSync(sourcePath, destPath, config.SyncInterval)
-		sync.Start(config.SyncInterval)
+	for _, sync := range sm.Syncs {
+		sm.startLoop(ctx, sync, interval)
 	}
+
+	// Restart pairs whose scheduling stopped
+	sm.running.Add(1)
+	go func() {
+		defer sm.running.Done()
+		sm.watchdog(ctx, interval)
+	}()
+}
+
+// Wait blocks until every sync started by Start has stopped, which happens
+// once their context is done and any run in progress has finished
+func (sm *SyncManager) Wait() {
+	sm.running.Wait()
 }
 
 // PauseSyncByID pauses a sync by its ID
 func (sm *SyncManager) PauseSyncByID(id string) bool {
-	sm.mu.RLock()
-	defer sm.mu.RUnlock()
-
-	for _, sync := range sm.Syncs {
-		if sync.ID == id {
-			sync.PauseSync()
-			return true
-		}
+	sync := sm.GetSyncByID(id)
+	if sync == nil {
+		return false
 	}
-
-	return false
+	sync.PauseSync()
+	return true
 }
 
 // ResumeSyncByID resumes a sync by its ID
 func (sm *SyncManager) ResumeSyncByID(id string) bool {
-	sm.mu.RLock()
-	defer sm.mu.RUnlock()
-
-	for _, sync := range sm.Syncs {
-		if sync.ID == id {
-			sync.ResumeSync()
-			return true
-		}
+	sync := sm.GetSyncByID(id)
+	if sync == nil {
+		return false
 	}
-
-	return false
+	sync.ResumeSync()
+	return true
 }
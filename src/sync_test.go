@@ -1,9 +1,11 @@
-package main
+package dirsync
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -268,7 +270,7 @@ func TestSetError(t *testing.T) {
 // TestSyncManager tests the SyncManager functionality
 func TestSyncManager(t *testing.T) {
 	// Create a test sync manager
-	manager := NewSyncManager()
+	manager := NewSyncManager(Options{})
 
 	// Verify it's initialized correctly
 	if len(manager.Syncs) != 0 {
@@ -332,3 +334,185 @@ func TestSyncManager(t *testing.T) {
 		t.Errorf("NextSyncTime for sync2 was not updated")
 	}
 }
+
+// TestRunProgressAndCancel tests progress callbacks and cancelling a run through its context
+func TestRunProgressAndCancel(t *testing.T) {
+	var lines []string
+	manager := NewSyncManager(Options{
+		OnProgress: func(p Progress) {
+			lines = append(lines, p.SyncID+" "+p.Line)
+		},
+	})
+
+	destDir := t.TempDir()
+	testSync := manager.AddSync(testSourceDir, destDir, 60)
+
+	// The fallback copier is forced by an ordered transfer, so this works
+	// whether or not rsync is installed
+	testSync.Pair.TransferOrder = orderBreadthFirst
+	if err := testSync.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	found := false
+	for _, line := range lines {
+		if line == testSync.ID+" file1.txt" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected progress for file1.txt, got %v", lines)
+	}
+
+	// A cancelled context fails the run
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := manager.AddSync(testSourceDir, t.TempDir(), 60).Run(ctx); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+// TestStartStopsWithContext tests that a started sync stops when its context is done
+func TestStartStopsWithContext(t *testing.T) {
+	testSync := NewSync(testSourceDir, t.TempDir(), 60)
+	testSync.NextSyncTime = time.Now().Add(time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	testSync.Start(ctx, 60)
+	cancel()
+
+	// Triggering after cancellation shouldn't start a run
+	time.Sleep(50 * time.Millisecond)
+	testSync.TriggerSync()
+	time.Sleep(50 * time.Millisecond)
+
+	if !testSync.LastSync.IsZero() {
+		t.Errorf("Expected no run after the context was cancelled")
+	}
+}
+
+// TestTriggerSyncCoalesces tests that repeated triggers cause at most one more run
+func TestTriggerSyncCoalesces(t *testing.T) {
+	testSync := NewSync(testSourceDir, t.TempDir(), 60)
+	testSync.NextSyncTime = time.Now().Add(time.Hour)
+
+	if got := testSync.TriggerSync(); got != TriggerQueued {
+		t.Errorf("Expected the first trigger to queue a run, got %s", got)
+	}
+	if got := testSync.TriggerSync(); got != TriggerCoalesced {
+		t.Errorf("Expected a trigger of a queued sync to be coalesced, got %s", got)
+	}
+
+	testSync.IsSyncing = true
+	if got := testSync.TriggerSync(); got != TriggerFollowUp {
+		t.Errorf("Expected a trigger of a running sync to queue a follow-up, got %s", got)
+	}
+	if got := testSync.TriggerSync(); got != TriggerCoalesced {
+		t.Errorf("Expected further triggers to be coalesced, got %s", got)
+	}
+	if testSync.GetStatus()["follow_up_queued"] != true {
+		t.Errorf("Expected the follow-up in the status")
+	}
+}
+
+// TestFollowUpRun tests that a trigger during a run starts another run after it
+func TestFollowUpRun(t *testing.T) {
+	sourceDir := t.TempDir()
+	testSync := NewSync(sourceDir, t.TempDir(), 3600)
+
+	var runs int32
+	testSync.Pair.Hooks = []string{"count-runs"}
+	RegisterHook("count-runs", countingHook{start: func() {
+		// Trigger during the first run only
+		if atomic.AddInt32(&runs, 1) == 1 {
+			testSync.TriggerSync()
+			testSync.TriggerSync()
+		}
+	}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	testSync.Start(ctx, 3600)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&runs) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if got := atomic.LoadInt32(&runs); got != 2 {
+		t.Errorf("Expected exactly one follow-up run, got %d runs", got)
+	}
+}
+
+// countingHook calls start when a run starts
+type countingHook struct {
+	NopHook
+	start func()
+}
+
+func (h countingHook) OnSyncStart(ctx context.Context, event HookEvent) error {
+	h.start()
+	return nil
+}
+
+// TestManagerGetRun tests that a run in progress has its pair's output so
+// far, and that a finished run keeps its own
+func TestManagerGetRun(t *testing.T) {
+	manager := NewSyncManager(Options{})
+	s := manager.AddSync(testSourceDir, t.TempDir(), 60)
+
+	run := manager.History.StartRun(s.ID)
+	s.mu.Lock()
+	s.runID = run.ID
+	s.Output = "copying file1.txt\n"
+	s.mu.Unlock()
+
+	if got, ok := manager.GetRun(run.ID); !ok || got.Output != "copying file1.txt\n" {
+		t.Errorf("Expected the live output of the run, got %+v", got)
+	}
+
+	s.mu.Lock()
+	s.runID = ""
+	s.Output = "the next run\n"
+	s.mu.Unlock()
+	manager.History.finish(run, TransferStats{}, strings.Repeat("x", maxRunOutput+10), nil)
+
+	got, ok := manager.GetRun(run.ID)
+	if !ok || !strings.HasPrefix(got.Output, "...\n") || len(got.Output) != maxRunOutput+4 {
+		t.Errorf("Expected the tail of the run's own output, got %d bytes", len(got.Output))
+	}
+
+	if _, ok := manager.GetRun("missing"); ok {
+		t.Errorf("Expected no run for an unknown ID")
+	}
+}
+
+// TestDisabledPair tests that a pair disabled in its configuration doesn't
+// run until it is enabled
+func TestDisabledPair(t *testing.T) {
+	manager := NewSyncManager(Options{})
+	enabled := false
+	s := manager.AddPair(PairConfig{Source: testSourceDir, Destination: t.TempDir(), Enabled: &enabled}, 3600)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		manager.Wait()
+	}()
+	manager.Start(ctx, 3600)
+
+	time.Sleep(200 * time.Millisecond)
+	if runs := manager.History.ListRuns(s.ID); len(runs) != 0 || s.GetStatus()["enabled"] != false {
+		t.Fatalf("Expected a disabled pair not to run, got %d runs", len(runs))
+	}
+
+	s.SetEnabled(true)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(manager.History.ListRuns(s.ID)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the pair to run once enabled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
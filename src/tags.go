@@ -0,0 +1,35 @@
+package dirsync
+
+// HasTag reports whether the pair is tagged with tag
+func (s *Sync) HasTag(tag string) bool {
+	for _, t := range s.Pair.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// SyncsWithTag returns the syncs tagged with tag, in the order they were added
+func (sm *SyncManager) SyncsWithTag(tag string) []*Sync {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	var syncs []*Sync
+	for _, sync := range sm.Syncs {
+		if sync.HasTag(tag) {
+			syncs = append(syncs, sync)
+		}
+	}
+	return syncs
+}
+
+// TriggerTagged triggers the syncs tagged with tag, returning what each
+// trigger did by sync ID
+func (sm *SyncManager) TriggerTagged(tag string) map[string]TriggerResult {
+	results := make(map[string]TriggerResult)
+	for _, sync := range sm.SyncsWithTag(tag) {
+		results[sync.ID] = sync.TriggerSync()
+	}
+	return results
+}
@@ -0,0 +1,203 @@
+package dirsync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTargets is how many changed paths a watch schedule collects for a
+// targeted run before it syncs the whole pair instead
+const maxTargets = 10000
+
+// defaultFullSyncInterval is how often targeted watch schedules sync the
+// whole pair when no interval is configured, in seconds
+const defaultFullSyncInterval = 60 * 60
+
+// fullSyncInterval returns how long targeted runs may go without a full one
+func (c *ScheduleConfig) fullSyncInterval() time.Duration {
+	if c != nil && c.FullSyncInterval > 0 {
+		return time.Duration(c.FullSyncInterval) * time.Second
+	}
+	return defaultFullSyncInterval * time.Second
+}
+
+// addTargets records paths that changed in the source, relative to it, for
+// the next run to sync. Without paths, the watcher lost track of what
+// changed, and the next run syncs everything.
+func (s *Sync) addTargets(paths []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if paths == nil {
+		s.targetsFull = true
+		return
+	}
+	if s.targets == nil {
+		s.targets = make(map[string]bool)
+	}
+	for _, path := range paths {
+		if len(s.targets) >= maxTargets {
+			s.targetsFull = true
+			return
+		}
+		s.targets[filepath.Clean(path)] = true
+	}
+}
+
+// takeTargets returns the changed paths for a targeted run, sorted with
+// the paths inside other changed directories left out, and forgets them.
+// It returns nil if the run should sync everything: when no changes were
+// collected, when they were too many or lost, or when a full sync is due.
+func (s *Sync) takeTargets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	targets, full := s.targets, s.targetsFull
+	s.targets, s.targetsFull = nil, false
+	if len(targets) == 0 || full || s.Pair.DryRun || targets["."] {
+		return nil
+	}
+	if s.lastFullScan.IsZero() || time.Since(s.lastFullScan) >= s.Pair.Schedule.fullSyncInterval() {
+		return nil
+	}
+
+	paths := make([]string, 0, len(targets))
+	for path := range targets {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	kept := paths[:0]
+	for _, path := range paths {
+		if n := len(kept); n > 0 && strings.HasPrefix(path, kept[n-1]+string(filepath.Separator)) {
+			continue
+		}
+		kept = append(kept, path)
+	}
+	return kept
+}
+
+// copyTargets syncs only the changed paths of a targeted run: directories
+// are copied with everything in them, files one by one, and paths gone
+// from the source are deleted from the destination with delete
+func (c *fileCopier) copyTargets() error {
+	if info, err := os.Stat(c.source); err == nil {
+		if id, ok := getFileID(info); ok {
+			c.device = id.dev
+		}
+	}
+
+	var wg sync.WaitGroup
+	var loopErr error
+	slots := c.fileSlots()
+	for _, rel := range c.targets {
+		if c.sync.isPaused() {
+			loopErr = errSyncPaused
+			break
+		}
+		if loopErr = c.ctx.Err(); loopErr != nil {
+			break
+		}
+		if c.failed() != nil {
+			break
+		}
+		if c.skipTarget(rel) {
+			continue
+		}
+
+		info, err := os.Lstat(filepath.Join(c.source, rel))
+		if err == nil {
+			err = c.makeParent(rel)
+		}
+		switch {
+		case os.IsNotExist(err):
+			if c.delete {
+				loopErr = c.deleteTarget(rel)
+			}
+		case err != nil:
+			loopErr = c.skipFailed(rel, err)
+		case info.IsDir():
+			loopErr = c.skipFailed(rel, c.copyDir(rel))
+		case info.Mode()&os.ModeSymlink != 0:
+			loopErr = c.skipFailed(rel, c.copySymlink(rel))
+		case info.Mode().IsRegular():
+			slots <- struct{}{}
+			wg.Add(1)
+			go func(rel string) {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+				lowerThreadPriority(c.sync.Pair.Niceness)
+				if err := c.skipFailed(rel, c.copyFileSafely(rel)); err != nil {
+					c.setErr(err)
+				}
+			}(rel)
+		}
+		if loopErr != nil {
+			break
+		}
+	}
+
+	wg.Wait()
+	if loopErr != nil {
+		return loopErr
+	}
+	return c.failed()
+}
+
+// skipTarget reports whether a changed path is left out of runs: inside an
+// excluded directory, hidden with skip_hidden, or protected in the
+// destination
+func (c *fileCopier) skipTarget(rel string) bool {
+	slashRel := filepath.ToSlash(rel)
+	if c.protected[slashRel] {
+		return true
+	}
+	parts := strings.Split(slashRel, "/")
+	for i, part := range parts {
+		if c.excludes[strings.Join(parts[:i+1], "/")] || (c.skipHidden && isHidden(part)) {
+			return true
+		}
+	}
+	return false
+}
+
+// makeParent creates the destination directory a changed path goes in
+func (c *fileCopier) makeParent(rel string) error {
+	if c.dryRun {
+		return nil
+	}
+	destDir, err := c.destPath(filepath.Dir(rel))
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(destDir, 0755)
+}
+
+// deleteTarget deletes a path gone from the source from the destination
+func (c *fileCopier) deleteTarget(rel string) error {
+	dest, err := c.destPath(rel)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(dest); os.IsNotExist(err) {
+		return nil
+	}
+
+	if !c.dryRun {
+		if err := os.RemoveAll(dest); err != nil {
+			return fmt.Errorf("deleting %s: %w", rel, err)
+		}
+	}
+	slashRel := filepath.ToSlash(rel)
+	c.deleted++
+	c.sync.recordChange(slashRel, ChangeDeleted)
+	c.sync.appendOutput("deleting " + slashRel)
+	return nil
+}
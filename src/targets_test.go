@@ -0,0 +1,80 @@
+package dirsync
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestTakeTargets tests when a run syncs only the changed paths, and that
+// paths inside other changed directories are left out
+func TestTakeTargets(t *testing.T) {
+	testSync := NewSync(t.TempDir(), t.TempDir(), 60)
+	testSync.Pair.Schedule = &ScheduleConfig{Type: "watch", Targeted: true, FullSyncInterval: 60}
+
+	testSync.addTargets([]string{"a.txt"})
+	if targets := testSync.takeTargets(); targets != nil {
+		t.Errorf("Expected a full run before the first full scan, got %v", targets)
+	}
+
+	testSync.lastFullScan = time.Now()
+	testSync.addTargets([]string{"sub/b.txt", "a.txt", "sub", "subdir/c.txt"})
+	want := []string{"a.txt", "sub", "subdir/c.txt"}
+	if targets := testSync.takeTargets(); !reflect.DeepEqual(targets, want) {
+		t.Errorf("Expected targets %v, got %v", want, targets)
+	}
+	if targets := testSync.takeTargets(); targets != nil {
+		t.Errorf("Expected the targets to be taken once, got %v", targets)
+	}
+
+	testSync.addTargets([]string{"a.txt"})
+	testSync.addTargets(nil)
+	if targets := testSync.takeTargets(); targets != nil {
+		t.Errorf("Expected a full run after events were lost, got %v", targets)
+	}
+
+	testSync.lastFullScan = time.Now().Add(-time.Hour)
+	testSync.addTargets([]string{"a.txt"})
+	if targets := testSync.takeTargets(); targets != nil {
+		t.Errorf("Expected a full run once the full sync interval passed, got %v", targets)
+	}
+}
+
+// TestTargetedSync tests that a targeted run copies and deletes only the
+// changed paths
+func TestTargetedSync(t *testing.T) {
+	sourceDir, destDir := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(sourceDir, "a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "b.txt"), []byte("b"), 0644)
+	testSync := NewSync(sourceDir, destDir, 60)
+	testSync.Pair.Delete = true
+	testSync.Pair.Schedule = &ScheduleConfig{Type: "watch", Targeted: true}
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("Full sync failed: %v", err)
+	}
+
+	os.WriteFile(filepath.Join(sourceDir, "a.txt"), []byte("changed"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "untracked.txt"), []byte("new"), 0644)
+	os.MkdirAll(filepath.Join(sourceDir, "new", "deep"), 0755)
+	os.WriteFile(filepath.Join(sourceDir, "new", "deep", "c.txt"), []byte("c"), 0644)
+	os.Remove(filepath.Join(sourceDir, "b.txt"))
+	testSync.addTargets([]string{"a.txt", "new", "b.txt"})
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("Targeted sync failed: %v", err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(destDir, "a.txt")); string(data) != "changed" {
+		t.Errorf("Expected the changed file to be copied, got %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "new", "deep", "c.txt")); err != nil {
+		t.Errorf("Expected the new directory to be copied: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "b.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected the deleted file to be deleted from the destination")
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "untracked.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected a file that wasn't reported not to be copied")
+	}
+}
@@ -0,0 +1,44 @@
+// Command plugin is a test plugin for every plugin kind. Build it with
+// GOOS=wasip1 GOARCH=wasm.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "filter":
+		// Skip temporary files
+		if strings.HasSuffix(os.Args[2], ".tmp") {
+			os.Exit(1)
+		}
+	case "transform":
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			os.Exit(3)
+		}
+		if strings.Contains(string(data), "fail") {
+			fmt.Fprintln(os.Stderr, "refusing to transform")
+			os.Exit(4)
+		}
+		os.Stdout.Write([]byte(strings.ToUpper(string(data))))
+	case "notify":
+		var data struct {
+			Event string `json:"event"`
+		}
+		if err := json.NewDecoder(os.Stdin).Decode(&data); err != nil || data.Event == "" {
+			os.Exit(5)
+		}
+	default:
+		os.Exit(2)
+	}
+}
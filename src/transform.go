@@ -0,0 +1,233 @@
+package dirsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TransformConfig runs files whose names match one of its patterns through
+// a shell command or transform plugin on their way to the destination, e.g.
+// to strip metadata or encrypt them. The command reads the file on stdin and
+// writes the transformed file to stdout.
+type TransformConfig struct {
+	Patterns []string `json:"patterns"`
+	Command  string   `json:"command,omitempty"`
+	Plugin   string   `json:"plugin,omitempty"`
+}
+
+// transformCacheFile records which files have been transformed, in the
+// root of the destination
+const transformCacheFile = ".dirsync-transforms.json"
+
+// Validate checks the transform's patterns and command
+func (t TransformConfig) Validate() error {
+	if strings.TrimSpace(t.Command) == "" && t.Plugin == "" {
+		return errors.New("transform has no command or plugin")
+	}
+	if t.Command != "" && t.Plugin != "" {
+		return errors.New("transform has both a command and a plugin")
+	}
+	if len(t.Patterns) == 0 {
+		return errors.New("transform has no patterns")
+	}
+	return validatePatterns("transform", t.Patterns)
+}
+
+// transformFor returns the first transform matching the file at rel, if any
+func transformFor(transforms []TransformConfig, rel string) (TransformConfig, bool) {
+	name := filepath.Base(rel)
+	for _, transform := range transforms {
+		if matchesAny(transform.Patterns, name) {
+			return transform, true
+		}
+	}
+	return TransformConfig{}, false
+}
+
+// transformCacheEntry describes the source file a destination file was
+// transformed from
+type transformCacheEntry struct {
+	Command  string    `json:"command"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	DestSize int64     `json:"dest_size"`
+}
+
+// transformCache remembers transformed files so unchanged ones aren't
+// transformed again on every run
+type transformCache struct {
+	Files map[string]transformCacheEntry `json:"files"`
+	path  string
+	dirty bool
+	mu    sync.Mutex
+}
+
+// loadTransformCache reads the transform cache from the destination, starting
+// an empty one if there is none
+func loadTransformCache(dest string) *transformCache {
+	cache := &transformCache{
+		Files: make(map[string]transformCacheEntry),
+		path:  filepath.Join(dest, transformCacheFile),
+	}
+
+	if data, err := os.ReadFile(cache.path); err == nil {
+		json.Unmarshal(data, cache)
+		if cache.Files == nil {
+			cache.Files = make(map[string]transformCacheEntry)
+		}
+	}
+
+	return cache
+}
+
+// fresh reports whether destPath was already transformed from the current
+// version of the source file with the same command
+func (tc *transformCache) fresh(key, command, destPath string, srcInfo os.FileInfo) bool {
+	tc.mu.Lock()
+	entry, ok := tc.Files[key]
+	tc.mu.Unlock()
+
+	if !ok || entry.Command != command || entry.Size != srcInfo.Size() || !entry.ModTime.Equal(srcInfo.ModTime()) {
+		return false
+	}
+
+	destInfo, err := os.Stat(destPath)
+	return err == nil && destInfo.Size() == entry.DestSize
+}
+
+// record remembers that destPath was transformed from srcInfo
+func (tc *transformCache) record(key, command, destPath string, srcInfo os.FileInfo) {
+	destInfo, err := os.Stat(destPath)
+	if err != nil {
+		return
+	}
+
+	tc.mu.Lock()
+	tc.Files[key] = transformCacheEntry{
+		Command:  command,
+		Size:     srcInfo.Size(),
+		ModTime:  srcInfo.ModTime(),
+		DestSize: destInfo.Size(),
+	}
+	tc.dirty = true
+	tc.mu.Unlock()
+}
+
+// forget drops the files whose keys match
+func (tc *transformCache) forget(match func(key string) bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	for key := range tc.Files {
+		if match(key) {
+			delete(tc.Files, key)
+			tc.dirty = true
+		}
+	}
+}
+
+// save writes the cache back to the destination if it changed
+func (tc *transformCache) save() error {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if !tc.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(tc, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := tc.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, tc.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	tc.dirty = false
+	return nil
+}
+
+// cacheKey identifies what the transform runs, so changing it transforms
+// files again
+func (t TransformConfig) cacheKey() string {
+	if t.Plugin != "" {
+		return "plugin:" + t.Plugin
+	}
+	return t.Command
+}
+
+// commandTransform returns a transform that runs src through a shell command
+func commandTransform(command, src, dest string) func(io.Reader, io.Writer) error {
+	return func(stdin io.Reader, stdout io.Writer) error {
+		var stderr bytes.Buffer
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Stdin = stdin
+		cmd.Stdout = stdout
+		cmd.Stderr = &stderr
+		cmd.Env = append(os.Environ(), "DIRSYNC_SOURCE_FILE="+src, "DIRSYNC_DEST_FILE="+dest)
+
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("transform of %s failed: %v: %s", filepath.Base(src), err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	}
+}
+
+// pluginTransformer returns a transform that runs the file at rel through a plugin
+func pluginTransformer(ctx context.Context, plug *plugin, rel string) func(io.Reader, io.Writer) error {
+	return func(stdin io.Reader, stdout io.Writer) error {
+		return plug.runChecked(ctx, []string{pluginTransform, filepath.ToSlash(rel)}, stdin, stdout)
+	}
+}
+
+// transformFile runs src through transform and writes the result to dest
+// through a temporary file, keeping the source's permissions and
+// modification time
+func transformFile(src, dest string, info os.FileInfo, transform func(io.Reader, io.Writer) error) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	err = transform(in, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	os.Chmod(tmpPath, info.Mode().Perm())
+	os.Chtimes(tmpPath, info.ModTime(), info.ModTime())
+
+	if err := os.Rename(tmpPath, dest); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
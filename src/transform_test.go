@@ -0,0 +1,71 @@
+package dirsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestTransformFor tests matching files against transforms
+func TestTransformFor(t *testing.T) {
+	transforms := []TransformConfig{{Patterns: []string{"*.JPG"}, Command: "exiftool -gps:all= -"}}
+
+	if _, ok := transformFor(transforms, "2024/photo.jpg"); !ok {
+		t.Errorf("Expected photo.jpg to match, ignoring case")
+	}
+	if _, ok := transformFor(transforms, "notes.txt"); ok {
+		t.Errorf("Expected notes.txt not to match")
+	}
+
+	if err := (TransformConfig{Patterns: []string{"*.jpg"}}).Validate(); err == nil {
+		t.Errorf("Expected error for missing command, got nil")
+	}
+}
+
+// TestSyncWithTransforms tests transforming files and caching the result
+func TestSyncWithTransforms(t *testing.T) {
+	destDir := t.TempDir()
+
+	testSync := NewSync(testSourceDir, destDir, 60)
+	testSync.Pair.Transforms = []TransformConfig{{Patterns: []string{"file1.txt"}, Command: "tr a-z A-Z"}}
+
+	if err := testSync.syncWithFileCopy(context.Background(), testSourceDir, destDir); err != nil {
+		t.Fatalf("syncWithFileCopy failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(destDir, "file1.txt"))
+	if err != nil || string(data) != "TEST FILE 1 CONTENT" {
+		t.Fatalf("Expected transformed content, got %q (%v)", data, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(destDir, "file2.txt")); string(data) != "Test file 2 content" {
+		t.Errorf("Files without a transform should be copied as is, got %q", data)
+	}
+
+	// An unchanged file isn't transformed again
+	testSync.Output = ""
+	if err := testSync.syncWithFileCopy(context.Background(), testSourceDir, destDir); err != nil {
+		t.Fatalf("syncWithFileCopy failed on second run: %v", err)
+	}
+	if strings.Contains(testSync.Output, "(transformed)") {
+		t.Errorf("Expected cached transform to be reused, output: %s", testSync.Output)
+	}
+
+	// Changing the command transforms it again
+	testSync.Pair.Transforms[0].Command = "tr a-z b-za"
+	testSync.Output = ""
+	if err := testSync.syncWithFileCopy(context.Background(), testSourceDir, destDir); err != nil {
+		t.Fatalf("syncWithFileCopy failed on third run: %v", err)
+	}
+	if !strings.Contains(testSync.Output, "file1.txt (transformed)") {
+		t.Errorf("Expected file to be transformed again after the command changed, output: %s", testSync.Output)
+	}
+
+	// A failing command fails the run
+	testSync.Pair.Transforms[0].Command = "echo broken >&2; exit 3"
+	os.Remove(filepath.Join(destDir, "file1.txt"))
+	if err := testSync.syncWithFileCopy(context.Background(), testSourceDir, destDir); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("Expected transform error with its output, got %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package dirsync
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// transferredFile is a file copied by a run, kept so a sample of them can be
+// read back from the destination afterwards
+type transferredFile struct {
+	source string
+	dest   string
+}
+
+// rsyncTransferredFile returns the transferred file for a path rsync
+// listed relative to the source, if it names a regular file
+func (s *Sync) rsyncTransferredFile(rel string) (transferredFile, bool) {
+	rel = strings.TrimSpace(rel)
+	if rel == "" || strings.HasSuffix(rel, "/") || filepath.IsAbs(rel) {
+		return transferredFile{}, false
+	}
+
+	// Progress and summary lines aren't files in the source
+	source := filepath.Join(s.SourcePath, rel)
+	if info, err := os.Lstat(source); err != nil || !info.Mode().IsRegular() {
+		return transferredFile{}, false
+	}
+	return transferredFile{source: source, dest: filepath.Join(s.runDestination(), rel)}, true
+}
+
+// verifySample reads back the sampled transferred files from the
+// destination and compares their hashes with the source's, using the
+// pair's hash algorithm
+func (s *Sync) verifySample(sample *transferSample) error {
+	if s.Pair.VerifySample <= 0 || s.Pair.DryRun || sample == nil || len(sample.files) == 0 {
+		return nil
+	}
+
+	files, total := sample.files, sample.total
+	for _, file := range files {
+		algorithm := s.Pair.hashAlgorithm()
+		want, err := hashFile(file.source, algorithm)
+		if err != nil {
+			return fmt.Errorf("error reading %s for verification: %w", file.source, err)
+		}
+		got, err := hashFile(file.dest, algorithm)
+		if err != nil {
+			return fmt.Errorf("error reading back %s: %w", file.dest, err)
+		}
+		if !bytes.Equal(want, got) {
+			return fmt.Errorf("%s does not match its source %s", file.dest, file.source)
+		}
+	}
+
+	s.appendOutput(fmt.Sprintf("Verified %d of %d transferred files", len(files), total))
+	return nil
+}
@@ -0,0 +1,61 @@
+package dirsync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSyncWithVerifySample tests reading back a sample of copied files
+func TestSyncWithVerifySample(t *testing.T) {
+	destDir := t.TempDir()
+
+	testSync := NewSync(testSourceDir, destDir, 60)
+	testSync.Pair.VerifySample = 2
+
+	if err := testSync.SyncDirectories(); err != nil {
+		t.Fatalf("SyncDirectories failed: %v", err)
+	}
+	if !strings.Contains(testSync.GetStatus()["output"].(string), "Verified 2 of 3 transferred files") {
+		t.Errorf("Expected verification in output, got %q", testSync.GetStatus()["output"])
+	}
+
+	// A destination file that doesn't match its source fails verification
+	dest := filepath.Join(destDir, "file1.txt")
+	if err := os.WriteFile(dest, []byte("corrupted"), 0644); err != nil {
+		t.Fatalf("Failed to corrupt destination file: %v", err)
+	}
+	sample := newTransferSample(1)
+	sample.add(transferredFile{source: filepath.Join(testSourceDir, "file1.txt"), dest: dest})
+	if err := testSync.verifySample(sample); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("Expected mismatch error, got %v", err)
+	}
+}
+
+// TestRsyncTransferred tests finding transferred files in rsync output
+func TestRsyncTransferred(t *testing.T) {
+	testSync := NewSync(testSourceDir, "/backup", 60)
+
+	output := []string{
+		"sending incremental file list",
+		"subdir/",
+		"file1.txt",
+		"             19 100%    0.00kB/s    0:00:00 (xfr#1, to-chk=2/4)",
+		"subdir/file3.txt",
+		"sent 312 bytes  received 57 bytes  738.00 bytes/sec",
+	}
+
+	var files []transferredFile
+	for _, line := range output {
+		if file, ok := testSync.rsyncTransferredFile(line); ok {
+			files = append(files, file)
+		}
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 transferred files, got %v", files)
+	}
+	if files[1].dest != filepath.Join("/backup", "subdir/file3.txt") {
+		t.Errorf("Unexpected destination %s", files[1].dest)
+	}
+}
@@ -0,0 +1,73 @@
+package dirsync
+
+import (
+	"context"
+	"log"
+)
+
+// Watchers a watch schedule can notice changes with
+const (
+	watcherAuto   = "auto"
+	watcherEvents = "events"
+	watcherPoll   = "poll"
+)
+
+// changes returns a channel that receives when the source changes, outside
+// the ignored paths. It uses file system events where it can, and polls
+// the source otherwise.
+func (ws watchScheduler) changes(ctx context.Context, s *Sync, skip []string) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+
+	mode := ws.watcher
+	if mode == "" || mode == watcherAuto {
+		mode = watcherEvents
+		if fs, ok := networkFilesystem(s.SourcePath); ok {
+			log.Printf("[%s] Source is on %s, which doesn't report changes, polling every %v", s.ID, fs, ws.poll)
+			mode = watcherPoll
+		}
+	}
+
+	if mode == watcherEvents {
+		err := watchEvents(ctx, s.SourcePath, ws.ignore, skip, func(paths []string) {
+			if ws.targeted {
+				s.addTargets(paths)
+			}
+			notify(changed)
+		})
+		if err == nil {
+			return changed
+		}
+		log.Printf("[%s] Can't watch the source for changes (%v), polling every %v", s.ID, err, ws.poll)
+	}
+
+	go ws.pollChanges(ctx, s, skip, changed)
+	return changed
+}
+
+// pollChanges compares the source's fingerprint every poll interval, a
+// scan of its file names, sizes and modification times that works on any
+// filesystem, and notifies changed when it differs. Polling doesn't tell
+// which paths changed, so targeted schedules sync everything.
+func (ws watchScheduler) pollChanges(ctx context.Context, s *Sync, skip []string, changed chan struct{}) {
+	last, _ := treeFingerprint(s.SourcePath, ws.ignore, skip)
+	for sleepContext(ctx, ws.poll) {
+		fingerprint, err := treeFingerprint(s.SourcePath, ws.ignore, skip)
+		if err != nil || fingerprint == last {
+			continue
+		}
+		last = fingerprint
+		if ws.targeted {
+			s.addTargets(nil)
+		}
+		notify(changed)
+	}
+}
+
+// notify sends on a channel with a buffer of one without blocking, so a
+// burst of changes leaves a single notification
+func notify(changed chan struct{}) {
+	select {
+	case changed <- struct{}{}:
+	default:
+	}
+}
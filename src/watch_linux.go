@@ -0,0 +1,190 @@
+//go:build linux
+
+package dirsync
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// networkFilesystems names the filesystems, by the magic number statfs
+// reports, whose changes made on other hosts aren't reported by inotify
+var networkFilesystems = map[int64]string{
+	0x6969:     "NFS",
+	0x517b:     "SMB",
+	0xff534d42: "CIFS",
+	0xfe534d42: "SMB2",
+	0x65735546: "FUSE",
+	0x01021997: "9P",
+	0x00c36400: "Ceph",
+	0x73757245: "Coda",
+	0x5346414f: "AFS",
+}
+
+// networkFilesystem returns the name of the network filesystem path is on,
+// if it is on one
+func networkFilesystem(path string) (string, bool) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return "", false
+	}
+	name, ok := networkFilesystems[int64(stat.Type)&0xffffffff]
+	return name, ok
+}
+
+// watchMask is the inotify events that mean the source changed
+const watchMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF
+
+// inotifyWatcher follows changes to a tree with inotify, which watches
+// single directories, so every directory of the tree is watched and new
+// directories are added as they appear
+type inotifyWatcher struct {
+	file   *os.File
+	fd     int
+	root   string
+	ignore []string
+	skip   []string
+
+	mu   sync.Mutex
+	dirs map[int]string
+}
+
+// watchEvents calls changed with the paths relative to root that inotify
+// reported as changed, outside the ignored paths, until ctx is done. The
+// paths are nil when events were lost. It returns an
+// error if the tree can't be watched, such as when there are more
+// directories than fs.inotify.max_user_watches allows.
+func watchEvents(ctx context.Context, root string, ignore, skip []string, changed func(paths []string)) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+	w := &inotifyWatcher{fd: fd, root: root, ignore: ignore, skip: skip, dirs: make(map[int]string)}
+	if err := w.addTree("."); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+
+	// A non-blocking descriptor can be read through the runtime's poller,
+	// so closing it stops the read below
+	w.file = os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		<-ctx.Done()
+		w.file.Close()
+	}()
+	go w.read(changed)
+	return nil
+}
+
+// addTree watches the directory rel and every directory below it
+func (w *inotifyWatcher) addTree(rel string) error {
+	return filepath.WalkDir(filepath.Join(w.root, rel), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Directories can disappear while we add them
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(w.root, path)
+		if err != nil {
+			return err
+		}
+		if rel != "." && (ignored(w.ignore, rel) || w.skipped(rel)) {
+			return filepath.SkipDir
+		}
+
+		wd, err := syscall.InotifyAddWatch(w.fd, path, watchMask)
+		if err != nil {
+			if err == syscall.ENOENT {
+				return nil
+			}
+			return &os.PathError{Op: "inotify_add_watch", Path: path, Err: err}
+		}
+		w.mu.Lock()
+		w.dirs[wd] = rel
+		w.mu.Unlock()
+		return nil
+	})
+}
+
+// skipped reports whether rel is one of the directories left out of the tree
+func (w *inotifyWatcher) skipped(rel string) bool {
+	for _, dir := range w.skip {
+		if filepath.ToSlash(rel) == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// read reads events until the watcher is closed, calling changed with the
+// paths each batch touched that aren't ignored
+func (w *inotifyWatcher) read(changed func(paths []string)) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		var paths []string
+		lost := false
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			nameEnd := nameStart + int(event.Len)
+			if nameEnd > n {
+				break
+			}
+			name := string(buf[nameStart:nameEnd])
+			for len(name) > 0 && name[len(name)-1] == 0 {
+				name = name[:len(name)-1]
+			}
+			offset = nameEnd
+
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				// Events were lost, so anything may have changed
+				lost = true
+				continue
+			}
+
+			w.mu.Lock()
+			dir, ok := w.dirs[int(event.Wd)]
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.dirs, int(event.Wd))
+			}
+			w.mu.Unlock()
+			if !ok || event.Mask&syscall.IN_IGNORED != 0 {
+				continue
+			}
+
+			rel := filepath.Join(dir, name)
+			if name != "" && ignored(w.ignore, rel) {
+				continue
+			}
+			paths = append(paths, rel)
+
+			// Watch directories created or moved into the tree
+			if event.Mask&syscall.IN_ISDIR != 0 && event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				w.addTree(rel)
+			}
+		}
+		switch {
+		case lost:
+			changed(nil)
+		case len(paths) > 0:
+			changed(paths)
+		}
+	}
+}
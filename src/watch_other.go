@@ -0,0 +1,19 @@
+//go:build !linux
+
+package dirsync
+
+import (
+	"context"
+	"errors"
+)
+
+// networkFilesystem can't tell network filesystems apart on this system;
+// without file system events, watch schedules poll anyway
+func networkFilesystem(path string) (string, bool) {
+	return "", false
+}
+
+// watchEvents isn't supported on this system, so watch schedules poll
+func watchEvents(ctx context.Context, root string, ignore, skip []string, changed func(paths []string)) error {
+	return errors.New("file system events aren't supported on this system")
+}
@@ -0,0 +1,108 @@
+package dirsync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+// watchdogInterval is how often the watchdog checks that every pair is
+// still being scheduled
+var watchdogInterval = 30 * time.Second
+
+// stallGrace is how long an idle pair may go past its next sync time before
+// its scheduler counts as stalled
+var stallGrace = 5 * time.Minute
+
+// startLoop runs the pair's scheduling loop in a goroutine, recovering from
+// panics so the watchdog can restart it
+func (sm *SyncManager) startLoop(ctx context.Context, s *Sync, interval int) {
+	loopCtx, cancel := context.WithCancel(ctx)
+	s.mu.Lock()
+	s.loopGen++
+	gen := s.loopGen
+	s.loopCancel = cancel
+	s.loopRunning = true
+	s.loopPanic = ""
+	s.mu.Unlock()
+
+	sm.running.Add(1)
+	go func() {
+		defer sm.running.Done()
+		defer cancel()
+		defer func() {
+			r := recover()
+			if r != nil {
+				log.Printf("[%s] Scheduler panicked: %v\n%s", s.ID, r, debug.Stack())
+			}
+
+			// A restarted loop's predecessor leaves the state alone
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.loopGen != gen {
+				return
+			}
+			s.loopRunning = false
+			if r != nil {
+				s.loopPanic = fmt.Sprint(r)
+				s.IsSyncing = false
+			}
+		}()
+		s.loop(loopCtx, interval)
+	}()
+}
+
+// stalled returns why the pair's scheduler looks dead, or "" if it is fine.
+// Loops that stopped by themselves, such as for an invalid schedule, and
+// pairs that are running, paused, disabled, locked, held back for resumed
+// runs, queued for a run slot or waiting for a trigger aren't stalled.
+func (s *Sync) stalled(now time.Time) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	switch {
+	case s.loopCancel == nil:
+		return ""
+	case s.loopPanic != "":
+		return "scheduler stopped after a panic: " + s.loopPanic
+	case !s.loopRunning, s.IsSyncing, s.Paused, s.disabled, s.heldBack, s.queued, s.lockedBy != "", s.NextSyncTime.IsZero():
+		return ""
+	case now.Sub(s.NextSyncTime) > stallGrace:
+		return fmt.Sprintf("run due at %s never started", s.NextSyncTime.Format(time.RFC3339))
+	}
+	return ""
+}
+
+// watchdog restarts the scheduling loops of stalled pairs until ctx is done
+func (sm *SyncManager) watchdog(ctx context.Context, interval int) {
+	for sleepContext(ctx, watchdogInterval) {
+		sm.mu.RLock()
+		syncs := append([]*Sync(nil), sm.Syncs...)
+		sm.mu.RUnlock()
+
+		for _, s := range syncs {
+			if reason := s.stalled(time.Now()); reason != "" {
+				sm.restartLoop(ctx, s, interval, reason)
+			}
+		}
+	}
+}
+
+// restartLoop abandons a pair's stalled scheduling loop and starts a new
+// one, counting the restart and noting it on the pair
+func (sm *SyncManager) restartLoop(ctx context.Context, s *Sync, interval int, reason string) {
+	log.Printf("[%s] Restarting scheduler: %s", s.ID, reason)
+
+	s.mu.Lock()
+	cancel := s.loopCancel
+	s.schedulerRestarts++
+	s.mu.Unlock()
+	cancel()
+
+	if err := sm.History.AnnotatePair(s.ID, "Scheduler restarted by the watchdog: "+reason); err != nil {
+		log.Printf("[%s] Error saving history: %v", s.ID, err)
+	}
+	sm.startLoop(ctx, s, interval)
+}
@@ -0,0 +1,104 @@
+package dirsync
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// panickyScheduler panics the first time it is asked for the next run,
+// and waits for triggers after that
+type panickyScheduler struct {
+	calls *int32
+}
+
+func (ps panickyScheduler) Next(time.Time) time.Time {
+	if atomic.AddInt32(ps.calls, 1) == 1 {
+		panic("time went backwards")
+	}
+	return time.Time{}
+}
+
+// withWatchdog makes the watchdog check often and give up quickly for the
+// duration of a test
+func withWatchdog(t *testing.T) {
+	interval, grace := watchdogInterval, stallGrace
+	watchdogInterval, stallGrace = 10*time.Millisecond, 50*time.Millisecond
+	t.Cleanup(func() { watchdogInterval, stallGrace = interval, grace })
+}
+
+// waitForRestart waits until the pair's scheduler has been restarted
+func waitForRestart(t *testing.T, s *Sync) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if s.GetStatus()["scheduler_restarts"].(int) > 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected the watchdog to restart the scheduler")
+}
+
+// TestWatchdogRestartsPanickedScheduler tests that a pair whose scheduling
+// loop panicked is started again and the restart is noted
+func TestWatchdogRestartsPanickedScheduler(t *testing.T) {
+	withWatchdog(t)
+	var calls int32
+	RegisterScheduler("test-panicky", func(ScheduleConfig, int) (Scheduler, error) {
+		return panickyScheduler{calls: &calls}, nil
+	})
+
+	manager := NewSyncManager(Options{})
+	s := manager.AddPair(PairConfig{Source: testSourceDir, Destination: t.TempDir(),
+		Schedule: &ScheduleConfig{Type: "test-panicky"}}, 60)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer manager.Wait()
+	defer cancel()
+	manager.Start(ctx, 60)
+
+	waitForRestart(t, s)
+	notes := manager.History.GetPairNotes(s.ID)
+	if len(notes) == 0 || !strings.Contains(notes[0].Text, "time went backwards") {
+		t.Errorf("Expected the restart to be noted on the pair, got %+v", notes)
+	}
+
+	// The new loop asks the scheduler again, which is fine this time
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt32(&calls) < 2 || s.stalled(time.Now()) != "" {
+		t.Errorf("Expected the restarted scheduler to be running")
+	}
+}
+
+// TestWatchdogRestartsOverdueScheduler tests that a pair whose run is long
+// overdue without starting is rescheduled, but a paused one is left alone
+func TestWatchdogRestartsOverdueScheduler(t *testing.T) {
+	withWatchdog(t)
+	manager := NewSyncManager(Options{})
+	s := manager.AddPair(PairConfig{Source: testSourceDir, Destination: t.TempDir(),
+		Schedule: &ScheduleConfig{Type: "manual"}}, 60)
+	paused := manager.AddPair(PairConfig{Source: testSourceDir, Destination: t.TempDir(),
+		Schedule: &ScheduleConfig{Type: "manual"}}, 60)
+	paused.Paused = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer manager.Wait()
+	defer cancel()
+	manager.Start(ctx, 60)
+	time.Sleep(50 * time.Millisecond)
+
+	// Lose track of a run as a time math bug would
+	for _, pair := range []*Sync{s, paused} {
+		pair.mu.Lock()
+		pair.NextSyncTime = time.Now().Add(-time.Hour)
+		pair.mu.Unlock()
+	}
+
+	waitForRestart(t, s)
+	if restarts := paused.GetStatus()["scheduler_restarts"].(int); restarts != 0 {
+		t.Errorf("Expected a paused pair to be left alone, got %d restarts", restarts)
+	}
+}
@@ -0,0 +1,79 @@
+package dirsync
+
+import (
+	"sort"
+	"time"
+)
+
+// WindowReport answers what ran between two times, for looking into
+// incidents such as "did last night's backup copy the corrupted files?"
+type WindowReport struct {
+	From  time.Time      `json:"from"`
+	To    time.Time      `json:"to"`
+	Pairs []PairActivity `json:"pairs"`
+}
+
+// PairActivity is what one pair did during a window
+type PairActivity struct {
+	SyncID           string      `json:"sync_id"`
+	Runs             []RunRecord `json:"runs"`
+	Failed           int         `json:"failed"`
+	BytesTransferred int64       `json:"bytes_transferred"`
+}
+
+// RunsBetween returns copies of the runs that were going at any point
+// between from and to, optionally only those of one sync. Runs still in
+// progress count as going until now.
+func (h *History) RunsBetween(from, to time.Time, syncID string) []RunRecord {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	runs := make([]RunRecord, 0)
+	for _, run := range h.Runs {
+		if syncID != "" && run.SyncID != syncID {
+			continue
+		}
+
+		end := run.EndTime
+		if end.IsZero() {
+			end = time.Now()
+		}
+		if run.StartTime.After(to) || end.Before(from) {
+			continue
+		}
+		runs = append(runs, *run)
+	}
+	return runs
+}
+
+// Window reports the runs between from and to grouped by pair, optionally
+// only for one sync. Pairs are sorted by ID and their runs by start time.
+func (h *History) Window(from, to time.Time, syncID string) WindowReport {
+	report := WindowReport{From: from, To: to, Pairs: []PairActivity{}}
+
+	bySync := make(map[string]*PairActivity)
+	for _, run := range h.RunsBetween(from, to, syncID) {
+		activity, ok := bySync[run.SyncID]
+		if !ok {
+			activity = &PairActivity{SyncID: run.SyncID}
+			bySync[run.SyncID] = activity
+		}
+
+		activity.Runs = append(activity.Runs, run)
+		activity.BytesTransferred += run.BytesTransferred
+		if !run.Success && !run.EndTime.IsZero() {
+			activity.Failed++
+		}
+	}
+
+	for _, activity := range bySync {
+		sort.Slice(activity.Runs, func(i, j int) bool {
+			return activity.Runs[i].StartTime.Before(activity.Runs[j].StartTime)
+		})
+		report.Pairs = append(report.Pairs, *activity)
+	}
+	sort.Slice(report.Pairs, func(i, j int) bool {
+		return report.Pairs[i].SyncID < report.Pairs[j].SyncID
+	})
+	return report
+}
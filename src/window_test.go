@@ -0,0 +1,41 @@
+package dirsync
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestHistoryWindow tests finding the runs that were going during a window
+func TestHistoryWindow(t *testing.T) {
+	history, _ := NewHistory("", 0)
+	now := time.Now()
+
+	addRun := func(syncID string, start, end time.Time, runErr error) {
+		run := history.StartRun(syncID)
+		history.finish(run, TransferStats{BytesTransferred: 100}, "", runErr)
+		run.StartTime, run.EndTime = start, end
+	}
+	addRun("a:b", now.Add(-50*time.Hour), now.Add(-49*time.Hour), nil)
+	addRun("a:b", now.Add(-10*time.Hour), now.Add(-9*time.Hour), nil)
+	addRun("c:d", now.Add(-13*time.Hour), now.Add(-11*time.Hour), errors.New("disk full"))
+	addRun("c:d", now.Add(-8*time.Hour), now.Add(-7*time.Hour), nil)
+
+	report := history.Window(now.Add(-12*time.Hour), now.Add(-8*time.Hour), "")
+	if len(report.Pairs) != 2 {
+		t.Fatalf("Expected runs of 2 pairs, got %+v", report.Pairs)
+	}
+	if a := report.Pairs[0]; a.SyncID != "a:b" || len(a.Runs) != 1 || a.BytesTransferred != 100 {
+		t.Errorf("Expected only the overlapping run of a:b, got %+v", a)
+	}
+	if c := report.Pairs[1]; len(c.Runs) != 2 || c.Failed != 1 || !c.Runs[0].StartTime.Before(c.Runs[1].StartTime) {
+		t.Errorf("Expected both runs of c:d, oldest first, with one failure, got %+v", c)
+	}
+
+	if report := history.Window(now.Add(-12*time.Hour), now, "a:b"); len(report.Pairs) != 1 {
+		t.Errorf("Expected only a:b, got %+v", report.Pairs)
+	}
+	if report := history.Window(now.Add(-100*time.Hour), now.Add(-90*time.Hour), ""); len(report.Pairs) != 0 {
+		t.Errorf("Expected no runs, got %+v", report.Pairs)
+	}
+}
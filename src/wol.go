@@ -0,0 +1,164 @@
+package dirsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os/exec"
+	"time"
+)
+
+// WakeOnLANConfig describes how to wake a destination host before a sync
+// and how to put it back to sleep afterwards
+type WakeOnLANConfig struct {
+	MAC            string `json:"mac"`
+	Broadcast      string `json:"broadcast"`
+	Host           string `json:"host"`
+	Timeout        int    `json:"timeout"`
+	SuspendCommand string `json:"suspend_command"`
+	SuspendWebhook string `json:"suspend_webhook"`
+}
+
+const (
+	defaultWakeBroadcast = "255.255.255.255:9"
+	defaultWakeTimeout   = 120
+)
+
+// wakeProbeInterval is how often we check whether a woken host is reachable
+var wakeProbeInterval = 2 * time.Second
+
+// magicPacket builds a Wake-on-LAN magic packet for the given MAC address
+func magicPacket(mac string) ([]byte, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return nil, err
+	}
+	if len(hw) != 6 {
+		return nil, fmt.Errorf("unsupported MAC address length: %s", mac)
+	}
+
+	// 6 bytes of 0xFF followed by the MAC repeated 16 times
+	packet := bytes.Repeat([]byte{0xFF}, 6)
+	for i := 0; i < 16; i++ {
+		packet = append(packet, hw...)
+	}
+	return packet, nil
+}
+
+// sendMagicPacket broadcasts a magic packet for mac to the given address
+func sendMagicPacket(mac, broadcast string) error {
+	packet, err := magicPacket(mac)
+	if err != nil {
+		return err
+	}
+
+	if broadcast == "" {
+		broadcast = defaultWakeBroadcast
+	}
+
+	conn, err := net.Dial("udp", broadcast)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write(packet)
+	return err
+}
+
+// waitForHost polls addr (host:port) until a TCP connection succeeds or the timeout expires
+func waitForHost(addr string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, wakeProbeInterval)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("host %s did not come online within %v", addr, timeout)
+		}
+		time.Sleep(wakeProbeInterval)
+	}
+}
+
+// wakeDestination sends a Wake-on-LAN packet and waits for the destination to come online
+func (s *Sync) wakeDestination() error {
+	wol := s.Pair.WakeOnLAN
+	if wol == nil || wol.MAC == "" {
+		return nil
+	}
+
+	log.Printf("[%s] Sending Wake-on-LAN packet to %s", s.ID, wol.MAC)
+	if err := sendMagicPacket(wol.MAC, wol.Broadcast); err != nil {
+		return fmt.Errorf("failed to send Wake-on-LAN packet: %w", err)
+	}
+
+	s.mu.Lock()
+	s.Output += fmt.Sprintf("Sent Wake-on-LAN packet to %s\n", wol.MAC)
+	s.mu.Unlock()
+
+	if wol.Host == "" {
+		return nil
+	}
+
+	timeout := wol.Timeout
+	if timeout <= 0 {
+		timeout = defaultWakeTimeout
+	}
+
+	log.Printf("[%s] Waiting up to %d seconds for %s to come online", s.ID, timeout, wol.Host)
+	if err := waitForHost(wol.Host, time.Duration(timeout)*time.Second); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.Output += fmt.Sprintf("Destination host %s is online\n", wol.Host)
+	s.mu.Unlock()
+
+	return nil
+}
+
+// suspendDestination runs the configured suspend command and reports the
+// exit status of the sync to the suspend webhook, if any
+func (s *Sync) suspendDestination(syncErr error) {
+	wol := s.Pair.WakeOnLAN
+	if wol == nil {
+		return
+	}
+
+	if wol.SuspendCommand != "" {
+		log.Printf("[%s] Suspending destination: %s", s.ID, wol.SuspendCommand)
+		out, err := exec.Command("sh", "-c", wol.SuspendCommand).CombinedOutput()
+		if err != nil {
+			log.Printf("[%s] Suspend command failed: %v: %s", s.ID, err, out)
+		}
+	}
+
+	if wol.SuspendWebhook != "" {
+		payload := map[string]interface{}{
+			"id":      s.ID,
+			"success": syncErr == nil,
+			"error":   "",
+		}
+		if syncErr != nil {
+			payload["error"] = syncErr.Error()
+		}
+
+		body, _ := json.Marshal(payload)
+		client := &http.Client{Timeout: 30 * time.Second}
+		resp, err := client.Post(wol.SuspendWebhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("[%s] Suspend webhook failed: %v", s.ID, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("[%s] Suspend webhook returned status %d", s.ID, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package dirsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestMagicPacket tests building a Wake-on-LAN magic packet
+func TestMagicPacket(t *testing.T) {
+	packet, err := magicPacket("01:23:45:67:89:ab")
+	if err != nil {
+		t.Fatalf("magicPacket failed: %v", err)
+	}
+
+	if len(packet) != 102 {
+		t.Fatalf("Expected packet length 102, got %d", len(packet))
+	}
+
+	if !bytes.Equal(packet[:6], []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}) {
+		t.Errorf("Packet should start with 6 bytes of 0xFF, got %x", packet[:6])
+	}
+
+	mac := []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab}
+	for i := 0; i < 16; i++ {
+		offset := 6 + i*6
+		if !bytes.Equal(packet[offset:offset+6], mac) {
+			t.Errorf("Repetition %d of MAC is wrong: %x", i, packet[offset:offset+6])
+		}
+	}
+
+	// Test with invalid MAC
+	if _, err := magicPacket("not-a-mac"); err == nil {
+		t.Errorf("Expected error for invalid MAC address, got nil")
+	}
+}
+
+// TestWaitForHost tests waiting for a host to come online
+func TestWaitForHost(t *testing.T) {
+	wakeProbeInterval = 50 * time.Millisecond
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := listener.Addr().String()
+
+	// Host is online
+	if err := waitForHost(addr, time.Second); err != nil {
+		t.Errorf("Expected host to be online, got %v", err)
+	}
+
+	// Host is offline
+	listener.Close()
+	if err := waitForHost(addr, 100*time.Millisecond); err == nil {
+		t.Errorf("Expected error for offline host, got nil")
+	}
+}
+
+// TestSuspendWebhook tests that the exit status is posted to the suspend webhook
+func TestSuspendWebhook(t *testing.T) {
+	var payload map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	defer ts.Close()
+
+	testSync := NewSync(testSourceDir, testDestDir, 60)
+	testSync.Pair.WakeOnLAN = &WakeOnLANConfig{SuspendWebhook: ts.URL}
+
+	testSync.suspendDestination(nil)
+
+	if success, ok := payload["success"].(bool); !ok || !success {
+		t.Errorf("Expected success: true in webhook payload, got %v", payload)
+	}
+
+	if id, ok := payload["id"].(string); !ok || id != testSync.ID {
+		t.Errorf("Expected id %s in webhook payload, got %v", testSync.ID, payload["id"])
+	}
+}